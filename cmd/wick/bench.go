@@ -0,0 +1,68 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/sirupsen/logrus"
+
+	"github.com/codebasepk/wick/wamp"
+)
+
+// runBenchSerializers runs the bench serializers command and prints its
+// table to stdout, with the results so far if interrupted with CTRL-c.
+func runBenchSerializers(connectOptions wamp.ConnectOptions, logger *logrus.Logger) error {
+	payload, err := generatePayload(*benchSerializersPayloadSize, *benchSerializersPayloadShape,
+		*benchSerializersPayloadRandom, false)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	results, err := wamp.BenchSerializers(ctx, logger, wamp.BenchOptions{
+		Serializers: *benchSerializersList,
+		Procedure:   *benchSerializersCall,
+		Topic:       *benchSerializersTopic,
+		Args:        *benchSerializersArgs,
+		Kwargs:      *benchSerializersKwargs,
+		Payload:     payload,
+		Repeat:      *benchSerializersRepeat,
+		Concurrency: *benchSerializersConcurrency,
+		Connect: func(serializer serialize.Serialization, bytes *wamp.ByteCounter) (*client.Client, error) {
+			options := connectOptions
+			options.Bytes = bytes
+			return connectSession(*url, firstRealm(), serializer, options, logger)
+		},
+	})
+	if len(results) > 0 {
+		wamp.PrintBenchResults(results, os.Stdout)
+	}
+	return err
+}
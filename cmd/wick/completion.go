@@ -0,0 +1,97 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/codebasepk/wick/wamp"
+)
+
+// The completion scripts ask wick itself for the completions of the command
+// line with the hidden --completion-bash flag of kingpin, that way they
+// follow the commands, flags and enum values of the binary in use. Kingpin
+// only completes arguments from an empty word, so the word being completed
+// is only passed along if it is a flag, the shell filters the rest.
+const (
+	bashCompletion = `_wick_completion() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" word="" options
+    [[ "${cur}" == -* ]] && word="${cur}"
+    options=$("${COMP_WORDS[0]}" --completion-bash "${COMP_WORDS[@]:1:$((COMP_CWORD - 1))}" "${word}" 2>/dev/null)
+    COMPREPLY=($(compgen -W "${options}" -- "${cur}"))
+}
+complete -o default -F _wick_completion wick
+`
+
+	zshCompletion = `#compdef wick
+
+_wick() {
+    local -a options
+    local word=""
+    [[ "${words[CURRENT]}" == -* ]] && word="${words[CURRENT]}"
+    options=(${(f)"$("${words[1]}" --completion-bash "${(@)words[2,CURRENT-1]}" "${word}" 2>/dev/null)"})
+    compadd -a options
+}
+
+if [ "$funcstack[1]" = "_wick" ]; then
+    _wick "$@"
+else
+    compdef _wick wick
+fi
+`
+
+	fishCompletion = `function __wick_completion
+    set -l words (commandline -opc)
+    set -l word (commandline -ct)
+    string match -q -- '-*' $word; or set word ''
+    wick --completion-bash $words[2..-1] $word 2>/dev/null
+end
+
+complete -c wick -f -a '(__wick_completion)'
+`
+)
+
+// profileHints completes the names of the profiles of the config file.
+func profileHints() []string {
+	config, err := wamp.LoadConfig(wamp.DefaultConfigFile())
+	if err != nil {
+		return nil
+	}
+	return config.ProfileNames()
+}
+
+// printCompletion writes the completion script of shell to out.
+func printCompletion(shell string, out io.Writer) error {
+	scripts := map[string]string{
+		"bash": bashCompletion,
+		"zsh":  zshCompletion,
+		"fish": fishCompletion,
+	}
+	script, ok := scripts[shell]
+	if !ok {
+		return fmt.Errorf("unsupported shell: %s", shell)
+	}
+	_, err := io.WriteString(out, script)
+	return err
+}
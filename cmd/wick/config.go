@@ -0,0 +1,98 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/codebasepk/wick/wamp"
+)
+
+// applyConfigDefaults makes the defaults of the config file, if any, and
+// the values of the profile given in args or WICK_PROFILE the defaults of
+// the flags, so that the environment and the command line still take
+// precedence.
+func applyConfigDefaults(args []string) error {
+	profile, given := flagArg(args, "profile")
+	if !given {
+		profile = os.Getenv("WICK_PROFILE")
+	}
+	path := wamp.DefaultConfigFile()
+	if path == "" {
+		if profile != "" {
+			return fmt.Errorf("unknown profile %q, there is no config file", profile)
+		}
+		return nil
+	}
+	config, err := wamp.LoadConfig(path)
+	if os.IsNotExist(err) {
+		if profile != "" {
+			return fmt.Errorf("unknown profile %q, there is no config file %s", profile, path)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for command, flags := range config.Defaults {
+		var clause *kingpin.CmdClause
+		for i, name := range strings.Fields(command) {
+			if i == 0 {
+				clause = kingpin.CommandLine.GetCommand(name)
+			} else if clause != nil {
+				clause = clause.GetCommand(name)
+			}
+		}
+		if clause == nil {
+			return fmt.Errorf("%s: unknown command %q", path, command)
+		}
+		for name, values := range flags {
+			flag := clause.GetFlag(name)
+			if flag == nil {
+				return fmt.Errorf("%s: unknown flag --%s of %s", path, name, command)
+			}
+			flag.Default(values...)
+		}
+	}
+
+	if profile == "" {
+		return nil
+	}
+	flags, ok := config.Profiles[profile]
+	if !ok {
+		return fmt.Errorf("%s: unknown profile %q", path, profile)
+	}
+	for name, values := range flags {
+		flag := kingpin.CommandLine.GetFlag(name)
+		if flag == nil {
+			return fmt.Errorf("%s: unknown flag --%s of profile %s", path, name, profile)
+		}
+		flag.Default(values...)
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/codebasepk/wick/wamp"
+)
+
+// coordinatorFlags are the global flags that only apply to the coordinator
+// of a distributed run, as name and whether they take a value. They are not
+// sent to the workers, which run with them cleared.
+var coordinatorFlags = map[string]bool{
+	"workers":            true,
+	"coordinator":        true,
+	"quiet":              false,
+	"stats-file":         true,
+	"report-file":        true,
+	"report-samples":     false,
+	"baseline":           true,
+	"fail-on-regression": true,
+	"worker-stats":       true,
+	"worker-token":       true,
+}
+
+// workerCommands are the commands a worker runs, the ones --workers
+// supports.
+var workerCommands = map[string]bool{
+	"call":    true,
+	"publish": true,
+}
+
+// workerAllowedFlags are the only flags a worker accepts in the command line
+// of a job. The others could run commands, read and write files, listen or
+// use the sessions and credentials of the worker host on behalf of the
+// coordinator.
+var workerAllowedFlags = map[string]bool{
+	// Connection and authentication.
+	"url":                 true,
+	"realm":               true,
+	"authmethod":          true,
+	"authid":              true,
+	"authrole":            true,
+	"secret":              true,
+	"ticket":              true,
+	"oauth-token-url":     true,
+	"oauth-client-id":     true,
+	"oauth-client-secret": true,
+	"oauth-scope":         true,
+	"authextra":           true,
+	"agent-name":          true,
+	"serializer":          true,
+	"ws-compression":      true,
+	"ping-interval":       true,
+	"pong-timeout":        true,
+	"connect-timeout":     true,
+	"join-timeout":        true,
+	"e2ee-key":            true,
+	// Output, which the worker discards.
+	"debug":               true,
+	"log-format":          true,
+	"log-level":           true,
+	"error-exit-code-map": true,
+	"show-bandwidth":      true,
+	"binary-format":       true,
+	"payload-display":     true,
+	"raw-kwargs":          true,
+	"no-color":            true,
+	"compact":             true,
+	"no-history":          true,
+	"otel-key":            true,
+	"otel-in-options":     true,
+	"output":              true,
+	"extract":             true,
+	// The payload and options of calls and publishes.
+	"kwarg":             true,
+	"option":            true,
+	"arg-binary":        true,
+	"payload-size":      true,
+	"payload-shape":     true,
+	"payload-random":    true,
+	"timeout":           true,
+	"disclose-me":       true,
+	"dealer-timeout":    true,
+	"rkey":              true,
+	"runmode":           true,
+	"retry":             true,
+	"retry-on":          true,
+	"retry-backoff":     true,
+	"acknowledge":       true,
+	"exclude-me":        true,
+	"retain":            true,
+	"eligible":          true,
+	"exclude":           true,
+	"eligible-authid":   true,
+	"exclude-authid":    true,
+	"eligible-authrole": true,
+	"exclude-authrole":  true,
+	// The load.
+	"parallel":     true,
+	"stagger":      true,
+	"repeat":       true,
+	"total":        true,
+	"concurrency":  true,
+	"interval":     true,
+	"rate":         true,
+	"preserialize": true,
+}
+
+// workerArgs returns the command line of wick without the coordinator
+// flags, for the workers to run.
+func workerArgs(args []string) []string {
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			kept = append(kept, args[i:]...)
+			break
+		}
+		name := strings.TrimPrefix(arg, "--")
+		if name == arg {
+			kept = append(kept, arg)
+			continue
+		}
+		name = strings.SplitN(name, "=", 2)[0]
+		takesValue, ok := coordinatorFlags[name]
+		if !ok {
+			// A negated boolean flag, like --no-quiet.
+			_, ok = coordinatorFlags[strings.TrimPrefix(name, "no-")]
+		}
+		if !ok {
+			kept = append(kept, arg)
+			continue
+		}
+		if takesValue && !strings.Contains(arg, "=") {
+			i++
+		}
+	}
+	return kept
+}
+
+// checkWorkerJob returns an error unless args run a command of
+// workerCommands with only workerAllowedFlags, and read no files: kingpin
+// expands @file arguments and --arg-binary reads @file values.
+func checkWorkerJob(args []string) error {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "@") {
+			return fmt.Errorf("workers do not read files, like %s", arg)
+		}
+	}
+	// Parsing the context neither sets the flags nor runs their actions.
+	parsed, err := kingpin.CommandLine.ParseContext(joinFileArgs(normalizeBoolFlags(args)))
+	if err != nil {
+		return err
+	}
+	if parsed.SelectedCommand == nil || !workerCommands[parsed.SelectedCommand.FullCommand()] {
+		return errors.New("workers only run call and publish")
+	}
+	for _, element := range parsed.Elements {
+		flag, ok := element.Clause.(*kingpin.FlagClause)
+		if !ok {
+			continue
+		}
+		name := flag.Model().Name
+		if !workerAllowedFlags[name] {
+			return fmt.Errorf("workers do not run jobs with --%s", name)
+		}
+		if name == "arg-binary" && element.Value != nil && strings.HasPrefix(*element.Value, "@") {
+			return fmt.Errorf("workers do not read files, like --arg-binary %s", *element.Value)
+		}
+	}
+	return nil
+}
+
+// runCoordinator runs the call or publish of the command line from count
+// worker processes, forked unless they connect to listen, and prints and
+// writes the merged stats as output says. Workers connecting to listen must
+// know token, forked ones are given a random one.
+func runCoordinator(count int, listen string, token string, output runOutput, logger *logrus.Logger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	addr := listen
+	if addr == "" {
+		addr = "127.0.0.1:0"
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			return err
+		}
+		token = hex.EncodeToString(random)
+	} else if token == "" {
+		return errors.New("--coordinator needs a --worker-token shared with the workers")
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	var forked []*exec.Cmd
+	if listen == "" {
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			// The workers end with the connections to them, not killed, so
+			// that they stop their jobs.
+			worker := exec.Command(executable, "--log-level", *logLevel, "worker", listener.Addr().String())
+			// In the environment rather than the command line, which other
+			// local users can read.
+			worker.Env = append(os.Environ(), "WICK_WORKER_TOKEN="+token)
+			worker.Stderr = os.Stderr
+			if err = worker.Start(); err != nil {
+				return err
+			}
+			forked = append(forked, worker)
+		}
+	}
+
+	stats := wamp.NewStats()
+	workers, err := wamp.Coordinate(ctx, listener, count, workerArgs(os.Args[1:]), token, stats, logger)
+	for _, worker := range forked {
+		worker.Wait()
+	}
+	if !output.quiet && stats.Ops > 0 {
+		fmt.Fprintln(os.Stderr, stats.Summary())
+		for i, worker := range workers {
+			if worker.Stats != nil {
+				fmt.Fprintf(os.Stderr, "worker %d %s (%s): %s\n", i+1, worker.Hostname, worker.Addr,
+					worker.Stats.SessionSummary())
+			}
+		}
+	}
+	return writeRunOutput(stats, output, err)
+}
+
+// runWorker runs the jobs of the coordinator at addr, which must know token,
+// every one as a wick process with the coordinator flags cleared, whose
+// output is discarded but for its errors.
+func runWorker(addr string, token string, logger *logrus.Logger) error {
+	if token == "" {
+		return errors.New("wick worker needs the --worker-token of the coordinator")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return wamp.RunWorker(ctx, addr, token, logger, func(ctx context.Context, args []string) (*wamp.WorkerStats,
+		error) {
+
+		if err := checkWorkerJob(args); err != nil {
+			return nil, err
+		}
+		file, err := ioutil.TempFile("", "wick-worker-*.json")
+		if err != nil {
+			return nil, err
+		}
+		file.Close()
+		defer os.Remove(file.Name())
+
+		// Clearing the coordinator flags overrides the environment and the
+		// config file, which could start workers of their own. No profile
+		// lends the stored credentials of the worker host to the job.
+		cleared := []string{"--quiet", "--workers=0", "--coordinator=", "--stats-file=", "--report-file=",
+			"--no-report-samples", "--baseline=", "--fail-on-regression=", "--profile=",
+			"--worker-stats=" + file.Name()}
+		job := exec.Command(executable, append(cleared, args...)...)
+		job.Stderr = os.Stderr
+		if err = job.Start(); err != nil {
+			return nil, err
+		}
+		// Interrupted like with CTRL-c, the job still writes its stats.
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				if job.Process.Signal(os.Interrupt) != nil {
+					job.Process.Kill()
+				}
+			case <-done:
+			}
+		}()
+		runErr := job.Wait()
+		close(done)
+		var exitErr *exec.ExitError
+		if errors.As(runErr, &exitErr) {
+			runErr = fmt.Errorf("the job exited with code %d", exitErr.ExitCode())
+		}
+
+		data, err := ioutil.ReadFile(file.Name())
+		if err != nil || len(data) == 0 {
+			if runErr == nil {
+				runErr = errors.New("the job wrote no stats")
+			}
+			return nil, runErr
+		}
+		stats := &wamp.WorkerStats{}
+		if err = json.Unmarshal(data, stats); err != nil {
+			return nil, err
+		}
+		return stats, runErr
+	})
+}
@@ -0,0 +1,127 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultEnvFile is loaded, if present, when no --env-file is given.
+const defaultEnvFile = ".wick.env"
+
+// trustedVariables are only loaded from an env file given with --env-file,
+// not from the ./.wick.env of whatever directory wick runs in, like a cloned
+// repository. They run commands, carry credentials, or pick where the
+// credentials, the argv of workers and the written files go.
+var trustedVariables = map[string]bool{
+	"WICK_URL":                 true,
+	"WICK_PROXY":               true,
+	"WICK_SSH_TUNNEL":          true,
+	"WICK_AUTHMETHOD":          true,
+	"WICK_AUTHID":              true,
+	"WICK_TICKET":              true,
+	"WICK_TICKET_COMMAND":      true,
+	"WICK_SECRET":              true,
+	"WICK_PRIVATE_KEY":         true,
+	"WICK_CHANNEL_BINDING":     true,
+	"WICK_OAUTH_CLIENT_ID":     true,
+	"WICK_OAUTH_CLIENT_SECRET": true,
+	"WICK_OAUTH_TOKEN_URL":     true,
+	"WICK_OAUTH_SCOPE":         true,
+	"WICK_CONFIG":              true,
+	"WICK_PLUGIN_DIR":          true,
+	"WICK_DAEMON_SOCKET":       true,
+	"WICK_COORDINATOR":         true,
+	"WICK_WORKER_TOKEN":        true,
+	"WICK_METRICS_LISTEN":      true,
+	"WICK_TRACE_FILE":          true,
+	"WICK_REPORT_FILE":         true,
+	"WICK_STATS_FILE":          true,
+}
+
+// flagArg returns the value of the flag name in args, for the flags that
+// must be known before the flags are parsed.
+func flagArg(args []string, name string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "--"+name+"=") {
+			return strings.TrimPrefix(arg, "--"+name+"="), true
+		}
+		if arg == "--"+name && i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+// loadEnvFile sets the WICK_* variables of the env file given in args, or
+// of ./.wick.env if there is one, without its trustedVariables. Variables
+// already set in the environment are kept, like with other dotenv tools.
+func loadEnvFile(args []string) error {
+	path, given := flagArg(args, "env-file")
+	if !given {
+		path = defaultEnvFile
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) && !given {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%s:%d: expected NAME=value", path, number)
+		}
+		name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if !strings.HasPrefix(name, "WICK_") {
+			continue
+		}
+		if !given && trustedVariables[name] {
+			fmt.Fprintf(os.Stderr, "wick: warning: %s:%d: ignoring %s, only --env-file may set it\n", path,
+				number, name)
+			continue
+		}
+		if _, ok := os.LookupEnv(name); !ok {
+			os.Setenv(name, value)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,93 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/gammazero/nexus/v3/client"
+	nxwamp "github.com/gammazero/nexus/v3/wamp"
+
+	"github.com/codebasepk/wick/wamp"
+)
+
+// Exit codes of wick, these are documented in the README.
+const (
+	exitOK                = 0
+	exitError             = 1
+	exitConnectionFailure = 2
+	exitAuthFailure       = 3
+	exitCallError         = 4
+	exitTimeout           = 5
+	exitCanceled          = 6
+	exitRegression        = 7
+)
+
+// parseExitCodeMap validates the --error-exit-code-map values.
+func parseExitCodeMap(raw map[string]string) (map[string]int, error) {
+	codes := map[string]int{}
+	for uri, value := range raw {
+		code, err := strconv.Atoi(value)
+		if err != nil || code < 0 || code > 255 {
+			return nil, errors.New("invalid exit code for " + uri + ": " + value)
+		}
+		codes[uri] = code
+	}
+	return codes, nil
+}
+
+// exitCode returns the exit code for err. WAMP error URIs found in
+// errorCodes take precedence over the builtin classification.
+func exitCode(err error, errorCodes map[string]int) int {
+	if err == nil {
+		return exitOK
+	}
+
+	uri := wamp.ErrorURI(err)
+	if code, ok := errorCodes[uri]; ok && uri != "" {
+		return code
+	}
+
+	var connectErr *wamp.ConnectError
+	var joinErr *wamp.JoinError
+	var regressionErr *wamp.RegressionError
+	switch {
+	case errors.As(err, &regressionErr):
+		return exitRegression
+	case errors.As(err, &connectErr):
+		return exitConnectionFailure
+	case errors.As(err, &joinErr) && !errors.Is(err, context.DeadlineExceeded):
+		return exitAuthFailure
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, client.ErrReplyTimeout),
+		uri == "wamp.error.timeout":
+		return exitTimeout
+	case errors.Is(err, context.Canceled), uri == string(nxwamp.ErrCanceled):
+		return exitCanceled
+	case uri != "":
+		return exitCallError
+	}
+
+	return exitError
+}
@@ -0,0 +1,90 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/codebasepk/wick/wamp"
+)
+
+// recordHistory adds the topic or procedure of cmd to the history, unless
+// --no-history is given.
+func recordHistory(cmd string, logger *logrus.Logger) {
+	path := wamp.DefaultHistoryFile()
+	if *noHistory || path == "" {
+		return
+	}
+
+	var kind, uri string
+	switch cmd {
+	case subscribe.FullCommand():
+		kind, uri = wamp.HistoryTopic, *subscribeTopic
+	case waitEvent.FullCommand():
+		kind, uri = wamp.HistoryTopic, *waitEventTopic
+	case publish.FullCommand():
+		kind, uri = wamp.HistoryTopic, *publishTopic
+	case register.FullCommand():
+		kind, uri = wamp.HistoryProcedure, *registerProcedure
+	case call.FullCommand():
+		kind, uri = wamp.HistoryProcedure, *callProcedure
+	default:
+		return
+	}
+	if err := wamp.NewHistory(path).Record(kind, uri); err != nil {
+		logger.Debug("failed to record history: ", err)
+	}
+}
+
+// printHistory prints the history as asked by the history command.
+func printHistory() error {
+	path := wamp.DefaultHistoryFile()
+	if path == "" {
+		return errors.New("no home directory to keep the history in")
+	}
+	entries, err := wamp.NewHistory(path).Entries(*historyKind, *historySearch)
+	if err != nil {
+		return err
+	}
+	if *historyLimit > 0 && len(entries) > *historyLimit {
+		entries = entries[:*historyLimit]
+	}
+	return wamp.PrintHistory(entries, os.Stdout)
+}
+
+// historyHints completes the URIs of kind from the history, most used
+// first.
+func historyHints(kind string) kingpin.HintAction {
+	return func() []string {
+		entries, _ := wamp.NewHistory(wamp.DefaultHistoryFile()).Entries(kind, "")
+		uris := make([]string, len(entries))
+		for i, entry := range entries {
+			uris[i] = entry.URI
+		}
+		return uris
+	}
+}
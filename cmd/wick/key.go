@@ -0,0 +1,163 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/codebasepk/wick/wamp"
+)
+
+// runKey runs the key subcommand cmd, printing to out.
+func runKey(cmd string, out io.Writer) error {
+	switch cmd {
+	case keyGenerate.FullCommand():
+		pvk, err := wamp.GenerateKey()
+		if err != nil {
+			return err
+		}
+		pub, err := wamp.FormatPublicKey(wamp.PublicKey(pvk), *keyGenerateFormat)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "private-key: %s\npublic-key: %s\n", hex.EncodeToString(pvk.Seed()), pub)
+		if err != nil {
+			return err
+		}
+		if *keyGenerateQR {
+			return wamp.WriteQR(out, pub)
+		}
+		return nil
+	case keyDerive.FullCommand():
+		if *privateKey == "" {
+			return errors.New("key derive needs --private-key")
+		}
+		pvk, err := wamp.LoadPrivateKey(*privateKey)
+		if err != nil {
+			return err
+		}
+		pub, err := wamp.FormatPublicKey(wamp.PublicKey(pvk), *keyDeriveFormat)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintln(out, pub); err != nil {
+			return err
+		}
+		if *keyDeriveQR {
+			return wamp.WriteQR(out, pub)
+		}
+		return nil
+	case keyVerify.FullCommand():
+		pub, err := wamp.ParsePublicKey(*keyVerifyPublicKey)
+		if err != nil {
+			return err
+		}
+		message, err := keyMessage()
+		if err != nil {
+			return err
+		}
+		valid, err := wamp.VerifySignature(pub, message, *keyVerifySignature)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return errors.New("signature does not match the message and public key")
+		}
+		_, err = fmt.Fprintln(out, "signature is valid")
+		return err
+	}
+	return nil
+}
+
+// exportProfile prints the connection settings as asked by profile export.
+func exportProfile(out io.Writer) error {
+	cfg := wamp.ClientConfig{
+		URL:        *url,
+		Realm:      firstRealm(),
+		AuthMethod: *authMethod,
+		AuthID:     *authid,
+		AuthRole:   *authrole,
+		Ticket:     *ticket,
+		Secret:     *secret,
+		PrivateKey: *privateKey,
+	}
+	profile, err := wamp.NewProfile(cfg, *serializer, *profileExportCredentials).Export(*profileExportFormat)
+	if err != nil {
+		return err
+	}
+	if *profileExportQR {
+		return wamp.WriteQR(out, profile)
+	}
+	_, err = fmt.Fprintln(out, profile)
+	return err
+}
+
+// importProfile prints the profile of the file given to profile import.
+func importProfile(out io.Writer) error {
+	data, err := ioutil.ReadFile(*profileImportFile)
+	if err != nil {
+		return err
+	}
+	profile, _, err := wamp.ImportProfile(data)
+	if err != nil {
+		return err
+	}
+	if *profileImportEnv {
+		_, err = fmt.Fprint(out, profile.Env())
+		return err
+	}
+	text, err := profile.JSON()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, text)
+	return err
+}
+
+// keyMessage returns the message to verify, from the argument or else stdin.
+func keyMessage() ([]byte, error) {
+	var message []byte
+	if *keyVerifyMessage != "" {
+		message = []byte(*keyVerifyMessage)
+	} else {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		message = data
+	}
+	if *keyVerifyHex {
+		decoded, err := hex.DecodeString(strings.TrimSpace(string(message)))
+		if err != nil {
+			return nil, errors.New("message is not hex")
+		}
+		return decoded, nil
+	}
+	return message, nil
+}
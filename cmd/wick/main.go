@@ -23,11 +23,24 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
 	"github.com/gammazero/nexus/v3/client"
 	"github.com/gammazero/nexus/v3/transport/serialize"
+	nxwamp "github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
 	"gopkg.in/alecthomas/kingpin.v2"
-	"log"
-	"os"
 
 	"github.com/codebasepk/wick/wamp"
 )
@@ -35,56 +48,648 @@ import (
 var (
 	url = kingpin.Flag("url", "WAMP URL to connect to").
 		Default("ws://localhost:8080/ws").Envar("WICK_URL").String()
-	realm      = kingpin.Flag("realm", "The WAMP realm to join").Default("realm1").
-		Envar("WICK_REALM").String()
-	authMethod = kingpin.Flag("authmethod", "The authentication method to use").Envar("WICK_AUTHMETHOD").
-			Default("anonymous").Enum("anonymous", "ticket", "wampcra", "cryptosign")
-	authid   = kingpin.Flag("authid", "The authid to use, if authenticating").Envar("WICK_AUTHID").
+	realm = kingpin.Flag("realm", "The WAMP realm to join, publish and call can be repeated or given "+
+		"several comma separated realms to run on each").Default("realm1").Envar("WICK_REALM").Strings()
+	authMethod = kingpin.Flag("authmethod", "The authentication method to use, anonymous, ticket, wampcra, "+
+		"cryptosign or a registered one").Envar("WICK_AUTHMETHOD").Default("anonymous").
+		HintAction(wamp.AuthMethods).String()
+	authid = kingpin.Flag("authid", "The authid to use, if authenticating").Envar("WICK_AUTHID").
 		String()
 	authrole = kingpin.Flag("authrole", "The authrole to use, if authenticating").
-		Envar("WICK_AUTHROLE").String()
-	secret   = kingpin.Flag("secret", "The secret to use in Challenge-Response Auth.").
-			Envar("WICK_SECRET").String()
-	privateKey = kingpin.Flag("private-key", "The ed25519 private key hex for cryptosign").
-			Envar("WICK_PRIVATE_KEY").String()
-	ticket     = kingpin.Flag("ticket", "The ticket when using ticket authentication").
+			Envar("WICK_AUTHROLE").String()
+	secret = kingpin.Flag("secret", "The secret to use in Challenge-Response Auth.").
+		Envar("WICK_SECRET").String()
+	privateKey = kingpin.Flag("private-key", "The ed25519 private key hex for cryptosign, key derive also "+
+		"takes a file").Envar("WICK_PRIVATE_KEY").String()
+	agentName = kingpin.Flag("agent-name", "Label the sessions NAME-1, NAME-2... in HELLO, wick-HOST-PID if not given").
+			PlaceHolder("NAME").Envar("WICK_AGENT_NAME").String()
+	channelBinding = kingpin.Flag("channel-binding", "Bind cryptosign signatures to the TLS connection").
+			Envar("WICK_CHANNEL_BINDING").Enum(wamp.ChannelBindingTLSUnique, wamp.ChannelBindingTLSExporter)
+	ticket = kingpin.Flag("ticket", "The ticket when using ticket authentication").
 		Envar("WICK_TICKET").String()
+	ticketCommand = kingpin.Flag("ticket-command", "Shell command printing a fresh ticket for every connect").
+			Envar("WICK_TICKET_COMMAND").String()
+	oauthTokenURL = kingpin.Flag("oauth-token-url", "Fetch the ticket for every connect as an OAuth access "+
+		"token from this endpoint, with the client credentials grant").Envar("WICK_OAUTH_TOKEN_URL").String()
+	oauthClientID = kingpin.Flag("oauth-client-id", "The OAuth client ID").Envar("WICK_OAUTH_CLIENT_ID").
+			String()
+	oauthClientSecret = kingpin.Flag("oauth-client-secret", "The OAuth client secret").
+				Envar("WICK_OAUTH_CLIENT_SECRET").String()
+	oauthScope = kingpin.Flag("oauth-scope", "The scopes of the OAuth access token, space separated").
+			Envar("WICK_OAUTH_SCOPE").String()
+	authExtra = kingpin.Flag("authextra", "Send this authextra in HELLO, as key=value, JSON objects and "+
+		"arrays decoded").StringMap()
 	serializer = kingpin.Flag("serializer", "The serializer to use").Envar("WICK_SERIALIZER").
-		Default("json").Enum("json", "msgpack", "cbor")
+			Default("json").Enum("json", "msgpack", "cbor")
+	debug     = kingpin.Flag("debug", "Enable debug logging").Envar("WICK_DEBUG").Bool()
+	logFormat = kingpin.Flag("log-format", "The format of log messages").Envar("WICK_LOG_FORMAT").
+			Default("text").Enum("text", "json")
+	logLevel = kingpin.Flag("log-level", "The minimum level of log messages to print").Envar("WICK_LOG_LEVEL").
+			Default("info").Enum("debug", "info", "warn", "error")
+	pingInterval = kingpin.Flag("ping-interval", "Interval between websocket pings, 0 disables keepalive").
+			Envar("WICK_PING_INTERVAL").Default("0s").Duration()
+	pongTimeout = kingpin.Flag("pong-timeout", "Time to wait for a pong before closing the connection").
+			Envar("WICK_PONG_TIMEOUT").Default("10s").Duration()
+	connectTimeout = kingpin.Flag("connect-timeout", "Time to establish the connection to the router, 0 waits forever").
+			Envar("WICK_CONNECT_TIMEOUT").Default("10s").Duration()
+	joinTimeout = kingpin.Flag("join-timeout", "Time for the router to answer the join of the realm, 0 waits forever").
+			Envar("WICK_JOIN_TIMEOUT").Default("10s").Duration()
+	trace       = kingpin.Flag("trace", "Print every WAMP message sent and received").Envar("WICK_TRACE").Bool()
+	traceFormat = kingpin.Flag("trace-format", "The format of traced messages").Envar("WICK_TRACE_FORMAT").
+			Default("text").Enum("text", "json")
+	traceFile = kingpin.Flag("trace-file", "Write traced messages to a file instead of stderr, implies --trace").
+			Envar("WICK_TRACE_FILE").String()
+	traceSecrets = kingpin.Flag("trace-secrets", "Trace tickets and authentication signatures instead of "+
+		"redacting them").Envar("WICK_TRACE_SECRETS").Bool()
+	errorExitCodeMap = kingpin.Flag("error-exit-code-map", "Exit with a custom code on a WAMP error, as uri=code").
+				Envar("WICK_ERROR_EXIT_CODE_MAP").StringMap()
+	e2eeKey = kingpin.Flag("e2ee-key", "32 bytes hex key to end-to-end encrypt and decrypt payloads, as "+
+		"WAMP cryptobox").Envar("WICK_E2EE_KEY").String()
+	proxy = kingpin.Flag("proxy", "HTTP proxy URL to tunnel websocket connections through").
+		Envar("WICK_PROXY").String()
+	socks5 = kingpin.Flag("socks5", "SOCKS5 proxy to dial the router through, as [user:password@]host:port").
+		Envar("WICK_SOCKS5").String()
+	sshTunnel = kingpin.Flag("ssh-tunnel", "SSH server to tunnel the connection through, as [user@]host[:port]").
+			Envar("WICK_SSH_TUNNEL").String()
+	quiet         = kingpin.Flag("quiet", "Do not print the progress of repeated runs").Envar("WICK_QUIET").Bool()
+	wsCompression = kingpin.Flag("ws-compression", "Negotiate permessage-deflate compression of websocket "+
+		"connections, or accept it with router start").Envar("WICK_WS_COMPRESSION").Bool()
+	showBandwidth = kingpin.Flag("show-bandwidth", "Print the bytes sent and received on exit, per "+
+		"operation for repeated runs").Envar("WICK_SHOW_BANDWIDTH").Bool()
+	statsFile = kingpin.Flag("stats-file", "Write the stats of repeated runs as JSON to this file").
+			Envar("WICK_STATS_FILE").String()
+	reportFile = kingpin.Flag("report-file", "Write the results of repeated runs to this .json or .csv file, "+
+		"a CSV file of stats gets a row per run").Envar("WICK_REPORT_FILE").String()
+	reportSamples = kingpin.Flag("report-samples", "Write every operation to --report-file rather than the stats").
+			Envar("WICK_REPORT_SAMPLES").Bool()
+	baselineFile = kingpin.Flag("baseline", "Compare repeated runs with this JSON report or stats file of a "+
+		"previous run").Envar("WICK_BASELINE").String()
+	workers = kingpin.Flag("workers", "Run repeated calls and publishes from this many worker processes at "+
+		"once, merging their stats").Envar("WICK_WORKERS").Int()
+	coordinator = kingpin.Flag("coordinator", "Wait for the --workers on this address, like :9000, started "+
+		"with wick worker, rather than forking them").Envar("WICK_COORDINATOR").String()
+	workerToken = kingpin.Flag("worker-token", "The secret shared by the coordinator and the workers started "+
+		"with wick worker").Envar("WICK_WORKER_TOKEN").String()
+	workerStatsFile = kingpin.Flag("worker-stats", "Write the stats of repeated runs for the coordinator "+
+		"to this file").Hidden().String()
+	failOnRegression = kingpin.Flag("fail-on-regression", "Fail when the rate drops or the p99 latency grows "+
+		"by more than this percentage of --baseline, like 10%").Envar("WICK_FAIL_ON_REGRESSION").String()
+	metricsListen = kingpin.Flag("metrics-listen", "Expose Prometheus metrics on this address, like :9464").
+			Envar("WICK_METRICS_LISTEN").String()
+	otel = kingpin.Flag("otel", "Export OpenTelemetry spans of connects, calls and publishes over OTLP").
+		Envar("WICK_OTEL").Bool()
+	otelKey = kingpin.Flag("otel-key", "The kwarg, or option, that carries the trace context").
+		Envar("WICK_OTEL_KEY").Default("trace_context").String()
+	otelInOptions = kingpin.Flag("otel-in-options", "Carry the trace context in options instead of kwargs").
+			Envar("WICK_OTEL_IN_OPTIONS").Bool()
+	binaryFormat = kingpin.Flag("binary-format", "How to print received binary payloads").
+			Envar("WICK_BINARY_FORMAT").Default(wamp.BinaryFormatBase64).
+			Enum(wamp.BinaryFormatBase64, wamp.BinaryFormatHex)
+	payloadDisplay = kingpin.Flag("payload-display", "How much of received payloads to print: full, "+
+		"truncate:N, size-only or hexdump").Envar("WICK_PAYLOAD_DISPLAY").Default(wamp.DisplayFull).String()
+	rawKwargs = kingpin.Flag("raw-kwargs", "Send JSON objects and arrays given as kwarg values as strings").
+			Envar("WICK_RAW_KWARGS").Bool()
+	noColor = kingpin.Flag("no-color", "Do not colorize JSON output on terminals").Envar("WICK_NO_COLOR").Bool()
+	compact = kingpin.Flag("compact", "Print JSON output on a single line").Envar("WICK_COMPACT").Bool()
+	// The env file is loaded before parsing by loadEnvFile, the flag is
+	// declared for --help and to be accepted.
+	_ = kingpin.Flag("env-file", "Load WICK_* variables from this file, ./.wick.env if present").
+		String()
+	// Likewise, the profile is applied by applyConfigDefaults.
+	_ = kingpin.Flag("profile", "Use the flag values of this profile of the config file").
+		Envar("WICK_PROFILE").HintAction(profileHints).String()
+	noHistory = kingpin.Flag("no-history", "Do not record the topics and procedures used in ~/.wick/history").
+			Envar("WICK_NO_HISTORY").Bool()
+	daemonSocket = kingpin.Flag("daemon-socket", "The control socket of wick daemon").
+			Envar("WICK_DAEMON_SOCKET").Default(wamp.DefaultDaemonSocket()).String()
 
 	subscribe      = kingpin.Command("subscribe", "subscribe a topic.")
-	subscribeTopic = subscribe.Arg("topic", "Topic to subscribe to").Required().String()
+	subscribeTopic = subscribe.Arg("topic", "Topic to subscribe to").Required().
+			HintAction(historyHints(wamp.HistoryTopic)).String()
+	subscribeLimit = subscribe.Flag("limit", "Exit after this many events").Int()
+	subscribeTime  = subscribe.Flag("duration", "Exit after this time, with an error if no event was received").
+			Duration()
+	subscribeExec = subscribe.Flag("exec", "Run this shell command for every event, with the event JSON on stdin").
+			String()
+	subscribeConcurrency = subscribe.Flag("concurrency", "How many --exec commands may run at once").
+				Default("1").Int()
+	subscribeExpire  = subscribe.Flag("expire", "Unsubscribe and exit after this time without events").Duration()
+	subscribeExtract = subscribe.Flag("extract", "Print only this value of every event, like '.kwargs.temperature'").
+				String()
+	subscribeOptions = subscribe.Flag("option", "give a SUBSCRIBE option, as key=value").Short('o').StringMap()
+	subscribeBuffer  = subscribe.Flag("buffer-size", "Queue this many events waiting to be printed and run, "+
+		"0 handles each event before receiving the next").Int()
+	subscribeOverflow = subscribe.Flag("on-overflow", "What to do with events received while the queue is full").
+				Default(wamp.OverflowBlock).Enum(wamp.OverflowBlock, wamp.OverflowDrop)
+	subscribeStats = subscribe.Flag("stats", "Print the counts, rate, publishers and payload sizes of the "+
+		"events every this interval instead of the events").Duration()
+	subscribeRecordDir = subscribe.Flag("record-dir", "Also record the events to gzip compressed JSON lines "+
+		"files in this directory").String()
+	subscribeRotateSize = subscribe.Flag("rotate-size", "Start a new recording file at this size, like 100MB").
+				String()
+	subscribeRotateInterval = subscribe.Flag("rotate-interval", "Start a new recording file after this time").
+				Duration()
+	subscribeGetRetained = subscribe.Flag("get-retained", "Also receive the retained event of the topic, if any").
+				Bool()
+	subscribeDedupe    = subscribe.Flag("dedupe", "Skip the events whose key was seen within this time").Duration()
+	subscribeDedupeKey = subscribe.Flag("dedupe-key", "The value that identifies duplicate events, like "+
+		"'kwargs.id', the whole payload if not given").String()
+
+	waitEvent      = kingpin.Command("wait-event", "Wait for an event matching the given args and kwargs.")
+	waitEventTopic = waitEvent.Arg("topic", "Topic to wait on").Required().
+			HintAction(historyHints(wamp.HistoryTopic)).String()
+	waitEventTimeout     = waitEvent.Flag("timeout", "Give up waiting after this time, 0 waits forever").Duration()
+	waitEventMatchArgs   = waitEvent.Flag("match-args", "The value of the next event arg to match").Strings()
+	waitEventMatchKwargs = waitEvent.Flag("match-kwargs", "The value of an event kwarg to match, as key=value").
+				StringMap()
 
-	publish            = kingpin.Command("publish", "Publish to a topic.")
-	publishTopic       = publish.Arg("topic", "topic name").Required().String()
-	publishArgs        = publish.Arg("args", "give the arguments").Strings()
-	publishKeywordArgs = publish.Flag("kwarg", "give the keyword arguments").Short('k').StringMap()
+	publish      = kingpin.Command("publish", "Publish to a topic.")
+	publishTopic = publish.Arg("topic", "topic name").Required().
+			HintAction(historyHints(wamp.HistoryTopic)).String()
+	publishArgs         = publish.Arg("args", "give the arguments").Strings()
+	publishKeywordArgs  = publish.Flag("kwarg", "give the keyword arguments").Short('k').StringMap()
+	publishBinaryArgs   = publish.Flag("arg-binary", "give a binary argument, as @file or base64").Strings()
+	publishPayloadSize  = publish.Flag("payload-size", "Add a generated argument of this size, like 4KB").String()
+	publishPayloadShape = publish.Flag("payload-shape", "The shape of the --payload-size argument").
+				Default(wamp.PayloadShapeString).Enum(wamp.PayloadShapes...)
+	publishPayloadRandom = publish.Flag("payload-random", "Fill the --payload-size argument with random "+
+		"content that does not compress").Bool()
+	publishAcknowledge = publish.Flag("acknowledge", "Wait for the router to acknowledge the publication").
+				Default("true").Bool()
+	publishExcludeMe = publish.Flag("exclude-me", "Do not receive the event on this session if subscribed").
+				Default("true").Bool()
+	publishDiscloseMe       = publish.Flag("disclose-me", "Ask the router to disclose the publisher identity").Bool()
+	publishRetain           = publish.Flag("retain", "Ask the router to keep the event for later subscribers").Bool()
+	publishEligible         = publish.Flag("eligible", "Only deliver to this session ID").Uint64List()
+	publishExclude          = publish.Flag("exclude", "Do not deliver to this session ID").Uint64List()
+	publishEligibleAuthID   = publish.Flag("eligible-authid", "Only deliver to sessions with this authid").Strings()
+	publishExcludeAuthID    = publish.Flag("exclude-authid", "Do not deliver to sessions with this authid").Strings()
+	publishEligibleAuthRole = publish.Flag("eligible-authrole", "Only deliver to sessions with this authrole").
+				Strings()
+	publishExcludeAuthRole = publish.Flag("exclude-authrole", "Do not deliver to sessions with this authrole").
+				Strings()
+	publishParallel     = publish.Flag("parallel", "Publish from this many sessions at once").Default("1").Int()
+	publishRepeat       = publish.Flag("repeat", "Publish this many times from every session").Default("1").Int()
+	publishPreserialize = publish.Flag("preserialize", "Prepare the payload once for all --repeat publishes "+
+		"and do not print every publish, for high rates").Bool()
+	publishConcurrency = publish.Flag("concurrency", "How many publishes of --repeat every session keeps in "+
+		"flight at once").Default("1").Int()
+	publishFromStdin = publish.Flag("from-stdin", "Publish one event per line read from stdin").Bool()
+	publishFormat    = publish.Flag("format", "The format of lines read with --from-stdin").
+				Default(wamp.StreamFormatJSONL).Enum(wamp.StreamFormatJSONL, wamp.StreamFormatLines)
+	publishRate = publish.Flag("rate", "Publish at most this many events per second with --from-stdin, 0 "+
+		"is unlimited").Float64()
+	publishSchema = publish.Flag("schema", "Validate events against this JSON Schema before publishing").
+			ExistingFile()
+	publishUseDaemon = publish.Flag("use-daemon", "Publish through the session of wick daemon").Bool()
+	publishOptions   = publish.Flag("option", "give a PUBLISH option, as key=value").Short('o').StringMap()
 
 	register          = kingpin.Command("register", "Register a procedure.")
-	registerProcedure = register.Arg("procedure", "procedure name").Required().String()
-	onInvocationCmd   = register.Arg("command", "Shell command to run and return it's output").String()
+	registerProcedure = register.Arg("procedure", "procedure name").Required().
+				HintAction(historyHints(wamp.HistoryProcedure)).String()
+	onInvocationCmd  = register.Arg("command", "Shell command to run and return it's output").String()
+	registerParallel = register.Flag("parallel", "Register from this many sessions sharing the registration").
+				Default("1").Int()
+	registerInvoke = register.Flag("invoke", "The invocation policy of a shared registration, roundrobin "+
+		"with --parallel").Enum(wamp.InvokeSingle, wamp.InvokeRoundRobin, wamp.InvokeRandom, wamp.InvokeFirst,
+		wamp.InvokeLast)
+	registerSchema        = register.Flag("schema", "Reject invocations not matching this JSON Schema").ExistingFile()
+	registerMaxConcurrent = register.Flag("max-concurrent-invocations", "How many commands may run at once, "+
+		"per session, 0 is unlimited").Int()
+	registerTimeout = register.Flag("invocation-timeout", "Kill commands running longer and answer with "+
+		"wamp.error.timeout, 0 waits forever").Duration()
+	registerDrainTimeout = register.Flag("drain-timeout", "On CTRL-c or SIGTERM, how long running commands may "+
+		"finish before they are killed, 0 waits until a second signal").Default("30s").Duration()
+	registerExpire  = register.Flag("expire", "Unregister and exit after this time without invocations").Duration()
+	registerOptions = register.Flag("option", "give a REGISTER option, as key=value").Short('o').StringMap()
+	registerForce   = register.Flag("force-reregister", "Take the procedure over from the callee that has it "+
+		"registered, and exit with an error once taken over").Bool()
+	registerScript = register.Flag("script", "Answer invocations in process with the handle function of this "+
+		"Starlark file, instead of a command").ExistingFile()
+
+	call          = kingpin.Command("call", "Call a procedure.")
+	callProcedure = call.Arg("procedure", "Procedure to call").Required().
+			HintAction(historyHints(wamp.HistoryProcedure)).String()
+	callArgs         = call.Arg("args", "give the arguments").Strings()
+	callKeywordArgs  = call.Flag("kwarg", "give the keyword arguments").Short('k').StringMap()
+	callOptions      = call.Flag("option", "give a CALL option, as key=value").Short('o').StringMap()
+	callBinaryArgs   = call.Flag("arg-binary", "give a binary argument, as @file or base64").Strings()
+	callPayloadSize  = call.Flag("payload-size", "Add a generated argument of this size, like 4KB").String()
+	callPayloadShape = call.Flag("payload-shape", "The shape of the --payload-size argument").
+				Default(wamp.PayloadShapeString).Enum(wamp.PayloadShapes...)
+	callPayloadRandom = call.Flag("payload-random", "Fill the --payload-size argument with random "+
+		"content that does not compress").Bool()
+	callTimeout = call.Flag("timeout", "Cancel the call if no result arrived in time, or stop "+
+		"polling with --until, 0 waits forever").Default("0s").Duration()
+	callDiscloseMe    = call.Flag("disclose-me", "Ask the router to disclose the caller identity").Bool()
+	callDealerTimeout = call.Flag("dealer-timeout", "Ask the router to cancel the call if no result arrived "+
+		"in time, in milliseconds or like 5s").String()
+	callRKey    = call.Flag("rkey", "The routing key of the shard to call of a sharded registration").String()
+	callRunMode = call.Flag("runmode", "Call the shard of --rkey, the default with --rkey, or all shards").
+			Enum(wamp.RunModePartition, wamp.RunModeAll)
+	callParallel = call.Flag("parallel", "Call from this many sessions at once").Default("1").Int()
+	callStagger  = call.Flag("stagger", "Time between the joins of the --parallel sessions").Duration()
+	callRepeat   = call.Flag("repeat", "Call this many times from every session").Default("1").Int()
+	callTotal    = call.Flag("total", "Call this many times in all, round-robin over the sessions, "+
+		"instead of --repeat").Int()
+	callConcurrency = call.Flag("concurrency", "How many calls of --repeat or --total every session keeps in "+
+		"flight at once").Default("1").Int()
+	callUntil = call.Flag("until", "Repeat the call until its result matches this condition, like "+
+		"'kwargs.state == \"ready\"'").String()
+	callInterval = call.Flag("interval", "Time between calls with --until").Default("1s").Duration()
+	callWatch    = call.Flag("watch", "Repeat the call on this interval and redraw its result").Duration()
+	callDiff     = call.Flag("differences", "Highlight the changes between results with --watch").Short('d').Bool()
+	callSchema   = call.Flag("schema", "Validate the arguments against this JSON Schema before calling").
+			ExistingFile()
+	callRetry   = call.Flag("retry", "Try a failed call again this many times").Int()
+	callRetryOn = call.Flag("retry-on", "Only retry calls failing with these error URIs, comma separated").
+			Strings()
+	callRetryBackoff = call.Flag("retry-backoff", "Time before the first retry, doubled for every next one").
+				Default("500ms").Duration()
+	callExtract   = call.Flag("extract", "Print only this value of the result, like '.kwargs.token'").String()
+	callUseDaemon = call.Flag("use-daemon", "Call through the session of wick daemon").Bool()
+	callOutput    = call.Flag("output", "Print the first result argument, or the args and kwargs as JSON").
+			Default(wamp.OutputText).Enum(wamp.OutputText, wamp.OutputJSON)
+
+	run     = kingpin.Command("run", "Run a scenario of sessions, calls and publishes described in a YAML file.")
+	runFile = run.Arg("scenario", "The scenario file").Required().ExistingFile()
+
+	mockCmd  = kingpin.Command("mock", "Register procedures answering with the canned responses of a YAML file.")
+	mockFile = mockCmd.Arg("mocks", "The mock file").Required().ExistingFile()
+
+	verifyCmd  = kingpin.Command("verify", "Check that the calls of a YAML file of contracts answer as expected.")
+	verifyFile = verifyCmd.Arg("contracts", "The contracts file").Required().ExistingFile()
+
+	scriptCmd  = kingpin.Command("script", "Run a Starlark script calling, publishing, subscribing and registering.")
+	scriptFile = scriptCmd.Arg("script", "The Starlark file").Required().ExistingFile()
+	scriptArgs = scriptCmd.Arg("args", "The argv of the script").Strings()
+
+	daemon = kingpin.Command("daemon", "Keep a session open for call, publish and testament --use-daemon.")
+
+	testamentCmd       = kingpin.Command("testament", "Have the router publish an event when the session ends.")
+	testamentAdd       = testamentCmd.Command("add", "Add a testament, then keep the session joined until CTRL-c.")
+	testamentAddTopic  = testamentAdd.Arg("topic", "The topic to publish to").Required().String()
+	testamentAddArgs   = testamentAdd.Arg("args", "give the arguments").Strings()
+	testamentAddKwargs = testamentAdd.Flag("kwarg", "give the keyword arguments").Short('k').StringMap()
+	testamentAddScope  = testamentAdd.Flag("scope", "Publish when the session is destroyed or detached").
+				Default(wamp.TestamentDestroyed).Enum(wamp.TestamentDestroyed, wamp.TestamentDetached)
+	testamentAddOptions   = testamentAdd.Flag("option", "give a PUBLISH option, as key=value").Short('o').StringMap()
+	testamentAddUseDaemon = testamentAdd.Flag("use-daemon", "Add it to the session of wick daemon and return").Bool()
+	testamentFlush        = testamentCmd.Command("flush", "Remove the testaments added to the session of wick daemon.")
+	testamentFlushScope   = testamentFlush.Flag("scope", "Remove the testaments of this scope").
+				Default(wamp.TestamentDestroyed).Enum(wamp.TestamentDestroyed, wamp.TestamentDetached)
+
+	gateway       = kingpin.Command("gateway", "Expose calls, publishes and subscriptions over HTTP.")
+	gatewayListen = gateway.Flag("listen", "The address to serve HTTP on").Default(":8000").String()
+
+	bridge          = kingpin.Command("bridge", "Forward events from one router or realm to another.")
+	bridgeFromURL   = bridge.Flag("from-url", "WAMP URL to forward events from, --url if not given").String()
+	bridgeFromRealm = bridge.Flag("from-realm", "The realm to forward events from, --realm if not given").String()
+	bridgeToURL     = bridge.Flag("to-url", "WAMP URL to forward events to, --url if not given").String()
+	bridgeToRealm   = bridge.Flag("to-realm", "The realm to forward events to, --realm if not given").String()
+	bridgeTopic     = bridge.Flag("topic", "The topic, or topic prefix or pattern, to forward").Required().String()
+	bridgeMatch     = bridge.Flag("match", "How --topic matches the topics to forward").Default(nxwamp.MatchPrefix).
+			Enum(nxwamp.MatchExact, nxwamp.MatchPrefix, nxwamp.MatchWildcard)
+	bridgeRewrite = bridge.Flag("rewrite", "Publish topics starting with a prefix with another prefix, as "+
+		"old=new").StringMap()
+
+	pingCmd          = kingpin.Command("ping", "Measure the round-trip time of calls to the router.")
+	pingProcedure    = pingCmd.Arg("procedure", "The procedure to call, wamp.session.count if not given").String()
+	pingCount        = pingCmd.Flag("count", "Stop after this many calls, 0 pings until CTRL-c").Short('c').Int()
+	pingCallInterval = pingCmd.Flag("interval", "Time between calls").Short('i').Default("1s").Duration()
+	pingTimeout      = pingCmd.Flag("timeout", "Count a call as failed if not answered in time").Default("5s").
+				Duration()
+
+	rttCmd = kingpin.Command("rtt", "Measure the round trip of calls through the router "+
+		"to an echo callee.")
+	rttCount = rttCmd.Flag("count", "Stop after this many calls, 0 calls until CTRL-c").Short('c').
+			Default("10").Int()
+	rttInterval    = rttCmd.Flag("interval", "Time between calls").Short('i').Default("1s").Duration()
+	rttTimeout     = rttCmd.Flag("timeout", "Count a call as failed if not answered in time").Default("5s").Duration()
+	rttSameSession = rttCmd.Flag("same-session", "Register the echo procedure on the calling session").Bool()
+	rttPayloadSize = rttCmd.Flag("payload-size", "The length of the string echoed back").Int()
+
+	latencyCmd = kingpin.Command("latency", "Measure the one-way latency of events between two "+
+		"wick instances.")
+	latencyPublish      = latencyCmd.Command("publish", "Publish timestamped events for latency subscribe.")
+	latencyPublishTopic = latencyPublish.Arg("topic", "The topic to publish to").Required().String()
+	latencyPublishCount = latencyPublish.Flag("count", "Stop after this many events, 0 publishes until CTRL-c").
+				Short('c').Default("100").Int()
+	latencyPublishInterval = latencyPublish.Flag("interval", "Time between events").Short('i').
+				Default("100ms").Duration()
+	latencySubscribe = latencyCmd.Command("subscribe", "Print the one-way latency of the events of "+
+		"latency publish.")
+	latencySubscribeTopic = latencySubscribe.Arg("topic", "The topic to subscribe to").Required().String()
+	latencySubscribeCount = latencySubscribe.Flag("count", "Stop after this many events, 0 measures until "+
+		"CTRL-c").Short('c').Int()
+	latencySubscribeSamples = latencySubscribe.Flag("clock-samples", "Calls to the clock of a publisher to "+
+		"estimate its offset").Default("8").Int()
+
+	healthcheck          = kingpin.Command("healthcheck", "Check that the router answers in time, for liveness probes.")
+	healthcheckProcedure = healthcheck.Flag("procedure", "A procedure to call once joined").String()
+	healthcheckMaxJoin   = healthcheck.Flag("max-join-ms", "Fail if joining the realm takes longer, "+
+		"in milliseconds").Int()
+	healthcheckMaxCall = healthcheck.Flag("max-call-ms", "Fail if the call to --procedure takes longer, "+
+		"in milliseconds").Int()
+	healthcheckTimeout = healthcheck.Flag("timeout", "Fail if the check takes longer").Default("10s").Duration()
+
+	discover       = kingpin.Command("discover", "List the procedures and topics of the realm.")
+	discoverPrefix = discover.Arg("prefix", "Only list the URIs starting with the prefix").String()
+	discoverSchema = discover.Flag("schema", "A procedure called with the URI of every procedure, that "+
+		"returns its description").String()
+
+	apidoc        = kingpin.Command("apidoc", "Print an AsyncAPI document of the procedures and topics of the realm.")
+	apidocPrefix  = apidoc.Flag("prefix", "Only describe the URIs starting with the prefix").String()
+	apidocSchemas = apidoc.Flag("schemas", "Call the <procedure>"+wamp.SchemaSuffix+" procedure of every "+
+		"procedure that has one, for its description and payload schemas").Bool()
+	apidocTitle   = apidoc.Flag("title", "The title of the API, the realm if not given").String()
+	apidocVersion = apidoc.Flag("api-version", "The version of the API").Default("1.0.0").String()
+	apidocFormat  = apidoc.Flag("format", "Print the document as YAML or JSON").Default(wamp.APIDocFormatYAML).
+			Enum(wamp.APIDocFormatYAML, wamp.APIDocFormatJSON)
+
+	presenceCmd   = kingpin.Command("presence", "Show the sessions subscribed to a topic as they come and go.")
+	presenceTopic = presenceCmd.Arg("topic", "The topic, or with --match prefix the prefix, to track").
+			Required().HintAction(historyHints(wamp.HistoryTopic)).String()
+	presenceMatch = presenceCmd.Flag("match", "Track the subscriptions to the topic or starting with it").
+			Default(nxwamp.MatchExact).Enum(nxwamp.MatchExact, nxwamp.MatchPrefix)
+
+	sessionCmd    = kingpin.Command("session", "Manage the sessions of the realm through the meta API.")
+	sessionKill   = sessionCmd.Command("kill", "Close sessions of the realm, by session ID, authid or authrole.")
+	sessionKillID = sessionKill.Arg("session-id", "The ID of the session to kill").Uint64()
+	// Named apart from the global --authid and --authrole, which kingpin
+	// does not let a subcommand flag take.
+	sessionKillAuth = sessionKill.Flag("by-authid", "Kill every session with this authid").String()
+	sessionKillRole = sessionKill.Flag("by-authrole", "Kill every session with this authrole").String()
+	sessionKillWhy  = sessionKill.Flag("reason", "The URI of the GOODBYE sent to the killed sessions").String()
+	sessionKillMsg  = sessionKill.Flag("message", "A message sent to the killed sessions").String()
+	sessionKillYes  = sessionKill.Flag("yes", "Do not ask for confirmation").Short('y').Bool()
 
-	call            = kingpin.Command("call", "Call a procedure.")
-	callProcedure   = call.Arg("procedure", "Procedure to call").Required().String()
-	callArgs        = call.Arg("args", "give the arguments").Strings()
-	callKeywordArgs = call.Flag("kwarg", "give the keyword arguments").Short('k').StringMap()
+	historyEvents      = kingpin.Command("history-events", "Print the events the router kept for a topic.")
+	historyEventsTopic = historyEvents.Arg("topic", "The topic of the events").Required().
+				HintAction(historyHints(wamp.HistoryTopic)).String()
+	historyEventsLimit = historyEvents.Flag("limit", "Fetch at most this many events, 0 lets the router choose").
+				Default("100").Int()
+
+	topCmd = kingpin.Command("top", "Show live counts of the sessions, registrations and subscriptions "+
+		"of the realm.")
+	topInterval = topCmd.Flag("interval", "Time between two refreshes").Default("1s").Duration()
+	topTopics   = topCmd.Flag("topic", "Also show the events per second of this topic, can be repeated").
+			HintAction(historyHints(wamp.HistoryTopic)).Strings()
+
+	featuresCmd    = kingpin.Command("features", "Show the features the router advertised for the realm.")
+	featuresOutput = featuresCmd.Flag("output", "Print a table or JSON").Default("text").Enum("text", "json")
+
+	fuzzCmd       = kingpin.Command("fuzz", "Send random calls or publishes to stress the router and callees.")
+	fuzzTarget    = fuzzCmd.Flag("target", "Send calls or publishes").Required().Enum(wamp.FuzzCall, wamp.FuzzPublish)
+	fuzzURIPrefix = fuzzCmd.Flag("uri-prefix", "Start every random URI with this").String()
+	fuzzURIs      = fuzzCmd.Flag("uri", "Also send random payloads to this URI, can be repeated").Strings()
+	fuzzCount     = fuzzCmd.Flag("count", "The number of messages to send").Default("100").Int()
+	fuzzSeed      = fuzzCmd.Flag("seed", "Seed the generator to send the same messages again, random if 0").Int64()
+	fuzzTimeout   = fuzzCmd.Flag("timeout", "Report messages not answered in time").Default("5s").Duration()
+
+	soakCmd = kingpin.Command("soak", "Keep sessions calling and publishing, reporting totals and "+
+		"memory use.")
+	soakDuration = soakCmd.Flag("duration", "Stop after this long, 0 runs until CTRL-c").Duration()
+	soakSessions = soakCmd.Flag("sessions", "The number of sessions").Default("2").Int()
+	soakInterval = soakCmd.Flag("interval", "Time between two calls and publishes of a session").
+			Default("1s").Duration()
+	soakReportInterval = soakCmd.Flag("report-interval", "Time between two reports").Default("1m").Duration()
+	soakURIPrefix      = soakCmd.Flag("uri-prefix", "Start the procedures and topic with this").
+				Default("wick.soak.").String()
+	soakTimeout = soakCmd.Flag("timeout", "Fail calls not answered in time").Default("10s").Duration()
+
+	proxyCmd = kingpin.Command("proxy", "Forward the invocations of a procedure to a procedure of another "+
+		"router or realm.")
+	proxyProcedure   = proxyCmd.Flag("procedure", "The procedure to register on --url and --realm").Required().String()
+	proxyTarget      = proxyCmd.Flag("target", "The procedure to forward to, --procedure if not given").String()
+	proxyTargetURL   = proxyCmd.Flag("target-url", "WAMP URL of the target, --url if not given").String()
+	proxyTargetRealm = proxyCmd.Flag("target-realm", "The realm of the target, --realm if not given").String()
+	proxyOptions     = proxyCmd.Flag("option", "give a REGISTER option, as key=value").Short('o').StringMap()
+
+	mqttBridge     = kingpin.Command("mqtt-bridge", "Forward events between WAMP topics and MQTT topics.")
+	mqttBridgeFile = mqttBridge.Arg("mapping-file", "The YAML file of the broker and the topics to map").
+			Required().ExistingFile()
+
+	versionCmd         = kingpin.Command("version", "Show the version and build info of wick.")
+	versionOutput      = versionCmd.Flag("output", "The format of the build info").Default("text").Enum("text", "json")
+	versionCheckUpdate = versionCmd.Flag("check-update", "Also check GitHub for a newer release").Bool()
+
+	historyCmd    = kingpin.Command("history", "List the topics and procedures used, most used first.")
+	historySearch = historyCmd.Arg("search", "Only list the URIs containing this").String()
+	historyKind   = historyCmd.Flag("kind", "Only list topics or procedures").
+			Enum(wamp.HistoryTopic, wamp.HistoryProcedure)
+	historyLimit = historyCmd.Flag("limit", "List at most this many URIs, 0 lists all").Int()
+
+	keyCmd            = kingpin.Command("key", "Generate, derive and verify cryptosign ed25519 keys.")
+	keyGenerate       = keyCmd.Command("generate", "Generate a private key and print it with its public key.")
+	keyGenerateFormat = keyGenerate.Flag("format", "The format of the public key").Default(wamp.KeyFormatHex).
+				Enum(wamp.KeyFormatHex, wamp.KeyFormatBase64, wamp.KeyFormatOpenSSH)
+	keyGenerateQR   = keyGenerate.Flag("qr", "Also print the public key as a QR code").Bool()
+	keyDerive       = keyCmd.Command("derive", "Print the public key of --private-key, given as hex or a file.")
+	keyDeriveFormat = keyDerive.Flag("format", "The format of the public key").Default(wamp.KeyFormatHex).
+			Enum(wamp.KeyFormatHex, wamp.KeyFormatBase64, wamp.KeyFormatOpenSSH)
+	keyDeriveQR        = keyDerive.Flag("qr", "Also print the public key as a QR code").Bool()
+	keyVerify          = keyCmd.Command("verify", "Check the signature of a message by a public key.")
+	keyVerifyPublicKey = keyVerify.Flag("public-key", "The public key as hex, base64, OpenSSH or a file with it").
+				Required().String()
+	keyVerifySignature = keyVerify.Flag("signature", "The signature as hex or base64").Required().String()
+	keyVerifyHex       = keyVerify.Flag("hex", "The message is hex, as cryptosign challenges are").Bool()
+	keyVerifyMessage   = keyVerify.Arg("message", "The signed message, read from stdin if not given").String()
+
+	profileCmd               = kingpin.Command("profile", "Hand the connection settings over to other clients.")
+	profileExport            = profileCmd.Command("export", "Print the connection settings as JSON.")
+	profileExportQR          = profileExport.Flag("qr", "Print them as a QR code instead").Bool()
+	profileExportCredentials = profileExport.Flag("credentials", "Include the ticket, secret or private key").Bool()
+	profileExportFormat      = profileExport.Flag("format", "Print them as a wick profile, or as a Crossbar or "+
+		"Autobahn config").Default(wamp.ProfileFormatWick).
+		Enum(wamp.ProfileFormatWick, wamp.ProfileFormatCrossbar, wamp.ProfileFormatAutobahn)
+	profileImport     = profileCmd.Command("import", "Print a wick profile of a Crossbar or Autobahn config.")
+	profileImportFile = profileImport.Arg("file", "The wick profile, Crossbar or Autobahn config").
+				Required().ExistingFile()
+	profileImportEnv = profileImport.Flag("env", "Print the WICK_* variables of an env file instead").Bool()
+
+	completion      = kingpin.Command("completion", "Print the shell completion script.")
+	completionShell = completion.Arg("shell", "The shell to complete in").Required().
+			HintOptions("bash", "zsh", "fish").Enum("bash", "zsh", "fish")
+
+	benchCmd         = kingpin.Command("bench", "Benchmark the router.")
+	benchSerializers = benchCmd.Command("serializers", "Run the same calls or publishes over every "+
+		"serializer and compare them.")
+	benchSerializersCall   = benchSerializers.Flag("call", "Call this procedure").String()
+	benchSerializersTopic  = benchSerializers.Flag("publish", "Publish to this topic").String()
+	benchSerializersArgs   = benchSerializers.Arg("args", "give the arguments").Strings()
+	benchSerializersKwargs = benchSerializers.Flag("kwarg", "give the keyword arguments").Short('k').
+				StringMap()
+	benchSerializersList = benchSerializers.Flag("serializers", "The serializers to compare, in order").
+				Default("json", "msgpack", "cbor").Enums("json", "msgpack", "cbor")
+	benchSerializersRepeat = benchSerializers.Flag("repeat", "Call or publish this many times per serializer").
+				Default("10000").Int()
+	benchSerializersConcurrency = benchSerializers.Flag("concurrency", "How many calls or publishes are "+
+		"in flight at once").Default("1").Int()
+	benchSerializersPayloadSize = benchSerializers.Flag("payload-size", "Add a generated argument of this "+
+		"size, like 4KB").String()
+	benchSerializersPayloadShape = benchSerializers.Flag("payload-shape", "The shape of the --payload-size "+
+		"argument").Default(wamp.PayloadShapeString).Enum(wamp.PayloadShapes...)
+	benchSerializersPayloadRandom = benchSerializers.Flag("payload-random", "Fill the --payload-size "+
+		"argument with random content that does not compress").Bool()
+
+	workerCmd = kingpin.Command("worker", "Run the repeated calls or publishes of a coordinator started "+
+		"with --workers.")
+	workerCoordinator = workerCmd.Arg("coordinator", "The host:port of the coordinator").Required().String()
+
+	routerCmd         = kingpin.Command("router", "Run an embedded WAMP router.")
+	routerStart       = routerCmd.Command("start", "Start a router serving the realm and any extra realms.")
+	routerHost        = routerStart.Flag("host", "The host to listen on").Default("localhost").String()
+	routerPort        = routerStart.Flag("port", "The port to listen on").Default("8080").Int()
+	routerExtraRealms = routerStart.Flag("extra-realm", "Serve another realm besides --realm").Strings()
+	routerAnonymous   = routerStart.Flag("anonymous", "Allow anonymous authentication").Default("true").Bool()
+	routerPrincipals  = routerStart.Flag("principal", "Allow ticket authentication as authid=ticket").StringMap()
+	routerSerializers = routerStart.Flag("serializers", "Serializers to accept, all if not given").
+				Enums("json", "msgpack", "cbor")
+	routerMetaKill = routerStart.Flag("meta-kill", "Let clients kill sessions with wamp.session.kill").Bool()
 )
 
 func main() {
-	cmd := kingpin.Parse()
+	kingpin.Version(version)
+	if err := loadEnvFile(os.Args[1:]); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	if err := applyConfigDefaults(os.Args[1:]); err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	plugins, err := registerPlugins()
+	if err != nil {
+		kingpin.Fatalf("%s", err)
+	}
+	args := passPluginArgs(joinFileArgs(normalizeBoolFlags(os.Args[1:])), plugins)
+	cmd := kingpin.MustParse(kingpin.CommandLine.Parse(args))
 
-	serializerToUse := serialize.JSON
+	logger := logrus.New()
+	if *logFormat == "json" {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		logger.SetFormatter(&logrus.TextFormatter{DisableTimestamp: true})
+	}
+	level, _ := logrus.ParseLevel(*logLevel)
+	if *debug {
+		level = logrus.DebugLevel
+	}
+	logger.SetLevel(level)
 
-	switch *serializer {
-	case "json":
-	case "msgpack":
-		serializerToUse = serialize.MSGPACK
-	case "cbor":
-		serializerToUse = serialize.CBOR
+	errorCodes, err := parseExitCodeMap(*errorExitCodeMap)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(exitError)
 	}
 
-	logger := log.New(os.Stdout, "", 0)
-	var session *client.Client
+	if command, ok := plugins[cmd]; ok {
+		code, err := runPlugin(command)
+		if err != nil {
+			logger.Error(err)
+		}
+		os.Exit(code)
+	}
+
+	if cmd == completion.FullCommand() {
+		if err = printCompletion(*completionShell, os.Stdout); err != nil {
+			logger.Error(err)
+			os.Exit(exitError)
+		}
+		return
+	}
+
+	if cmd == versionCmd.FullCommand() {
+		if err = printVersion(os.Stdout, *versionOutput, *versionCheckUpdate); err != nil {
+			logger.Error(err)
+			os.Exit(exitError)
+		}
+		return
+	}
+
+	if cmd == historyCmd.FullCommand() {
+		if err = printHistory(); err != nil {
+			logger.Error(err)
+			os.Exit(exitError)
+		}
+		return
+	}
+
+	if cmd == keyGenerate.FullCommand() || cmd == keyDerive.FullCommand() || cmd == keyVerify.FullCommand() {
+		if err = runKey(cmd, os.Stdout); err != nil {
+			logger.Error(err)
+			os.Exit(exitError)
+		}
+		return
+	}
+
+	if cmd == profileExport.FullCommand() || cmd == profileImport.FullCommand() {
+		run := exportProfile
+		if cmd == profileImport.FullCommand() {
+			run = importProfile
+		}
+		if err = run(os.Stdout); err != nil {
+			logger.Error(err)
+			os.Exit(exitError)
+		}
+		return
+	}
+
+	if cmd == routerStart.FullCommand() {
+		startRouter(logger)
+		return
+	}
+
+	if cmd == workerCmd.FullCommand() {
+		if err = runWorker(*workerCoordinator, *workerToken, logger); err != nil {
+			logger.Error(err)
+			os.Exit(exitError)
+		}
+		return
+	}
+
+	serializerToUse, err := wamp.Serializer(*serializer)
+	if err != nil {
+		logger.Error(err)
+		os.Exit(exitError)
+	}
+
+	connectOptions := wamp.ConnectOptions{
+		Debug:        *debug,
+		PingInterval: *pingInterval,
+		PongTimeout:  *pongTimeout,
+		TraceFormat:  *traceFormat,
+		TraceSecrets: *traceSecrets,
+		Proxy:        *proxy,
+		Socks5:       *socks5,
+		SSHTunnel:    *sshTunnel,
+		Compression:  *wsCompression,
+	}
+	if *traceFile != "" {
+		file, err := os.OpenFile(*traceFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			logger.Fatal("Failed to open trace file: ", err)
+		}
+		defer file.Close()
+		connectOptions.Trace = file
+	} else if *trace {
+		connectOptions.Trace = os.Stderr
+	}
+	var bandwidth *bandwidthCounter
+	if *showBandwidth {
+		bandwidth = &bandwidthCounter{bytes: &wamp.ByteCounter{}, start: time.Now()}
+		connectOptions.Bytes = bandwidth.bytes
+		atExit = append(atExit, bandwidth.print)
+	}
+
+	if *channelBinding != "" && *authMethod != "cryptosign" {
+		println("Channel binding can only be used with cryptosign auth")
+		os.Exit(1)
+	}
+
+	if !isAuthMethod(*authMethod) {
+		println("Unknown authmethod " + *authMethod + ", must be one of " + strings.Join(wamp.AuthMethods(), ", "))
+		os.Exit(1)
+	}
 
 	switch *authMethod {
 	case "anonymous":
@@ -92,7 +697,7 @@ func main() {
 			println("Private key not needed for anonymous auth")
 			os.Exit(1)
 		}
-		if *ticket != "" {
+		if *ticket != "" || *ticketCommand != "" || *oauthTokenURL != "" {
 			println("ticket not needed for anonymous auth")
 			os.Exit(1)
 		}
@@ -100,37 +705,1120 @@ func main() {
 			println("secret not needed for anonymous auth")
 			os.Exit(1)
 		}
-		session = wamp.ConnectAnonymous(*url, *realm, serializerToUse, *authid, *authrole, logger)
 	case "ticket":
-		if *ticket == "" {
-			println("Must provide ticket when authMethod is ticket")
+		sources := 0
+		for _, source := range []string{*ticket, *ticketCommand, *oauthTokenURL} {
+			if source != "" {
+				sources++
+			}
+		}
+		if sources == 0 {
+			println("Must provide ticket, ticket command or OAuth token URL when authMethod is ticket")
+			os.Exit(1)
+		}
+		if sources > 1 {
+			println("Only one of ticket, ticket command and OAuth token URL can be used")
 			os.Exit(1)
 		}
-		session = wamp.ConnectTicket(*url, *realm, serializerToUse, *authid, *authrole, *ticket, logger)
 	case "wampcra":
 		if *secret == "" {
 			println("Must provide secret when authMethod is wampcra")
 			os.Exit(1)
 		}
-		session = wamp.ConnectCRA(*url, *realm, serializerToUse, *authid, *authrole, *secret, logger)
 	case "cryptosign":
 		if *privateKey == "" {
 			println("Must provide private key when authMethod is cryptosign")
 			os.Exit(1)
 		}
-		session = wamp.ConnectCryptoSign(*url, *realm, serializerToUse, *authid, *authrole, *privateKey, logger)
 	}
 
-	defer session.Close()
+	if cmd == run.FullCommand() {
+		err = runScenario(serializerToUse, connectOptions, logger)
+		exit(err, errorCodes, logger)
+	}
+
+	if cmd == mockCmd.FullCommand() {
+		err = runMock(serializerToUse, connectOptions, logger)
+		exit(err, errorCodes, logger)
+	}
+
+	if cmd == verifyCmd.FullCommand() {
+		err = runVerify(serializerToUse, connectOptions, logger)
+		exit(err, errorCodes, logger)
+	}
+
+	if cmd == scriptCmd.FullCommand() {
+		err = runScript(serializerToUse, connectOptions, logger)
+		exit(err, errorCodes, logger)
+	}
+
+	if cmd == bridge.FullCommand() {
+		err = runBridge(serializerToUse, connectOptions, logger)
+		exit(err, errorCodes, logger)
+	}
+
+	if cmd == proxyCmd.FullCommand() {
+		err = runProxy(serializerToUse, connectOptions, logger)
+		exit(err, errorCodes, logger)
+	}
+
+	// A failed check exits 1 whatever the cause, probes only tell 0 from
+	// non-zero.
+	if cmd == healthcheck.FullCommand() {
+		options := wamp.HealthcheckOptions{
+			Procedure: *healthcheckProcedure,
+			MaxJoin:   time.Duration(*healthcheckMaxJoin) * time.Millisecond,
+			MaxCall:   time.Duration(*healthcheckMaxCall) * time.Millisecond,
+			Timeout:   *healthcheckTimeout,
+		}
+		connect := func() (*client.Client, error) {
+			return connectSession(*url, firstRealm(), serializerToUse, connectOptions, logger)
+		}
+		if report := wamp.Healthcheck(connect, options, os.Stdout); !report.Healthy {
+			os.Exit(exitError)
+		}
+		os.Exit(exitOK)
+	}
+
+	if cmd == soakCmd.FullCommand() {
+		options := wamp.SoakOptions{
+			Duration:       *soakDuration,
+			Sessions:       *soakSessions,
+			Interval:       *soakInterval,
+			ReportInterval: *soakReportInterval,
+			URIPrefix:      *soakURIPrefix,
+			Timeout:        *soakTimeout,
+		}
+		connect := func() (*client.Client, error) {
+			return connectSession(*url, firstRealm(), serializerToUse, connectOptions, logger)
+		}
+		_, err = wamp.Soak(connect, logger, options)
+		exit(err, errorCodes, logger)
+	}
+
+	var cryptobox *wamp.Cryptobox
+	if *e2eeKey != "" {
+		if cryptobox, err = wamp.NewCryptobox(*e2eeKey); err != nil {
+			exit(err, errorCodes, logger)
+		}
+	}
+
+	var tracing *wamp.Tracing
+	if *otel {
+		var shutdown func()
+		if tracing, shutdown, err = setupTracing(*otelKey, *otelInOptions); err != nil {
+			exit(err, errorCodes, logger)
+		}
+		atExit = append(atExit, shutdown)
+		connectOptions.Tracing = tracing
+	}
+
+	var metrics *wamp.Metrics
+	if *metricsListen != "" {
+		metrics = wamp.NewMetrics()
+		metricsServer, err := wamp.ServeMetrics(*metricsListen, metrics, logger)
+		if err != nil {
+			exit(err, errorCodes, logger)
+		}
+		defer metricsServer.Close()
+		connectOptions.Metrics = metrics
+	}
+
+	// NO_COLOR is the cross-tool convention, https://no-color.org.
+	jsonStyle := wamp.JSONStyle{
+		Color:   !*noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout),
+		Compact: *compact,
+	}
+	if jsonStyle.Display, err = wamp.ParsePayloadDisplay(*payloadDisplay); err != nil {
+		exit(err, errorCodes, logger)
+	}
+
+	parallel := 1
+	var schemaFile, extractPath string
+	var optionValues map[string]string
+	var revoked chan struct{}
+	var script *wamp.Script
+	switch cmd {
+	case subscribe.FullCommand():
+		extractPath, optionValues = *subscribeExtract, *subscribeOptions
+	case publish.FullCommand():
+		parallel, schemaFile, optionValues = *publishParallel, *publishSchema, *publishOptions
+	case register.FullCommand():
+		parallel, schemaFile, optionValues = *registerParallel, *registerSchema, *registerOptions
+		if *registerScript != "" {
+			if *onInvocationCmd != "" {
+				exit(errors.New("a command cannot be given with --script"), errorCodes, logger)
+			}
+			if script, err = wamp.LoadScript(*registerScript, logger); err != nil {
+				exit(err, errorCodes, logger)
+			}
+		}
+		if *registerForce {
+			if parallel > 1 {
+				exit(errors.New("--force-reregister cannot be used with --parallel, every session would take "+
+					"over the previous one"), errorCodes, logger)
+			}
+			revoked = make(chan struct{}, 1)
+			connectOptions.Revoked = func() {
+				select {
+				case revoked <- struct{}{}:
+				default:
+				}
+			}
+		}
+	case call.FullCommand():
+		parallel, schemaFile, extractPath = *callParallel, *callSchema, *callExtract
+		optionValues = *callOptions
+	case rttCmd.FullCommand():
+		// The echo procedure is registered on a second session, unless
+		// --same-session.
+		if !*rttSameSession {
+			parallel = 2
+		}
+	}
+	var extraOptions nxwamp.Dict
+	if len(optionValues) > 0 {
+		if extraOptions, err = wamp.ParseOptions(optionValues); err != nil {
+			exit(err, errorCodes, logger)
+		}
+	}
+	var schema *wamp.Schema
+	if schemaFile != "" {
+		if schema, err = wamp.LoadSchema(schemaFile); err != nil {
+			exit(err, errorCodes, logger)
+		}
+	}
+	var extractor *wamp.Extractor
+	if extractPath != "" {
+		if extractor, err = wamp.ParseExtractor(extractPath); err != nil {
+			exit(err, errorCodes, logger)
+		}
+	}
+	var dedupeKey *wamp.Extractor
+	if cmd == subscribe.FullCommand() && *subscribeDedupeKey != "" {
+		path := *subscribeDedupeKey
+		// The leading dot of the path may be left out, as in "kwargs.id".
+		if !strings.HasPrefix(path, ".") && !strings.HasPrefix(path, "[") && !strings.HasPrefix(path, "$") {
+			path = "." + path
+		}
+		if dedupeKey, err = wamp.ParseExtractor(path); err != nil {
+			exit(err, errorCodes, logger)
+		}
+	}
+	realms := splitList(*realm)
+	if len(realms) > 1 && cmd != publish.FullCommand() && cmd != call.FullCommand() {
+		exit(fmt.Errorf("%s joins a single realm, only publish and call run on several", cmd), errorCodes, logger)
+	}
+	var useDaemon bool
+	var dealerTimeout time.Duration
+	var killOptions wamp.KillOptions
+	switch cmd {
+	case publish.FullCommand():
+		useDaemon = *publishUseDaemon
+		if useDaemon && (*publishFromStdin || *publishRepeat > 1) {
+			exit(errors.New("--from-stdin and --repeat cannot be used with --use-daemon"), errorCodes, logger)
+		}
+	case call.FullCommand():
+		useDaemon = *callUseDaemon
+		if useDaemon && (*callWatch > 0 || *callUntil != "" || *callRepeat > 1 || *callTotal > 0) {
+			exit(errors.New("--watch, --until, --repeat and --total cannot be used with --use-daemon"), errorCodes,
+				logger)
+		}
+		if *callTotal > 0 && *callRepeat > 1 {
+			exit(errors.New("--total and --repeat cannot be used together"), errorCodes, logger)
+		}
+		if err = wamp.CheckRunMode(*callRunMode, *callRKey); err != nil {
+			exit(err, errorCodes, logger)
+		}
+		if dealerTimeout, err = parseMillis(*callDealerTimeout); err != nil {
+			exit(fmt.Errorf("invalid --dealer-timeout: %w", err), errorCodes, logger)
+		}
+	case sessionKill.FullCommand():
+		killOptions = wamp.KillOptions{
+			SessionID: *sessionKillID,
+			AuthID:    *sessionKillAuth,
+			AuthRole:  *sessionKillRole,
+			Reason:    *sessionKillWhy,
+			Message:   *sessionKillMsg,
+		}
+		if err = killOptions.Check(); err != nil {
+			exit(err, errorCodes, logger)
+		}
+		// Ask before joining, so no session waits for the answer.
+		if !*sessionKillYes {
+			if err = confirm(fmt.Sprintf("Kill %s?", killOptions.Target())); err != nil {
+				exit(err, errorCodes, logger)
+			}
+		}
+	case testamentAdd.FullCommand():
+		useDaemon = *testamentAddUseDaemon
+	case testamentFlush.FullCommand():
+		// Testaments belong to the session that added them.
+		useDaemon = true
+	}
+	if *reportFile != "" {
+		if _, err = wamp.ReportFormat(*reportFile); err != nil {
+			exit(err, errorCodes, logger)
+		}
+	}
+	if (cmd == call.FullCommand() && *callConcurrency < 1) ||
+		(cmd == publish.FullCommand() && *publishConcurrency < 1) {
+		exit(errors.New("--concurrency must be at least 1"), errorCodes, logger)
+	}
+	var baseline *wamp.Baseline
+	var threshold float64
+	if *baselineFile != "" {
+		if baseline, err = wamp.LoadBaseline(*baselineFile); err != nil {
+			exit(err, errorCodes, logger)
+		}
+	}
+	if *failOnRegression != "" {
+		if baseline == nil {
+			exit(errors.New("--fail-on-regression needs a --baseline to compare with"), errorCodes, logger)
+		}
+		if threshold, err = wamp.ParseThreshold(*failOnRegression); err != nil {
+			exit(err, errorCodes, logger)
+		}
+	}
+	if cmd == benchSerializers.FullCommand() {
+		exit(runBenchSerializers(connectOptions, logger), errorCodes, logger)
+	}
+	if *workers > 0 {
+		switch {
+		case cmd != call.FullCommand() && cmd != publish.FullCommand():
+			err = errors.New("--workers only runs the repeated calls and publishes of call and publish")
+		case useDaemon:
+			err = errors.New("--workers cannot be used with --use-daemon")
+		case *reportSamples:
+			err = errors.New("--report-samples cannot be used with --workers, the workers send their stats only")
+		default:
+			output := runOutputFromFlags(baseline, threshold, bandwidth)
+			err = runCoordinator(*workers, *coordinator, *workerToken, output, logger)
+		}
+		exit(err, errorCodes, logger)
+	}
+	if useDaemon && (parallel > 1 || len(realms) > 1) {
+		exit(errors.New("--use-daemon runs on the single session of the daemon, not with --parallel or "+
+			"several realms"), errorCodes, logger)
+	}
+
+	var stagger time.Duration
+	if cmd == call.FullCommand() {
+		stagger = *callStagger
+	}
+	var sessions []*client.Client
+	var sessionInfos map[*client.Client]sessionInfo
+	var session *client.Client
+	if !useDaemon {
+		if sessions, sessionInfos, err = getSessions(parallel, realms, stagger, serializerToUse, connectOptions,
+			logger); err != nil {
+			exit(err, errorCodes, logger)
+		}
+		session = sessions[0]
+	}
+	// Routers without a feature ignore the options of the feature.
+	if session != nil {
+		switch {
+		case cmd == call.FullCommand() && dealerTimeout > 0:
+			checkFeature(session, "dealer", "call_timeout", "--dealer-timeout", logger)
+		case cmd == publish.FullCommand() && *publishRetain:
+			checkFeature(session, "broker", "event_retention", "--retain", logger)
+		case cmd == subscribe.FullCommand() && *subscribeGetRetained:
+			checkFeature(session, "broker", "event_retention", "--get-retained", logger)
+		}
+	}
+	// With several realms every output line tells the realm it came from.
+	tag := func(session *client.Client) string {
+		if len(realms) < 2 {
+			return ""
+		}
+		return "[" + sessionInfos[session].realm + "] "
+	}
 
 	switch cmd {
 	case subscribe.FullCommand():
-		wamp.Subscribe(session, logger, *subscribeTopic)
+		var rotateSize int64
+		if *subscribeRotateSize != "" {
+			if rotateSize, err = wamp.ParseSize(*subscribeRotateSize); err != nil {
+				exit(err, errorCodes, logger)
+			}
+		}
+		options := wamp.SubscribeOptions{
+			Cryptobox:    cryptobox,
+			BinaryFormat: *binaryFormat,
+			JSONStyle:    jsonStyle,
+			Metrics:      metrics,
+			Limit:        *subscribeLimit,
+			Duration:     *subscribeTime,
+			Exec:         *subscribeExec,
+			Concurrency:  *subscribeConcurrency,
+			Extract:      extractor,
+			Expire:       *subscribeExpire,
+			Extra:        extraOptions,
+			GetRetained:  *subscribeGetRetained,
+			BufferSize:   *subscribeBuffer,
+			Overflow:     *subscribeOverflow,
+			Dedupe:       *subscribeDedupe,
+			Stats:        *subscribeStats,
+			Record: wamp.RecordOptions{
+				Dir:            *subscribeRecordDir,
+				RotateSize:     rotateSize,
+				RotateInterval: *subscribeRotateInterval,
+			},
+			DedupeKey: dedupeKey,
+			Output:    os.Stdout,
+		}
+		err = wamp.Subscribe(session, logger, *subscribeTopic, options)
+	case gateway.FullCommand():
+		err = wamp.Gateway(session, logger, *gatewayListen)
+	case daemon.FullCommand():
+		options := wamp.DaemonOptions{
+			Socket:    *daemonSocket,
+			Cryptobox: cryptobox,
+		}
+		err = wamp.Daemon(session, logger, options)
+	case testamentAdd.FullCommand():
+		options := wamp.TestamentOptions{
+			Scope: *testamentAddScope,
+			Extra: *testamentAddOptions,
+		}
+		if useDaemon {
+			err = wamp.DaemonAddTestament(*daemonSocket, *testamentAddTopic, *testamentAddArgs,
+				*testamentAddKwargs, options)
+		} else {
+			err = wamp.AddTestament(session, logger, *testamentAddTopic, *testamentAddArgs, *testamentAddKwargs,
+				options)
+		}
+	case testamentFlush.FullCommand():
+		err = wamp.DaemonFlushTestaments(*daemonSocket, wamp.TestamentOptions{Scope: *testamentFlushScope})
+	case pingCmd.FullCommand():
+		options := wamp.PingOptions{
+			Procedure: *pingProcedure,
+			Count:     *pingCount,
+			Interval:  *pingCallInterval,
+			Timeout:   *pingTimeout,
+		}
+		err = wamp.Ping(session, logger, options, os.Stdout)
+	case rttCmd.FullCommand():
+		options := wamp.RTTOptions{
+			Count:       *rttCount,
+			Interval:    *rttInterval,
+			Timeout:     *rttTimeout,
+			PayloadSize: *rttPayloadSize,
+		}
+		err = wamp.RTT(session, sessions[len(sessions)-1], logger, options, os.Stdout)
+	case latencyPublish.FullCommand():
+		options := wamp.LatencyPublishOptions{
+			Count:    *latencyPublishCount,
+			Interval: *latencyPublishInterval,
+		}
+		err = wamp.PublishTimestamped(session, logger, *latencyPublishTopic, options, os.Stdout)
+	case latencySubscribe.FullCommand():
+		options := wamp.LatencySubscribeOptions{
+			Count:   *latencySubscribeCount,
+			Samples: *latencySubscribeSamples,
+		}
+		err = wamp.MeasureLatency(session, logger, *latencySubscribeTopic, options, os.Stdout)
+	case discover.FullCommand():
+		options := wamp.DiscoverOptions{
+			Prefix: *discoverPrefix,
+			Schema: *discoverSchema,
+		}
+		err = wamp.Discover(session, logger, options, os.Stdout)
+	case apidoc.FullCommand():
+		options := wamp.APIDocOptions{
+			Prefix:  *apidocPrefix,
+			Schemas: *apidocSchemas,
+			Title:   *apidocTitle,
+			Version: *apidocVersion,
+			URL:     *url,
+			Realm:   firstRealm(),
+			Format:  *apidocFormat,
+		}
+		err = wamp.APIDoc(session, logger, options, os.Stdout)
+	case presenceCmd.FullCommand():
+		err = wamp.Presence(session, logger, *presenceTopic, wamp.PresenceOptions{Match: *presenceMatch}, os.Stdout)
+	case sessionKill.FullCommand():
+		err = wamp.KillSessions(session, killOptions, os.Stdout)
+	case historyEvents.FullCommand():
+		options := wamp.EventHistoryOptions{
+			Limit:        *historyEventsLimit,
+			Cryptobox:    cryptobox,
+			BinaryFormat: *binaryFormat,
+			JSONStyle:    jsonStyle,
+			Output:       os.Stdout,
+		}
+		err = wamp.EventHistory(session, logger, *historyEventsTopic, options)
+	case topCmd.FullCommand():
+		err = wamp.Top(session, logger, wamp.TopOptions{Interval: *topInterval, Topics: *topTopics}, os.Stdout)
+	case featuresCmd.FullCommand():
+		err = wamp.PrintFeatures(session, *featuresOutput, os.Stdout)
+	case fuzzCmd.FullCommand():
+		options := wamp.FuzzOptions{
+			Target:    *fuzzTarget,
+			URIPrefix: *fuzzURIPrefix,
+			URIs:      *fuzzURIs,
+			Count:     *fuzzCount,
+			Seed:      *fuzzSeed,
+			Timeout:   *fuzzTimeout,
+		}
+		if options.Seed == 0 {
+			options.Seed = time.Now().UnixNano()
+		}
+		err = wamp.Fuzz(session, logger, options, os.Stdout)
+	case mqttBridge.FullCommand():
+		var config *wamp.MQTTBridgeConfig
+		if config, err = wamp.LoadMQTTBridgeConfig(*mqttBridgeFile); err == nil {
+			err = wamp.MQTTBridge(session, logger, config, serializerToUse)
+		}
+	case waitEvent.FullCommand():
+		options := wamp.WaitEventOptions{
+			Timeout:      *waitEventTimeout,
+			MatchArgs:    *waitEventMatchArgs,
+			MatchKwargs:  *waitEventMatchKwargs,
+			Cryptobox:    cryptobox,
+			BinaryFormat: *binaryFormat,
+			JSONStyle:    jsonStyle,
+			Output:       os.Stdout,
+		}
+		err = wamp.WaitEvent(session, logger, *waitEventTopic, options)
 	case publish.FullCommand():
-		wamp.Publish(session, logger, *publishTopic, *publishArgs, *publishKeywordArgs)
+		var binaryArgs [][]byte
+		if binaryArgs, err = parseBinaryArgs(*publishBinaryArgs); err != nil {
+			break
+		}
+		var payload interface{}
+		if payload, err = generatePayload(*publishPayloadSize, *publishPayloadShape, *publishPayloadRandom,
+			useDaemon); err != nil {
+			break
+		}
+		options := wamp.PublishOptions{
+			Acknowledge:      *publishAcknowledge,
+			ExcludeMe:        *publishExcludeMe,
+			DiscloseMe:       *publishDiscloseMe,
+			Retain:           *publishRetain,
+			Eligible:         *publishEligible,
+			Exclude:          *publishExclude,
+			EligibleAuthID:   *publishEligibleAuthID,
+			ExcludeAuthID:    *publishExcludeAuthID,
+			EligibleAuthRole: *publishEligibleAuthRole,
+			ExcludeAuthRole:  *publishExcludeAuthRole,
+			BinaryArgs:       binaryArgs,
+			Payload:          payload,
+			Cryptobox:        cryptobox,
+			Tracing:          tracing,
+			Schema:           schema,
+			RawKwargs:        *rawKwargs,
+			Extra:            extraOptions,
+			Output:           os.Stdout,
+		}
+		if useDaemon {
+			err = wamp.DaemonPublish(*daemonSocket, *publishTopic, *publishArgs, *publishKeywordArgs, options)
+			break
+		}
+		if *publishFromStdin {
+			err = wamp.PublishStream(session, logger, *publishTopic, os.Stdin, *publishFormat, *publishRate,
+				options)
+			break
+		}
+		stats := wamp.NewStats()
+		options.Stats = stats
+		fn := func(ctx context.Context, session *client.Client) error {
+			options := options
+			options.Tag = tag(session)
+			return wamp.PublishContext(ctx, session, logger, *publishTopic, *publishArgs, *publishKeywordArgs, options)
+		}
+		if *publishPreserialize {
+			var prepared *wamp.PreparedPublish
+			if prepared, err = wamp.PreparePublish(*publishTopic, *publishArgs, *publishKeywordArgs,
+				options); err != nil {
+				break
+			}
+			fn = prepared.Publish
+		}
+		err = runRepeated(sessions, sessionInfos, *publishRepeat, 0, *publishConcurrency, stats,
+			runOutputFromFlags(baseline, threshold, bandwidth), fn)
 	case register.FullCommand():
-		wamp.Register(session, logger, *registerProcedure, *onInvocationCmd)
+		options := wamp.RegisterOptions{
+			Invoke:          *registerInvoke,
+			Cryptobox:       cryptobox,
+			BinaryFormat:    *binaryFormat,
+			JSONStyle:       jsonStyle,
+			Metrics:         metrics,
+			Schema:          schema,
+			MaxConcurrent:   *registerMaxConcurrent,
+			Timeout:         *registerTimeout,
+			Expire:          *registerExpire,
+			DrainTimeout:    *registerDrainTimeout,
+			ForceReregister: *registerForce,
+			Revoked:         revoked,
+			Extra:           extraOptions,
+			Script:          script,
+			Output:          os.Stdout,
+		}
+		if options.Invoke == "" && parallel > 1 {
+			options.Invoke = wamp.InvokeRoundRobin
+		}
+		err = forEachSession(sessions, func(session *client.Client) error {
+			return wamp.Register(session, logger, *registerProcedure, *onInvocationCmd, options)
+		})
 	case call.FullCommand():
-		wamp.Call(session, logger, *callProcedure, *callArgs, *callKeywordArgs)
+		var binaryArgs [][]byte
+		if binaryArgs, err = parseBinaryArgs(*callBinaryArgs); err != nil {
+			break
+		}
+		var payload interface{}
+		if payload, err = generatePayload(*callPayloadSize, *callPayloadShape, *callPayloadRandom,
+			useDaemon); err != nil {
+			break
+		}
+		options := wamp.CallOptions{
+			Timeout:       *callTimeout,
+			DiscloseMe:    *callDiscloseMe,
+			DealerTimeout: dealerTimeout,
+			RunMode:       *callRunMode,
+			RKey:          *callRKey,
+			BinaryArgs:    binaryArgs,
+			Payload:       payload,
+			Cryptobox:     cryptobox,
+			BinaryFormat:  *binaryFormat,
+			JSONStyle:     jsonStyle,
+			Metrics:       metrics,
+			Tracing:       tracing,
+			Schema:        schema,
+			Extract:       extractor,
+			Retry:         *callRetry,
+			RetryOn:       splitList(*callRetryOn),
+			RetryBackoff:  *callRetryBackoff,
+			RawKwargs:     *rawKwargs,
+			Extra:         extraOptions,
+			Format:        *callOutput,
+			Output:        os.Stdout,
+		}
+		if useDaemon {
+			err = wamp.DaemonCall(*daemonSocket, *callProcedure, *callArgs, *callKeywordArgs, options)
+			break
+		}
+		if *callWatch > 0 {
+			err = wamp.WatchCall(session, logger, *callProcedure, *callArgs, *callKeywordArgs, options, *callWatch,
+				*callDiff, os.Stdout)
+			break
+		}
+		if *callUntil != "" {
+			poll := wamp.PollOptions{Until: *callUntil, Interval: *callInterval, Timeout: *callTimeout}
+			options.Timeout = 0
+			err = forEachSession(sessions, func(session *client.Client) error {
+				options := options
+				options.Tag = tag(session)
+				return wamp.PollCall(session, logger, *callProcedure, *callArgs, *callKeywordArgs, options, poll)
+			})
+			break
+		}
+		err = runRepeated(sessions, sessionInfos, *callRepeat, *callTotal, *callConcurrency, wamp.NewStats(),
+			runOutputFromFlags(baseline, threshold, bandwidth),
+			func(ctx context.Context, session *client.Client) error {
+				options := options
+				options.Tag = tag(session)
+				return wamp.CallContext(ctx, session, logger, *callProcedure, *callArgs, *callKeywordArgs, options)
+			})
+	}
+
+	for _, session := range sessions {
+		session.Close()
+	}
+	if err == nil {
+		recordHistory(cmd, logger)
+	}
+	exit(err, errorCodes, logger)
+}
+
+// sessionInfo tells the realm a session joined and how long joining took.
+type sessionInfo struct {
+	realm string
+	join  time.Duration
+}
+
+// getSessions joins count sessions to every realm at once, or one every
+// stagger if not zero, with the router and authentication given on the
+// command line. It also returns the realm each session joined and how long
+// it took.
+func getSessions(count int, realms []string, stagger time.Duration, serializerToUse serialize.Serialization,
+	connectOptions wamp.ConnectOptions, logger *logrus.Logger) ([]*client.Client, map[*client.Client]sessionInfo,
+	error) {
+
+	if count < 1 {
+		return nil, nil, fmt.Errorf("invalid number of parallel sessions: %d", count)
+	}
+	if len(realms) == 0 {
+		return nil, nil, errors.New("no realm given")
+	}
+
+	sessions := make([]*client.Client, count*len(realms))
+	joins := make([]time.Duration, len(sessions))
+	errs := make([]error, len(sessions))
+	var wg sync.WaitGroup
+	for i := range sessions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			time.Sleep(time.Duration(i) * stagger)
+			start := time.Now()
+			sessions[i], errs[i] = connectSession(*url, realms[i/count], serializerToUse, connectOptions, logger)
+			joins[i] = time.Since(start)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			for _, session := range sessions {
+				if session != nil {
+					session.Close()
+				}
+			}
+			return nil, nil, err
+		}
+	}
+
+	infos := make(map[*client.Client]sessionInfo, len(sessions))
+	for i, session := range sessions {
+		infos[session] = sessionInfo{realm: realms[i/count], join: joins[i]}
+	}
+	return sessions, infos, nil
+}
+
+// firstRealm returns the realm joined by the commands that join a single
+// realm.
+func firstRealm() string {
+	if realms := splitList(*realm); len(realms) > 0 {
+		return realms[0]
+	}
+	return ""
+}
+
+// forEachSession runs fn for every session at once and returns the first
+// error, if any, once all of them are done.
+func forEachSession(sessions []*client.Client, fn func(session *client.Client) error) error {
+	errs := make([]error, len(sessions))
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		wg.Add(1)
+		go func(i int, session *client.Client) {
+			defer wg.Done()
+			errs[i] = fn(session)
+		}(i, session)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFeature logs whether the router advertises the feature of role that
+// flag relies on.
+func checkFeature(session *client.Client, role string, feature string, flag string, logger *logrus.Logger) {
+	if session.HasFeature(role, feature) {
+		logger.Infof("The router advertises %s for %s", feature, flag)
+	} else {
+		logger.Warnf("The router does not advertise %s, %s may be ignored", feature, flag)
+	}
+}
+
+// parseMillis parses a number of milliseconds or a duration like "5s",
+// empty is zero.
+func parseMillis(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil && millis >= 0 {
+		return time.Duration(millis) * time.Millisecond, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("expected milliseconds or a duration like 5s: %s", value)
+	}
+	return duration, nil
+}
+
+// isAuthMethod tells whether method is anonymous or a registered
+// authentication method.
+func isAuthMethod(method string) bool {
+	for _, known := range wamp.AuthMethods() {
+		if method == known {
+			return true
+		}
+	}
+	return false
+}
+
+// generatePayload returns the --payload-size argument, nil if no size is
+// given. The daemon only sends the arguments of the command line.
+func generatePayload(size string, shape string, random bool, useDaemon bool) (interface{}, error) {
+	if size == "" {
+		return nil, nil
+	}
+	if useDaemon {
+		return nil, errors.New("--payload-size cannot be used with --use-daemon")
+	}
+	n, err := wamp.ParseSize(size)
+	if err != nil {
+		return nil, err
+	}
+	return wamp.GeneratePayload(int(n), shape, random)
+}
+
+func parseBinaryArgs(values []string) ([][]byte, error) {
+	var args [][]byte
+	for _, value := range values {
+		arg, err := wamp.ParseBinaryArg(value)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// normalizeBoolFlags rewrites "--flag=true" and "--flag=false" of boolean
+// flags to "--flag" and "--no-flag", as kingpin only understands the latter.
+func normalizeBoolFlags(args []string) []string {
+	boolFlags := map[string]bool{}
+	var collect func(flags []*kingpin.FlagModel, commands []*kingpin.CmdModel)
+	collect = func(flags []*kingpin.FlagModel, commands []*kingpin.CmdModel) {
+		for _, flag := range flags {
+			if flag.IsBoolFlag() {
+				boolFlags[flag.Name] = true
+			}
+		}
+		for _, command := range commands {
+			collect(command.Flags, command.Commands)
+		}
+	}
+	model := kingpin.CommandLine.Model()
+	collect(model.Flags, model.Commands)
+
+	normalized := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if parts := strings.SplitN(arg, "=", 2); len(parts) == 2 && strings.HasPrefix(parts[0], "--") &&
+			boolFlags[strings.TrimPrefix(parts[0], "--")] {
+			switch strings.ToLower(parts[1]) {
+			case "true":
+				arg = parts[0]
+			case "false":
+				arg = "--no-" + strings.TrimPrefix(parts[0], "--")
+			}
+		}
+		normalized = append(normalized, arg)
+	}
+
+	return append(normalized, args[len(normalized):]...)
+}
+
+// joinFileArgs rewrites "--arg-binary @file" to "--arg-binary=@file", as
+// kingpin would otherwise expand @file to the arguments read from the file.
+func joinFileArgs(args []string) []string {
+	joined := make([]string, 0, len(args))
+	i := 0
+	for ; i < len(args) && args[i] != "--"; i++ {
+		if args[i] == "--arg-binary" && i+1 < len(args) && strings.HasPrefix(args[i+1], "@") {
+			joined = append(joined, args[i]+"="+args[i+1])
+			i++
+			continue
+		}
+		joined = append(joined, args[i])
+	}
+
+	return append(joined, args[i:]...)
+}
+
+// atExit holds the functions to run before exiting, like flushing traces.
+var atExit []func()
+
+// exit logs err, if any, and exits with the matching exit code.
+func exit(err error, errorCodes map[string]int, logger *logrus.Logger) {
+	if err != nil {
+		logger.Error(err)
+	}
+	for _, fn := range atExit {
+		fn()
+	}
+	os.Exit(exitCode(err, errorCodes))
+}
+
+// connectSession joins realmName on routerURL using the authentication
+// method given on the command line.
+func connectSession(routerURL string, realmName string, serializerToUse serialize.Serialization,
+	connectOptions wamp.ConnectOptions, logger *logrus.Logger) (*client.Client, error) {
+
+	authExtraDict, err := wamp.ParseAuthExtra(*authExtra)
+	if err != nil {
+		return nil, err
+	}
+
+	label := sessionLabel()
+	return wamp.Connect(context.Background(), wamp.ClientConfig{
+		URL:            routerURL,
+		Realm:          realmName,
+		Serialization:  serializerToUse,
+		AuthMethod:     *authMethod,
+		AuthID:         *authid,
+		AuthRole:       *authrole,
+		Ticket:         *ticket,
+		Secret:         *secret,
+		PrivateKey:     *privateKey,
+		TicketSource:   ticketSource(),
+		AuthExtra:      authExtraDict,
+		Agent:          fmt.Sprintf("wick/%s (%s)", version, label),
+		Label:          label,
+		ChannelBinding: *channelBinding,
+		DialTimeout:    *connectTimeout,
+		JoinTimeout:    *joinTimeout,
+		Options:        connectOptions,
+		Logger:         logger,
+	})
+}
+
+// sessionCount numbers the sessions joined, for their labels.
+var sessionCount int64
+
+// sessionLabel returns the label of the next session joined, --agent-name
+// or wick-HOST-PID followed by the number of the session.
+func sessionLabel() string {
+	name := *agentName
+	if name == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "localhost"
+		}
+		name = fmt.Sprintf("wick-%s-%d", host, os.Getpid())
+	}
+	return fmt.Sprintf("%s-%d", name, atomic.AddInt64(&sessionCount, 1))
+}
+
+// ticketSource returns where fresh tickets are fetched from, nil if the
+// ticket is static.
+func ticketSource() wamp.TicketSource {
+	switch {
+	case *ticketCommand != "":
+		return wamp.TicketCommand(*ticketCommand)
+	case *oauthTokenURL != "":
+		return wamp.OAuthTicket(wamp.OAuthOptions{
+			TokenURL:     *oauthTokenURL,
+			ClientID:     *oauthClientID,
+			ClientSecret: *oauthClientSecret,
+			Scope:        *oauthScope,
+		})
+	}
+	return nil
+}
+
+func runScenario(serializerToUse serialize.Serialization, connectOptions wamp.ConnectOptions,
+	logger *logrus.Logger) error {
+
+	scenario, err := wamp.LoadScenario(*runFile)
+	if err != nil {
+		return err
+	}
+
+	return wamp.RunScenario(scenario, func(routerURL string, realmName string) (*client.Client, error) {
+		if routerURL == "" {
+			routerURL = *url
+		}
+		if realmName == "" {
+			realmName = firstRealm()
+		}
+		return connectSession(routerURL, realmName, serializerToUse, connectOptions, logger)
+	}, logger)
+}
+
+// runMock serves the procedures of the mock file on --url and --realm until
+// CTRL-c.
+func runMock(serializerToUse serialize.Serialization, connectOptions wamp.ConnectOptions,
+	logger *logrus.Logger) error {
+
+	mock, err := wamp.LoadMock(*mockFile)
+	if err != nil {
+		return err
+	}
+	session, err := connectSession(*url, firstRealm(), serializerToUse, connectOptions, logger)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	return wamp.RunMock(session, mock, os.Stdout, logger)
+}
+
+// runVerify calls the contracts of the contracts file on --url and --realm
+// and prints the report.
+func runVerify(serializerToUse serialize.Serialization, connectOptions wamp.ConnectOptions,
+	logger *logrus.Logger) error {
+
+	contracts, err := wamp.LoadContracts(*verifyFile)
+	if err != nil {
+		return err
+	}
+	session, err := connectSession(*url, firstRealm(), serializerToUse, connectOptions, logger)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	return wamp.VerifyContracts(session, contracts, os.Stdout)
+}
+
+// runScript runs the scenario script of the script command until it ends or
+// CTRL-c, its sessions joining --url and --realm unless it says otherwise.
+func runScript(serializerToUse serialize.Serialization, connectOptions wamp.ConnectOptions,
+	logger *logrus.Logger) error {
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	return wamp.RunScript(ctx, *scriptFile, *scriptArgs, func(routerURL string, realmName string) (*client.Client,
+		error) {
+
+		if routerURL == "" {
+			routerURL = *url
+		}
+		if realmName == "" {
+			realmName = firstRealm()
+		}
+		return connectSession(routerURL, realmName, serializerToUse, connectOptions, logger)
+	}, os.Stdout, logger)
+}
+
+func runBridge(serializerToUse serialize.Serialization, connectOptions wamp.ConnectOptions,
+	logger *logrus.Logger) error {
+
+	orDefault := func(value string, fallback string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	}
+	fromURL, fromRealm := orDefault(*bridgeFromURL, *url), orDefault(*bridgeFromRealm, firstRealm())
+	toURL, toRealm := orDefault(*bridgeToURL, *url), orDefault(*bridgeToRealm, firstRealm())
+	if fromURL == toURL && fromRealm == toRealm && len(*bridgeRewrite) == 0 {
+		return errors.New("bridging a realm to itself would forward events forever, use --rewrite")
+	}
+
+	from, err := connectSession(fromURL, fromRealm, serializerToUse, connectOptions, logger)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	to, err := connectSession(toURL, toRealm, serializerToUse, connectOptions, logger)
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+
+	return wamp.Bridge(from, to, logger, wamp.BridgeOptions{
+		Topic:     *bridgeTopic,
+		Match:     *bridgeMatch,
+		Rewrite:   *bridgeRewrite,
+		SameRealm: fromURL == toURL && fromRealm == toRealm,
+	})
+}
+
+func runProxy(serializerToUse serialize.Serialization, connectOptions wamp.ConnectOptions,
+	logger *logrus.Logger) error {
+
+	orDefault := func(value string, fallback string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	}
+	target := orDefault(*proxyTarget, *proxyProcedure)
+	targetURL, targetRealm := orDefault(*proxyTargetURL, *url), orDefault(*proxyTargetRealm, firstRealm())
+	if targetURL == *url && targetRealm == firstRealm() && target == *proxyProcedure {
+		return errors.New("proxying a procedure to itself would forward invocations forever, use --target")
+	}
+	extra, err := wamp.ParseOptions(*proxyOptions)
+	if err != nil {
+		return err
+	}
+
+	local, err := connectSession(*url, firstRealm(), serializerToUse, connectOptions, logger)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+	remote, err := connectSession(targetURL, targetRealm, serializerToUse, connectOptions, logger)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	return wamp.Proxy(local, remote, logger, wamp.ProxyOptions{
+		Procedure: *proxyProcedure,
+		Target:    target,
+		Extra:     extra,
+	})
+}
+
+func startRouter(logger *logrus.Logger) {
+	options := wamp.RouterOptions{
+		Host:        *routerHost,
+		Port:        *routerPort,
+		Realms:      append([]string{firstRealm()}, *routerExtraRealms...),
+		Anonymous:   *routerAnonymous,
+		Tickets:     *routerPrincipals,
+		Serializers: *routerSerializers,
+		MetaKill:    *routerMetaKill,
+		Compression: *wsCompression,
+	}
+	closer, err := wamp.StartRouter(options, logger)
+	if err != nil {
+		logger.Fatal("Failed to start router: ", err)
+	}
+	fmt.Printf("Router listening on ws://%s:%d/ws\n", *routerHost, *routerPort)
+
+	// Wait for CTRL-c, then shut the router down.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	<-sigChan
+	closer.Close()
+}
+
+// confirm asks question on the terminal and returns an error unless it is
+// answered with yes. Without a terminal nobody can answer, --yes is needed.
+func confirm(question string) error {
+	if !isTerminal(os.Stdin) {
+		return errors.New("stdin is not a terminal to confirm on, use --yes")
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("not confirmed")
+}
+
+// isTerminal reports whether file is a terminal rather than a pipe or file.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// splitList splits the comma separated items of values, so list flags can
+// be given once as a,b or repeated.
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
 	}
+	return items
 }
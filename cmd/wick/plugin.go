@@ -0,0 +1,154 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/codebasepk/wick/wamp"
+)
+
+// pluginCommand is the command running a plugin, with the arguments given
+// to it.
+type pluginCommand struct {
+	plugin wamp.Plugin
+	args   *[]string
+}
+
+// registerPlugins adds a command for every plugin of the plugin directory,
+// except those named like a builtin command, which cannot be replaced.
+func registerPlugins() (map[string]pluginCommand, error) {
+	plugins, err := wamp.FindPlugins(wamp.DefaultPluginDir())
+	if err != nil {
+		return nil, err
+	}
+	commands := map[string]pluginCommand{}
+	for _, plugin := range plugins {
+		if kingpin.CommandLine.GetCommand(plugin.Name) != nil {
+			continue
+		}
+		command := kingpin.Command(plugin.Name, "Run the plugin "+plugin.Path+".")
+		commands[plugin.Name] = pluginCommand{
+			plugin: plugin,
+			args:   command.Arg("args", "The arguments of the plugin").Strings(),
+		}
+	}
+	return commands, nil
+}
+
+// passPluginArgs puts "--" after the plugin command of args, if any, for
+// its flags to be given to the plugin instead of parsed by wick.
+func passPluginArgs(args []string, commands map[string]pluginCommand) []string {
+	valueFlags := map[string]bool{}
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		if !flag.IsBoolFlag() {
+			valueFlags[flag.Name] = true
+		}
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "-") {
+			if !strings.Contains(arg, "=") && valueFlags[strings.TrimPrefix(arg, "--")] {
+				i++
+			}
+			continue
+		}
+		if _, ok := commands[arg]; !ok {
+			break
+		}
+		passed := append([]string{}, args[:i+1]...)
+		passed = append(passed, "--")
+		return append(passed, args[i+1:]...)
+	}
+	return args
+}
+
+// runPlugin runs the plugin of command in the session settings of the
+// global flags, and returns its exit code.
+func runPlugin(command pluginCommand) (int, error) {
+	profile := wamp.NewProfile(wamp.ClientConfig{
+		URL:        *url,
+		Realm:      firstRealm(),
+		AuthMethod: *authMethod,
+		AuthID:     *authid,
+		AuthRole:   *authrole,
+		Ticket:     *ticket,
+		Secret:     *secret,
+		PrivateKey: *privateKey,
+	}, *serializer, true)
+	env, err := wamp.PluginEnv(os.Environ(), profile)
+	if err != nil {
+		return exitError, err
+	}
+	// Fresh tickets are fetched by the wick commands of the plugin too.
+	for name, value := range map[string]string{
+		"WICK_TICKET_COMMAND":      *ticketCommand,
+		"WICK_OAUTH_TOKEN_URL":     *oauthTokenURL,
+		"WICK_OAUTH_CLIENT_ID":     *oauthClientID,
+		"WICK_OAUTH_CLIENT_SECRET": *oauthClientSecret,
+		"WICK_OAUTH_SCOPE":         *oauthScope,
+		"WICK_CHANNEL_BINDING":     *channelBinding,
+	} {
+		if value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	if executable, err := os.Executable(); err == nil {
+		env = append(env, "WICK_EXECUTABLE="+executable)
+	}
+
+	plugin := exec.Command(command.plugin.Path, *command.args...)
+	plugin.Env = env
+	plugin.Stdin = os.Stdin
+	plugin.Stdout = os.Stdout
+	plugin.Stderr = os.Stderr
+
+	// CTRL-c reaches the plugin too, wick waits for it to exit.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	err = plugin.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// Killed by a signal, like CTRL-c.
+		if exitErr.ExitCode() < 0 {
+			return exitCanceled, nil
+		}
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return exitError, err
+	}
+	return exitOK, nil
+}
@@ -0,0 +1,169 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+
+	"github.com/codebasepk/wick/wamp"
+)
+
+// runOutput is what a repeated run prints and writes once done.
+type runOutput struct {
+	// quiet turns the progress and the summary off.
+	quiet bool
+
+	// statsFile and reportFile are written if not empty, the report lists
+	// every operation if samples.
+	statsFile  string
+	reportFile string
+	samples    bool
+
+	// baseline, if not nil, is compared with the run, which fails if it
+	// regressed by more than threshold percent, if positive.
+	baseline  *wamp.Baseline
+	threshold float64
+
+	// workerStats is written with the stats for the coordinator, if not
+	// empty.
+	workerStats string
+
+	// bandwidth counts the operations of the run, if not nil.
+	bandwidth *bandwidthCounter
+}
+
+// bandwidthCounter counts the traffic of a command for --show-bandwidth.
+type bandwidthCounter struct {
+	bytes *wamp.ByteCounter
+	start time.Time
+
+	// ops are the operations of the repeated runs of the command.
+	ops int
+}
+
+// print writes the traffic of the command to stderr, if it had any.
+func (b *bandwidthCounter) print() {
+	if b.bytes.Sent()+b.bytes.Received() > 0 {
+		fmt.Fprintln(os.Stderr, b.bytes.Summary(time.Since(b.start), b.ops))
+	}
+}
+
+// runOutputFromFlags returns the output of repeated runs set by the global
+// flags.
+func runOutputFromFlags(baseline *wamp.Baseline, threshold float64, bandwidth *bandwidthCounter) runOutput {
+	return runOutput{quiet: *quiet, statsFile: *statsFile, reportFile: *reportFile, samples: *reportSamples,
+		baseline: baseline, threshold: threshold, workerStats: *workerStatsFile, bandwidth: bandwidth}
+}
+
+// runRepeated runs fn count times on every session at once, or total times
+// in all spread over the sessions if not zero, concurrency runs in flight
+// per session, with a context canceled on CTRL-c, recording the runs in
+// stats. Unless quiet, a progress line is printed to stderr every second and
+// a summary at the end, with a line per session if there are several. The
+// stats and report files are written and the run compared with the baseline
+// after output.
+func runRepeated(sessions []*client.Client, infos map[*client.Client]sessionInfo, count int, total int,
+	concurrency int, stats *wamp.Stats, output runOutput,
+	fn func(ctx context.Context, session *client.Client) error) error {
+
+	quiet := output.quiet
+	stats.KeepSamples = output.samples && output.reportFile != ""
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	report := !quiet && (count > 1 || total > 1)
+	var stopReport func()
+	if report {
+		stopReport = stats.Report(os.Stderr, time.Second)
+	}
+	var err error
+	if total > 0 {
+		err = wamp.RepeatTotal(ctx, sessions, total, concurrency, stats, fn)
+	} else {
+		err = wamp.Repeat(ctx, sessions, count, concurrency, stats, fn)
+	}
+	if report {
+		stopReport()
+		fmt.Fprintln(os.Stderr, stats.Summary())
+	}
+	for _, session := range sessions {
+		if sessionStats := stats.Sessions[session.ID()]; sessionStats != nil {
+			sessionStats.Join = infos[session].join
+			if !quiet {
+				fmt.Fprintf(os.Stderr, "session %v: %s\n", session.ID(), sessionStats.SessionSummary())
+			}
+		}
+	}
+
+	if output.bandwidth != nil {
+		output.bandwidth.ops += stats.Ops
+	}
+	return writeRunOutput(stats, output, err)
+}
+
+// writeRunOutput writes the stats and report files of a run that failed with
+// err, if not nil, and compares it with the baseline. The first error is
+// returned.
+func writeRunOutput(stats *wamp.Stats, output runOutput, err error) error {
+	if output.workerStats != "" {
+		if writeErr := writeJSON(output.workerStats, stats.WorkerStats()); writeErr != nil && err == nil {
+			err = writeErr
+		}
+	}
+	if output.statsFile != "" && stats.Ops > 0 {
+		if writeErr := writeJSON(output.statsFile, stats); writeErr != nil && err == nil {
+			err = writeErr
+		}
+	}
+	if output.reportFile != "" && stats.Ops > 0 {
+		if writeErr := wamp.WriteReport(output.reportFile, stats); writeErr != nil && err == nil {
+			err = writeErr
+		}
+	}
+	if output.baseline != nil && stats.Ops > 0 {
+		// The comparison is printed even if quiet, it is what was asked for.
+		if compareErr := output.baseline.Compare(stats, output.threshold, os.Stderr); compareErr != nil &&
+			err == nil {
+			err = compareErr
+		}
+	}
+	return err
+}
+
+// writeJSON writes value as indented JSON to path.
+func writeJSON(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,177 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	runtimedebug "runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Set at build time by goreleaser, with -X main.version=... and so on.
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// latestReleaseURL is the GitHub API for the newest wick release.
+const latestReleaseURL = "https://api.github.com/repos/codebasepk/wick/releases/latest"
+
+// buildInfo describes the wick binary, for inventories.
+type buildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	Date    string `json:"date,omitempty"`
+	Go      string `json:"go"`
+	OS      string `json:"os"`
+	Arch    string `json:"arch"`
+	Nexus   string `json:"nexus,omitempty"`
+
+	// Latest and UpdateAvailable are only set with --check-update.
+	Latest          string `json:"latest,omitempty"`
+	UpdateAvailable *bool  `json:"update_available,omitempty"`
+}
+
+// binaryInfo returns the build info of the running binary.
+func binaryInfo() buildInfo {
+	info := buildInfo{
+		Version: version,
+		Commit:  commit,
+		Date:    date,
+		Go:      runtime.Version(),
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+	}
+	if build, ok := runtimedebug.ReadBuildInfo(); ok {
+		for _, module := range build.Deps {
+			if module.Path == "github.com/gammazero/nexus/v3" {
+				info.Nexus = module.Version
+			}
+		}
+	}
+	return info
+}
+
+// checkUpdate sets the latest release of info from GitHub.
+func checkUpdate(info *buildInfo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, latestReleaseURL, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("checking for updates: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("checking for updates: %s", response.Status)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&release); err != nil {
+		return fmt.Errorf("checking for updates: %w", err)
+	}
+	info.Latest = strings.TrimPrefix(release.TagName, "v")
+	available := compareVersions(info.Latest, strings.TrimPrefix(info.Version, "v")) > 0
+	info.UpdateAvailable = &available
+	return nil
+}
+
+// compareVersions compares dotted versions number by number, a version that
+// is not numeric, like dev, is older than any other.
+func compareVersions(a string, b string) int {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		aNumber, bNumber := versionNumber(aParts, i), versionNumber(bParts, i)
+		if aNumber != bNumber {
+			if aNumber < bNumber {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionNumber returns the i-th number of a split version, 0 if missing and
+// -1 if not a number.
+func versionNumber(parts []string, i int) int {
+	if i >= len(parts) {
+		return 0
+	}
+	// Pre-release suffixes, like 1.2.0-rc1, are ignored.
+	number, err := strconv.Atoi(strings.SplitN(parts[i], "-", 2)[0])
+	if err != nil {
+		return -1
+	}
+	return number
+}
+
+// printVersion prints the build info, as text or JSON, and the latest
+// release if checkForUpdate.
+func printVersion(out io.Writer, output string, checkForUpdate bool) error {
+	info := binaryInfo()
+	if checkForUpdate {
+		if err := checkUpdate(&info); err != nil {
+			return err
+		}
+	}
+
+	if output == "json" {
+		return json.NewEncoder(out).Encode(info)
+	}
+
+	fmt.Fprintf(out, "wick %s\n", info.Version)
+	for _, field := range []struct{ name, value string }{
+		{"commit", info.Commit},
+		{"built", info.Date},
+		{"go", info.Go + " " + info.OS + "/" + info.Arch},
+		{"nexus", info.Nexus},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(out, "  %-7s %s\n", field.name+":", field.value)
+		}
+	}
+	if info.UpdateAvailable != nil {
+		if *info.UpdateAvailable {
+			fmt.Fprintf(out, "A new release is available: %s, https://github.com/codebasepk/wick/releases\n",
+				info.Latest)
+		} else {
+			fmt.Fprintln(out, "wick is up to date")
+		}
+	}
+	return nil
+}
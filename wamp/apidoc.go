@@ -0,0 +1,271 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaSuffix ends the URI of the procedure describing a procedure by
+// convention, com.app.add.__schema describing com.app.add.
+const SchemaSuffix = ".__schema"
+
+// Formats of API documents.
+const (
+	APIDocFormatYAML = "yaml"
+	APIDocFormatJSON = "json"
+)
+
+// APIDocOptions holds the options of APIDoc.
+type APIDocOptions struct {
+	// Prefix keeps only the URIs starting with it.
+	Prefix string
+
+	// Schemas calls the SchemaSuffix procedure of every procedure that has
+	// one registered, for its description and the schemas of its payloads.
+	Schemas bool
+
+	// Title and Version are the info of the document, the realm and 1.0.0
+	// if empty.
+	Title   string
+	Version string
+
+	// URL and Realm are the server of the document.
+	URL   string
+	Realm string
+
+	// Format is one of the APIDocFormat formats, YAML if empty.
+	Format string
+}
+
+// asyncAPIDoc is an AsyncAPI 2 document, a channel for every procedure and
+// topic.
+type asyncAPIDoc struct {
+	AsyncAPI string                     `yaml:"asyncapi" json:"asyncapi"`
+	Info     asyncAPIInfo               `yaml:"info" json:"info"`
+	Servers  map[string]asyncAPIServer  `yaml:"servers" json:"servers"`
+	Channels map[string]asyncAPIChannel `yaml:"channels" json:"channels"`
+}
+
+type asyncAPIInfo struct {
+	Title   string `yaml:"title" json:"title"`
+	Version string `yaml:"version" json:"version"`
+}
+
+type asyncAPIServer struct {
+	URL      string                 `yaml:"url" json:"url"`
+	Protocol string                 `yaml:"protocol" json:"protocol"`
+	Bindings map[string]interface{} `yaml:"bindings,omitempty" json:"bindings,omitempty"`
+}
+
+// asyncAPIChannel describes a procedure, the messages clients send to it
+// as its publish operation, or a topic, the events clients receive as its
+// subscribe operation. The x-wamp fields are what the meta API tells.
+type asyncAPIChannel struct {
+	Description string             `yaml:"description,omitempty" json:"description,omitempty"`
+	Publish     *asyncAPIOperation `yaml:"publish,omitempty" json:"publish,omitempty"`
+	Subscribe   *asyncAPIOperation `yaml:"subscribe,omitempty" json:"subscribe,omitempty"`
+	Kind        string             `yaml:"x-wamp-type" json:"x-wamp-type"`
+	Match       string             `yaml:"x-wamp-match" json:"x-wamp-match"`
+	Invoke      string             `yaml:"x-wamp-invoke,omitempty" json:"x-wamp-invoke,omitempty"`
+	Callees     *int               `yaml:"x-wamp-callees,omitempty" json:"x-wamp-callees,omitempty"`
+	Subscribers *int               `yaml:"x-wamp-subscribers,omitempty" json:"x-wamp-subscribers,omitempty"`
+}
+
+type asyncAPIOperation struct {
+	OperationID string          `yaml:"operationId" json:"operationId"`
+	Message     asyncAPIMessage `yaml:"message" json:"message"`
+}
+
+// asyncAPIMessage is the payload of calls and events, an object with args
+// and kwargs like Schema validates, and of procedures the schema of their
+// result as x-wamp-result.
+type asyncAPIMessage struct {
+	Payload interface{} `yaml:"payload" json:"payload"`
+	Result  interface{} `yaml:"x-wamp-result,omitempty" json:"x-wamp-result,omitempty"`
+}
+
+// APIDoc prints to out an AsyncAPI document of the procedures registered
+// and the topics subscribed on the realm of session, as found by the meta
+// procedures like Discover.
+//
+// With options.Schemas, the SchemaSuffix procedure of a procedure is called
+// with no arguments. A string result is its description, an object may
+// have a description, the JSON Schemas of the args and kwargs of its calls
+// and of its result, e.g.
+//
+//	{"description": "Adds two numbers", "args": {"type": "array", "items": {"type": "number"}},
+//	 "result": {"type": "number"}}
+func APIDoc(session *client.Client, logger *logrus.Logger, options APIDocOptions, out io.Writer) error {
+	ctx := context.Background()
+	procedures, err := listCatalog(ctx, session, wamp.MetaProcRegList, wamp.MetaProcRegGet,
+		wamp.MetaProcRegCountCallees, options.Prefix)
+	if err != nil {
+		return err
+	}
+	topics, err := listCatalog(ctx, session, wamp.MetaProcSubList, wamp.MetaProcSubGet,
+		wamp.MetaProcSubCountSubscribers, options.Prefix)
+	if err != nil {
+		return err
+	}
+
+	registered := map[string]bool{}
+	for _, entry := range procedures {
+		registered[entry.uri] = true
+	}
+
+	doc := asyncAPIDoc{
+		AsyncAPI: "2.6.0",
+		Info:     asyncAPIInfo{Title: options.Title, Version: options.Version},
+		Servers: map[string]asyncAPIServer{"router": {
+			URL:      options.URL,
+			Protocol: "wamp",
+			Bindings: map[string]interface{}{"x-wamp": map[string]interface{}{"realm": options.Realm}},
+		}},
+		Channels: map[string]asyncAPIChannel{},
+	}
+	if doc.Info.Title == "" {
+		doc.Info.Title = options.Realm
+	}
+	if doc.Info.Version == "" {
+		doc.Info.Version = "1.0.0"
+	}
+
+	for _, entry := range procedures {
+		// Schema procedures document the others, not themselves.
+		if strings.HasSuffix(entry.uri, SchemaSuffix) && registered[strings.TrimSuffix(entry.uri, SchemaSuffix)] {
+			continue
+		}
+		callees := entry.count
+		channel := asyncAPIChannel{
+			Kind:    "procedure",
+			Match:   entry.match,
+			Invoke:  entry.invoke,
+			Callees: &callees,
+			Publish: &asyncAPIOperation{
+				OperationID: "call " + entry.uri,
+				Message:     asyncAPIMessage{Payload: payloadSchema(nil, nil)},
+			},
+		}
+		if options.Schemas && registered[entry.uri+SchemaSuffix] {
+			if err = describeChannel(ctx, session, entry.uri, &channel); err != nil {
+				logger.WithField("procedure", entry.uri).Warn("no schema: ", err)
+			}
+		}
+		doc.Channels[entry.uri] = channel
+	}
+
+	for _, entry := range topics {
+		subscribers := entry.count
+		channel := asyncAPIChannel{
+			Kind:        "topic",
+			Match:       entry.match,
+			Subscribers: &subscribers,
+			Subscribe: &asyncAPIOperation{
+				OperationID: "event " + entry.uri,
+				Message:     asyncAPIMessage{Payload: payloadSchema(nil, nil)},
+			},
+		}
+		// A procedure and a topic may share the URI, the topic is then
+		// described with it.
+		if procedure, ok := doc.Channels[entry.uri]; ok {
+			procedure.Subscribe = channel.Subscribe
+			procedure.Subscribers = channel.Subscribers
+			channel = procedure
+		}
+		doc.Channels[entry.uri] = channel
+	}
+
+	switch options.Format {
+	case APIDocFormatJSON:
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	case "", APIDocFormatYAML:
+		encoder := yaml.NewEncoder(out)
+		encoder.SetIndent(2)
+		if err = encoder.Encode(doc); err != nil {
+			return err
+		}
+		return encoder.Close()
+	}
+	return fmt.Errorf("unknown API document format: %s", options.Format)
+}
+
+// describeChannel sets the description and payload schemas of the channel
+// of a procedure to what its schema procedure returns.
+func describeChannel(ctx context.Context, session *client.Client, uri string, channel *asyncAPIChannel) error {
+	result, err := session.Call(ctx, uri+SchemaSuffix, nil, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	var described interface{} = map[string]interface{}(result.ArgumentsKw)
+	if len(result.Arguments) > 0 {
+		described = result.Arguments[0]
+	}
+	if description, ok := wamp.AsString(described); ok {
+		channel.Description = description
+		return nil
+	}
+	// Decoded as JSON, the document has the types of the schemas as sent.
+	var schema map[string]interface{}
+	if err = json.Unmarshal([]byte(jsonString(printable(described, BinaryFormatBase64))), &schema); err != nil ||
+		schema == nil {
+		return fmt.Errorf("want a description or an object of schemas, got %s", jsonString(described))
+	}
+	if description, ok := schema["description"].(string); ok {
+		channel.Description = description
+	}
+	channel.Publish.Message = asyncAPIMessage{
+		Payload: payloadSchema(schema["args"], schema["kwargs"]),
+		Result:  schema["result"],
+	}
+	return nil
+}
+
+// payloadSchema returns the JSON Schema of a payload with args and kwargs
+// matching the given schemas, any list and object if nil.
+func payloadSchema(args interface{}, kwargs interface{}) map[string]interface{} {
+	if args == nil {
+		args = map[string]interface{}{"type": "array"}
+	}
+	if kwargs == nil {
+		kwargs = map[string]interface{}{"type": "object"}
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"args": args, "kwargs": kwargs},
+	}
+}
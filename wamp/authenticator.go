@@ -0,0 +1,145 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Authenticator answers the challenges of one WAMP authentication method.
+type Authenticator interface {
+	// AuthExtra returns the entries the method adds to the authextra of
+	// HELLO, nil if none.
+	AuthExtra() wamp.Dict
+
+	// Authenticate returns the signature and extra of the CHALLENGE answer.
+	Authenticate(challenge *wamp.Challenge) (string, wamp.Dict)
+}
+
+// AuthenticatorFactory returns the Authenticator of a session, from the
+// credentials of cfg.
+type AuthenticatorFactory func(cfg ClientConfig, logger *logrus.Logger) (Authenticator, error)
+
+var (
+	authenticatorsMu sync.RWMutex
+	authenticators   = map[string]AuthenticatorFactory{}
+)
+
+func init() {
+	RegisterAuthenticator(AuthTicket, func(cfg ClientConfig, logger *logrus.Logger) (Authenticator, error) {
+		return funcAuthenticator{handler: ticketAuth(cfg.Ticket)}, nil
+	})
+	RegisterAuthenticator(AuthWAMPCRA, func(cfg ClientConfig, logger *logrus.Logger) (Authenticator, error) {
+		return funcAuthenticator{handler: craAuth(cfg.Secret)}, nil
+	})
+	RegisterAuthenticator(AuthCryptosign, newCryptosignAuthenticator)
+}
+
+// RegisterAuthenticator makes sessions with the authentication method
+// answer its challenges with the Authenticator of factory, replacing the
+// factory registered before for method, if any. Registering anonymous
+// panics, it needs no Authenticator.
+func RegisterAuthenticator(method string, factory AuthenticatorFactory) {
+	if method == "" || method == AuthAnonymous {
+		panic(fmt.Sprintf("cannot register an authenticator for %q", method))
+	}
+	authenticatorsMu.Lock()
+	defer authenticatorsMu.Unlock()
+	authenticators[method] = factory
+}
+
+// AuthMethods returns the sorted authentication methods sessions can use,
+// anonymous and the registered ones.
+func AuthMethods() []string {
+	authenticatorsMu.RLock()
+	defer authenticatorsMu.RUnlock()
+	methods := []string{AuthAnonymous}
+	for method := range authenticators {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// lookupAuthenticator returns the factory registered for method.
+func lookupAuthenticator(method string) (AuthenticatorFactory, bool) {
+	authenticatorsMu.RLock()
+	defer authenticatorsMu.RUnlock()
+	factory, ok := authenticators[method]
+	return factory, ok
+}
+
+// funcAuthenticator is an Authenticator of a challenge handler, with no
+// authextra.
+type funcAuthenticator struct {
+	handler func(*wamp.Challenge) (string, wamp.Dict)
+}
+
+func (a funcAuthenticator) AuthExtra() wamp.Dict {
+	return nil
+}
+
+func (a funcAuthenticator) Authenticate(challenge *wamp.Challenge) (string, wamp.Dict) {
+	return a.handler(challenge)
+}
+
+// cryptosignAuthenticator signs challenges with an ed25519 key, sending its
+// public key and channel binding in the authextra.
+type cryptosignAuthenticator struct {
+	pvk            ed25519.PrivateKey
+	channelBinding string
+	logger         *logrus.Logger
+}
+
+// newCryptosignAuthenticator returns the cryptosign Authenticator of the
+// private key of cfg.
+func newCryptosignAuthenticator(cfg ClientConfig, logger *logrus.Logger) (Authenticator, error) {
+	pvk, err := cryptosignKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ChannelBinding != "" && cfg.ChannelBinding != ChannelBindingTLSUnique &&
+		cfg.ChannelBinding != ChannelBindingTLSExporter {
+		return nil, fmt.Errorf("unknown channel binding: %s", cfg.ChannelBinding)
+	}
+	return cryptosignAuthenticator{pvk: pvk, channelBinding: cfg.ChannelBinding, logger: logger}, nil
+}
+
+func (a cryptosignAuthenticator) AuthExtra() wamp.Dict {
+	extra := wamp.Dict{"pubkey": hex.EncodeToString(a.pvk.Public().(ed25519.PublicKey))}
+	if a.channelBinding != "" {
+		extra["channel_binding"] = a.channelBinding
+	}
+	return extra
+}
+
+func (a cryptosignAuthenticator) Authenticate(challenge *wamp.Challenge) (string, wamp.Dict) {
+	return cryptosignAuth(a.pvk, nil, a.logger)(challenge)
+}
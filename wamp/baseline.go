@@ -0,0 +1,107 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Baseline holds the figures of a previous run that a new run is compared
+// against, read from a JSON report or stats file.
+type Baseline struct {
+	Rate    float64 `json:"rate"`
+	Latency struct {
+		P99 float64 `json:"p99"`
+	} `json:"latency_seconds"`
+}
+
+// RegressionError is returned when a run regressed against its baseline by
+// more than the allowed threshold.
+type RegressionError struct {
+	Regressions []string
+}
+
+func (e *RegressionError) Error() string {
+	return "regression against baseline: " + strings.Join(e.Regressions, ", ")
+}
+
+// LoadBaseline reads the baseline of the JSON report or stats file at path.
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	baseline := &Baseline{}
+	if err = json.Unmarshal(data, baseline); err != nil {
+		return nil, fmt.Errorf("invalid baseline %s: %w", path, err)
+	}
+	if baseline.Rate <= 0 || baseline.Latency.P99 <= 0 {
+		return nil, fmt.Errorf("invalid baseline %s: no rate or p99 latency", path)
+	}
+	return baseline, nil
+}
+
+// ParseThreshold parses a regression threshold in percent, like "10%" or
+// "10".
+func ParseThreshold(value string) (float64, error) {
+	threshold, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 64)
+	if err != nil || threshold < 0 {
+		return 0, fmt.Errorf("invalid regression threshold: %s", value)
+	}
+	return threshold, nil
+}
+
+// Compare writes the change of the rate and p99 latency of stats against the
+// baseline to out. If threshold is positive, a *RegressionError is returned
+// when the rate dropped or the p99 latency grew by more than threshold
+// percent.
+func (b *Baseline) Compare(stats *Stats, threshold float64, out io.Writer) error {
+	rate := stats.Rate()
+	p99 := stats.LatencyPercentile(99)
+	rateChange := (rate - b.Rate) / b.Rate * 100
+	p99Change := (p99.Seconds() - b.Latency.P99) / b.Latency.P99 * 100
+	baselineP99 := time.Duration(b.Latency.P99 * float64(time.Second)).Round(time.Microsecond)
+	fmt.Fprintf(out, "baseline: rate %.0f/s -> %.0f/s (%+.1f%%)  p99 %s -> %s (%+.1f%%)\n", b.Rate, rate,
+		rateChange, baselineP99, p99, p99Change)
+
+	if threshold <= 0 {
+		return nil
+	}
+	var regressions []string
+	if -rateChange > threshold {
+		regressions = append(regressions, fmt.Sprintf("rate dropped %.1f%%", -rateChange))
+	}
+	if p99Change > threshold {
+		regressions = append(regressions, fmt.Sprintf("p99 grew %.1f%%", p99Change))
+	}
+	if len(regressions) > 0 {
+		return &RegressionError{Regressions: regressions}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/sirupsen/logrus"
+)
+
+// BenchOptions configure a comparison of serializers.
+type BenchOptions struct {
+	// Serializers are the names of the serializers to compare, in order.
+	Serializers []string
+
+	// Procedure is called, or Topic published to if empty.
+	Procedure string
+	Topic     string
+
+	Args   []string
+	Kwargs map[string]string
+
+	// Payload is a generated argument sent after Args, nil if none.
+	Payload interface{}
+
+	// Repeat is how many calls or publishes are done per serializer, with
+	// up to Concurrency in flight.
+	Repeat      int
+	Concurrency int
+
+	// Connect joins a session with serializer, counting its traffic with
+	// bytes.
+	Connect func(serializer serialize.Serialization, bytes *ByteCounter) (*client.Client, error)
+}
+
+// BenchResult is the outcome of the workload over one serializer.
+type BenchResult struct {
+	Serializer string
+	Stats      *Stats
+
+	// Sent and Received are the bytes on the wire during the workload,
+	// without the ones of joining.
+	Sent     int64
+	Received int64
+}
+
+// BenchSerializers runs the same workload over every serializer, one after
+// the other, and returns the results in the same order. Publishes are
+// acknowledged, so that their latency is a round trip too.
+func BenchSerializers(ctx context.Context, logger *logrus.Logger, options BenchOptions) ([]BenchResult, error) {
+	if (options.Procedure == "") == (options.Topic == "") {
+		return nil, errors.New("give either a procedure to call or a topic to publish to")
+	}
+	var results []BenchResult
+	for _, name := range options.Serializers {
+		serializer, err := Serializer(name)
+		if err != nil {
+			return results, err
+		}
+		bytes := &ByteCounter{}
+		session, err := options.Connect(serializer, bytes)
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", name, err)
+		}
+		logger.Infof("Running %d operations over %s", options.Repeat, name)
+
+		stats := NewStats()
+		var fn func(ctx context.Context, session *client.Client) error
+		if options.Procedure != "" {
+			callOptions := CallOptions{Payload: options.Payload, Output: ioutil.Discard}
+			fn = func(ctx context.Context, session *client.Client) error {
+				return CallContext(ctx, session, logger, options.Procedure, options.Args, options.Kwargs, callOptions)
+			}
+		} else {
+			prepared, err := PreparePublish(options.Topic, options.Args, options.Kwargs, PublishOptions{
+				Acknowledge: true, Payload: options.Payload})
+			if err != nil {
+				session.Close()
+				return results, err
+			}
+			fn = prepared.Publish
+		}
+		sent, received := bytes.Sent(), bytes.Received()
+		err = Repeat(ctx, []*client.Client{session}, options.Repeat, options.Concurrency, stats, fn)
+		results = append(results, BenchResult{Serializer: name, Stats: stats, Sent: bytes.Sent() - sent,
+			Received: bytes.Received() - received})
+		session.Close()
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return results, nil
+}
+
+// PrintBenchResults writes a table comparing the results to out.
+func PrintBenchResults(results []BenchResult, out io.Writer) {
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "SERIALIZER\tOPS\tRATE\tP50\tP99\tMAX\tSENT\tRECEIVED\tBYTES/OP")
+	for _, result := range results {
+		stats := result.Stats
+		perOp := 0
+		if stats.Ops > 0 {
+			perOp = int((result.Sent + result.Received) / int64(stats.Ops))
+		}
+		fmt.Fprintf(writer, "%s\t%d\t%.0f/s\t%s\t%s\t%s\t%s\t%s\t%s\n", result.Serializer, stats.Ops, stats.Rate(),
+			stats.LatencyPercentile(50), stats.LatencyPercentile(99), stats.Latency.Max.Round(time.Microsecond),
+			formatSize(int(result.Sent)), formatSize(int(result.Received)), formatSize(perOp))
+	}
+	writer.Flush()
+}
@@ -0,0 +1,131 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Binary payloads are printed in one of these formats.
+const (
+	BinaryFormatBase64 = "base64"
+	BinaryFormatHex    = "hex"
+)
+
+// ParseBinaryArg reads a binary argument, either from a file when value is
+// "@path" or by decoding value as base64.
+func ParseBinaryArg(value string) ([]byte, error) {
+	if strings.HasPrefix(value, "@") {
+		return ioutil.ReadFile(value[1:])
+	}
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid binary argument, expected @file or base64: %w", err)
+	}
+	return data, nil
+}
+
+// binaryList returns the binary arguments as byte strings, they are
+// transmitted as such by msgpack and cbor, and base64 encoded by json.
+func binaryList(args [][]byte) wamp.List {
+	arguments := make(wamp.List, len(args))
+	for i, arg := range args {
+		arguments[i] = serialize.BinaryData(arg)
+	}
+	return arguments
+}
+
+// printable replaces byte strings in value, including binary sent through
+// the json serializer, by their base64 or hex representation.
+func printable(value interface{}, format string) interface{} {
+	if data, ok := binaryValue(value); ok {
+		return formatBinary(data, format)
+	}
+	switch v := value.(type) {
+	case wamp.List:
+		return printableList(v, format)
+	case []interface{}:
+		return printableList(v, format)
+	case wamp.Dict:
+		return printableDict(v, format)
+	case map[string]interface{}:
+		return printableDict(v, format)
+	case map[interface{}]interface{}:
+		dict := make(wamp.Dict, len(v))
+		for key, item := range v {
+			dict[fmt.Sprint(key)] = printable(item, format)
+		}
+		return dict
+	default:
+		return v
+	}
+}
+
+// binaryValue returns the bytes of value if it is a byte string, including
+// binary sent through the json serializer.
+func binaryValue(value interface{}) ([]byte, bool) {
+	switch v := value.(type) {
+	case []byte:
+		return v, true
+	case serialize.BinaryData:
+		return v, true
+	case string:
+		if strings.HasPrefix(v, "\x00") {
+			if data, err := base64.StdEncoding.DecodeString(v[1:]); err == nil {
+				return data, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func printableList(list []interface{}, format string) wamp.List {
+	result := make(wamp.List, len(list))
+	for i, item := range list {
+		result[i] = printable(item, format)
+	}
+	return result
+}
+
+func printableDict(dict map[string]interface{}, format string) wamp.Dict {
+	result := make(wamp.Dict, len(dict))
+	for key, item := range dict {
+		result[key] = printable(item, format)
+	}
+	return result
+}
+
+func formatBinary(data []byte, format string) string {
+	if format == BinaryFormatHex {
+		return hex.EncodeToString(data)
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
@@ -0,0 +1,140 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// BridgeOptions configure a bridge.
+type BridgeOptions struct {
+	// Topic is the topic to forward, or the URI prefix or wildcard pattern
+	// depending on Match.
+	Topic string
+
+	// Match is the matching policy of the subscription: "exact", "prefix"
+	// or "wildcard".
+	Match string
+
+	// Rewrite maps URI prefixes of forwarded events to the prefixes they are
+	// published with, the longest matching prefix wins.
+	Rewrite map[string]string
+
+	// SameRealm tells that from and to are joined to the same realm of the
+	// same router. Events that would be published to a topic matching the
+	// subscription are then skipped, as they would be forwarded forever.
+	SameRealm bool
+}
+
+// Bridge subscribes to options.Topic on from and republishes every event to
+// to, with the same args and kwargs, until CTRL-c or one of the sessions is
+// closed.
+func Bridge(from *client.Client, to *client.Client, logger *logrus.Logger, options BridgeOptions) error {
+	prefixes := make([]string, 0, len(options.Rewrite))
+	for prefix := range options.Rewrite {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
+	var forwarded, looped int64
+	eventHandler := func(event *wamp.Event) {
+		topic := options.Topic
+		if actual, ok := wamp.AsString(event.Details["topic"]); ok {
+			topic = actual
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(topic, prefix) {
+				topic = options.Rewrite[prefix] + strings.TrimPrefix(topic, prefix)
+				break
+			}
+		}
+
+		fields := logrus.Fields{"uri": topic}
+		if options.SameRealm && topicMatches(topic, options.Topic, options.Match) {
+			if atomic.AddInt64(&looped, 1) == 1 {
+				logger.WithFields(fields).Warn("Skipped an event that would be forwarded back to the bridge")
+			}
+			return
+		}
+		if err := to.Publish(topic, nil, event.Arguments, event.ArgumentsKw); err != nil {
+			logger.WithFields(fields).Error("Failed to forward event: ", err)
+			return
+		}
+		atomic.AddInt64(&forwarded, 1)
+		logger.WithFields(fields).Debug("forwarded event")
+	}
+
+	subscribeOptions := wamp.Dict{}
+	if options.Match != "" && options.Match != wamp.MatchExact {
+		subscribeOptions[wamp.OptMatch] = options.Match
+	}
+	if err := from.Subscribe(options.Topic, eventHandler, subscribeOptions); err != nil {
+		return err
+	}
+	fmt.Printf("Bridging topic '%s'\n", options.Topic)
+
+	// Wait for CTRL-c or either client close while forwarding events.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	var err error
+	select {
+	case <-sigChan:
+	case <-from.Done():
+		err = errors.New("source router gone")
+	case <-to.Done():
+		err = errors.New("destination router gone")
+	}
+
+	if err == nil {
+		if unsubscribeErr := from.Unsubscribe(options.Topic); unsubscribeErr != nil {
+			logger.WithField("uri", options.Topic).Error("Failed to unsubscribe: ", unsubscribeErr)
+		}
+	}
+	logger.WithFields(logrus.Fields{
+		"count":   atomic.LoadInt64(&forwarded),
+		"skipped": atomic.LoadInt64(&looped),
+	}).Info("Bridge stopped")
+	return err
+}
+
+// topicMatches reports whether a subscription to pattern with the match
+// policy receives the events of topic.
+func topicMatches(topic string, pattern string, match string) bool {
+	switch match {
+	case wamp.MatchPrefix:
+		return wamp.URI(topic).PrefixMatch(wamp.URI(pattern))
+	case wamp.MatchWildcard:
+		return wamp.URI(topic).WildcardMatch(wamp.URI(pattern))
+	}
+	return topic == pattern
+}
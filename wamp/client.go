@@ -0,0 +1,482 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/gammazero/nexus/v3/wamp/crsign"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Authentication methods of a ClientConfig.
+const (
+	AuthAnonymous  = "anonymous"
+	AuthTicket     = "ticket"
+	AuthWAMPCRA    = "wampcra"
+	AuthCryptosign = "cryptosign"
+)
+
+// Channel bindings of cryptosign authentication.
+const (
+	ChannelBindingTLSUnique   = "tls-unique"
+	ChannelBindingTLSExporter = "tls-exporter"
+)
+
+// ClientConfig configures the session opened by Connect.
+type ClientConfig struct {
+	// URL is the router URL, with a ws, wss, http, https, tcp, tcps, rs,
+	// rss or unix scheme.
+	URL   string
+	Realm string
+
+	// Serialization is the serializer of the session, JSON if zero.
+	Serialization serialize.Serialization
+
+	// AuthMethod is anonymous if empty, one of the Auth methods or a method
+	// added with RegisterAuthenticator.
+	AuthMethod string
+	AuthID     string
+	AuthRole   string
+
+	// Ticket, Secret and PrivateKey are the credentials of the ticket,
+	// wampcra and cryptosign methods. PrivateKey is a hex ed25519 seed or
+	// private key.
+	Ticket     string
+	Secret     string
+	PrivateKey string
+
+	// TicketSource fetches the ticket again for every connect attempt,
+	// instead of Ticket. Nil uses Ticket.
+	TicketSource TicketSource
+
+	// AuthExtra is sent as the authextra of HELLO, the entries of the
+	// Authenticator of AuthMethod, like the pubkey of cryptosign, taking
+	// precedence.
+	AuthExtra wamp.Dict
+
+	// Agent is sent as the agent of HELLO, if not empty.
+	Agent string
+
+	// Label identifies the session in the authextra of HELLO, under the
+	// "label" key unless AuthExtra has one, if not empty.
+	Label string
+
+	// ChannelBinding binds cryptosign signatures to the TLS connection, as
+	// one of the ChannelBinding types, to protect them from being replayed
+	// on another connection. Empty does not bind them.
+	ChannelBinding string
+
+	// DialTimeout gives up establishing the transport, and JoinTimeout
+	// joining the realm once connected, after this time. Zero waits until
+	// the context of Connect is done.
+	DialTimeout time.Duration
+	JoinTimeout time.Duration
+
+	// ReconnectAttempts is how many times the transport is dialed again if
+	// it could not be established, after ReconnectBackoff doubled for every
+	// following attempt. A refused join is never retried.
+	ReconnectAttempts int
+	ReconnectBackoff  time.Duration
+
+	// Options are the transport settings, TLS, proxies and tracing.
+	Options ConnectOptions
+
+	// Logger logs the session, the logrus standard logger if nil.
+	Logger *logrus.Logger
+}
+
+// Connect opens a session as cfg says, canceled with ctx.
+func Connect(ctx context.Context, cfg ClientConfig) (*client.Client, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+
+	backoff := cfg.ReconnectBackoff
+	for attempt := 0; ; attempt++ {
+		if cfg.AuthMethod == AuthTicket && cfg.TicketSource != nil {
+			ticket, err := cfg.TicketSource(ctx)
+			if err != nil {
+				return nil, err
+			}
+			cfg.Ticket = ticket
+		}
+		clientConfig, err := cfg.clientConfig(logger)
+		if err != nil {
+			return nil, err
+		}
+
+		session, err := connect(ctx, cfg, clientConfig, logger)
+		var connectErr *ConnectError
+		if err == nil || attempt >= cfg.ReconnectAttempts || !errors.As(err, &connectErr) || ctx.Err() != nil {
+			return session, err
+		}
+
+		logger.WithField("uri", cfg.URL).Warnf("Failed to connect, retrying in %s: %s", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// clientConfig returns the nexus configuration of the realm and
+// authentication method of cfg.
+func (cfg ClientConfig) clientConfig(logger *logrus.Logger) (client.Config, error) {
+	helloDict := wamp.Dict{}
+	if cfg.AuthID != "" {
+		helloDict["authid"] = cfg.AuthID
+	}
+
+	if cfg.AuthRole != "" {
+		helloDict["authrole"] = cfg.AuthRole
+	}
+
+	if cfg.Agent != "" {
+		helloDict["agent"] = cfg.Agent
+	}
+
+	authExtra := wamp.Dict{}
+	if cfg.Label != "" {
+		authExtra["label"] = cfg.Label
+	}
+	for key, value := range cfg.AuthExtra {
+		authExtra[key] = value
+	}
+
+	clientConfig := client.Config{
+		Realm:         cfg.Realm,
+		Logger:        logger,
+		HelloDetails:  helloDict,
+		Serialization: cfg.Serialization,
+		Debug:         cfg.Options.Debug,
+	}
+
+	if cfg.ChannelBinding != "" && cfg.AuthMethod != AuthCryptosign {
+		return client.Config{}, errors.New("channel binding is only supported by cryptosign authentication")
+	}
+	if cfg.AuthMethod != "" && cfg.AuthMethod != AuthAnonymous {
+		factory, ok := lookupAuthenticator(cfg.AuthMethod)
+		if !ok {
+			return client.Config{}, fmt.Errorf("unknown authentication method: %s", cfg.AuthMethod)
+		}
+		authenticator, err := factory(cfg, logger)
+		if err != nil {
+			return client.Config{}, err
+		}
+		for key, value := range authenticator.AuthExtra() {
+			authExtra[key] = value
+		}
+		clientConfig.AuthHandlers = map[string]client.AuthFunc{cfg.AuthMethod: authenticator.Authenticate}
+	}
+
+	if len(authExtra) > 0 {
+		helloDict["authextra"] = authExtra
+	}
+	for method, handler := range clientConfig.AuthHandlers {
+		clientConfig.AuthHandlers[method] = logChallenge(handler, logger)
+	}
+	return clientConfig, nil
+}
+
+// ParseAuthExtra returns authextra given as key=value strings, the values
+// that are JSON objects or arrays decoded like kwargs.
+func ParseAuthExtra(values map[string]string) (wamp.Dict, error) {
+	authExtra := wamp.Dict{}
+	for key, value := range values {
+		decoded, err := structuredValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON value of authextra %s: %w", key, err)
+		}
+		authExtra[key] = decoded
+	}
+	return authExtra, nil
+}
+
+// logChallenge logs the extra data of the challenges that handler answers.
+func logChallenge(handler client.AuthFunc, logger *logrus.Logger) client.AuthFunc {
+	return func(c *wamp.Challenge) (string, wamp.Dict) {
+		if logger.IsLevelEnabled(logrus.DebugLevel) {
+			extra, _ := json.Marshal(c.Extra)
+			logger.WithField("authmethod", c.AuthMethod).Debugf("challenge extra: %s", extra)
+		}
+		return handler(c)
+	}
+}
+
+func ticketAuth(ticket string) client.AuthFunc {
+	return func(c *wamp.Challenge) (string, wamp.Dict) {
+		return ticket, wamp.Dict{}
+	}
+}
+
+func craAuth(secret string) client.AuthFunc {
+	return func(c *wamp.Challenge) (string, wamp.Dict) {
+		ch, _ := wamp.AsString(c.Extra["challenge"])
+		// If the client needed to lookup a user's key, this would require decoding
+		// the JSON-encoded challenge string and getting the authid.  For this
+		// example assume that client only operates as one user and knows the key
+		// to use.
+		saltStr, _ := wamp.AsString(c.Extra["salt"])
+		// If no salt given, use raw password as key.
+		if saltStr == "" {
+			return crsign.SignChallenge(ch, []byte(secret)), wamp.Dict{}
+		}
+
+		// If salting info give, then compute a derived key using PBKDF2.
+		salt := []byte(saltStr)
+		iters, _ := wamp.AsInt64(c.Extra["iterations"])
+		keylen, _ := wamp.AsInt64(c.Extra["keylen"])
+
+		if iters == 0 {
+			iters = 1000
+		}
+		if keylen == 0 {
+			keylen = 32
+		}
+
+		// Compute derived key.
+		dk := pbkdf2.Key([]byte(secret), salt, int(iters), int(keylen), sha256.New)
+		// Get base64 bytes. see https://github.com/gammazero/nexus/issues/252
+		derivedKey := []byte(base64.StdEncoding.EncodeToString(dk))
+
+		return crsign.SignChallenge(ch, derivedKey), wamp.Dict{}
+	}
+}
+
+func cryptosignKey(privateKey string) (ed25519.PrivateKey, error) {
+	privkey, _ := hex.DecodeString(privateKey)
+
+	if len(privkey) == 32 {
+		return ed25519.NewKeyFromSeed(privkey), nil
+	} else if len(privkey) == 64 {
+		return ed25519.NewKeyFromSeed(privkey[:32]), nil
+	}
+	return nil, errors.New("invalid private key. Cryptosign private key must be either 32 or 64 characters long")
+}
+
+// cryptosignAuth signs the challenge with pvk, XORed with channelID first if
+// not nil.
+func cryptosignAuth(pvk ed25519.PrivateKey, channelID []byte, logger *logrus.Logger) client.AuthFunc {
+	return func(c *wamp.Challenge) (string, wamp.Dict) {
+		challengeHex, _ := wamp.AsString(c.Extra["challenge"])
+		challengeBytes, _ := hex.DecodeString(challengeHex)
+
+		if channelID != nil {
+			if len(challengeBytes) != len(channelID) {
+				logger.Errorf("cannot bind a %d bytes challenge to the channel", len(challengeBytes))
+				return "", wamp.Dict{}
+			}
+			bound := make([]byte, len(challengeBytes))
+			for i := range bound {
+				bound[i] = challengeBytes[i] ^ channelID[i]
+			}
+			challengeBytes = bound
+			challengeHex = hex.EncodeToString(bound)
+		}
+
+		signed := ed25519.Sign(pvk, challengeBytes)
+		signedHex := hex.EncodeToString(signed)
+		result := signedHex + challengeHex
+		return result, wamp.Dict{}
+	}
+}
+
+// channelID returns the 32 bytes ID of the TLS channel that cryptosign
+// challenges are bound to, as the WAMP spec defines them for binding.
+func channelID(state *tls.ConnectionState, binding string) ([]byte, error) {
+	if state == nil {
+		return nil, errors.New("channel binding needs a TLS connection, with a wss URL")
+	}
+
+	switch binding {
+	case ChannelBindingTLSUnique:
+		if state.TLSUnique == nil {
+			return nil, errors.New("tls-unique is not available with TLS 1.3, use tls-exporter")
+		}
+		id := sha256.Sum256(state.TLSUnique)
+		return id[:], nil
+	case ChannelBindingTLSExporter:
+		id, err := state.ExportKeyingMaterial("EXPORTER-Channel-Binding", nil, 32)
+		if err != nil {
+			return nil, fmt.Errorf("tls-exporter: %w", err)
+		}
+		return id, nil
+	}
+	return nil, fmt.Errorf("unknown channel binding: %s", binding)
+}
+
+// connect dials the router of cfg and joins the realm of clientConfig, each
+// within its timeout and canceled with ctx.
+func connect(ctx context.Context, cfg ClientConfig, clientConfig client.Config,
+	logger *logrus.Logger) (*client.Client, error) {
+
+	url := cfg.URL
+	if strings.HasPrefix(url, "rs") {
+		url = "tcp" + strings.TrimPrefix(url, "rs")
+	} else if strings.HasPrefix(url, "rss") {
+		url = "tcp" + strings.TrimPrefix(url, "rss")
+	}
+
+	opts := cfg.Options
+	ctx, span := opts.Tracing.start(ctx, "connect", clientConfig.Realm)
+	span.SetAttributes(attribute.String("wamp.router", url))
+
+	start := time.Now()
+	dialCtx, cancel := withTimeout(ctx, cfg.DialTimeout)
+	peer, tlsState, err := dialWithin(dialCtx, url, clientConfig.Serialization, opts, logger)
+	if err != nil && dialCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		err = fmt.Errorf("no connection within %s: %w", cfg.DialTimeout, context.DeadlineExceeded)
+	}
+	cancel()
+	if err != nil {
+		err = &ConnectError{URL: url, Err: err}
+		endSpan(span, err)
+		return nil, err
+	}
+
+	if cfg.ChannelBinding != "" {
+		channelID, err := channelID(tlsState, cfg.ChannelBinding)
+		if err != nil {
+			peer.Close()
+			endSpan(span, err)
+			return nil, err
+		}
+		pvk, _ := cryptosignKey(cfg.PrivateKey)
+		clientConfig.AuthHandlers[AuthCryptosign] = logChallenge(cryptosignAuth(pvk, channelID, logger), logger)
+	}
+
+	joinCtx, cancel := withTimeout(ctx, cfg.JoinTimeout)
+	session, err := join(joinCtx, peer, clientConfig)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		err = fmt.Errorf("no answer to HELLO within %s: %w", cfg.JoinTimeout, err)
+	}
+	cancel()
+	if err != nil {
+		err = &JoinError{Realm: clientConfig.Realm, Err: err}
+		endSpan(span, err)
+		return nil, err
+	}
+	endSpan(span, nil)
+
+	logger.WithFields(logrus.Fields{
+		"session_id":  session.ID(),
+		"uri":         url,
+		"duration_ms": time.Since(start).Milliseconds(),
+	}).Debug("joined realm")
+	opts.Metrics.sessionJoined()
+
+	return session, nil
+}
+
+// withTimeout is context.WithTimeout, without a deadline if timeout is zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// dialWithin is dialPeer, returning once ctx is done even if the transport
+// ignores it, like the raw socket handshake does. A peer connected too late
+// is closed.
+func dialWithin(ctx context.Context, routerURL string, serializer serialize.Serialization, opts ConnectOptions,
+	logger *logrus.Logger) (wamp.Peer, *tls.ConnectionState, error) {
+
+	var peer wamp.Peer
+	var tlsState *tls.ConnectionState
+	var err error
+	dialed := make(chan struct{})
+	go func() {
+		peer, tlsState, err = dialPeer(ctx, routerURL, serializer, opts, logger)
+		close(dialed)
+	}()
+
+	select {
+	case <-dialed:
+		return peer, tlsState, err
+	case <-ctx.Done():
+		go func() {
+			<-dialed
+			if err == nil {
+				peer.Close()
+			}
+		}()
+		return nil, nil, ctx.Err()
+	}
+}
+
+// onceClosePeer closes its peer only once, as nexus closes the peer of a
+// failed join again after join closed it.
+type onceClosePeer struct {
+	wamp.Peer
+	once sync.Once
+}
+
+func (p *onceClosePeer) Close() {
+	p.once.Do(p.Peer.Close)
+}
+
+// join joins the realm of cfg over peer. Nexus cannot cancel a join, so the
+// peer is closed instead if ctx is done first.
+func join(ctx context.Context, peer wamp.Peer, cfg client.Config) (*client.Client, error) {
+	peer = &onceClosePeer{Peer: peer}
+	var session *client.Client
+	var err error
+	joined := make(chan struct{})
+	go func() {
+		session, err = client.NewClient(peer, cfg)
+		close(joined)
+	}()
+
+	select {
+	case <-joined:
+		return session, err
+	case <-ctx.Done():
+		peer.Close()
+		<-joined
+		if err == nil {
+			session.Close()
+		}
+		return nil, ctx.Err()
+	}
+}
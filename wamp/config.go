@@ -0,0 +1,155 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings of the wick config file.
+type Config struct {
+	// Defaults are the flag values every command uses unless given on the
+	// command line or in the environment, by command and flag name, like
+	// {"call": {"timeout": ["5s"]}}. Nested commands are named like
+	// "router start".
+	Defaults map[string]map[string][]string
+
+	// Profiles are named sets of global flag values, like
+	// {"staging": {"url": ["wss://staging.example.com/ws"]}}.
+	Profiles map[string]map[string][]string
+}
+
+// configFile is the YAML layout of the config file:
+//
+//	defaults:
+//	  call:
+//	    timeout: 5s
+//	  publish:
+//	    acknowledge: false
+//	    kwarg: {source: wick}
+//	profiles:
+//	  staging:
+//	    url: wss://staging.example.com/ws
+type configFile struct {
+	Defaults map[string]map[string]interface{} `yaml:"defaults"`
+	Profiles map[string]map[string]interface{} `yaml:"profiles"`
+}
+
+// DefaultConfigFile returns WICK_CONFIG if set, or ~/.wick/config.yaml, or
+// an empty string if there is no home directory.
+func DefaultConfigFile() string {
+	if path := os.Getenv("WICK_CONFIG"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".wick", "config.yaml")
+}
+
+// LoadConfig reads the config file at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file configFile
+	if err = yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	config := &Config{Defaults: map[string]map[string][]string{}, Profiles: map[string]map[string][]string{}}
+	for command, flags := range file.Defaults {
+		if config.Defaults[command], err = configFlags(flags); err != nil {
+			return nil, fmt.Errorf("invalid config %s: %s %w", path, command, err)
+		}
+	}
+	for profile, flags := range file.Profiles {
+		if config.Profiles[profile], err = configFlags(flags); err != nil {
+			return nil, fmt.Errorf("invalid config %s: profile %s %w", path, profile, err)
+		}
+	}
+	return config, nil
+}
+
+// ProfileNames returns the names of the profiles, sorted.
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// configFlags returns the values of flags as given on the command line, by
+// flag name.
+func configFlags(flags map[string]interface{}) (map[string][]string, error) {
+	values := map[string][]string{}
+	for flag, value := range flags {
+		parsed, err := flagValues(value)
+		if err != nil {
+			return nil, fmt.Errorf("--%s: %w", flag, err)
+		}
+		values[flag] = parsed
+	}
+	return values, nil
+}
+
+// flagValues returns value as given on the command line, lists for the
+// flags that can be repeated and maps as key=value.
+func flagValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, errors.New("no value")
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			itemValues, err := flagValues(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, itemValues...)
+		}
+		return values, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		values := make([]string, len(keys))
+		for i, key := range keys {
+			values[i] = fmt.Sprintf("%s=%v", key, v[key])
+		}
+		return values, nil
+	}
+	return []string{fmt.Sprint(value)}, nil
+}
@@ -0,0 +1,403 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"gopkg.in/yaml.v3"
+)
+
+// Contracts are the calls a callee must answer as expected, checked by
+// VerifyContracts.
+type Contracts struct {
+	Contracts []Contract `yaml:"contracts"`
+}
+
+// Contract is a call and what its result or error must be.
+type Contract struct {
+	// Name describes the contract in the report, the procedure if empty.
+	Name string `yaml:"name"`
+
+	Call    string                 `yaml:"call"`
+	Args    []interface{}          `yaml:"args"`
+	Kwargs  map[string]interface{} `yaml:"kwargs"`
+	Options map[string]interface{} `yaml:"options"`
+
+	// Timeout bounds the call, 5 seconds by default.
+	Timeout time.Duration `yaml:"timeout"`
+
+	Expect ContractExpect `yaml:"expect"`
+}
+
+// ContractExpect is what the answer of a contract call must be. Every
+// field that is set is checked, a contract that sets none passes if the
+// call succeeds.
+type ContractExpect struct {
+	// Error is the URI the call must fail with. Args, Kwargs, Shape and
+	// Schema are then checked against the payload of the error.
+	Error string `yaml:"error"`
+
+	// Args must be equal to the args, and each of Kwargs to the kwarg of
+	// the same name.
+	Args   []interface{}          `yaml:"args"`
+	Kwargs map[string]interface{} `yaml:"kwargs"`
+
+	// Shape describes the types of the payload, an object with args and
+	// kwargs whose values are type names, any, string, number, integer,
+	// boolean, null, array or object, ending with ? to also accept null or
+	// missing keys. A list of one shape matches lists whose every item
+	// matches it, a map matches objects that have its keys.
+	Shape map[string]interface{} `yaml:"shape"`
+
+	// Schema is a JSON Schema file the payload must match, relative to the
+	// contracts file, see Schema.
+	Schema string `yaml:"schema"`
+
+	schema *Schema
+}
+
+// shapeTypes are the type names of shapes.
+var shapeTypes = map[string]bool{
+	"any": true, "string": true, "number": true, "integer": true, "boolean": true, "null": true,
+	"array": true, "object": true,
+}
+
+// LoadContracts reads and checks a contracts file, and compiles the schemas
+// it refers to.
+func LoadContracts(path string) (*Contracts, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	contracts := &Contracts{}
+	if err = yaml.Unmarshal(data, contracts); err != nil {
+		return nil, fmt.Errorf("invalid contracts %s: %w", path, err)
+	}
+	if len(contracts.Contracts) == 0 {
+		return nil, fmt.Errorf("invalid contracts %s: no contracts", path)
+	}
+	for i := range contracts.Contracts {
+		contract := &contracts.Contracts[i]
+		if contract.Call == "" {
+			return nil, fmt.Errorf("invalid contracts %s: contract %d has no call", path, i+1)
+		}
+		if contract.Name == "" {
+			contract.Name = contract.Call
+		}
+		if err = checkShape(contract.Expect.Shape, ""); err != nil {
+			return nil, fmt.Errorf("invalid contracts %s: %s: %w", path, contract.Name, err)
+		}
+		if schema := contract.Expect.Schema; schema != "" {
+			if !filepath.IsAbs(schema) {
+				schema = filepath.Join(filepath.Dir(path), schema)
+			}
+			if contract.Expect.schema, err = LoadSchema(schema); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return contracts, nil
+}
+
+// checkShape returns an error if shape has a value that is not a type
+// name, a list of one shape or a map of shapes.
+func checkShape(shape interface{}, path string) error {
+	switch s := shape.(type) {
+	case nil:
+		return nil
+	case string:
+		if !shapeTypes[strings.TrimSuffix(s, "?")] {
+			return fmt.Errorf("shape %s: unknown type %q", shapePath(path), s)
+		}
+	case []interface{}:
+		if len(s) > 1 {
+			return fmt.Errorf("shape %s: a list shape has one item shape", shapePath(path))
+		}
+		if len(s) == 1 {
+			return checkShape(s[0], path+"/*")
+		}
+	case map[string]interface{}:
+		for key, value := range s {
+			if err := checkShape(value, path+"/"+key); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("shape %s: want a type name, got %v", shapePath(path), shape)
+	}
+	return nil
+}
+
+// shapePath returns the JSON pointer of a value of a payload.
+func shapePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// VerifyContracts calls every contract on session, in order, and prints to
+// out whether it passed, how it failed with the differences to what was
+// expected, and the totals. It returns an error if any failed.
+func VerifyContracts(session *client.Client, contracts *Contracts, out io.Writer) error {
+	out = output(out)
+	failed := 0
+	for _, contract := range contracts.Contracts {
+		start := time.Now()
+		failures := contract.verify(session)
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if len(failures) == 0 {
+			fmt.Fprintf(out, "PASS  %s (%s)\n", contract.Name, elapsed)
+			continue
+		}
+		failed++
+		fmt.Fprintf(out, "FAIL  %s (%s)\n", contract.Name, elapsed)
+		for _, failure := range failures {
+			fmt.Fprintf(out, "      %s\n", strings.ReplaceAll(failure, "\n", "\n      "))
+		}
+	}
+
+	total := len(contracts.Contracts)
+	fmt.Fprintf(out, "%d passed, %d failed\n", total-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d contracts failed", failed, total)
+	}
+	return nil
+}
+
+// verify calls the contract and returns why its answer is not the expected
+// one, nothing if it is.
+func (c Contract) verify(session *client.Client) []string {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var args wamp.List
+	var kwargs wamp.Dict
+	result, err := session.Call(ctx, c.Call, c.Options, c.Args, c.Kwargs, nil)
+	var rpcErr client.RPCError
+	switch {
+	case errors.As(err, &rpcErr):
+		if c.Expect.Error == "" {
+			return []string{fmt.Sprintf("call failed with %s: %s", rpcErr.Err.Error,
+				jsonString(nonNilList(rpcErr.Err.Arguments)))}
+		}
+		if string(rpcErr.Err.Error) != c.Expect.Error {
+			return []string{fmt.Sprintf("expected error %s, got %s", c.Expect.Error, rpcErr.Err.Error)}
+		}
+		args, kwargs = rpcErr.Err.Arguments, rpcErr.Err.ArgumentsKw
+	case err != nil:
+		return []string{err.Error()}
+	case c.Expect.Error != "":
+		return []string{fmt.Sprintf("expected error %s, got the result %s", c.Expect.Error,
+			jsonString(nonNilList(result.Arguments)))}
+	default:
+		args, kwargs = result.Arguments, result.ArgumentsKw
+	}
+	args = nonNilList(args)
+	if kwargs == nil {
+		kwargs = wamp.Dict{}
+	}
+
+	var failures []string
+	if c.Expect.Args != nil && !jsonEqual(c.Expect.Args, args) {
+		failures = append(failures, "args differ:\n"+lineDiff(indentedJSON(c.Expect.Args), indentedJSON(args)))
+	}
+	if len(c.Expect.Kwargs) > 0 {
+		// Only the expected kwargs are compared, the others may be anything.
+		actual := map[string]interface{}{}
+		for key := range c.Expect.Kwargs {
+			if value, ok := kwargs[key]; ok {
+				actual[key] = value
+			}
+		}
+		if !jsonEqual(c.Expect.Kwargs, actual) {
+			failures = append(failures, "kwargs differ:\n"+
+				lineDiff(indentedJSON(c.Expect.Kwargs), indentedJSON(actual)))
+		}
+	}
+
+	var payload interface{}
+	json.Unmarshal([]byte(jsonString(printable(wamp.Dict{"args": args, "kwargs": kwargs},
+		BinaryFormatBase64))), &payload)
+	if c.Expect.Shape != nil {
+		if err := matchShape(c.Expect.Shape, payload, ""); err != nil {
+			failures = append(failures, "shape: "+err.Error())
+		}
+	}
+	if err := c.Expect.schema.Validate(args, kwargs); err != nil {
+		failures = append(failures, err.Error())
+	}
+	return failures
+}
+
+// nonNilList returns list, or an empty list if nil, which is printed as []
+// instead of null.
+func nonNilList(list wamp.List) wamp.List {
+	if list == nil {
+		return wamp.List{}
+	}
+	return list
+}
+
+// matchShape returns an error naming the first value of value, at path,
+// that does not have the type of shape.
+func matchShape(shape interface{}, value interface{}, path string) error {
+	switch s := shape.(type) {
+	case string:
+		optional := strings.HasSuffix(s, "?")
+		if optional && value == nil {
+			return nil
+		}
+		kind, want := shapeType(value), strings.TrimSuffix(s, "?")
+		if want == "any" || want == kind || (want == "number" && kind == "integer") {
+			return nil
+		}
+		return fmt.Errorf("%s: want %s, got %s %s", shapePath(path), want, kind, jsonString(value))
+	case []interface{}:
+		list, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: want array, got %s %s", shapePath(path), shapeType(value), jsonString(value))
+		}
+		if len(s) == 0 {
+			return nil
+		}
+		for i, item := range list {
+			if err := matchShape(s[0], item, fmt.Sprintf("%s/%d", path, i)); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		dict, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: want object, got %s %s", shapePath(path), shapeType(value), jsonString(value))
+		}
+		keys := make([]string, 0, len(s))
+		for key := range s {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			item, present := dict[key]
+			if !present {
+				if name, ok := s[key].(string); ok && strings.HasSuffix(name, "?") {
+					continue
+				}
+				return fmt.Errorf("%s/%s: missing", path, key)
+			}
+			if err := matchShape(s[key], item, path+"/"+key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// shapeType returns the shape type name of a decoded JSON value.
+func shapeType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "any"
+}
+
+// indentedJSON returns value as indented JSON, for the lines of diffs.
+func indentedJSON(value interface{}) string {
+	data, err := json.MarshalIndent(printable(value, BinaryFormatBase64), "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+// lineDiff returns the lines of expected and actual, the lines only in
+// expected prefixed with -, those only in actual with + and the common ones
+// with spaces, aligned on their longest common subsequence.
+func lineDiff(expected string, actual string) string {
+	a, b := strings.Split(expected, "\n"), strings.Split(actual, "\n")
+	// common[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	var diff strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(&diff, "  %s\n", a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && common[i+1][j] >= common[i][j+1]):
+			fmt.Fprintf(&diff, "- %s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&diff, "+ %s\n", b[j])
+			j++
+		}
+	}
+	return strings.TrimSuffix(diff.String(), "\n")
+}
@@ -0,0 +1,347 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+	"github.com/ugorji/go/codec"
+)
+
+// Commands run by a daemon.
+const (
+	daemonCall           = "call"
+	daemonPublish        = "publish"
+	daemonTestamentAdd   = "testament-add"
+	daemonTestamentFlush = "testament-flush"
+)
+
+// DaemonOptions configure a daemon.
+type DaemonOptions struct {
+	// Socket is the path of the unix control socket.
+	Socket string
+
+	// Cryptobox encrypts and decrypts the payloads of every call and
+	// publish end-to-end, nil if not used.
+	Cryptobox *Cryptobox
+}
+
+// daemonRequest is sent by a client over the control socket, one per
+// connection. The arguments are given as on the command line, the options
+// without their Cryptobox, Tracing, Schema and the like, which are the
+// business of either the daemon or the client.
+type daemonRequest struct {
+	Command   string
+	URI       string
+	Args      []string
+	Kwargs    map[string]string
+	Call      CallOptions
+	Publish   PublishOptions
+	Testament TestamentOptions
+}
+
+// daemonResponse answers a daemonRequest. Error is the message of the error
+// the command failed with, if any. ErrorURI, ErrorArgs and ErrorKwargs are
+// set if it was a WAMP error, and Timeout or Canceled if it was a context
+// error, so the client exits as if it ran the command itself.
+type daemonResponse struct {
+	Args        wamp.List
+	Kwargs      wamp.Dict
+	Error       string
+	ErrorURI    string
+	ErrorArgs   wamp.List
+	ErrorKwargs wamp.Dict
+	Timeout     bool
+	Canceled    bool
+}
+
+// daemonError is the error a daemon answered with, it unwraps to the
+// context error the daemon saw, if any.
+type daemonError struct {
+	message string
+	err     error
+}
+
+func (e *daemonError) Error() string { return e.message }
+
+func (e *daemonError) Unwrap() error { return e.err }
+
+// err returns the error of the response, nil if the command succeeded.
+func (r daemonResponse) err(uri string) error {
+	switch {
+	case r.ErrorURI != "":
+		return client.RPCError{
+			Err:       &wamp.Error{Error: wamp.URI(r.ErrorURI), Arguments: r.ErrorArgs, ArgumentsKw: r.ErrorKwargs},
+			Procedure: uri,
+		}
+	case r.Timeout:
+		return &daemonError{message: r.Error, err: context.DeadlineExceeded}
+	case r.Canceled:
+		return &daemonError{message: r.Error, err: context.Canceled}
+	case r.Error != "":
+		return errors.New(r.Error)
+	}
+	return nil
+}
+
+// daemonHandle encodes the messages of the control socket. CBOR keeps byte
+// strings apart from text strings, unlike JSON.
+func daemonHandle() *codec.CborHandle {
+	handle := &codec.CborHandle{}
+	handle.MapType = mapStringInterfaceType
+	return handle
+}
+
+// DefaultDaemonSocket returns the control socket path used when none is
+// given, in a directory private to the user: XDG_RUNTIME_DIR if set, or
+// ~/.wick.
+func DefaultDaemonSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "wick.sock")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), fmt.Sprintf("wick-%d", os.Getuid()), "daemon.sock")
+	}
+	return filepath.Join(home, ".wick", "daemon.sock")
+}
+
+// Daemon serves the calls and publishes of clients on the unix socket of
+// options through session, until CTRL-c, SIGTERM or the router going away.
+// That spares the clients the cost of connecting and joining.
+func Daemon(session *client.Client, logger *logrus.Logger, options DaemonOptions) error {
+	// A socket left behind by a daemon that died is removed, one in use is an
+	// error.
+	if conn, err := net.Dial("unix", options.Socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("a daemon is already listening on %s", options.Socket)
+	}
+	os.Remove(options.Socket)
+
+	if err := os.MkdirAll(filepath.Dir(options.Socket), 0700); err != nil {
+		return err
+	}
+	listener, err := listenPrivate(options.Socket)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	fmt.Printf("Daemon listening on %s\n", options.Socket)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-session.Done():
+			logger.Info("Router gone, exiting")
+			stop()
+		}
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go serveDaemonClient(ctx, session, logger, conn, options)
+	}
+}
+
+// serveDaemonClient runs the request of conn and answers with its outcome.
+func serveDaemonClient(ctx context.Context, session *client.Client, logger *logrus.Logger, conn net.Conn,
+	options DaemonOptions) {
+
+	defer conn.Close()
+
+	var request daemonRequest
+	if err := codec.NewDecoder(conn, daemonHandle()).Decode(&request); err != nil {
+		logger.Debug("invalid daemon request: ", err)
+		return
+	}
+
+	// The client hangs up when interrupted, which cancels its call.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ioutil.ReadAll(conn)
+		cancel()
+	}()
+
+	var response daemonResponse
+	var err error
+	switch request.Command {
+	case daemonCall:
+		callOptions := request.Call
+		callOptions.Cryptobox = options.Cryptobox
+		response.Args, response.Kwargs, err = callOnce(ctx, session, logger, request.URI, request.Args,
+			request.Kwargs, callOptions)
+	case daemonPublish:
+		publishOptions := request.Publish
+		publishOptions.Cryptobox = options.Cryptobox
+		var keywordArguments wamp.Dict
+		if keywordArguments, err = dictToWampDict(request.Kwargs, publishOptions.RawKwargs); err != nil {
+			break
+		}
+		arguments := append(listToWampList(request.Args), binaryList(publishOptions.BinaryArgs)...)
+		err = publishOnce(ctx, session, request.URI, arguments, keywordArguments, publishOptions)
+	case daemonTestamentAdd:
+		err = addTestament(ctx, session, request.URI, request.Args, request.Kwargs, request.Testament)
+	case daemonTestamentFlush:
+		err = flushTestaments(ctx, session, request.Testament)
+	default:
+		err = fmt.Errorf("unknown daemon command: %s", request.Command)
+	}
+	logger.WithFields(logrus.Fields{"command": request.Command, "uri": request.URI}).Debug("daemon request done")
+
+	if err != nil {
+		response.Error = err.Error()
+		var rpcErr client.RPCError
+		if errors.As(err, &rpcErr) {
+			response.ErrorURI = string(rpcErr.Err.Error)
+			response.ErrorArgs, response.ErrorKwargs = rpcErr.Err.Arguments, rpcErr.Err.ArgumentsKw
+		}
+		response.Timeout = errors.Is(err, context.DeadlineExceeded)
+		response.Canceled = errors.Is(err, context.Canceled)
+	}
+	if err = codec.NewEncoder(conn, daemonHandle()).Encode(response); err != nil {
+		logger.Debug("failed to answer daemon request: ", err)
+	}
+}
+
+// requestDaemon sends request to the daemon listening on socket and returns
+// its response. CTRL-c hangs up, which makes the daemon cancel the command.
+func requestDaemon(socket string, request daemonRequest) (daemonResponse, error) {
+	var response daemonResponse
+	if err := checkSocketOwner(socket); err != nil && !os.IsNotExist(err) {
+		return response, &ConnectError{URL: socket, Err: err}
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return response, &ConnectError{URL: socket, Err: fmt.Errorf("no daemon listening, start one with "+
+			"'wick daemon': %w", err)}
+	}
+	defer conn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	if err = codec.NewEncoder(conn, daemonHandle()).Encode(request); err != nil {
+		return response, err
+	}
+	if err = codec.NewDecoder(conn, daemonHandle()).Decode(&response); err != nil {
+		if ctx.Err() != nil {
+			return response, ctx.Err()
+		}
+		return response, fmt.Errorf("reading the daemon response: %w", err)
+	}
+	return response, nil
+}
+
+// DaemonCall is like Call, through the session of the daemon listening on
+// socket.
+func DaemonCall(socket string, procedure string, args []string, kwargs map[string]string,
+	options CallOptions) error {
+
+	keywordArguments, err := dictToWampDict(kwargs, options.RawKwargs)
+	if err != nil {
+		return err
+	}
+	arguments := append(listToWampList(args), binaryList(options.BinaryArgs)...)
+	if err = options.Schema.Validate(arguments, keywordArguments); err != nil {
+		return err
+	}
+
+	callOptions := options
+	callOptions.Cryptobox, callOptions.Metrics, callOptions.Tracing = nil, nil, nil
+	callOptions.Schema, callOptions.Extract, callOptions.Output, callOptions.ErrorOutput = nil, nil, nil, nil
+	response, err := requestDaemon(socket, daemonRequest{
+		Command: daemonCall,
+		URI:     procedure,
+		Args:    args,
+		Kwargs:  kwargs,
+		Call:    callOptions,
+	})
+	if err != nil {
+		return err
+	}
+	if err = response.err(procedure); err != nil {
+		printCallError(err, options)
+		return err
+	}
+	return printCallResult(response.Args, response.Kwargs, options)
+}
+
+// DaemonPublish is like Publish, through the session of the daemon
+// listening on socket.
+func DaemonPublish(socket string, topic string, args []string, kwargs map[string]string,
+	options PublishOptions) error {
+
+	keywordArguments, err := dictToWampDict(kwargs, options.RawKwargs)
+	if err != nil {
+		return err
+	}
+	arguments := append(listToWampList(args), binaryList(options.BinaryArgs)...)
+	if err = options.Schema.Validate(arguments, keywordArguments); err != nil {
+		return err
+	}
+
+	publishOptions := options
+	publishOptions.Cryptobox, publishOptions.Tracing, publishOptions.Schema = nil, nil, nil
+	publishOptions.Output, publishOptions.Stats = nil, nil
+	response, err := requestDaemon(socket, daemonRequest{
+		Command: daemonPublish,
+		URI:     topic,
+		Args:    args,
+		Kwargs:  kwargs,
+		Publish: publishOptions,
+	})
+	if err != nil {
+		return err
+	}
+	if err = response.err(topic); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(output(options.Output), "%sPublished to topic '%s'\n", options.Tag, topic)
+	return nil
+}
@@ -0,0 +1,96 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// dedupeWindow tells the events whose key was already seen in an event
+// handled within the window. The time of a key is that of the event last
+// handled, not of its duplicates, so a key repeated continuously still lets
+// an event through every window. A nil dedupeWindow sees no duplicates.
+type dedupeWindow struct {
+	window time.Duration
+	key    *Extractor
+
+	lock   sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+// newDedupeWindow returns a dedupeWindow keying events by the value at key,
+// or by their whole payload if key is nil. It returns nil if window is zero.
+func newDedupeWindow(window time.Duration, key *Extractor) *dedupeWindow {
+	if window <= 0 {
+		return nil
+	}
+	if key == nil {
+		key = &Extractor{path: "."}
+	}
+	return &dedupeWindow{
+		window: window,
+		key:    key,
+		seen:   map[string]time.Time{},
+		pruned: time.Now(),
+	}
+}
+
+// duplicate returns true if the key of args and kwargs was seen within the
+// window, otherwise it records the key as seen now. Events without the key
+// are never duplicates.
+func (d *dedupeWindow) duplicate(args wamp.List, kwargs wamp.Dict) bool {
+	if d == nil {
+		return false
+	}
+	value, ok := d.key.extract(args, kwargs, BinaryFormatBase64)
+	if !ok {
+		return false
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	key := string(data)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := time.Now()
+	if now.Sub(d.pruned) >= d.window {
+		for seenKey, seen := range d.seen {
+			if now.Sub(seen) >= d.window {
+				delete(d.seen, seenKey)
+			}
+		}
+		d.pruned = now
+	}
+	if seen, ok := d.seen[key]; ok && now.Sub(seen) < d.window {
+		return true
+	}
+	d.seen[key] = now
+	return false
+}
@@ -0,0 +1,174 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// DiscoverOptions holds the options of Discover.
+type DiscoverOptions struct {
+	// Prefix keeps only the URIs starting with it.
+	Prefix string
+
+	// Schema is a procedure called with the URI of every procedure found,
+	// its result is shown as the description of the procedure. Empty means
+	// no descriptions.
+	Schema string
+}
+
+// catalogEntry is a registration or subscription found by Discover.
+type catalogEntry struct {
+	uri         string
+	match       string
+	invoke      string
+	count       int
+	description string
+}
+
+// Discover prints the procedures registered and the topics subscribed on the
+// realm of session to out, as found by the registration and subscription
+// meta procedures. The router must allow the session to call them. The meta
+// procedures themselves are left out unless prefix selects them.
+func Discover(session *client.Client, logger *logrus.Logger, options DiscoverOptions, out io.Writer) error {
+	ctx := context.Background()
+	procedures, err := listCatalog(ctx, session, wamp.MetaProcRegList, wamp.MetaProcRegGet,
+		wamp.MetaProcRegCountCallees, options.Prefix)
+	if err != nil {
+		return err
+	}
+	topics, err := listCatalog(ctx, session, wamp.MetaProcSubList, wamp.MetaProcSubGet,
+		wamp.MetaProcSubCountSubscribers, options.Prefix)
+	if err != nil {
+		return err
+	}
+
+	if options.Schema != "" {
+		for _, entry := range procedures {
+			entry.description, err = describeProcedure(ctx, session, options.Schema, entry.uri)
+			if err != nil {
+				logger.WithField("procedure", entry.uri).Debug("no description: ", err)
+			}
+		}
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "Procedures (%d):\n", len(procedures))
+	for _, entry := range procedures {
+		line := fmt.Sprintf("  %s\t%s\t%s\t%d callees", entry.uri, entry.match, entry.invoke, entry.count)
+		if entry.description != "" {
+			line += "\t" + entry.description
+		}
+		fmt.Fprintln(writer, line)
+	}
+	fmt.Fprintf(writer, "Topics (%d):\n", len(topics))
+	for _, entry := range topics {
+		fmt.Fprintf(writer, "  %s\t%s\t%d subscribers\n", entry.uri, entry.match, entry.count)
+	}
+	return writer.Flush()
+}
+
+// listCatalog returns the entries of the registrations or subscriptions of
+// the list meta procedure, sorted by URI.
+func listCatalog(ctx context.Context, session *client.Client, list, get, count wamp.URI,
+	prefix string) ([]*catalogEntry, error) {
+
+	result, err := session.Call(ctx, string(list), nil, nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("calling %s: %w", list, err)
+	}
+	var ids []wamp.ID
+	if len(result.Arguments) > 0 {
+		lists, _ := wamp.AsDict(result.Arguments[0])
+		for _, match := range []string{wamp.MatchExact, wamp.MatchPrefix, wamp.MatchWildcard} {
+			matchIDs, _ := wamp.AsList(lists[match])
+			for _, id := range matchIDs {
+				if id, ok := wamp.AsID(id); ok {
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+
+	var entries []*catalogEntry
+	for _, id := range ids {
+		result, err = session.Call(ctx, string(get), nil, wamp.List{id}, nil, nil)
+		if err != nil {
+			// Gone between the list and the get.
+			continue
+		}
+		var details wamp.Dict
+		if len(result.Arguments) > 0 {
+			details, _ = wamp.AsDict(result.Arguments[0])
+		}
+		uri, _ := wamp.AsString(details["uri"])
+		// The meta procedures of the router are only listed when asked for.
+		if uri == "" || !strings.HasPrefix(uri, prefix) || (prefix == "" && strings.HasPrefix(uri, "wamp.")) {
+			continue
+		}
+		entry := &catalogEntry{uri: uri, match: wamp.MatchExact, invoke: InvokeSingle}
+		if match, _ := wamp.AsString(details[wamp.OptMatch]); match != "" {
+			entry.match = match
+		}
+		if invoke, _ := wamp.AsString(details[wamp.OptInvoke]); invoke != "" {
+			entry.invoke = invoke
+		}
+
+		if result, err = session.Call(ctx, string(count), nil, wamp.List{id}, nil, nil); err == nil &&
+			len(result.Arguments) > 0 {
+			n, _ := wamp.AsInt64(result.Arguments[0])
+			entry.count = int(n)
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].uri < entries[j].uri })
+	return entries, nil
+}
+
+// describeProcedure calls the schema procedure for uri and returns its first
+// result on a single line.
+func describeProcedure(ctx context.Context, session *client.Client, schema string, uri string) (string, error) {
+	result, err := session.Call(ctx, schema, nil, wamp.List{uri}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(result.Arguments) == 0 {
+		return "", nil
+	}
+	if description, ok := wamp.AsString(result.Arguments[0]); ok {
+		return strings.Join(strings.Fields(description), " "), nil
+	}
+	description, err := json.Marshal(result.Arguments[0])
+	return string(description), err
+}
@@ -0,0 +1,196 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// How payloads are displayed, truncate is given with its length as
+// "truncate:N".
+const (
+	DisplayFull     = "full"
+	DisplayTruncate = "truncate"
+	DisplaySizeOnly = "size-only"
+	DisplayHexdump  = "hexdump"
+)
+
+// PayloadDisplay is how much of the received payloads is printed. The zero
+// value prints them in full.
+type PayloadDisplay struct {
+	// Mode is one of the Display modes, empty is DisplayFull.
+	Mode string
+
+	// Length is how many bytes of every string, and of every binary value
+	// as printed, are kept by DisplayTruncate.
+	Length int
+}
+
+// ParsePayloadDisplay parses "full", "truncate:N", "size-only" or
+// "hexdump".
+func ParsePayloadDisplay(value string) (PayloadDisplay, error) {
+	mode, length := value, ""
+	hasLength := false
+	if i := strings.IndexByte(value, ':'); i >= 0 {
+		mode, length, hasLength = value[:i], value[i+1:], true
+	}
+	switch mode {
+	case DisplayFull, DisplaySizeOnly, DisplayHexdump:
+		if !hasLength {
+			return PayloadDisplay{Mode: mode}, nil
+		}
+	case DisplayTruncate:
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 1 {
+			return PayloadDisplay{}, fmt.Errorf("invalid payload display %q, expected truncate:N with N > 0", value)
+		}
+		return PayloadDisplay{Mode: mode, Length: n}, nil
+	}
+	return PayloadDisplay{}, fmt.Errorf("invalid payload display %q, expected full, truncate:N, size-only or hexdump",
+		value)
+}
+
+// truncated returns the printable value with its strings longer than length
+// cut, and the length they had appended.
+func truncated(value interface{}, length int) interface{} {
+	switch v := value.(type) {
+	case string:
+		if len(v) <= length {
+			return v
+		}
+		cut := length
+		for cut > 0 && !utf8.RuneStart(v[cut]) {
+			cut--
+		}
+		return fmt.Sprintf("%s... (%d bytes)", v[:cut], len(v))
+	case wamp.List:
+		list := make(wamp.List, len(v))
+		for i, item := range v {
+			list[i] = truncated(item, length)
+		}
+		return list
+	case wamp.Dict:
+		dict := make(wamp.Dict, len(v))
+		for key, item := range v {
+			dict[key] = truncated(item, length)
+		}
+		return dict
+	}
+	return value
+}
+
+// itemized returns whether d prints every arg and kwarg on its own, like
+// printItems.
+func (d PayloadDisplay) itemized() bool {
+	return d.Mode == DisplaySizeOnly || d.Mode == DisplayHexdump
+}
+
+// displayed returns the printable value of an arg or kwarg as style.Display
+// says: with its strings cut in DisplayTruncate, and as printItems describes
+// it in DisplaySizeOnly and DisplayHexdump.
+func displayed(value interface{}, binaryFormat string, style JSONStyle) interface{} {
+	switch {
+	case style.Display.itemized():
+		return formatItem(value, binaryFormat, style)
+	case style.Display.Mode == DisplayTruncate:
+		return truncated(printable(value, binaryFormat), style.Display.Length)
+	}
+	return printable(value, binaryFormat)
+}
+
+// formatItem returns an arg or kwarg as printItems prints it, as its type and
+// size in DisplaySizeOnly, and with binary values hex dumped on the next
+// lines in DisplayHexdump.
+func formatItem(value interface{}, binaryFormat string, style JSONStyle) string {
+	if data, ok := binaryValue(value); ok {
+		text := fmt.Sprintf("binary %d bytes", len(data))
+		if style.Display.Mode == DisplayHexdump {
+			text += "\n" + strings.TrimSuffix(hex.Dump(data), "\n")
+		}
+		return text
+	}
+	value = printable(value, binaryFormat)
+	if style.Display.Mode == DisplaySizeOnly {
+		data, _ := json.Marshal(value)
+		return fmt.Sprintf("%s %d bytes", jsonType(value), len(data))
+	}
+	// On its own line.
+	style.Compact = true
+	jsonString, err := style.format(value)
+	if err != nil {
+		jsonString = fmt.Sprint(value)
+	}
+	return jsonString
+}
+
+// printItems prints every arg and kwarg on its own, as its type and size in
+// DisplaySizeOnly, and with binary values hex dumped in DisplayHexdump.
+func printItems(out io.Writer, args wamp.List, kwArgs wamp.Dict, binaryFormat string, style JSONStyle) {
+	item := func(name string, value interface{}) {
+		fmt.Fprintf(out, "  %s: %s\n", name, formatItem(value, binaryFormat, style))
+	}
+
+	if len(args) != 0 {
+		fmt.Fprintln(out, "args:")
+		for i, arg := range args {
+			item(strconv.Itoa(i), arg)
+		}
+	}
+	if len(kwArgs) != 0 {
+		fmt.Fprintln(out, "kwargs:")
+		keys := make([]string, 0, len(kwArgs))
+		for key := range kwArgs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			item(key, kwArgs[key])
+		}
+	}
+}
+
+// jsonType returns the JSON type of a printable value.
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case wamp.List, []interface{}:
+		return "list"
+	case wamp.Dict, map[string]interface{}:
+		return "dict"
+	}
+	return "number"
+}
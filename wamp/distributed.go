@@ -0,0 +1,443 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// WorkerStats are the stats of the repeated run of a worker process, sent to
+// the coordinator to be merged with the stats of the other workers.
+type WorkerStats struct {
+	Start     time.Time
+	Duration  time.Duration
+	Ops       int
+	Errors    int
+	ErrorURIs map[string]int
+	Latency   LatencyHistogram
+	Acks      LatencyHistogram
+	Reservoir []time.Duration
+}
+
+// Worker is a worker process that ran its share of a distributed run.
+type Worker struct {
+	Hostname string
+	Addr     string
+
+	// Stats are the stats of the worker alone, nil if it sent none.
+	Stats *Stats
+
+	// Err is the error the run of the worker failed with, if any.
+	Err error
+}
+
+// workerHello is sent by a worker once connected.
+type workerHello struct {
+	Hostname string `json:"hostname"`
+	Nonce    []byte `json:"nonce"`
+}
+
+// workerChallenge answers the hello of a worker, proving that the
+// coordinator knows the token.
+type workerChallenge struct {
+	Nonce []byte `json:"nonce"`
+	Proof []byte `json:"proof"`
+}
+
+// workerProof answers the challenge, proving that the worker knows the token
+// too.
+type workerProof struct {
+	Proof []byte `json:"proof"`
+}
+
+// workerJob is the command line a worker is sent to run, sealed as it
+// carries credentials.
+type workerJob struct {
+	Sealed []byte `json:"sealed"`
+}
+
+// workerAuth authenticates both ends of a worker connection with the token
+// they share, which is never sent. The proofs and the key of the job are
+// derived from the token and the nonces of both ends.
+type workerAuth struct {
+	token       string
+	worker      []byte
+	coordinator []byte
+}
+
+// mac returns the HMAC of label and the nonces keyed with the token.
+func (a workerAuth) mac(label string) []byte {
+	mac := hmac.New(sha256.New, []byte(a.token))
+	mac.Write([]byte(label))
+	mac.Write(a.worker)
+	mac.Write(a.coordinator)
+	return mac.Sum(nil)
+}
+
+// seal encrypts args in a XSalsa20-Poly1305 secretbox.
+func (a workerAuth) seal(args []string) ([]byte, error) {
+	plaintext, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	copy(key[:], a.mac("job"))
+	var nonce [24]byte
+	if _, err = rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	return secretbox.Seal(nonce[:], plaintext, &nonce, &key), nil
+}
+
+// open decrypts the args sealed by seal.
+func (a workerAuth) open(sealed []byte) ([]string, error) {
+	var key [32]byte
+	copy(key[:], a.mac("job"))
+	var nonce [24]byte
+	if len(sealed) < len(nonce)+secretbox.Overhead {
+		return nil, errors.New("sealed job too short")
+	}
+	copy(nonce[:], sealed)
+	plaintext, ok := secretbox.Open(nil, sealed[len(nonce):], &nonce, &key)
+	if !ok {
+		return nil, errors.New("cannot open the sealed job")
+	}
+	var args []string
+	err := json.Unmarshal(plaintext, &args)
+	return args, err
+}
+
+// workerNonce returns a random nonce for workerAuth.
+func workerNonce() ([]byte, error) {
+	nonce := make([]byte, 32)
+	_, err := rand.Read(nonce)
+	return nonce, err
+}
+
+// workerResult is sent by a worker once its run is done.
+type workerResult struct {
+	Stats *WorkerStats `json:"stats,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+// WorkerStats returns the stats to send to the coordinator.
+func (s *Stats) WorkerStats() WorkerStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return WorkerStats{
+		Start:     s.Start,
+		Duration:  s.Duration,
+		Ops:       s.Ops,
+		Errors:    s.Errors,
+		ErrorURIs: s.ErrorURIs,
+		Latency:   s.Latency,
+		Acks:      s.Acks,
+		Reservoir: s.reservoir,
+	}
+}
+
+// Merge adds the stats of a worker to s, which then spans from the first
+// start to the last end of the merged stats.
+func (s *Stats) Merge(worker WorkerStats) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	end := worker.Start.Add(worker.Duration)
+	if !s.merged {
+		s.Start, s.Duration, s.merged = worker.Start, worker.Duration, true
+	} else {
+		if s.Start.Add(s.Duration).After(end) {
+			end = s.Start.Add(s.Duration)
+		}
+		if worker.Start.Before(s.Start) {
+			s.Start = worker.Start
+		}
+		s.Duration = end.Sub(s.Start)
+	}
+	s.Ops += worker.Ops
+	s.Errors += worker.Errors
+	for key, count := range worker.ErrorURIs {
+		s.ErrorURIs[key] += count
+	}
+	s.Latency.merge(worker.Latency)
+	s.Acks.merge(worker.Acks)
+	s.reservoir = append(s.reservoir, worker.Reservoir...)
+}
+
+// merge adds the counts of other, which must have the same bounds, to h.
+func (h *LatencyHistogram) merge(other LatencyHistogram) {
+	if other.Total() == 0 {
+		return
+	}
+	if h.Total() == 0 || other.Min < h.Min {
+		h.Min = other.Min
+	}
+	if other.Max > h.Max {
+		h.Max = other.Max
+	}
+	h.Sum += other.Sum
+	for i, count := range other.Counts {
+		if i < len(h.Counts) {
+			h.Counts[i] += count
+		}
+	}
+}
+
+// Coordinate waits for count workers that know token to connect on
+// listener, then sends all of them args to run at once and merges the stats
+// they send back into stats. The workers are returned in the order they
+// connected, the error is the first one a worker failed with.
+func Coordinate(ctx context.Context, listener net.Listener, count int, args []string, token string,
+	stats *Stats, logger *logrus.Logger) ([]*Worker, error) {
+
+	if token == "" {
+		return nil, errors.New("a worker token is required")
+	}
+
+	// Once ctx is done, closing the listener ends the wait for the workers
+	// and closing the connections for writing ends their runs, which still
+	// send their stats.
+	var lock sync.Mutex
+	var conns []net.Conn
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		lock.Lock()
+		defer lock.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		listener.Close()
+		lock.Lock()
+		defer lock.Unlock()
+		for _, conn := range conns {
+			if tcpConn, ok := conn.(*net.TCPConn); ok {
+				tcpConn.CloseWrite()
+			} else {
+				conn.Close()
+			}
+		}
+	}()
+
+	logger.Infof("Waiting for %d workers on %s", count, listener.Addr())
+	type connection struct {
+		conn    net.Conn
+		decoder *json.Decoder
+		auth    workerAuth
+	}
+	var connections []connection
+	workers := make([]*Worker, 0, count)
+	for len(workers) < count {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		decoder := json.NewDecoder(conn)
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		hello, auth, err := acceptWorker(conn, decoder, token)
+		if err != nil {
+			logger.Warnf("Ignoring worker %s: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		conn.SetDeadline(time.Time{})
+		worker := &Worker{Hostname: hello.Hostname, Addr: conn.RemoteAddr().String()}
+		logger.Infof("Worker %d connected from %s (%s)", len(workers)+1, worker.Hostname, worker.Addr)
+		workers = append(workers, worker)
+		connections = append(connections, connection{conn: conn, decoder: decoder, auth: auth})
+		lock.Lock()
+		conns = append(conns, conn)
+		lock.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range connections {
+		wg.Add(1)
+		go func(worker *Worker, c connection) {
+			defer wg.Done()
+			sealed, err := c.auth.seal(args)
+			if err != nil {
+				worker.Err = err
+				return
+			}
+			if err := json.NewEncoder(c.conn).Encode(workerJob{Sealed: sealed}); err != nil {
+				worker.Err = err
+				return
+			}
+			var result workerResult
+			if err := c.decoder.Decode(&result); err != nil {
+				worker.Err = fmt.Errorf("connection lost: %w", err)
+				return
+			}
+			if result.Stats != nil {
+				worker.Stats = NewStats()
+				worker.Stats.Merge(*result.Stats)
+				stats.Merge(*result.Stats)
+			}
+			if result.Error != "" {
+				worker.Err = fmt.Errorf("%s", result.Error)
+			}
+		}(workers[i], c)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return workers, ctx.Err()
+	}
+	for i, worker := range workers {
+		if worker.Err != nil {
+			return workers, fmt.Errorf("worker %d on %s: %w", i+1, worker.Hostname, worker.Err)
+		}
+	}
+	return workers, nil
+}
+
+// acceptWorker reads the hello of a worker and checks that it knows token.
+func acceptWorker(conn net.Conn, decoder *json.Decoder, token string) (workerHello, workerAuth, error) {
+	var hello workerHello
+	if err := decoder.Decode(&hello); err != nil {
+		return hello, workerAuth{}, err
+	}
+	nonce, err := workerNonce()
+	if err != nil {
+		return hello, workerAuth{}, err
+	}
+	auth := workerAuth{token: token, worker: hello.Nonce, coordinator: nonce}
+	if err = json.NewEncoder(conn).Encode(workerChallenge{Nonce: nonce, Proof: auth.mac("coordinator")}); err != nil {
+		return hello, auth, err
+	}
+	var proof workerProof
+	if err = decoder.Decode(&proof); err != nil {
+		return hello, auth, err
+	}
+	if !hmac.Equal(proof.Proof, auth.mac("worker")) {
+		return hello, auth, errors.New("wrong worker token")
+	}
+	return hello, auth, nil
+}
+
+// RunWorker connects to the coordinator at addr, retrying every second
+// until it listens, and checks that both know token. Then it runs the
+// command line it is sent with run and sends back the stats of the run.
+// The context of run is canceled once the coordinator closes the connection
+// for writing, or goes away.
+func RunWorker(ctx context.Context, addr string, token string, logger *logrus.Logger,
+	run func(ctx context.Context, args []string) (*WorkerStats, error)) error {
+
+	if token == "" {
+		return errors.New("a worker token is required")
+	}
+	var conn net.Conn
+	var err error
+	for {
+		var dialer net.Dialer
+		if conn, err = dialer.DialContext(ctx, "tcp", addr); err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logger.Debugf("Waiting for the coordinator: %v", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	defer conn.Close()
+
+	hostname, _ := os.Hostname()
+	nonce, err := workerNonce()
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(conn)
+	if err = encoder.Encode(workerHello{Hostname: hostname, Nonce: nonce}); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(conn)
+	var challenge workerChallenge
+	if err = decoder.Decode(&challenge); err != nil {
+		return fmt.Errorf("reading the challenge of the coordinator: %w", err)
+	}
+	auth := workerAuth{token: token, worker: nonce, coordinator: challenge.Nonce}
+	if !hmac.Equal(challenge.Proof, auth.mac("coordinator")) {
+		return errors.New("the coordinator does not know the worker token")
+	}
+	if err = encoder.Encode(workerProof{Proof: auth.mac("worker")}); err != nil {
+		return err
+	}
+
+	var job workerJob
+	if err = decoder.Decode(&job); err != nil {
+		return fmt.Errorf("reading the job of the coordinator: %w", err)
+	}
+	args, err := auth.open(job.Sealed)
+	if err != nil {
+		return fmt.Errorf("reading the job of the coordinator: %w", err)
+	}
+	logger.Info("Running the job of the coordinator")
+
+	// The coordinator sends nothing more, reading only tells when it goes
+	// away.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		var discard json.RawMessage
+		decoder.Decode(&discard)
+		cancel()
+	}()
+
+	stats, runErr := run(ctx, args)
+	result := workerResult{Stats: stats}
+	if runErr != nil {
+		result.Error = runErr.Error()
+	}
+	if err = encoder.Encode(result); err != nil {
+		return err
+	}
+	return runErr
+}
@@ -0,0 +1,124 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// drainGroup tracks the invocations in flight, for a graceful shutdown to
+// let them finish.
+type drainGroup struct {
+	lock     sync.Mutex
+	draining bool
+	inFlight sync.WaitGroup
+
+	// kill is canceled when the invocations still running after the drain
+	// timeout are killed.
+	kill   context.Context
+	cancel context.CancelFunc
+}
+
+func newDrainGroup() *drainGroup {
+	kill, cancel := context.WithCancel(context.Background())
+	return &drainGroup{kill: kill, cancel: cancel}
+}
+
+// enter records the start of an invocation, false if draining already.
+func (d *drainGroup) enter() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inFlight.Add(1)
+	return true
+}
+
+// leave records the end of an invocation started with enter.
+func (d *drainGroup) leave() {
+	d.inFlight.Done()
+}
+
+// context returns a child of ctx that is also canceled when the drain kills
+// the invocations.
+func (d *drainGroup) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-d.kill.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// killed reports whether the drain killed the invocations.
+func (d *drainGroup) killed() bool {
+	return d.kill.Err() != nil
+}
+
+// drain stops accepting invocations and waits for the ones in flight to
+// finish, for up to timeout or until abort is closed. Then the remaining ones
+// are killed. Zero timeout waits until abort, or kills at once if abort is
+// nil as it never fires. It returns false if any was killed.
+func (d *drainGroup) drain(timeout time.Duration, abort <-chan struct{}) bool {
+	d.lock.Lock()
+	d.draining = true
+	d.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.inFlight.Wait()
+		close(done)
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	if expired == nil && abort == nil {
+		// Nothing would end the wait, kill what still runs.
+		select {
+		case <-done:
+			return true
+		default:
+		}
+	} else {
+		select {
+		case <-done:
+			return true
+		case <-expired:
+		case <-abort:
+		}
+	}
+
+	d.cancel()
+	<-done
+	return false
+}
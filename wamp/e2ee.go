@@ -0,0 +1,271 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/ugorji/go/codec"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	pptScheme     = "wamp"
+	pptSerializer = "json"
+	pptCipher     = "xsalsa20poly1305"
+	nonceSize     = 24
+)
+
+// pptHandles are the codecs of the ppt_serializer values Open supports,
+// configured like the nexus serializers so that values decode to the same
+// types as the ones received from the router.
+var pptHandles = map[string]codec.Handle{
+	"json":    &codec.JsonHandle{BasicHandle: codec.BasicHandle{DecodeOptions: pptDecodeOptions}},
+	"cbor":    &codec.CborHandle{BasicHandle: codec.BasicHandle{DecodeOptions: pptDecodeOptions}},
+	"msgpack": &codec.MsgpackHandle{BasicHandle: codec.BasicHandle{DecodeOptions: pptDecodeOptions}, WriteExt: true},
+}
+
+var pptDecodeOptions = codec.DecodeOptions{MapType: reflect.TypeOf(map[string]interface{}(nil))}
+
+// Cryptobox encrypts and decrypts the args and kwargs of calls and events
+// end-to-end, following the WAMP cryptobox payload passthru mode of Crossbar
+// and Autobahn: the URI, args and kwargs are serialized, sealed in a
+// XSalsa20-Poly1305 box and sent as the only (binary) argument, with the
+// ppt_* options describing the encryption. The key is the box key shared by
+// the peers.
+type Cryptobox struct {
+	key   [32]byte
+	keyID string
+}
+
+// NewCryptobox creates a Cryptobox from a 32 bytes hex encoded key.
+func NewCryptobox(hexKey string) (*Cryptobox, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("invalid e2ee key, must be 32 bytes hex encoded")
+	}
+
+	box := &Cryptobox{}
+	copy(box.key[:], key)
+	sum := sha256.Sum256(key)
+	box.keyID = hex.EncodeToString(sum[:8])
+	return box, nil
+}
+
+// Seal encrypts uri, args and kwargs into the args to send without kwargs,
+// and adds the ppt_* options that describe the encryption to options, unless
+// nil like for YIELD, which the nexus client sends without options.
+func (c *Cryptobox) Seal(uri string, args wamp.List, kwargs wamp.Dict, options wamp.Dict) (wamp.List, error) {
+	payload := wamp.Dict{"uri": uri}
+	if len(args) > 0 {
+		payload["args"] = jsonBinary(args)
+	}
+	if len(kwargs) > 0 {
+		payload["kwargs"] = jsonBinary(kwargs)
+	}
+	var plaintext []byte
+	if err := codec.NewEncoderBytes(&plaintext, pptHandles[pptSerializer]).Encode(payload); err != nil {
+		return nil, err
+	}
+
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	sealed := secretbox.Seal(nonce[:], plaintext, &nonce, &c.key)
+
+	if options != nil {
+		options["ppt_scheme"] = pptScheme
+		options["ppt_serializer"] = pptSerializer
+		options["ppt_cipher"] = pptCipher
+		options["ppt_keyid"] = c.keyID
+	}
+	return wamp.List{serialize.BinaryData(sealed)}, nil
+}
+
+// Open decrypts args if they hold a payload sealed for uri, which the topic
+// or procedure of details overrides for pattern-based subscriptions and
+// registrations. The ppt_* details tell encrypted payloads apart, but nexus
+// routers do not forward them, so a lone binary argument is taken as sealed
+// too. ok is false if args were not encrypted in the first place.
+func (c *Cryptobox) Open(uri string, args wamp.List, kwargs wamp.Dict, details wamp.Dict) (wamp.List, wamp.Dict,
+	bool, error) {
+
+	scheme, marked := details["ppt_scheme"]
+	if marked && scheme != pptScheme {
+		// Passthru payloads of another scheme, like mqtt, are left as is.
+		return args, kwargs, false, nil
+	}
+	serializer := pptSerializer
+	if marked {
+		if cipher, _ := wamp.AsString(details["ppt_cipher"]); cipher != pptCipher {
+			return args, kwargs, false, fmt.Errorf("unsupported e2ee cipher %q", cipher)
+		}
+		if keyID, ok := wamp.AsString(details["ppt_keyid"]); ok && keyID != c.keyID {
+			return args, kwargs, false, fmt.Errorf("payload encrypted with the e2ee key %s, not %s", keyID, c.keyID)
+		}
+		serializer, _ = wamp.AsString(details["ppt_serializer"])
+		if _, ok := pptHandles[serializer]; !ok {
+			return args, kwargs, false, fmt.Errorf("unsupported e2ee serializer %q", serializer)
+		}
+	}
+	if len(args) != 1 || len(kwargs) != 0 {
+		if marked {
+			return args, kwargs, false, errors.New("invalid encrypted payload")
+		}
+		return args, kwargs, false, nil
+	}
+
+	var sealed []byte
+	switch value := args[0].(type) {
+	case []byte:
+		sealed = value
+	case serialize.BinaryData:
+		sealed = value
+	case string:
+		// The JSON serializer transmits binary as a base64 string prefixed
+		// with a NUL character. Binary converted by the router from another
+		// serializer arrives as a plain base64 string instead, which is only
+		// known to be sealed if it decrypts.
+		binary := strings.HasPrefix(value, "\x00")
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, "\x00"))
+		if err != nil {
+			if marked {
+				return args, kwargs, false, errors.New("invalid encrypted payload")
+			}
+			return args, kwargs, false, nil
+		}
+		sealed = decoded
+		marked = marked || binary
+	default:
+		if marked {
+			return args, kwargs, false, errors.New("invalid encrypted payload")
+		}
+		return args, kwargs, false, nil
+	}
+
+	if len(sealed) < nonceSize+secretbox.Overhead {
+		if !marked {
+			return args, kwargs, false, nil
+		}
+		return args, kwargs, false, errors.New("encrypted payload too short")
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], sealed[:nonceSize])
+	plaintext, ok := secretbox.Open(nil, sealed[nonceSize:], &nonce, &c.key)
+	if !ok {
+		if _, isString := args[0].(string); isString && !marked {
+			return args, kwargs, false, nil
+		}
+		return args, kwargs, false, errors.New("failed to decrypt payload, wrong e2ee key?")
+	}
+
+	var payload map[string]interface{}
+	if err := codec.NewDecoderBytes(plaintext, pptHandles[serializer]).Decode(&payload); err != nil {
+		return args, kwargs, false, err
+	}
+	if topic, ok := wamp.AsString(details["topic"]); ok {
+		uri = topic
+	} else if procedure, ok := wamp.AsString(details["procedure"]); ok {
+		uri = procedure
+	}
+	if sealedURI, _ := wamp.AsString(payload["uri"]); sealedURI != "" && uri != "" && sealedURI != uri {
+		return args, kwargs, false, fmt.Errorf("payload encrypted for %s, not %s", sealedURI, uri)
+	}
+
+	openedArgs, _ := wamp.AsList(payload["args"])
+	openedKwargs, _ := wamp.AsDict(payload["kwargs"])
+	if serializer == "json" && openedArgs != nil {
+		openedArgs = binaryFromJSON(openedArgs).(wamp.List)
+	}
+	if serializer == "json" && openedKwargs != nil {
+		openedKwargs = binaryFromJSON(openedKwargs).(wamp.Dict)
+	}
+	return openedArgs, openedKwargs, true, nil
+}
+
+// jsonBinary returns value with its byte strings as serialize.BinaryData,
+// which the JSON serializer encodes as a NUL prefixed base64 string.
+func jsonBinary(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return serialize.BinaryData(v)
+	case wamp.List:
+		list := make(wamp.List, len(v))
+		for i, item := range v {
+			list[i] = jsonBinary(item)
+		}
+		return list
+	case []interface{}:
+		return jsonBinary(wamp.List(v))
+	case wamp.Dict:
+		dict := make(wamp.Dict, len(v))
+		for key, item := range v {
+			dict[key] = jsonBinary(item)
+		}
+		return dict
+	case map[string]interface{}:
+		return jsonBinary(wamp.Dict(v))
+	default:
+		return v
+	}
+}
+
+// binaryFromJSON returns value with its NUL prefixed base64 strings decoded
+// to byte strings, the reverse of jsonBinary.
+func binaryFromJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if data, ok := binaryValue(v); ok {
+			return data
+		}
+		return v
+	case wamp.List:
+		list := make(wamp.List, len(v))
+		for i, item := range v {
+			list[i] = binaryFromJSON(item)
+		}
+		return list
+	case []interface{}:
+		return binaryFromJSON(wamp.List(v))
+	case wamp.Dict:
+		dict := make(wamp.Dict, len(v))
+		for key, item := range v {
+			dict[key] = binaryFromJSON(item)
+		}
+		return dict
+	case map[string]interface{}:
+		return binaryFromJSON(wamp.Dict(v))
+	default:
+		return v
+	}
+}
@@ -0,0 +1,97 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// ConnectError is returned when the transport to the router could not be
+// established.
+type ConnectError struct {
+	URL string
+	Err error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("connecting to %s: %s", e.URL, e.Err)
+}
+
+func (e *ConnectError) Unwrap() error { return e.Err }
+
+// JoinError is returned when the router refused or did not answer the
+// request to join the realm, which usually means authentication failed.
+type JoinError struct {
+	Realm string
+	Err   error
+}
+
+func (e *JoinError) Error() string {
+	return fmt.Sprintf("joining realm %s: %s", e.Realm, e.Err)
+}
+
+func (e *JoinError) Unwrap() error { return e.Err }
+
+// nexus formats the ERROR and ABORT messages it receives for publish,
+// subscribe, register and join as "<context>: <error uri>[: <args>]".
+var errorURIPattern = regexp.MustCompile(`: ([a-zA-Z0-9_\-]+(?:\.[a-zA-Z0-9_\-]+)+)(?::|\s|$)`)
+
+// ErrorURI returns the WAMP error URI carried by err, or an empty string if
+// there is none.
+func ErrorURI(err error) string {
+	var rpcErr client.RPCError
+	if errors.As(err, &rpcErr) {
+		return string(rpcErr.Err.Error)
+	}
+
+	if match := errorURIPattern.FindStringSubmatch(err.Error()); match != nil {
+		return match[1]
+	}
+
+	return ""
+}
+
+// errorDetails returns the URI, args, kwargs and details of the WAMP error
+// carried by err, binary values printed in binaryFormat, or nil if there is
+// none.
+func errorDetails(err error, binaryFormat string) wamp.Dict {
+	var rpcErr client.RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Err == nil {
+		return nil
+	}
+
+	details := wamp.Dict{
+		"uri":    string(rpcErr.Err.Error),
+		"args":   printableList(rpcErr.Err.Arguments, binaryFormat),
+		"kwargs": printableDict(rpcErr.Err.ArgumentsKw, binaryFormat),
+	}
+	if len(rpcErr.Err.Details) > 0 {
+		details["details"] = printableDict(rpcErr.Err.Details, binaryFormat)
+	}
+	return details
+}
@@ -0,0 +1,112 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// metaProcSubGetEvents returns the events kept for a subscription by
+// routers with event history, like Crossbar.
+const metaProcSubGetEvents = "wamp.subscription.get_events"
+
+// EventHistoryOptions holds the options of EventHistory.
+type EventHistoryOptions struct {
+	// Limit is the maximum number of events to fetch, 0 lets the router
+	// choose.
+	Limit int
+
+	// Cryptobox decrypts end-to-end encrypted events, nil if not used.
+	Cryptobox *Cryptobox
+
+	BinaryFormat string
+	JSONStyle    JSONStyle
+
+	// Output receives what is printed, nil prints to stdout.
+	Output io.Writer
+}
+
+// EventHistory prints the events the router kept for topic, with when they
+// were published. The events are kept per subscription, so the topic is
+// subscribed for the time of the wamp.subscription.get_events call.
+func EventHistory(session *client.Client, logger *logrus.Logger, topic string, options EventHistoryOptions) error {
+	out := output(options.Output)
+	if err := session.Subscribe(topic, func(*wamp.Event) {}, nil); err != nil {
+		return err
+	}
+	defer func() {
+		if err := session.Unsubscribe(topic); err != nil {
+			logger.WithField("uri", topic).Debug("Failed to unsubscribe: ", err)
+		}
+	}()
+	subscription, _ := session.SubscriptionID(topic)
+
+	var kwargs wamp.Dict
+	if options.Limit > 0 {
+		kwargs = wamp.Dict{"limit": options.Limit}
+	}
+	result, err := session.Call(context.Background(), metaProcSubGetEvents, nil, wamp.List{subscription}, kwargs,
+		nil)
+	if err != nil {
+		return fmt.Errorf("fetching the event history: %w", err)
+	}
+	var events wamp.List
+	if len(result.Arguments) > 0 {
+		events, _ = wamp.AsList(result.Arguments[0])
+	}
+
+	fmt.Fprintf(out, "Event history of topic '%s' (%d):\n", topic, len(events))
+	for _, value := range events {
+		event, _ := wamp.AsDict(value)
+		args, _ := wamp.AsList(event["args"])
+		kwargs, _ := wamp.AsDict(event["kwargs"])
+		if options.Cryptobox != nil {
+			if args, kwargs, _, err = options.Cryptobox.Open(topic, args, kwargs, event); err != nil {
+				logger.WithField("uri", topic).Warn(err)
+			}
+		}
+		fmt.Fprintf(out, "%s publication %v\n", eventTimestamp(event["timestamp"]), event["publication"])
+		printIdentity(out, "publisher", event)
+		argsKWArgs(out, args, kwargs, options.BinaryFormat, options.JSONStyle)
+	}
+	return nil
+}
+
+// eventTimestamp formats the timestamp of a history event, given by the
+// router as text or as seconds since the epoch.
+func eventTimestamp(value interface{}) string {
+	if text, ok := wamp.AsString(value); ok {
+		return text
+	}
+	if seconds, ok := wamp.AsFloat64(value); ok {
+		return time.Unix(0, int64(seconds*float64(time.Second))).UTC().Format(time.RFC3339Nano)
+	}
+	return "unknown time"
+}
@@ -0,0 +1,144 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// eventStats aggregates the events of a subscription, for subscribe to print
+// the traffic of every interval instead of the events.
+type eventStats struct {
+	out io.Writer
+
+	lock       sync.Mutex
+	start      time.Time
+	since      time.Time
+	total      int64
+	sizes      []int
+	publishers map[wamp.ID]struct{}
+	disclosed  bool
+}
+
+func newEventStats(out io.Writer) *eventStats {
+	now := time.Now()
+	return &eventStats{
+		out:        out,
+		start:      now,
+		since:      now,
+		publishers: map[wamp.ID]struct{}{},
+	}
+}
+
+// record counts an event with its payload, the publisher is counted if
+// disclosed.
+func (s *eventStats) record(event *wamp.Event, args wamp.List, kwargs wamp.Dict) {
+	size := payloadSize(args, kwargs)
+	publisher, disclosed := wamp.AsID(event.Details["publisher"])
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.total++
+	s.sizes = append(s.sizes, size)
+	if disclosed {
+		s.publishers[publisher] = struct{}{}
+		s.disclosed = true
+	}
+}
+
+// report prints the counts of the events since the last report, and starts
+// a new interval.
+func (s *eventStats) report() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(s.since)
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(len(s.sizes)) / elapsed.Seconds()
+	}
+
+	line := fmt.Sprintf("events %d  rate %.1f/s  publishers ", len(s.sizes), rate)
+	if s.disclosed {
+		line += fmt.Sprint(len(s.publishers))
+	} else {
+		line += "-"
+	}
+	if len(s.sizes) > 0 {
+		sort.Ints(s.sizes)
+		percentile := func(p int) string { return formatSize(s.sizes[(len(s.sizes)-1)*p/100]) }
+		line += fmt.Sprintf("  size min %s  p50 %s  p90 %s  p99 %s  max %s", percentile(0), percentile(50),
+			percentile(90), percentile(99), percentile(100))
+	}
+	line += fmt.Sprintf("  total %d", s.total)
+	fmt.Fprintln(s.out, line)
+
+	s.since = now
+	s.sizes = s.sizes[:0]
+	s.publishers = map[wamp.ID]struct{}{}
+	s.disclosed = false
+}
+
+// summary prints the events counted since the start.
+func (s *eventStats) summary() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	elapsed := time.Since(s.start)
+	fmt.Fprintf(s.out, "done: events %d in %s  rate %.1f/s\n", s.total, elapsed.Round(time.Millisecond),
+		float64(s.total)/elapsed.Seconds())
+}
+
+// payloadSize returns the length of args and kwargs as JSON, with binary
+// values in base64.
+func payloadSize(args wamp.List, kwargs wamp.Dict) int {
+	size := 0
+	if len(args) > 0 {
+		if data, err := json.Marshal(printable(args, BinaryFormatBase64)); err == nil {
+			size += len(data)
+		}
+	}
+	if len(kwargs) > 0 {
+		if data, err := json.Marshal(printable(kwargs, BinaryFormatBase64)); err == nil {
+			size += len(data)
+		}
+	}
+	return size
+}
+
+// formatSize returns a number of bytes like "512B", "1.5KiB" or "3.2MiB".
+func formatSize(n int) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%dB", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1fKiB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%.1fMiB", float64(n)/(1024*1024))
+}
@@ -0,0 +1,129 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// eventRunner runs a shell command for every event, at most concurrency at
+// once.
+type eventRunner struct {
+	command string
+	slots   chan struct{}
+	queued  bool
+	wg      sync.WaitGroup
+	metrics *Metrics
+	logger  *logrus.Logger
+
+	dropped int64
+}
+
+// newEventRunner returns a runner of command. Unless queued, it is run by
+// the client dispatcher, which must never wait for a free slot.
+func newEventRunner(command string, concurrency int, queued bool, metrics *Metrics,
+	logger *logrus.Logger) *eventRunner {
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &eventRunner{
+		command: command,
+		slots:   make(chan struct{}, concurrency),
+		queued:  queued,
+		metrics: metrics,
+		logger:  logger,
+	}
+}
+
+// run starts the command for an event, with the event as JSON on stdin. If
+// all slots are busy, a queued runner blocks until one is free, which holds
+// back the following events in the queue, others drop the event.
+func (r *eventRunner) run(topic string, event *wamp.Event, args wamp.List, kwargs wamp.Dict) {
+	if actual, ok := wamp.AsString(event.Details["topic"]); ok {
+		topic = actual
+	}
+	input, err := json.Marshal(map[string]interface{}{
+		"topic":   topic,
+		"args":    printable(args, BinaryFormatBase64),
+		"kwargs":  printable(kwargs, BinaryFormatBase64),
+		"details": event.Details,
+	})
+	if err != nil {
+		r.logger.WithField("uri", topic).Error("Failed to encode event: ", err)
+		return
+	}
+
+	cmd := exec.Command("bash", "-c", r.command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"WICK_TOPIC="+topic,
+		"WICK_PUBLICATION_ID="+strconv.FormatUint(uint64(event.Publication), 10),
+	)
+	if publisher, ok := wamp.AsID(event.Details["publisher"]); ok {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("WICK_PUBLISHER=%d", publisher))
+	}
+
+	if r.queued {
+		r.slots <- struct{}{}
+	} else {
+		select {
+		case r.slots <- struct{}{}:
+		default:
+			atomic.AddInt64(&r.dropped, 1)
+			r.metrics.eventDropped(topic)
+			r.logger.WithField("uri", topic).Debug("event command skipped, all slots are busy")
+			return
+		}
+	}
+	r.wg.Add(1)
+	go func() {
+		defer func() {
+			<-r.slots
+			r.wg.Done()
+		}()
+		if err := cmd.Run(); err != nil {
+			r.logger.WithField("uri", topic).Error("Event command failed: ", err)
+		}
+	}()
+}
+
+// wait blocks until all started commands are done.
+func (r *eventRunner) wait() {
+	r.wg.Wait()
+	if dropped := atomic.LoadInt64(&r.dropped); dropped > 0 {
+		r.logger.Warnf("%d events not run, all command slots were busy", dropped)
+	}
+}
@@ -0,0 +1,166 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Extractor selects a single value of a payload with a jq-like path such as
+// `.kwargs.token`, `.args[0].name` or `.kwargs["content-type"]`, over the
+// object {"args": [...], "kwargs": {...}}. A leading `$`, as in JSONPath, is
+// accepted and `.` selects the whole payload. Negative indexes count from the
+// end of arrays.
+type Extractor struct {
+	path  string
+	steps []interface{}
+}
+
+// ParseExtractor compiles path, returning an error if it is not valid.
+func ParseExtractor(path string) (*Extractor, error) {
+	invalid := func(reason string) error { return fmt.Errorf("invalid extract path %q: %s", path, reason) }
+
+	rest := strings.TrimPrefix(path, "$")
+	if rest == "." {
+		return &Extractor{path: path}, nil
+	}
+	if rest == "" || (rest[0] != '.' && rest[0] != '[') {
+		return nil, invalid("must start with . or [")
+	}
+
+	extractor := &Extractor{path: path}
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, invalid("empty key")
+			}
+			extractor.steps = append(extractor.steps, rest[:end])
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, invalid("missing ]")
+			}
+			index := rest[1:end]
+			if key, err := strconv.Unquote(index); err == nil && strings.HasPrefix(index, `"`) {
+				extractor.steps = append(extractor.steps, key)
+			} else if n, err := strconv.Atoi(index); err == nil {
+				extractor.steps = append(extractor.steps, n)
+			} else {
+				return nil, invalid("index must be a number or a quoted key")
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, invalid("unexpected " + strconv.QuoteRune(rune(rest[0])))
+		}
+	}
+	return extractor, nil
+}
+
+// extract returns the selected value of args and kwargs, printable, false
+// if the payload does not have it.
+func (e *Extractor) extract(args wamp.List, kwargs wamp.Dict, binaryFormat string) (interface{}, bool) {
+	value, ok := e.lookup(args, kwargs)
+	if !ok {
+		return nil, false
+	}
+	return printable(value, binaryFormat), true
+}
+
+// lookup returns the selected value of args and kwargs as received, false if
+// the payload does not have it.
+func (e *Extractor) lookup(args wamp.List, kwargs wamp.Dict) (interface{}, bool) {
+	if args == nil {
+		args = wamp.List{}
+	}
+	if kwargs == nil {
+		kwargs = wamp.Dict{}
+	}
+	var value interface{} = wamp.Dict{"args": args, "kwargs": kwargs}
+	for _, step := range e.steps {
+		switch step := step.(type) {
+		case string:
+			dict, ok := wamp.AsDict(value)
+			if !ok {
+				return nil, false
+			}
+			if value, ok = dict[step]; !ok {
+				return nil, false
+			}
+		case int:
+			// Binary values are not lists of bytes.
+			if _, binary := binaryValue(value); binary {
+				return nil, false
+			}
+			list, ok := wamp.AsList(value)
+			if !ok {
+				return nil, false
+			}
+			if step < 0 {
+				step += len(list)
+			}
+			if step < 0 || step >= len(list) {
+				return nil, false
+			}
+			value = list[step]
+		}
+	}
+	return value, true
+}
+
+// format returns the value at the path in args and kwargs as style.Display
+// says, strings as they are and anything else as JSON in style.
+func (e *Extractor) format(args wamp.List, kwargs wamp.Dict, binaryFormat string, style JSONStyle) (string, error) {
+	value, ok := e.lookup(args, kwargs)
+	if !ok {
+		return "", fmt.Errorf("no value at %s", e.path)
+	}
+	value = displayed(value, binaryFormat, style)
+	if text, ok := value.(string); ok {
+		return text, nil
+	}
+	return style.format(value)
+}
+
+// print prints to out the selected value of args and kwargs, strings as is like
+// `jq -r` and anything else as JSON in style. It returns an error if the
+// payload does not have the value.
+func (e *Extractor) print(out io.Writer, args wamp.List, kwargs wamp.Dict, binaryFormat string, style JSONStyle) error {
+	text, err := e.format(args, kwargs, binaryFormat, style)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, text)
+	return nil
+}
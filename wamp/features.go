@@ -0,0 +1,122 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// knownFeatures are the features of the router roles defined by the WAMP
+// advanced profile, they are listed as unsupported if not advertised.
+var knownFeatures = map[string][]string{
+	"broker": {
+		wamp.FeaturePatternSub, wamp.FeaturePubExclusion, wamp.FeaturePubIdent, wamp.FeatureSessionMetaAPI,
+		wamp.FeatureSubBlackWhiteListing, wamp.FeatureSubMetaAPI, "event_history", "event_retention",
+		"sharded_subscription", "publication_trustlevels", "payload_passthru_mode",
+	},
+	"dealer": {
+		wamp.FeatureCallCanceling, wamp.FeatureCallTimeout, wamp.FeatureCallerIdent, wamp.FeaturePatternBasedReg,
+		wamp.FeatureProgCallResults, wamp.FeatureRegMetaAPI, wamp.FeatureSessionMetaAPI, wamp.FeatureSharedReg,
+		wamp.FeatureTestamentMetaAPI, "sharded_registration", "call_trustlevels", "registration_revocation",
+		"payload_passthru_mode",
+	},
+}
+
+// RouterFeatures is the report of PrintFeatures.
+type RouterFeatures struct {
+	Agent string                     `json:"agent,omitempty"`
+	Roles map[string]map[string]bool `json:"roles"`
+}
+
+// routerFeatures returns the features of the router roles advertised in the
+// WELCOME of session, with the known features not advertised as false.
+func routerFeatures(session *client.Client) RouterFeatures {
+	details := session.RealmDetails()
+	agent, _ := wamp.AsString(details["agent"])
+	report := RouterFeatures{Agent: agent, Roles: map[string]map[string]bool{}}
+	for role, features := range knownFeatures {
+		report.Roles[role] = map[string]bool{}
+		for _, feature := range features {
+			report.Roles[role][feature] = false
+		}
+	}
+
+	roles, _ := wamp.AsDict(details["roles"])
+	for role, value := range roles {
+		if report.Roles[role] == nil {
+			report.Roles[role] = map[string]bool{}
+		}
+		roleDetails, _ := wamp.AsDict(value)
+		features, _ := wamp.AsDict(roleDetails["features"])
+		for feature, enabled := range features {
+			report.Roles[role][feature], _ = wamp.AsBool(enabled)
+		}
+	}
+	return report
+}
+
+// PrintFeatures prints the features the router advertised for its broker and
+// dealer roles when session joined, as a table or as JSON with output
+// "json".
+func PrintFeatures(session *client.Client, output string, out io.Writer) error {
+	report := routerFeatures(session)
+	if output == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "    ")
+		return encoder.Encode(report)
+	}
+
+	if report.Agent != "" {
+		fmt.Fprintf(out, "Router: %s\n", report.Agent)
+	}
+	roles := make([]string, 0, len(report.Roles))
+	for role := range report.Roles {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ROLE\tFEATURE\tSUPPORTED")
+	for _, role := range roles {
+		features := make([]string, 0, len(report.Roles[role]))
+		for feature := range report.Roles[role] {
+			features = append(features, feature)
+		}
+		sort.Strings(features)
+		for _, feature := range features {
+			supported := "no"
+			if report.Roles[role][feature] {
+				supported = "yes"
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", role, feature, supported)
+		}
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,125 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ANSI colors of the JSON syntax elements.
+const (
+	colorKey     = "\033[34m"
+	colorString  = "\033[32m"
+	colorNumber  = "\033[36m"
+	colorLiteral = "\033[33m"
+	colorNull    = "\033[90m"
+)
+
+// JSONStyle is how received payloads are printed.
+type JSONStyle struct {
+	// Color highlights the JSON syntax with ANSI colors, for terminals.
+	Color bool
+
+	// Compact prints JSON on a single line instead of indented with four
+	// spaces.
+	Compact bool
+
+	// Display is how much of large payloads is printed.
+	Display PayloadDisplay
+}
+
+// format returns value as JSON in the style.
+func (s JSONStyle) format(value interface{}) (string, error) {
+	var data []byte
+	var err error
+	if s.Compact {
+		data, err = json.Marshal(value)
+	} else {
+		data, err = json.MarshalIndent(value, "", "    ")
+	}
+	if err != nil {
+		return "", err
+	}
+	if !s.Color {
+		return string(data), nil
+	}
+	return colorize(string(data)), nil
+}
+
+// colorize adds ANSI colors to the keys and values of the valid JSON text.
+func colorize(text string) string {
+	var out strings.Builder
+	paint := func(color string, token string) {
+		out.WriteString(color)
+		out.WriteString(token)
+		out.WriteString(resetStyle)
+	}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '"':
+			end := i + 1
+			for text[end] != '"' {
+				if text[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end++
+
+			// A string followed by a colon is an object key.
+			next := end
+			for next < len(text) && strings.IndexByte(" \n\t", text[next]) >= 0 {
+				next++
+			}
+			if next < len(text) && text[next] == ':' {
+				paint(colorKey, text[i:end])
+			} else {
+				paint(colorString, text[i:end])
+			}
+			i = end
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i + 1
+			for end < len(text) && strings.IndexByte("0123456789.eE+-", text[end]) >= 0 {
+				end++
+			}
+			paint(colorNumber, text[i:end])
+			i = end
+		case strings.HasPrefix(text[i:], "true"):
+			paint(colorLiteral, "true")
+			i += 4
+		case strings.HasPrefix(text[i:], "false"):
+			paint(colorLiteral, "false")
+			i += 5
+		case strings.HasPrefix(text[i:], "null"):
+			paint(colorNull, "null")
+			i += 4
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,424 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// The kinds of messages wick fuzz sends.
+const (
+	FuzzCall    = "call"
+	FuzzPublish = "publish"
+)
+
+// FuzzOptions configure a fuzz run.
+type FuzzOptions struct {
+	// Target is FuzzCall or FuzzPublish.
+	Target string
+
+	// URIPrefix starts every random URI.
+	URIPrefix string
+
+	// URIs are called or published to with random payloads half of the
+	// time, to reach existing callees and subscribers.
+	URIs []string
+
+	// Count is the number of messages sent.
+	Count int
+
+	// Seed seeds the generator, the same seed sends the same messages.
+	Seed int64
+
+	// Timeout fails a call or publish that was not answered in time.
+	Timeout time.Duration
+}
+
+// rejectedErrors are the errors routers answer invalid messages with, the
+// fuzzer expects them.
+var rejectedErrors = map[wamp.URI]bool{
+	wamp.ErrNoSuchProcedure:   true,
+	wamp.ErrInvalidURI:        true,
+	wamp.ErrInvalidArgument:   true,
+	wamp.ErrNotAuthorized:     true,
+	wamp.ErrOptionNotAllowed:  true,
+	wamp.ErrNoSuchRealm:       true,
+	wamp.ErrNoSuchRole:        true,
+	wamp.ErrProtocolViolation: true,
+}
+
+// fuzzMessage is one message sent by the fuzzer.
+type fuzzMessage struct {
+	URI     string
+	Args    wamp.List
+	Kwargs  wamp.Dict
+	Options wamp.Dict
+}
+
+// Fuzz sends options.Count random calls or publishes through session,
+// printing every unexpected answer to out: timeouts, errors that are not
+// WAMP errors or that callees raise on bugs like wamp.error.runtime_error,
+// and the session being lost. A summary is printed at the end. It returns
+// an error if there was an unexpected answer.
+func Fuzz(session *client.Client, logger *logrus.Logger, options FuzzOptions, out io.Writer) error {
+	if options.Target != FuzzCall && options.Target != FuzzPublish {
+		return fmt.Errorf("invalid fuzz target: %s", options.Target)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fuzzer := &fuzzer{rand: rand.New(rand.NewSource(options.Seed))}
+	fmt.Fprintf(out, "FUZZ %s with seed %d\n", options.Target, options.Seed)
+
+	var sent, ok, unexpected int
+	rejected := map[string]int{}
+	for n := 1; n <= options.Count && ctx.Err() == nil; n++ {
+		message := fuzzer.message(options)
+		err := sendFuzz(ctx, session, options, message)
+		if ctx.Err() != nil {
+			break
+		}
+		sent++
+
+		lost := false
+		select {
+		case <-session.Done():
+			lost = true
+		default:
+		}
+		switch {
+		case err == nil && !lost:
+			ok++
+			continue
+		case err != nil && !lost && expectedFuzzError(err):
+			rejected[ErrorURI(err)]++
+			logger.WithFields(logrus.Fields{"uri": message.URI, "n": n}).Debug("fuzz message rejected: ", err)
+			continue
+		}
+
+		unexpected++
+		if lost {
+			err = fmt.Errorf("session lost: %v", err)
+		} else if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, client.ErrReplyTimeout) {
+			err = fmt.Errorf("no answer within %s", options.Timeout)
+		}
+		fmt.Fprintf(out, "#%d %s: %v\n", n, message.URI, err)
+		fmt.Fprintf(out, "    %s\n", message.summary())
+		if lost {
+			break
+		}
+	}
+
+	summary := fmt.Sprintf("%d sent, %d ok, %d rejected, %d unexpected", sent, ok, sumCounts(rejected),
+		unexpected)
+	if len(rejected) > 0 {
+		summary += " (" + formatCounts(rejected) + ")"
+	}
+	fmt.Fprintln(out, summary)
+	if unexpected > 0 {
+		return fmt.Errorf("%d unexpected answers, send the same messages again with --seed %d", unexpected,
+			options.Seed)
+	}
+	return nil
+}
+
+// sendFuzz sends message as a call or an acknowledged publish, so that the
+// router answers it, within options.Timeout.
+func sendFuzz(ctx context.Context, session *client.Client, options FuzzOptions, message fuzzMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, options.Timeout)
+	defer cancel()
+
+	if options.Target == FuzzCall {
+		_, err := session.Call(ctx, message.URI, message.Options, message.Args, message.Kwargs, nil)
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Publish(message.URI, message.Options, message.Args, message.Kwargs)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// expectedFuzzError reports whether err is a WAMP error a router or callee
+// may rightly answer a random message with.
+func expectedFuzzError(err error) bool {
+	uri := ErrorURI(err)
+	if uri == "" {
+		return false
+	}
+	if rejectedErrors[wamp.URI(uri)] {
+		return true
+	}
+	return !strings.HasPrefix(uri, "wamp.")
+}
+
+func sumCounts(counts map[string]int) int {
+	sum := 0
+	for _, count := range counts {
+		sum += count
+	}
+	return sum
+}
+
+// formatCounts returns counts like "wamp.error.invalid_uri 3, app.error 1",
+// sorted by key.
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s %d", key, counts[key])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// summary returns the message as one line of JSON, long strings shortened.
+func (m fuzzMessage) summary() string {
+	var text strings.Builder
+	encoder := json.NewEncoder(&text)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(map[string]interface{}{
+		"args":    abbreviated(m.Args, 0),
+		"kwargs":  abbreviated(m.Kwargs, 0),
+		"options": abbreviated(m.Options, 0),
+	})
+	if err != nil {
+		return err.Error()
+	}
+	return strings.TrimSuffix(text.String(), "\n")
+}
+
+// abbreviated returns value with the strings of more than 64 characters
+// shortened and the lists and dicts nested more than 4 levels below depth
+// elided, for printing.
+func abbreviated(value interface{}, depth int) interface{} {
+	switch value.(type) {
+	case wamp.List, wamp.Dict:
+		if depth > 4 {
+			return "..."
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		if runes := []rune(v); len(runes) > 64 {
+			return fmt.Sprintf("%s... (%d characters)", string(runes[:32]), len(runes))
+		}
+	case []byte:
+		return fmt.Sprintf("(%d bytes)", len(v))
+	case wamp.List:
+		list := make(wamp.List, len(v))
+		for i, item := range v {
+			list[i] = abbreviated(item, depth+1)
+		}
+		return list
+	case wamp.Dict:
+		dict := make(wamp.Dict, len(v))
+		for key, item := range v {
+			dict[abbreviated(key, depth).(string)] = abbreviated(item, depth+1)
+		}
+		return dict
+	}
+	return value
+}
+
+// fuzzer generates random messages, mostly made of edge cases.
+type fuzzer struct {
+	rand *rand.Rand
+}
+
+// fuzzStrings are the strings the fuzzer picks from, besides random and
+// huge ones.
+var fuzzStrings = []string{
+	"", " ", "a", "null", "true", "0", "-1", "{}", "[]", "\x00", "\x00\x01\x02", "\n\r\t", "'\"\\",
+	"☃", "😀👍🏽", "日本語", "\u202eevil", "ümlaut", "\ufeff", "%s%n%x", "<script>", "../../etc/passwd",
+	"wamp.error.runtime_error", "${jndi:ldap://x}",
+}
+
+func (f *fuzzer) message(options FuzzOptions) fuzzMessage {
+	var uri string
+	if len(options.URIs) > 0 && f.rand.Intn(2) == 0 {
+		uri = options.URIs[f.rand.Intn(len(options.URIs))]
+	} else {
+		uri = f.uri(options.URIPrefix)
+	}
+
+	args := wamp.List{}
+	for i := f.rand.Intn(5); i > 0; i-- {
+		args = append(args, f.value(0))
+	}
+	kwargs := wamp.Dict{}
+	for i := f.rand.Intn(4); i > 0; i-- {
+		kwargs[f.key()] = f.value(0)
+	}
+	return fuzzMessage{URI: uri, Args: args, Kwargs: kwargs, Options: f.options(options.Target)}
+}
+
+// uri returns prefix followed by random components, sometimes invalid.
+func (f *fuzzer) uri(prefix string) string {
+	switch f.rand.Intn(10) {
+	case 0:
+		return prefix
+	case 1:
+		return prefix + ".." + f.word()
+	case 2:
+		return prefix + f.word() + " " + f.word()
+	case 3:
+		return prefix + f.pick(fuzzStrings)
+	case 4:
+		return prefix + strings.Repeat("x", 1+f.rand.Intn(4096))
+	case 5:
+		return prefix + f.word() + "#"
+	}
+	parts := make([]string, 1+f.rand.Intn(4))
+	for i := range parts {
+		parts[i] = f.word()
+	}
+	return prefix + strings.Join(parts, ".")
+}
+
+func (f *fuzzer) word() string {
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789_"
+	word := make([]byte, 1+f.rand.Intn(12))
+	for i := range word {
+		word[i] = letters[f.rand.Intn(len(letters))]
+	}
+	return string(word)
+}
+
+func (f *fuzzer) key() string {
+	if f.rand.Intn(3) == 0 {
+		return f.pick(fuzzStrings)
+	}
+	return f.word()
+}
+
+func (f *fuzzer) pick(values []string) string {
+	return values[f.rand.Intn(len(values))]
+}
+
+// value returns a random value, nested at most a few levels deep unless
+// it is one of the deeply nested ones.
+func (f *fuzzer) value(depth int) interface{} {
+	kind := f.rand.Intn(12)
+	if depth > 3 && kind >= 9 {
+		kind = f.rand.Intn(9)
+	}
+	switch kind {
+	case 0:
+		return nil
+	case 1:
+		return f.rand.Intn(2) == 0
+	case 2:
+		ints := []int64{0, 1, -1, math.MaxInt32, math.MinInt32, math.MaxInt64, math.MinInt64, 1 << 53}
+		if f.rand.Intn(2) == 0 {
+			return ints[f.rand.Intn(len(ints))]
+		}
+		return f.rand.Int63() - f.rand.Int63()
+	case 3:
+		return f.rand.NormFloat64() * math.Pow(10, float64(f.rand.Intn(40)-20))
+	case 4, 5:
+		return f.pick(fuzzStrings)
+	case 6:
+		return strings.Repeat(f.pick(fuzzStrings[1:]), 1+f.rand.Intn(1<<16))
+	case 7:
+		data := make([]byte, f.rand.Intn(1024))
+		f.rand.Read(data)
+		return data
+	case 8:
+		return f.word()
+	case 9:
+		list := wamp.List{}
+		for i := f.rand.Intn(6); i > 0; i-- {
+			list = append(list, f.value(depth+1))
+		}
+		return list
+	case 10:
+		dict := wamp.Dict{}
+		for i := f.rand.Intn(6); i > 0; i-- {
+			dict[f.key()] = f.value(depth + 1)
+		}
+		return dict
+	}
+	return f.nested(1 + f.rand.Intn(500))
+}
+
+// nested returns a list or dict nested depth levels deep.
+func (f *fuzzer) nested(depth int) interface{} {
+	var value interface{} = f.word()
+	for i := 0; i < depth; i++ {
+		if f.rand.Intn(2) == 0 {
+			value = wamp.List{value}
+		} else {
+			value = wamp.Dict{f.word(): value}
+		}
+	}
+	return value
+}
+
+// options returns random options for target, known ones with valid or
+// wrongly typed values and unknown ones. Publishes are always
+// acknowledged, for the router to answer them.
+func (f *fuzzer) options(target string) wamp.Dict {
+	known := []string{wamp.OptDiscloseMe, wamp.OptTimeout, wamp.OptReceiveProgress, wamp.OptMatch, "ppt_scheme"}
+	if target == FuzzPublish {
+		known = []string{wamp.OptExcludeMe, wamp.OptDiscloseMe, "exclude", "eligible",
+			"exclude_authid", "eligible_authrole", "retain"}
+	}
+	options := wamp.Dict{}
+	for i := f.rand.Intn(4); i > 0; i-- {
+		if f.rand.Intn(4) == 0 {
+			options[f.key()] = f.value(2)
+		} else {
+			options[known[f.rand.Intn(len(known))]] = f.value(3)
+		}
+	}
+	if target == FuzzPublish {
+		options[wamp.OptAcknowledge] = true
+	}
+	return options
+}
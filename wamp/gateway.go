@@ -0,0 +1,277 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// gateway exposes a WAMP session over HTTP.
+type gateway struct {
+	session *client.Client
+	logger  *logrus.Logger
+
+	// subscriptionLock serializes the subscribe and unsubscribe round trips.
+	// They run without lock, which the event handlers take on the receive
+	// goroutine of the session.
+	subscriptionLock sync.Mutex
+
+	lock sync.Mutex
+	// listeners holds the channels of the SSE clients of every topic, the
+	// session is subscribed to topics that have at least one.
+	listeners map[string]map[chan []byte]struct{}
+}
+
+// Gateway serves HTTP on listen until CTRL-c, backed by session:
+//
+//	POST /call/<procedure>  calls procedure and answers with its result
+//	POST /publish/<topic>   publishes to topic
+//	GET /subscribe/<topic>  streams the events of topic as server-sent events
+//
+// Request bodies are JSON payloads, as described for StreamFormatJSONL.
+func Gateway(session *client.Client, logger *logrus.Logger, listen string) error {
+	g := &gateway{
+		session:   session,
+		logger:    logger,
+		listeners: map[string]map[chan []byte]struct{}{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/call/", g.handleCall)
+	mux.HandleFunc("/publish/", g.handlePublish)
+	mux.HandleFunc("/subscribe/", g.handleSubscribe)
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: mux}
+	fmt.Printf("Gateway listening on http://%s\n", listener.Addr())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-session.Done():
+			logger.Info("Router gone, exiting")
+		}
+		server.Close()
+	}()
+
+	if err = server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (g *gateway) handleCall(w http.ResponseWriter, r *http.Request) {
+	procedure := strings.TrimPrefix(r.URL.Path, "/call/")
+	args, kwargs, ok := readPayload(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := g.session.Call(r.Context(), procedure, nil, args, kwargs, nil)
+	if err != nil {
+		var rpcErr client.RPCError
+		if errors.As(err, &rpcErr) {
+			writeJSON(w, http.StatusBadGateway, map[string]interface{}{
+				"error":  rpcErr.Err.Error,
+				"args":   printable(rpcErr.Err.Arguments, BinaryFormatBase64),
+				"kwargs": printable(rpcErr.Err.ArgumentsKw, BinaryFormatBase64),
+			})
+			return
+		}
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	g.logger.WithField("uri", procedure).Debug("gateway call succeeded")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"args":   printable(result.Arguments, BinaryFormatBase64),
+		"kwargs": printable(result.ArgumentsKw, BinaryFormatBase64),
+	})
+}
+
+func (g *gateway) handlePublish(w http.ResponseWriter, r *http.Request) {
+	topic := strings.TrimPrefix(r.URL.Path, "/publish/")
+	args, kwargs, ok := readPayload(w, r)
+	if !ok {
+		return
+	}
+
+	// The SSE clients of this gateway must get the event too.
+	options := wamp.Dict{wamp.OptAcknowledge: true, wamp.OptExcludeMe: false}
+	if err := g.session.Publish(topic, options, args, kwargs); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	g.logger.WithField("uri", topic).Debug("gateway published")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *gateway) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("use GET"))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+
+	topic := strings.TrimPrefix(r.URL.Path, "/subscribe/")
+	events := make(chan []byte, 64)
+	if err := g.addListener(topic, events); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer g.removeListener(topic, events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-events:
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
+func (g *gateway) addListener(topic string, events chan []byte) error {
+	g.subscriptionLock.Lock()
+	defer g.subscriptionLock.Unlock()
+
+	g.lock.Lock()
+	if listeners, ok := g.listeners[topic]; ok {
+		listeners[events] = struct{}{}
+		g.lock.Unlock()
+		return nil
+	}
+	g.lock.Unlock()
+
+	eventHandler := func(event *wamp.Event) {
+		data, err := json.Marshal(map[string]interface{}{
+			"args":   printable(event.Arguments, BinaryFormatBase64),
+			"kwargs": printable(event.ArgumentsKw, BinaryFormatBase64),
+		})
+		if err != nil {
+			g.logger.WithField("uri", topic).Error("Failed to encode event: ", err)
+			return
+		}
+
+		g.lock.Lock()
+		defer g.lock.Unlock()
+		for listener := range g.listeners[topic] {
+			select {
+			case listener <- data:
+			default:
+				g.logger.WithField("uri", topic).Warn("Dropped event for a slow SSE client")
+			}
+		}
+	}
+	if err := g.session.Subscribe(topic, eventHandler, nil); err != nil {
+		return err
+	}
+	g.lock.Lock()
+	g.listeners[topic] = map[chan []byte]struct{}{events: {}}
+	g.lock.Unlock()
+	return nil
+}
+
+func (g *gateway) removeListener(topic string, events chan []byte) {
+	g.subscriptionLock.Lock()
+	defer g.subscriptionLock.Unlock()
+
+	g.lock.Lock()
+	listeners := g.listeners[topic]
+	delete(listeners, events)
+	if len(listeners) > 0 {
+		g.lock.Unlock()
+		return
+	}
+	delete(g.listeners, topic)
+	g.lock.Unlock()
+
+	if err := g.session.Unsubscribe(topic); err != nil {
+		g.logger.WithField("uri", topic).Error("Failed to unsubscribe: ", err)
+	}
+}
+
+// readPayload reads the args and kwargs of a POST request, an empty body
+// has neither. It answers the request itself if it is invalid.
+func readPayload(w http.ResponseWriter, r *http.Request) (wamp.List, wamp.Dict, bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+		return nil, nil, false
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, nil, false
+	}
+	if strings.TrimSpace(string(body)) == "" {
+		return nil, nil, true
+	}
+
+	args, kwargs, err := parseJSONPayload(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, nil, false
+	}
+	return args, kwargs, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,125 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+)
+
+// HealthcheckOptions configure Healthcheck.
+type HealthcheckOptions struct {
+	// Procedure is called once joined, nothing is called if empty.
+	Procedure string
+
+	// MaxJoin and MaxCall are the slowest join and call that are still
+	// healthy, zero accepts any.
+	MaxJoin time.Duration
+	MaxCall time.Duration
+
+	// Timeout fails the whole check if it did not finish in time, zero
+	// waits forever.
+	Timeout time.Duration
+}
+
+// HealthReport is the outcome of Healthcheck, printed as a JSON line.
+type HealthReport struct {
+	Healthy bool `json:"healthy"`
+
+	// JoinMs and CallMs are how long the join and the call took, in
+	// milliseconds. CallMs is nil if nothing was called.
+	JoinMs float64  `json:"join_ms"`
+	CallMs *float64 `json:"call_ms,omitempty"`
+
+	// Error says why the router is not healthy.
+	Error string `json:"error,omitempty"`
+}
+
+// Healthcheck joins the realm with connect, calls the procedure of options
+// if any, and checks how long both took against the thresholds of options.
+// The report is printed to out as one JSON line before it is returned.
+func Healthcheck(connect func() (*client.Client, error), options HealthcheckOptions, out io.Writer) HealthReport {
+	done := make(chan HealthReport, 1)
+	go func() { done <- checkHealth(connect, options) }()
+
+	var report HealthReport
+	if options.Timeout > 0 {
+		select {
+		case report = <-done:
+		case <-time.After(options.Timeout):
+			report = HealthReport{Error: fmt.Sprintf("no answer within %s", options.Timeout)}
+		}
+	} else {
+		report = <-done
+	}
+
+	line, _ := json.Marshal(report)
+	fmt.Fprintln(out, string(line))
+	return report
+}
+
+func checkHealth(connect func() (*client.Client, error), options HealthcheckOptions) HealthReport {
+	var report HealthReport
+	start := time.Now()
+	session, err := connect()
+	joined := time.Since(start)
+	report.JoinMs = toMilliseconds(joined)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	defer session.Close()
+	if options.MaxJoin > 0 && joined > options.MaxJoin {
+		report.Error = fmt.Sprintf("join took longer than %s", options.MaxJoin)
+		return report
+	}
+
+	if options.Procedure != "" {
+		start = time.Now()
+		_, err = session.Call(context.Background(), options.Procedure, nil, nil, nil, nil)
+		took := time.Since(start)
+		callMs := toMilliseconds(took)
+		report.CallMs = &callMs
+		if err != nil {
+			report.Error = err.Error()
+			return report
+		}
+		if options.MaxCall > 0 && took > options.MaxCall {
+			report.Error = fmt.Sprintf("call took longer than %s", options.MaxCall)
+			return report
+		}
+	}
+
+	report.Healthy = true
+	return report
+}
+
+// toMilliseconds returns d in milliseconds, rounded to microseconds.
+func toMilliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
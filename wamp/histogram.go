@@ -0,0 +1,137 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// histogramWidth is the length of the bar of the largest bucket.
+	histogramWidth = 40
+
+	// sparklineWidth is the most values a sparkline shows, longer series
+	// are averaged down to it.
+	sparklineWidth = 60
+)
+
+// sparkBlocks draw a sparkline, from the lowest value to the highest.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// render returns the buckets of h, from the first to the last one counted,
+// as lines of bars, so the shape of the distribution shows at a glance.
+// Empty if nothing was counted.
+func (h LatencyHistogram) render() string {
+	first, last, largest := -1, -1, 0
+	for i, count := range h.Counts {
+		if count == 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+		if count > largest {
+			largest = count
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+
+	labels := make([]string, 0, last-first+1)
+	labelWidth := 0
+	for i := first; i <= last; i++ {
+		label := "> " + h.Bounds[len(h.Bounds)-1].String()
+		if i < len(h.Bounds) {
+			label = "<= " + h.Bounds[i].String()
+		}
+		if len([]rune(label)) > labelWidth {
+			labelWidth = len([]rune(label))
+		}
+		labels = append(labels, label)
+	}
+	lines := make([]string, 0, len(labels))
+	for i, label := range labels {
+		count := h.Counts[first+i]
+		bar := count * histogramWidth / largest
+		if bar == 0 && count > 0 {
+			bar = 1
+		}
+		padding := strings.Repeat(" ", labelWidth-len([]rune(label)))
+		lines = append(lines, fmt.Sprintf("  %s%s |%-*s %d", padding, label, histogramWidth,
+			strings.Repeat("#", bar), count))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// histogramOf returns the histogram of latencies.
+func histogramOf(latencies []time.Duration) LatencyHistogram {
+	h := newLatencyHistogram()
+	for _, latency := range latencies {
+		h.add(latency)
+	}
+	return h
+}
+
+// sparkline returns the latencies in order as a line of blocks, from the
+// lowest to the highest, with the range they span, like
+// "▁▁▂█▃▁ 1.2ms..20.5ms". Empty for fewer than two latencies.
+func sparkline(latencies []time.Duration) string {
+	if len(latencies) < 2 {
+		return ""
+	}
+	values := latencies
+	if len(values) > sparklineWidth {
+		values = make([]time.Duration, sparklineWidth)
+		for i := range values {
+			group := latencies[i*len(latencies)/sparklineWidth : (i+1)*len(latencies)/sparklineWidth]
+			var sum time.Duration
+			for _, latency := range group {
+				sum += latency
+			}
+			values[i] = sum / time.Duration(len(group))
+		}
+	}
+
+	min, max := values[0], values[0]
+	for _, value := range values {
+		if value < min {
+			min = value
+		}
+		if value > max {
+			max = value
+		}
+	}
+	line := make([]rune, len(values))
+	for i, value := range values {
+		level := 0
+		if max > min {
+			level = int(int64(value-min) * int64(len(sparkBlocks)-1) / int64(max-min))
+		}
+		line[i] = sparkBlocks[level]
+	}
+	return fmt.Sprintf("%s %s..%s", string(line), min.Round(time.Microsecond), max.Round(time.Microsecond))
+}
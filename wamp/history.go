@@ -0,0 +1,204 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Kinds of URIs in the history.
+const (
+	HistoryTopic     = "topic"
+	HistoryProcedure = "procedure"
+)
+
+// historyMaxSize is the size beyond which the oldest half of the history is
+// dropped.
+const historyMaxSize = 256 * 1024
+
+// HistoryEntry is a URI with how often and how recently it was used.
+type HistoryEntry struct {
+	URI      string
+	Kind     string
+	Uses     int
+	LastUsed time.Time
+
+	// Score is the frecency of the URI, the sum of the uses weighted by how
+	// recent they are.
+	Score float64
+}
+
+// History records the topics and procedures used, one line per use, so that
+// they can be listed and completed most often and most recently used first.
+type History struct {
+	path string
+}
+
+// DefaultHistoryFile returns ~/.wick/history, or an empty string if there is
+// no home directory.
+func DefaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".wick", "history")
+}
+
+// NewHistory returns the history kept in the file at path.
+func NewHistory(path string) *History {
+	return &History{path: path}
+}
+
+// Record adds a use of uri as kind at the current time.
+func (h *History) Record(kind string, uri string) error {
+	if err := os.MkdirAll(filepath.Dir(h.path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(h.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(file, "%d\t%s\t%s\n", time.Now().Unix(), kind, uri)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(h.path); err == nil && info.Size() > historyMaxSize {
+		return h.truncate()
+	}
+	return nil
+}
+
+// truncate drops the oldest half of the history.
+func (h *History) truncate() error {
+	data, err := ioutil.ReadFile(h.path)
+	if err != nil {
+		return err
+	}
+	data = data[len(data)/2:]
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	temp := h.path + ".tmp"
+	if err = ioutil.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, h.path)
+}
+
+// Entries returns the URIs of kind, or of all kinds if empty, that contain
+// search, best scored first. A missing history has no entries.
+func (h *History) Entries(kind string, search string) ([]HistoryEntry, error) {
+	file, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	now := time.Now()
+	entries := map[string]*HistoryEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		seconds, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		usedKind, uri := fields[1], fields[2]
+		if (kind != "" && usedKind != kind) || !strings.Contains(uri, search) {
+			continue
+		}
+
+		key := usedKind + "\t" + uri
+		entry, ok := entries[key]
+		if !ok {
+			entry = &HistoryEntry{URI: uri, Kind: usedKind}
+			entries[key] = entry
+		}
+		used := time.Unix(seconds, 0)
+		entry.Uses++
+		if used.After(entry.LastUsed) {
+			entry.LastUsed = used
+		}
+		entry.Score += frecencyWeight(now.Sub(used))
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sorted := make([]HistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		sorted = append(sorted, *entry)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Score != sorted[j].Score {
+			return sorted[i].Score > sorted[j].Score
+		}
+		return sorted[i].LastUsed.After(sorted[j].LastUsed)
+	})
+	return sorted, nil
+}
+
+// frecencyWeight returns how much a use age ago counts.
+func frecencyWeight(age time.Duration) float64 {
+	switch {
+	case age < time.Hour:
+		return 4
+	case age < 24*time.Hour:
+		return 2
+	case age < 7*24*time.Hour:
+		return 1
+	}
+	return 0.25
+}
+
+// PrintHistory prints entries as a table.
+func PrintHistory(entries []HistoryEntry, out io.Writer) error {
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "URI\tKIND\tUSES\tLAST USED")
+	for _, entry := range entries {
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%s\n", entry.URI, entry.Kind, entry.Uses,
+			entry.LastUsed.Format("2006-01-02 15:04"))
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,113 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// idleTimer fires once nothing happened, and nothing was in progress, for a
+// period. A nil idleTimer never fires.
+type idleTimer struct {
+	period time.Duration
+	last   int64
+	active int64
+	done   chan struct{}
+	stop   chan struct{}
+}
+
+// newIdleTimer starts an idleTimer, nil if period is zero.
+func newIdleTimer(period time.Duration) *idleTimer {
+	if period <= 0 {
+		return nil
+	}
+	t := &idleTimer{
+		period: period,
+		last:   time.Now().UnixNano(),
+		done:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *idleTimer) run() {
+	timer := time.NewTimer(t.period)
+	defer timer.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-timer.C:
+		}
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&t.last)))
+		if atomic.LoadInt64(&t.active) > 0 {
+			idle = 0
+		}
+		if idle >= t.period {
+			close(t.done)
+			return
+		}
+		timer.Reset(t.period - idle)
+	}
+}
+
+// touch records activity, restarting the period.
+func (t *idleTimer) touch() {
+	if t != nil {
+		atomic.StoreInt64(&t.last, time.Now().UnixNano())
+	}
+}
+
+// begin records the start of an activity, the timer cannot fire until end
+// is called.
+func (t *idleTimer) begin() {
+	if t != nil {
+		atomic.AddInt64(&t.active, 1)
+		t.touch()
+	}
+}
+
+// end records the end of an activity started with begin.
+func (t *idleTimer) end() {
+	if t != nil {
+		t.touch()
+		atomic.AddInt64(&t.active, -1)
+	}
+}
+
+// expired returns a channel closed once the period passed without activity.
+func (t *idleTimer) expired() <-chan struct{} {
+	if t == nil {
+		return nil
+	}
+	return t.done
+}
+
+// close stops the timer.
+func (t *idleTimer) close() {
+	if t != nil {
+		close(t.stop)
+	}
+}
@@ -0,0 +1,172 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// The formats public keys can be printed in.
+const (
+	KeyFormatHex     = "hex"
+	KeyFormatBase64  = "base64"
+	KeyFormatOpenSSH = "openssh"
+)
+
+// GenerateKey returns a new cryptosign private key.
+func GenerateKey() (ed25519.PrivateKey, error) {
+	_, pvk, err := ed25519.GenerateKey(rand.Reader)
+	return pvk, err
+}
+
+// LoadPrivateKey returns the cryptosign private key held by value, which is
+// either the hex of the key or its seed, or the path of a file holding that
+// hex or an OpenSSH ed25519 private key.
+func LoadPrivateKey(value string) (ed25519.PrivateKey, error) {
+	if _, err := hex.DecodeString(value); err == nil {
+		return cryptosignKey(value)
+	}
+	data, err := ioutil.ReadFile(value)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.New("private key is neither hex nor an existing file")
+		}
+		return nil, err
+	}
+	if bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+		raw, err := ssh.ParseRawPrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key file %s: %w", value, err)
+		}
+		pvk, ok := raw.(*ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("private key file %s does not hold an ed25519 key", value)
+		}
+		return *pvk, nil
+	}
+	return cryptosignKey(strings.TrimSpace(string(data)))
+}
+
+// PublicKey returns the public key of pvk.
+func PublicKey(pvk ed25519.PrivateKey) ed25519.PublicKey {
+	return pvk.Public().(ed25519.PublicKey)
+}
+
+// FormatPublicKey returns pub in format, the OpenSSH format is the
+// authorized_keys line without a comment.
+func FormatPublicKey(pub ed25519.PublicKey, format string) (string, error) {
+	switch format {
+	case KeyFormatHex, "":
+		return hex.EncodeToString(pub), nil
+	case KeyFormatBase64:
+		return base64.StdEncoding.EncodeToString(pub), nil
+	case KeyFormatOpenSSH:
+		sshKey, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshKey))), nil
+	}
+	return "", fmt.Errorf("unknown key format: %s", format)
+}
+
+// ParsePublicKey returns the ed25519 public key held by value, in any of the
+// formats of FormatPublicKey, or the path of a file holding it.
+func ParsePublicKey(value string) (ed25519.PublicKey, error) {
+	value = strings.TrimSpace(value)
+	pub, parseErr := parsePublicKey(value)
+	if parseErr == nil {
+		return pub, nil
+	}
+	data, err := ioutil.ReadFile(value)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, parseErr
+		}
+		return nil, err
+	}
+	return parsePublicKey(strings.TrimSpace(string(data)))
+}
+
+func parsePublicKey(value string) (ed25519.PublicKey, error) {
+	if strings.HasPrefix(value, ssh.KeyAlgoED25519+" ") {
+		sshKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid OpenSSH public key: %w", err)
+		}
+		cryptoKey, ok := sshKey.(ssh.CryptoPublicKey)
+		if !ok {
+			return nil, errors.New("invalid OpenSSH public key")
+		}
+		pub, ok := cryptoKey.CryptoPublicKey().(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.New("OpenSSH public key is not an ed25519 key")
+		}
+		return pub, nil
+	}
+	key, err := decodeKeyBytes(value)
+	if err != nil {
+		return nil, errors.New("public key is neither hex, base64, OpenSSH nor an existing file")
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes, got %d", ed25519.PublicKeySize, len(key))
+	}
+	return key, nil
+}
+
+// VerifySignature reports whether signature, as hex or base64, is the
+// signature of message by pub. The signature may be followed by the signed
+// message, as cryptosign sends it in AUTHENTICATE.
+func VerifySignature(pub ed25519.PublicKey, message []byte, signature string) (bool, error) {
+	sig, err := decodeKeyBytes(strings.TrimSpace(signature))
+	if err != nil {
+		return false, fmt.Errorf("invalid signature: %w", err)
+	}
+	if len(sig) < ed25519.SignatureSize {
+		return false, fmt.Errorf("signature must be %d bytes, got %d", ed25519.SignatureSize, len(sig))
+	}
+	return ed25519.Verify(pub, message, sig[:ed25519.SignatureSize]), nil
+}
+
+// decodeKeyBytes decodes value as hex, or else as standard base64.
+func decodeKeyBytes(value string) ([]byte, error) {
+	if data, err := hex.DecodeString(value); err == nil {
+		return data, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("neither hex nor base64")
+	}
+	return data, nil
+}
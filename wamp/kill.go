@@ -0,0 +1,122 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// KillOptions select the sessions KillSessions closes, by exactly one of
+// SessionID, AuthID and AuthRole.
+type KillOptions struct {
+	SessionID uint64
+	AuthID    string
+	AuthRole  string
+
+	// Reason is the URI of the GOODBYE sent to the killed sessions, empty
+	// lets the router choose.
+	Reason string
+
+	// Message is sent to the killed sessions along with Reason.
+	Message string
+}
+
+// Check returns an error unless exactly one way of selecting the sessions
+// was given.
+func (o KillOptions) Check() error {
+	given := 0
+	for _, set := range []bool{o.SessionID != 0, o.AuthID != "", o.AuthRole != ""} {
+		if set {
+			given++
+		}
+	}
+	if given != 1 {
+		return errors.New("give exactly one of a session ID, --by-authid and --by-authrole")
+	}
+	return nil
+}
+
+// Target describes the sessions of the options, like "the sessions with
+// authid alice".
+func (o KillOptions) Target() string {
+	if selector := o.selector(); selector != "" {
+		return "the sessions with " + selector
+	}
+	return fmt.Sprintf("session %d", o.SessionID)
+}
+
+// selector returns the authid or authrole of the options, like "authid
+// alice", empty if killing by session ID.
+func (o KillOptions) selector() string {
+	switch {
+	case o.AuthID != "":
+		return "authid " + o.AuthID
+	case o.AuthRole != "":
+		return "authrole " + o.AuthRole
+	}
+	return ""
+}
+
+// KillSessions closes the sessions of the options with the session meta
+// procedures, which the router must allow session to call. The session of
+// wick itself is never killed.
+func KillSessions(session *client.Client, options KillOptions, out io.Writer) error {
+	var procedure wamp.URI
+	var args wamp.List
+	switch {
+	case options.AuthID != "":
+		procedure, args = wamp.MetaProcSessionKillByAuthid, wamp.List{options.AuthID}
+	case options.AuthRole != "":
+		procedure, args = wamp.MetaProcSessionKillByAuthrole, wamp.List{options.AuthRole}
+	default:
+		procedure, args = wamp.MetaProcSessionKill, wamp.List{wamp.ID(options.SessionID)}
+	}
+	kwargs := wamp.Dict{}
+	if options.Reason != "" {
+		kwargs["reason"] = options.Reason
+	}
+	if options.Message != "" {
+		kwargs["message"] = options.Message
+	}
+
+	result, err := session.Call(context.Background(), string(procedure), nil, args, kwargs, nil)
+	if err != nil {
+		return fmt.Errorf("killing %s: %w", options.Target(), err)
+	}
+	if procedure == wamp.MetaProcSessionKill {
+		fmt.Fprintf(out, "Killed session %d\n", options.SessionID)
+		return nil
+	}
+	var count int64
+	if len(result.Arguments) > 0 {
+		count, _ = wamp.AsInt64(result.Arguments[0])
+	}
+	fmt.Fprintf(out, "Killed %d sessions with %s\n", count, options.selector())
+	return nil
+}
@@ -0,0 +1,264 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// The kwargs of the events of PublishTimestamped.
+const (
+	latencySentKey  = "wick_sent_ns"
+	latencyClockKey = "wick_clock"
+	latencySeqKey   = "wick_seq"
+)
+
+// LatencyPublishOptions configure PublishTimestamped.
+type LatencyPublishOptions struct {
+	// Count stops after this many events, zero publishes until CTRL-c.
+	Count int
+
+	// Interval is the time between two events.
+	Interval time.Duration
+}
+
+// PublishTimestamped publishes events carrying the time they were sent to
+// topic every interval, for MeasureLatency. The session registers a clock
+// procedure the subscribers call to estimate the offset between the clocks
+// of both hosts.
+func PublishTimestamped(session *client.Client, logger *logrus.Logger, topic string, options LatencyPublishOptions,
+	out io.Writer) error {
+
+	if options.Interval <= 0 {
+		return errors.New("the interval must be positive")
+	}
+	clock := fmt.Sprintf("wick.clock.%v", session.ID())
+	now := func(ctx context.Context, invocation *wamp.Invocation) client.InvokeResult {
+		return client.InvokeResult{Args: wamp.List{time.Now().UnixNano()}}
+	}
+	if err := session.Register(clock, now, nil); err != nil {
+		return err
+	}
+	defer func() {
+		if err := session.Unregister(clock); err != nil {
+			logger.WithField("uri", clock).Debug("failed to unregister clock procedure: ", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Fprintf(out, "Publishing timestamped events to '%s', clock %s\n", topic, clock)
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+	for seq := 1; options.Count == 0 || seq <= options.Count; seq++ {
+		kwargs := wamp.Dict{
+			latencySentKey:  time.Now().UnixNano(),
+			latencyClockKey: clock,
+			latencySeqKey:   seq,
+		}
+		if err := session.Publish(topic, wamp.Dict{wamp.OptAcknowledge: true}, nil, kwargs); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// LatencySubscribeOptions configure MeasureLatency.
+type LatencySubscribeOptions struct {
+	// Count stops after this many events, zero measures until CTRL-c.
+	Count int
+
+	// Samples is the number of calls to the clock procedure of a
+	// publisher to estimate the offset of its clock, the one with the
+	// shortest round trip is used.
+	Samples int
+}
+
+// clockOffset is the estimated offset of the clock of a publisher, its
+// clock minus ours, give or take the error.
+type clockOffset struct {
+	offset time.Duration
+	error  time.Duration
+}
+
+// timestampedEvent is an event of PublishTimestamped, with the time it was
+// received.
+type timestampedEvent struct {
+	sent     time.Time
+	received time.Time
+	clock    string
+	seq      int64
+}
+
+// MeasureLatency subscribes to the timestamped events of PublishTimestamped
+// on topic and prints the one-way delivery latency of each to out, followed
+// by the latency distribution once the count is reached or on CTRL-c. The
+// clock offset of every publisher is estimated from its clock procedure, like
+// NTP does, when its first event arrives.
+func MeasureLatency(session *client.Client, logger *logrus.Logger, topic string, options LatencySubscribeOptions,
+	out io.Writer) error {
+
+	// The clock procedures are called from another goroutine than the event
+	// handler, which must not wait for a call result.
+	events := make(chan timestampedEvent, 1024)
+	handler := func(event *wamp.Event) {
+		received := time.Now()
+		sentNs, ok := wamp.AsInt64(event.ArgumentsKw[latencySentKey])
+		clock, _ := wamp.AsString(event.ArgumentsKw[latencyClockKey])
+		if !ok || clock == "" {
+			logger.WithField("uri", topic).Debug("ignoring an event without timestamp")
+			return
+		}
+		seq, _ := wamp.AsInt64(event.ArgumentsKw[latencySeqKey])
+		select {
+		case events <- timestampedEvent{sent: time.Unix(0, sentNs), received: received, clock: clock, seq: seq}:
+		default:
+			logger.WithField("uri", topic).Warn("Dropped a timestamped event, latencies are printed too slowly")
+		}
+	}
+	if err := session.Subscribe(topic, handler, nil); err != nil {
+		return err
+	}
+	defer func() {
+		if err := session.Unsubscribe(topic); err != nil {
+			logger.WithField("uri", topic).Debug("failed to unsubscribe: ", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Fprintf(out, "Measuring the latency of the events of '%s'\n", topic)
+	offsets := map[string]clockOffset{}
+	var latencies []time.Duration
+	for received := 0; options.Count == 0 || received < options.Count; {
+		var event timestampedEvent
+		select {
+		case <-ctx.Done():
+		case <-session.Done():
+			logger.Info("Router gone, exiting")
+		case event = <-events:
+		}
+		if event.clock == "" {
+			break
+		}
+
+		offset, ok := offsets[event.clock]
+		if !ok {
+			var err error
+			if offset, err = estimateOffset(ctx, session, event.clock, options.Samples); err != nil {
+				logger.WithField("uri", event.clock).Warn("Failed to estimate the clock offset, assuming "+
+					"the clocks are in sync: ", err)
+			} else {
+				fmt.Fprintf(out, "clock %s offset %s ms ± %s ms\n", event.clock, milliseconds(offset.offset),
+					milliseconds(offset.error))
+			}
+			offsets[event.clock] = offset
+		}
+
+		latency := event.received.Sub(event.sent.Add(-offset.offset))
+		fmt.Fprintf(out, "seq=%d from %s latency=%s ms\n", event.seq, event.clock, milliseconds(latency))
+		latencies = append(latencies, latency)
+		received++
+	}
+
+	printLatencySummary(out, topic, latencies)
+	return nil
+}
+
+// estimateOffset calls the clock procedure samples times and returns the
+// offset measured by the call with the shortest round trip, assuming the
+// clock was read halfway through it.
+func estimateOffset(ctx context.Context, session *client.Client, clock string, samples int) (clockOffset,
+	error) {
+
+	if samples < 1 {
+		samples = 1
+	}
+	best := clockOffset{error: -1}
+	for i := 0; i < samples; i++ {
+		callCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		start := time.Now()
+		result, err := session.Call(callCtx, clock, nil, nil, nil, nil)
+		end := time.Now()
+		cancel()
+		if err != nil {
+			return clockOffset{}, err
+		}
+		if len(result.Arguments) == 0 {
+			return clockOffset{}, fmt.Errorf("%s returned no time", clock)
+		}
+		remoteNs, ok := wamp.AsInt64(result.Arguments[0])
+		if !ok {
+			return clockOffset{}, fmt.Errorf("%s returned no time", clock)
+		}
+
+		rtt := end.Sub(start)
+		if best.error < 0 || rtt/2 < best.error {
+			midpoint := start.Add(rtt / 2)
+			best = clockOffset{offset: time.Unix(0, remoteNs).Sub(midpoint), error: rtt / 2}
+		}
+	}
+	return best, nil
+}
+
+// printLatencySummary prints the distribution of the latencies. They may be
+// negative within the error of the clock offset.
+func printLatencySummary(out io.Writer, topic string, latencies []time.Duration) {
+	fmt.Fprintf(out, "\n--- %s latency statistics ---\n", topic)
+	fmt.Fprintf(out, "%d events\n", len(latencies))
+	if len(latencies) == 0 {
+		return
+	}
+
+	min, max, sum := latencies[0], latencies[0], time.Duration(0)
+	for _, latency := range latencies {
+		if latency < min {
+			min = latency
+		}
+		if latency > max {
+			max = latency
+		}
+		sum += latency
+	}
+	fmt.Fprintf(out, "latency min/avg/max = %s/%s/%s ms\n", milliseconds(min),
+		milliseconds(sum/time.Duration(len(latencies))), milliseconds(max))
+	fmt.Fprintf(out, "latency p50/p90/p99 = %s/%s/%s ms\n", milliseconds(percentile(latencies, 50)),
+		milliseconds(percentile(latencies, 90)), milliseconds(percentile(latencies, 99)))
+}
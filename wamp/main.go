@@ -25,290 +25,1241 @@ package wamp
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"golang.org/x/crypto/ed25519"
-	"golang.org/x/crypto/pbkdf2"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gammazero/nexus/v3/client"
 	"github.com/gammazero/nexus/v3/transport/serialize"
 	"github.com/gammazero/nexus/v3/wamp"
-	"github.com/gammazero/nexus/v3/wamp/crsign"
+	"github.com/sirupsen/logrus"
 )
 
-func connect(url string, cfg client.Config, logger *log.Logger) *client.Client {
-	//baseUrl := url
-	if strings.HasPrefix(url, "rs") {
-		url = "tcp" + strings.TrimPrefix(url, "rs")
-	} else if strings.HasPrefix(url, "rss") {
-		url = "tcp" + strings.TrimPrefix(url, "rss")
-	}
-	session, err := client.ConnectNet(context.Background(), url, cfg)
-	if err != nil {
-		logger.Fatal(err)
-	} else {
-		// FIXME: use a better logger and only print such messages in debug mode.
-		//logger.Println("Connected to ", baseUrl)
-	}
-
-	return session
-}
-
 func ConnectAnonymous(url string, realm string, serializer serialize.Serialization, authid string, authrole string,
-	logger *log.Logger) *client.Client {
+	opts ConnectOptions, logger *logrus.Logger) (*client.Client, error) {
 
-	helloDict := wamp.Dict{}
-	if authid != "" {
-		helloDict["authid"] = authid
-	}
+	return ConnectAnonymousContext(context.Background(), url, realm, serializer, authid, authrole, opts, logger)
+}
 
-	if authrole != "" {
-		helloDict["authrole"] = authrole
-	}
+// ConnectAnonymousContext is like ConnectAnonymous, canceled with ctx.
+func ConnectAnonymousContext(ctx context.Context, url string, realm string, serializer serialize.Serialization,
+	authid string, authrole string, opts ConnectOptions, logger *logrus.Logger) (*client.Client, error) {
 
-	cfg := client.Config{
+	return Connect(ctx, ClientConfig{
+		URL:           url,
 		Realm:         realm,
-		Logger:        logger,
-		HelloDetails:  helloDict,
 		Serialization: serializer,
-	}
-
-	return connect(url, cfg, logger)
+		AuthMethod:    AuthAnonymous,
+		AuthID:        authid,
+		AuthRole:      authrole,
+		Options:       opts,
+		Logger:        logger,
+	})
 }
 
 func ConnectTicket(url string, realm string, serializer serialize.Serialization, authid string, authrole string,
-	ticket string, logger *log.Logger) *client.Client {
+	ticket string, opts ConnectOptions, logger *logrus.Logger) (*client.Client, error) {
 
-	helloDict := wamp.Dict{}
-	if authid != "" {
-		helloDict["authid"] = authid
-	}
+	return ConnectTicketContext(context.Background(), url, realm, serializer, authid, authrole, ticket, opts, logger)
+}
 
-	if authrole != "" {
-		helloDict["authrole"] = authrole
-	}
+// ConnectTicketContext is like ConnectTicket, canceled with ctx.
+func ConnectTicketContext(ctx context.Context, url string, realm string, serializer serialize.Serialization,
+	authid string, authrole string, ticket string, opts ConnectOptions, logger *logrus.Logger) (*client.Client,
+	error) {
 
-	cfg := client.Config{
-		Realm:        realm,
-		Logger:       logger,
-		HelloDetails: helloDict,
-		AuthHandlers: map[string]client.AuthFunc{
-			"ticket": func(c *wamp.Challenge) (string, wamp.Dict) {
-				return ticket, wamp.Dict{}
-			},
-		},
+	return Connect(ctx, ClientConfig{
+		URL:           url,
+		Realm:         realm,
 		Serialization: serializer,
-	}
-
-	return connect(url, cfg, logger)
+		AuthMethod:    AuthTicket,
+		AuthID:        authid,
+		AuthRole:      authrole,
+		Ticket:        ticket,
+		Options:       opts,
+		Logger:        logger,
+	})
 }
 
 func ConnectCRA(url string, realm string, serializer serialize.Serialization, authid string, authrole string,
-	secret string, logger *log.Logger) *client.Client {
-
-	helloDict := wamp.Dict{}
-	if authid != "" {
-		helloDict["authid"] = authid
-	}
-
-	if authrole != "" {
-		helloDict["authrole"] = authrole
-	}
-
-	cfg := client.Config{
-		Realm:        realm,
-		Logger:       logger,
-		HelloDetails: helloDict,
-		AuthHandlers: map[string]client.AuthFunc{
-			"wampcra": func(c *wamp.Challenge) (string, wamp.Dict) {
-				ch, _ := wamp.AsString(c.Extra["challenge"])
-				// If the client needed to lookup a user's key, this would require decoding
-				// the JSON-encoded challenge string and getting the authid.  For this
-				// example assume that client only operates as one user and knows the key
-				// to use.
-				saltStr, _ := wamp.AsString(c.Extra["salt"])
-				// If no salt given, use raw password as key.
-				if saltStr == "" {
-					return crsign.SignChallenge(ch, []byte(secret)), wamp.Dict{}
-				}
+	secret string, opts ConnectOptions, logger *logrus.Logger) (*client.Client, error) {
 
-				// If salting info give, then compute a derived key using PBKDF2.
-				salt := []byte(saltStr)
-				iters, _ := wamp.AsInt64(c.Extra["iterations"])
-				keylen, _ := wamp.AsInt64(c.Extra["keylen"])
+	return ConnectCRAContext(context.Background(), url, realm, serializer, authid, authrole, secret, opts, logger)
+}
 
-				if iters == 0 {
-					iters = 1000
-				}
-				if keylen == 0 {
-					keylen = 32
-				}
+// ConnectCRAContext is like ConnectCRA, canceled with ctx.
+func ConnectCRAContext(ctx context.Context, url string, realm string, serializer serialize.Serialization,
+	authid string, authrole string, secret string, opts ConnectOptions, logger *logrus.Logger) (*client.Client,
+	error) {
+
+	return Connect(ctx, ClientConfig{
+		URL:           url,
+		Realm:         realm,
+		Serialization: serializer,
+		AuthMethod:    AuthWAMPCRA,
+		AuthID:        authid,
+		AuthRole:      authrole,
+		Secret:        secret,
+		Options:       opts,
+		Logger:        logger,
+	})
+}
+
+func ConnectCryptoSign(url string, realm string, serializer serialize.Serialization, authid string, authrole string,
+	privateKey string, opts ConnectOptions, logger *logrus.Logger) (*client.Client, error) {
+
+	return ConnectCryptoSignContext(context.Background(), url, realm, serializer, authid, authrole, privateKey, opts,
+		logger)
+}
 
-				// Compute derived key.
-				dk := pbkdf2.Key([]byte(secret), salt, int(iters), int(keylen), sha256.New)
-				// Get base64 bytes. see https://github.com/gammazero/nexus/issues/252
-				derivedKey := []byte(base64.StdEncoding.EncodeToString(dk))
+// ConnectCryptoSignContext is like ConnectCryptoSign, canceled with ctx.
+func ConnectCryptoSignContext(ctx context.Context, url string, realm string, serializer serialize.Serialization,
+	authid string, authrole string, privateKey string, opts ConnectOptions, logger *logrus.Logger) (*client.Client,
+	error) {
 
-				return crsign.SignChallenge(ch, derivedKey), wamp.Dict{}
-			},
-		},
+	return Connect(ctx, ClientConfig{
+		URL:           url,
+		Realm:         realm,
 		Serialization: serializer,
-	}
+		AuthMethod:    AuthCryptosign,
+		AuthID:        authid,
+		AuthRole:      authrole,
+		PrivateKey:    privateKey,
+		Options:       opts,
+		Logger:        logger,
+	})
+}
+
+// SubscribeOptions configure a subscription.
+type SubscribeOptions struct {
+	// Cryptobox decrypts end-to-end encrypted events, nil if not used.
+	Cryptobox *Cryptobox
+
+	// BinaryFormat is how binary payloads are printed, BinaryFormatBase64
+	// or BinaryFormatHex.
+	BinaryFormat string
+
+	// JSONStyle is how payloads are printed.
+	JSONStyle JSONStyle
+
+	// Metrics counts the events received, nil if not collected.
+	Metrics *Metrics
+
+	// Limit unsubscribes after this many events, zero never does.
+	Limit int
+
+	// Duration unsubscribes after this time, zero never does.
+	Duration time.Duration
+
+	// Exec is a shell command run for every event, with the event as JSON
+	// on stdin and WICK_TOPIC in the environment. Empty runs nothing.
+	Exec string
 
-	return connect(url, cfg, logger)
+	// Concurrency is how many Exec commands may run at once, at least 1.
+	// Events arriving while all are busy are dropped, or wait in the buffer
+	// if BufferSize is set.
+	Concurrency int
+
+	// Extract prints only a value of every event, the events without it
+	// are skipped. Nil prints whole events.
+	Extract *Extractor
+
+	// Expire unsubscribes once no event was received for this time, zero
+	// never does.
+	Expire time.Duration
+
+	// Extra are the options of the SUBSCRIBE message, nil sends none.
+	Extra wamp.Dict
+
+	// GetRetained asks the router for the retained event of the topic, if
+	// any, right after subscribing.
+	GetRetained bool
+
+	// BufferSize is how many events may wait to be printed and run by Exec,
+	// so that a slow output does not hold up the session. Zero handles
+	// every event before the next one is received.
+	BufferSize int
+
+	// Overflow is what happens to the events received while the buffer is
+	// full, OverflowBlock waits for room and OverflowDrop drops them.
+	Overflow string
+
+	// Dedupe skips the events whose DedupeKey was seen in an event handled
+	// within this time, zero handles duplicates too. Skipped events do not
+	// count towards the limit.
+	Dedupe time.Duration
+
+	// DedupeKey is the value that identifies an event for Dedupe, nil uses
+	// the whole payload.
+	DedupeKey *Extractor
+
+	// Stats prints, every this interval, the number and rate of events,
+	// their unique disclosed publishers and payload sizes instead of the
+	// events. Zero prints the events.
+	Stats time.Duration
+
+	// Record also writes the events to rotating files.
+	Record RecordOptions
+
+	// Output receives what is printed, nil prints to stdout.
+	Output io.Writer
 }
 
-func ConnectCryptoSign(url string, realm string, serializer serialize.Serialization, authid string, authrole string,
-	privateKey string, logger *log.Logger) *client.Client {
+// Subscribe prints the events of topic until CTRL-c, or until the limit or
+// duration of options is reached. If the duration passed without any event,
+// the returned error wraps context.DeadlineExceeded.
+func Subscribe(session *client.Client, logger *logrus.Logger, topic string, options SubscribeOptions) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return SubscribeContext(ctx, session, logger, topic, options)
+}
+
+// SubscribeContext is like Subscribe, until ctx is done instead of CTRL-c.
+func SubscribeContext(ctx context.Context, session *client.Client, logger *logrus.Logger, topic string,
+	options SubscribeOptions) error {
+
+	out := output(options.Output)
+	var received int64
+	limitReached := make(chan struct{})
+	idle := newIdleTimer(options.Expire)
+	defer idle.close()
 
-	helloDict := wamp.Dict{}
-	if authid != "" {
-		helloDict["authid"] = authid
+	var runner *eventRunner
+	if options.Exec != "" {
+		runner = newEventRunner(options.Exec, options.Concurrency, options.BufferSize > 0, options.Metrics, logger)
+		defer runner.wait()
 	}
 
-	if authrole != "" {
-		helloDict["authrole"] = authrole
+	dedupe := newDedupeWindow(options.Dedupe, options.DedupeKey)
+	recorder, err := newRecorder(topic, options.Record)
+	if err != nil {
+		return fmt.Errorf("cannot record events: %w", err)
+	}
+	defer func() {
+		if err := recorder.close(); err != nil {
+			logger.WithField("uri", topic).Error("Failed to close the recording: ", err)
+		}
+	}()
+	var stats *eventStats
+	if options.Stats > 0 {
+		stats = newEventStats(out)
+		ticker := time.NewTicker(options.Stats)
+		stopReports := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-ticker.C:
+					stats.report()
+				case <-stopReports:
+					return
+				}
+			}
+		}()
+		defer func() {
+			ticker.Stop()
+			close(stopReports)
+			stats.summary()
+		}()
 	}
 
-	privkey, _ := hex.DecodeString(privateKey)
-	var pvk ed25519.PrivateKey
+	// Define function to handle events received, they are handled one at a
+	// time.
+	eventHandler := func(event *wamp.Event) {
+		if options.Limit > 0 && atomic.LoadInt64(&received) >= int64(options.Limit) {
+			return
+		}
+		idle.touch()
+		options.Metrics.eventReceived(topic)
+		args, kwargs := event.Arguments, event.ArgumentsKw
+		if options.Cryptobox != nil {
+			var err error
+			if args, kwargs, _, err = options.Cryptobox.Open(topic, args, kwargs, event.Details); err != nil {
+				logger.WithField("uri", topic).Warn(err)
+			}
+		}
+		if dedupe.duplicate(args, kwargs) {
+			logger.WithField("uri", topic).Debug("duplicate event skipped")
+			return
+		}
+		count := atomic.AddInt64(&received, 1)
+		if err := recorder.record(event, args, kwargs); err != nil {
+			logger.WithField("uri", topic).Error("Failed to record event: ", err)
+		}
 
-	if len(privkey) == 32 {
-		pvk = ed25519.NewKeyFromSeed(privkey)
-	} else if len(privkey) == 64 {
-		pvk = ed25519.NewKeyFromSeed(privkey[:32])
-	} else {
-		logger.Fatal("Invalid private key. Cryptosign private key must be either 32 or 64 characters long")
-	}
-
-	key := pvk.Public().(ed25519.PublicKey)
-	publicKey := hex.EncodeToString(key)
-	helloDict["authextra"] = wamp.Dict{"pubkey": publicKey}
-
-	cfg := client.Config{
-		Realm:        realm,
-		Logger:       logger,
-		HelloDetails: helloDict,
-		AuthHandlers: map[string]client.AuthFunc{
-			"cryptosign": func(c *wamp.Challenge) (string, wamp.Dict) {
-				challengeHex, _ := wamp.AsString(c.Extra["challenge"])
-				challengeBytes, _ := hex.DecodeString(challengeHex)
-
-				signed := ed25519.Sign(pvk, challengeBytes)
-				signedHex := hex.EncodeToString(signed)
-				result := signedHex + challengeHex
-				return result, wamp.Dict{}
-			},
-		},
-		Serialization: serializer,
-	}
+		if stats != nil {
+			stats.record(event, args, kwargs)
+		} else if options.Extract != nil {
+			if err := options.Extract.print(out, args, kwargs, options.BinaryFormat, options.JSONStyle); err != nil {
+				logger.WithField("uri", topic).Debug("event skipped: ", err)
+			}
+		} else {
+			printIdentity(out, "publisher", event.Details)
+			argsKWArgs(out, args, kwargs, options.BinaryFormat, options.JSONStyle)
+		}
+		if runner != nil {
+			runner.run(topic, event, args, kwargs)
+		}
 
-	return connect(url, cfg, logger)
-}
+		if options.Limit > 0 && count == int64(options.Limit) {
+			close(limitReached)
+		}
+	}
 
-func Subscribe(session *client.Client, logger *log.Logger, topic string) {
-	// Define function to handle events received.
-	eventHandler := func(event *wamp.Event) {
-		argsKWArgs(event.Arguments, event.ArgumentsKw)
+	handler := eventHandler
+	queue := newEventQueue(options.BufferSize, options.Overflow, eventHandler, topic, options.Metrics, logger)
+	if queue != nil {
+		handler = queue.push
+		defer func() { queue.close(ctx.Err() == nil) }()
 	}
 
 	// Subscribe to topic.
-	err := session.Subscribe(topic, eventHandler, nil)
+	subscribeOptions := options.Extra
+	if options.GetRetained {
+		subscribeOptions = withOptions(options.Extra, wamp.Dict{"get_retained": true})
+	}
+	err = session.Subscribe(topic, handler, subscribeOptions)
 	if err != nil {
-		logger.Fatal("subscribe error:", err)
-	} else {
-		fmt.Printf("Subscribed to topic '%s'\n", topic)
+		return err
+	}
+	fmt.Fprintf(out, "Subscribed to topic '%s'\n", topic)
+
+	var timeout <-chan time.Time
+	if options.Duration > 0 {
+		timer := time.NewTimer(options.Duration)
+		defer timer.Stop()
+		timeout = timer.C
 	}
-	// Wait for CTRL-c or client close while handling events.
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt)
+
+	// Wait for the context or client close while handling events.
 	select {
-	case <-sigChan:
+	case <-ctx.Done():
+	case <-limitReached:
+	case <-idle.expired():
+		logger.WithField("uri", topic).Infof("No event for %s, unsubscribing", options.Expire)
+	case <-timeout:
+		if atomic.LoadInt64(&received) == 0 {
+			err = fmt.Errorf("no event received within %s: %w", options.Duration, context.DeadlineExceeded)
+		}
 	case <-session.Done():
-		logger.Print("Router gone, exiting")
-		return // router gone, just exit
+		logger.Info("Router gone, exiting")
+		options.Metrics.sessionLost()
+		return nil // router gone, just exit
 	}
 
 	// Unsubscribe from topic.
-	if err = session.Unsubscribe(topic); err != nil {
-		logger.Println("Failed to unsubscribe:", err)
+	if unsubscribeErr := session.Unsubscribe(topic); unsubscribeErr != nil {
+		logger.WithField("uri", topic).Error("Failed to unsubscribe: ", unsubscribeErr)
 	}
+
+	return err
 }
 
-func Publish(session *client.Client, logger *log.Logger, topic string, args []string, kwargs map[string]string) {
+// SubscribeChan subscribes to topic and returns its events, decrypted,
+// instead of printing them. The channel is closed, and topic unsubscribed,
+// once ctx is done, the limit of options is reached or the router is gone.
+// Only the Cryptobox, Metrics and Limit options apply. Events are not
+// dropped, a slow reader holds up the events of topic.
+func SubscribeChan(ctx context.Context, session *client.Client, logger *logrus.Logger, topic string,
+	options SubscribeOptions) (<-chan *wamp.Event, error) {
 
-	// Publish to topic.
-	options := wamp.Dict{wamp.OptAcknowledge: true}
-	err := session.Publish(topic, options, listToWampList(args), dictToWampDict(kwargs))
+	events := make(chan *wamp.Event, 64)
+	stop := make(chan struct{})
+	limitReached := make(chan struct{})
+	var lock sync.Mutex
+	var received int
+	closed := false
+
+	eventHandler := func(event *wamp.Event) {
+		options.Metrics.eventReceived(topic)
+		if options.Cryptobox != nil {
+			opened := *event
+			var err error
+			opened.Arguments, opened.ArgumentsKw, _, err = options.Cryptobox.Open(topic, event.Arguments,
+				event.ArgumentsKw, event.Details)
+			if err != nil {
+				logger.WithField("uri", topic).Warn(err)
+			}
+			event = &opened
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		if closed || (options.Limit > 0 && received >= options.Limit) {
+			return
+		}
+		select {
+		case events <- event:
+		case <-stop:
+			return
+		}
+		if received++; options.Limit > 0 && received == options.Limit {
+			close(limitReached)
+		}
+	}
+
+	if err := session.Subscribe(topic, eventHandler, options.Extra); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-limitReached:
+		case <-session.Done():
+			options.Metrics.sessionLost()
+		}
+		close(stop)
+		lock.Lock()
+		closed = true
+		close(events)
+		lock.Unlock()
+
+		select {
+		case <-session.Done():
+		default:
+			if err := session.Unsubscribe(topic); err != nil {
+				logger.WithField("uri", topic).Error("Failed to unsubscribe: ", err)
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// PublishOptions are the publish options with a dedicated command-line flag.
+type PublishOptions struct {
+	Acknowledge bool
+	ExcludeMe   bool
+	DiscloseMe  bool
+
+	// Retain asks the router to keep the event as the retained event of the
+	// topic, sent to later subscribers that ask for it.
+	Retain bool
+
+	// Eligible and Exclude are session IDs.
+	Eligible []uint64
+	Exclude  []uint64
+
+	EligibleAuthID   []string
+	ExcludeAuthID    []string
+	EligibleAuthRole []string
+	ExcludeAuthRole  []string
+
+	// BinaryArgs are sent as byte strings after the string arguments.
+	BinaryArgs [][]byte
+
+	// Payload is a generated argument sent after the binary arguments, nil
+	// if none.
+	Payload interface{}
+
+	// Cryptobox encrypts the event end-to-end, nil if not used.
+	Cryptobox *Cryptobox
+
+	// Tracing traces the publish, nil if not traced.
+	Tracing *Tracing
+
+	// Stats records how long acknowledged publishes took to be
+	// acknowledged, nil if not recorded.
+	Stats *Stats
+
+	// Schema validates the event before it is published, nil if not
+	// validated.
+	Schema *Schema
+
+	// RawKwargs sends every kwarg value as a string, otherwise the values
+	// that are JSON objects or arrays are sent decoded.
+	RawKwargs bool
+
+	// Tag prefixes every printed line, e.g. "[realm1] " when publishing to
+	// several realms.
+	Tag string
+
+	// Extra are more options sent as is, the ones above take precedence.
+	Extra wamp.Dict
+
+	// Output receives what is printed, nil prints to stdout.
+	Output io.Writer
+}
+
+// Dict returns the options as sent in the PUBLISH message, omitting the
+// ones the router applies by default.
+func (o PublishOptions) Dict() wamp.Dict {
+	options := wamp.Dict{}
+	if o.Acknowledge {
+		options[wamp.OptAcknowledge] = true
+	}
+	if !o.ExcludeMe {
+		options[wamp.OptExcludeMe] = false
+	}
+	if o.DiscloseMe {
+		options[wamp.OptDiscloseMe] = true
+	}
+	if o.Retain {
+		options["retain"] = true
+	}
+
+	ids := func(key string, values []uint64) {
+		if len(values) > 0 {
+			list := make(wamp.List, len(values))
+			for i, value := range values {
+				list[i] = wamp.ID(value)
+			}
+			options[key] = list
+		}
+	}
+	ids("eligible", o.Eligible)
+	ids("exclude", o.Exclude)
+
+	names := func(key string, values []string) {
+		if len(values) > 0 {
+			list := make(wamp.List, len(values))
+			for i, value := range values {
+				list[i] = value
+			}
+			options[key] = list
+		}
+	}
+	names("eligible_authid", o.EligibleAuthID)
+	names("exclude_authid", o.ExcludeAuthID)
+	names("eligible_authrole", o.EligibleAuthRole)
+	names("exclude_authrole", o.ExcludeAuthRole)
+
+	return withOptions(o.Extra, options)
+}
+
+func Publish(session *client.Client, logger *logrus.Logger, topic string, args []string,
+	kwargs map[string]string, options PublishOptions) error {
+
+	return PublishContext(context.Background(), session, logger, topic, args, kwargs, options)
+}
+
+// PublishContext is like Publish, canceled with ctx.
+func PublishContext(ctx context.Context, session *client.Client, logger *logrus.Logger, topic string,
+	args []string, kwargs map[string]string, options PublishOptions) error {
+
+	keywordArguments, err := dictToWampDict(kwargs, options.RawKwargs)
 	if err != nil {
-		logger.Fatal("Publish error:", err)
-	} else {
-		fmt.Printf("Published to topic '%s'\n", topic)
+		return err
 	}
+	arguments := append(listToWampList(args), binaryList(options.BinaryArgs)...)
+	if options.Payload != nil {
+		arguments = append(arguments, options.Payload)
+	}
+	if err := publishOnce(ctx, session, topic, arguments, keywordArguments, options); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(output(options.Output), "%sPublished to topic '%s'\n", options.Tag, topic)
+	logger.WithField("uri", topic).Debug("published")
+	return nil
+}
+
+// publishOnce publishes args and kwargs to topic, traced and encrypted as
+// options say.
+func publishOnce(ctx context.Context, session *client.Client, topic string, arguments wamp.List,
+	keywordArguments wamp.Dict, options PublishOptions) (err error) {
+
+	if err = options.Schema.Validate(arguments, keywordArguments); err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	ctx, span := options.Tracing.start(ctx, "publish "+topic, topic)
+	defer func() { endSpan(span, err) }()
+
+	if keywordArguments == nil {
+		keywordArguments = wamp.Dict{}
+	}
+	publishOptions := options.Dict()
+	options.Tracing.inject(ctx, keywordArguments, publishOptions)
+	if options.Cryptobox != nil {
+		if arguments, err = options.Cryptobox.Seal(topic, arguments, keywordArguments, publishOptions); err != nil {
+			return err
+		}
+		keywordArguments = nil
+	}
+
+	// Publish to topic.
+	start := time.Now()
+	if err = session.Publish(topic, publishOptions, arguments, keywordArguments); err != nil {
+		return err
+	}
+	if options.Acknowledge && options.Stats != nil {
+		options.Stats.RecordAck(time.Since(start))
+	}
+	return nil
+}
+
+// Invocation policies of shared registrations.
+const (
+	InvokeSingle     = "single"
+	InvokeRoundRobin = "roundrobin"
+	InvokeRandom     = "random"
+	InvokeFirst      = "first"
+	InvokeLast       = "last"
+)
+
+// RegisterOptions configure a registration.
+type RegisterOptions struct {
+	// Invoke is the invocation policy, the router default if empty. All
+	// sessions registering the same procedure must use the same policy.
+	Invoke string
+
+	// Cryptobox decrypts end-to-end encrypted invocations and encrypts their
+	// result, nil if not used.
+	Cryptobox *Cryptobox
+
+	// BinaryFormat is how binary payloads are printed, BinaryFormatBase64
+	// or BinaryFormatHex.
+	BinaryFormat string
+
+	// JSONStyle is how payloads are printed.
+	JSONStyle JSONStyle
+
+	// Metrics counts the invocations handled, nil if not collected.
+	Metrics *Metrics
+
+	// Schema validates invocations, the ones not matching it are answered
+	// with wamp.error.invalid_argument. Nil if not validated.
+	Schema *Schema
+
+	// MaxConcurrent is how many commands may run at once for the
+	// registration, the following invocations wait for a free slot. Zero
+	// runs every invocation at once.
+	MaxConcurrent int
+
+	// Timeout kills a command that ran longer and answers its invocation
+	// with wamp.error.timeout, the time spent queued for a slot does not
+	// count. Zero lets commands run forever.
+	Timeout time.Duration
+
+	// Expire unregisters once no invocation was handled for this time,
+	// zero never does.
+	Expire time.Duration
+
+	// DrainTimeout is how long commands in flight may still run once
+	// unregistered on CTRL-c or SIGTERM, before they are killed. Zero waits
+	// for them until a second signal, RegisterContext has none and kills
+	// them right away.
+	DrainTimeout time.Duration
+
+	// ForceReregister takes the procedure over from the session that has
+	// it registered, if the router supports force_reregister.
+	ForceReregister bool
+
+	// Revoked receives when the router revoked the registration, as told by
+	// ConnectOptions.Revoked. The invocations in flight finish and an error
+	// is returned. Nil is never revoked.
+	Revoked <-chan struct{}
+
+	// Extra are more options of the REGISTER message, Invoke takes
+	// precedence.
+	Extra wamp.Dict
+
+	// Script answers the invocations in process instead of the command,
+	// within Timeout too.
+	Script *Script
+
+	// Output receives what is printed, nil prints to stdout.
+	Output io.Writer
+}
+
+// Register runs command for every invocation of procedure until CTRL-c or
+// SIGTERM, then lets the invocations in flight finish as options say. A
+// second signal kills them.
+func Register(session *client.Client, logger *logrus.Logger, procedure string, command string,
+	options RegisterOptions) error {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	abort := make(chan struct{})
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-sigChan:
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case <-sigChan:
+			close(abort)
+		case <-done:
+		}
+	}()
+
+	return register(ctx, abort, session, logger, procedure, command, options)
+}
+
+// RegisterContext is like Register, until ctx is done instead of a signal.
+// As there is no second signal, a zero drain timeout of options kills the
+// invocations in flight at once.
+func RegisterContext(ctx context.Context, session *client.Client, logger *logrus.Logger, procedure string,
+	command string, options RegisterOptions) error {
+
+	return register(ctx, nil, session, logger, procedure, command, options)
 }
 
-func Register(session *client.Client, logger *log.Logger, procedure string, command string) {
+// register serves procedure until ctx is done, then drains the invocations
+// until the drain timeout of options or abort is closed.
+func register(ctx context.Context, abort <-chan struct{}, session *client.Client, logger *logrus.Logger,
+	procedure string, command string, options RegisterOptions) error {
+
+	out := output(options.Output)
+	var slots chan struct{}
+	if options.MaxConcurrent > 0 {
+		slots = make(chan struct{}, options.MaxConcurrent)
+	}
+	idle := newIdleTimer(options.Expire)
+	defer idle.close()
+	drain := newDrainGroup()
+
 	eventHandler := func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+		if !drain.enter() {
+			return client.InvokeResult{Err: "wick.error.shutting_down"}
+		}
+		defer drain.leave()
+		idle.begin()
+		defer idle.end()
 
-		argsKWArgs(inv.Arguments, inv.ArgumentsKw)
+		args, kwargs := inv.Arguments, inv.ArgumentsKw
+		encrypted := false
+		// The result is sealed for the procedure the caller called, which
+		// pattern-based registrations disclose.
+		invoked := procedure
+		if called, ok := wamp.AsString(inv.Details["procedure"]); ok {
+			invoked = called
+		}
+		if options.Cryptobox != nil {
+			var err error
+			if args, kwargs, encrypted, err = options.Cryptobox.Open(procedure, args, kwargs, inv.Details); err != nil {
+				logger.WithField("uri", procedure).Warn(err)
+			}
+		}
 
-		if command != "" {
-			err, out, _ := shellOut(command)
+		printIdentity(out, "caller", inv.Details)
+		argsKWArgs(out, args, kwargs, options.BinaryFormat, options.JSONStyle)
+
+		result := client.InvokeResult{Args: wamp.List{""}}
+		failed := false
+
+		if err := options.Schema.Validate(args, kwargs); err != nil {
+			logger.WithField("uri", procedure).Error("Rejected invocation: ", err)
+			options.Metrics.invocationHandled(procedure, true)
+			return client.InvokeResult{Err: wamp.ErrInvalidArgument, Args: wamp.List{err.Error()}}
+		}
+
+		if options.Script != nil {
+			scriptCtx, cancel := drain.context(ctx)
+			defer cancel()
+			if options.Timeout > 0 {
+				var cancel context.CancelFunc
+				scriptCtx, cancel = context.WithTimeout(scriptCtx, options.Timeout)
+				defer cancel()
+			}
+
+			start := time.Now()
+			result = options.Script.Invoke(scriptCtx, args, kwargs, inv.Details, logger)
+			fields := logrus.Fields{
+				"session_id":  session.ID(),
+				"uri":         procedure,
+				"duration_ms": time.Since(start).Milliseconds(),
+			}
+			if scriptCtx.Err() != nil && ctx.Err() == nil {
+				logger.WithFields(fields).Error("script stopped: ", scriptCtx.Err())
+				options.Metrics.invocationHandled(procedure, true)
+				if drain.killed() {
+					return client.InvokeResult{Err: "wick.error.shutting_down"}
+				}
+				return client.InvokeResult{Err: "wamp.error.timeout",
+					Args: wamp.List{fmt.Sprintf("script did not finish within %s", options.Timeout)}}
+			}
+			failed = result.Err != ""
+			logger.WithFields(fields).Debug("script finished")
+		} else if command != "" {
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+					defer func() { <-slots }()
+				case <-ctx.Done():
+					// Canceled or timed out by the caller while queued.
+					options.Metrics.invocationHandled(procedure, true)
+					return client.InvokeResult{Err: wamp.ErrCanceled}
+				}
+			}
+
+			commandCtx, cancel := drain.context(ctx)
+			defer cancel()
+			if options.Timeout > 0 {
+				var cancel context.CancelFunc
+				commandCtx, cancel = context.WithTimeout(commandCtx, options.Timeout)
+				defer cancel()
+			}
+
+			start := time.Now()
+			err, out, _ := shellOut(commandCtx, command)
+			fields := logrus.Fields{
+				"session_id":  session.ID(),
+				"uri":         procedure,
+				"duration_ms": time.Since(start).Milliseconds(),
+			}
+			if commandCtx.Err() != nil {
+				// The caller gave up, the drain timed out or the command ran
+				// out of time.
+				logger.WithFields(fields).Error("command killed: ", commandCtx.Err())
+				options.Metrics.invocationHandled(procedure, true)
+				if ctx.Err() != nil {
+					return client.InvokeResult{Err: wamp.ErrCanceled}
+				}
+				if drain.killed() {
+					return client.InvokeResult{Err: "wick.error.shutting_down"}
+				}
+				return client.InvokeResult{Err: "wamp.error.timeout",
+					Args: wamp.List{fmt.Sprintf("command did not finish within %s", options.Timeout)}}
+			}
 			if err != nil {
-				log.Println("error: ", err)
+				logger.WithFields(fields).Error("error: ", err)
+				failed = true
+			} else {
+				logger.WithFields(fields).Debug("command finished")
 			}
 
-			return client.InvokeResult{Args: wamp.List{out}}
+			result = client.InvokeResult{Args: wamp.List{out}}
+		}
+
+		if encrypted {
+			sealed, err := options.Cryptobox.Seal(invoked, result.Args, result.Kwargs, nil)
+			if err != nil {
+				logger.WithField("uri", procedure).Error("Failed to encrypt result: ", err)
+				result = client.InvokeResult{Err: "wick.error.encryption_failed"}
+				failed = true
+			} else {
+				result = client.InvokeResult{Args: sealed}
+			}
 		}
 
-		return client.InvokeResult{Args: wamp.List{""}}
+		options.Metrics.invocationHandled(procedure, failed)
+		return result
 	}
 
-	if err := session.Register(procedure, eventHandler, nil); err != nil {
-		logger.Fatal("Failed to register procedure:", err)
+	registerOptions := wamp.Dict{}
+	if options.Invoke != "" {
+		registerOptions[wamp.OptInvoke] = options.Invoke
+	}
+	if options.ForceReregister {
+		registerOptions["force_reregister"] = true
+	}
+	registerOptions = withOptions(options.Extra, registerOptions)
+	if err := session.Register(procedure, eventHandler, registerOptions); err != nil {
+		// nexus only tells register errors by their message.
+		if options.ForceReregister && strings.Contains(err.Error(), string(wamp.ErrProcedureAlreadyExists)) {
+			return fmt.Errorf("%w (the router does not support force_reregister)", err)
+		}
+		return err
+	}
+	if options.ForceReregister {
+		fmt.Fprintf(out, "Registered procedure '%s', taken over from any other callee\n", procedure)
 	} else {
-		fmt.Printf("Registered procedure '%s'\n", procedure)
+		fmt.Fprintf(out, "Registered procedure '%s'\n", procedure)
 	}
 
-	// Wait for CTRL-c or client close while handling remote procedure calls.
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt)
+	// Wait for the context or client close while handling remote procedure
+	// calls.
 	select {
-	case <-sigChan:
+	case <-ctx.Done():
+	case <-idle.expired():
+		logger.WithField("uri", procedure).Infof("No invocation for %s, unregistering", options.Expire)
+	case <-options.Revoked:
+		logger.WithField("uri", procedure).Warn("Registration taken over by another callee")
+		if !drain.drain(options.DrainTimeout, abort) {
+			logger.WithField("uri", procedure).Warn("Killed the invocations still running")
+		}
+		return fmt.Errorf("registration of %s revoked by the router", procedure)
 	case <-session.Done():
-		logger.Print("Router gone, exiting")
-		return // router gone, just exit
+		logger.Info("Router gone, exiting")
+		options.Metrics.sessionLost()
+		return nil // router gone, just exit
 	}
 
 	if err := session.Unregister(procedure); err != nil {
-		logger.Println("Failed to unregister procedure:", err)
+		logger.WithField("uri", procedure).Error("Failed to unregister procedure: ", err)
+	}
+	logger.WithField("uri", procedure).Info("Unregistered procedure with router")
+
+	// Let the invocations in flight finish, unless aborted.
+	if !drain.drain(options.DrainTimeout, abort) {
+		logger.WithField("uri", procedure).Warn("Killed the invocations still running")
+	}
+	return nil
+}
+
+// CallOptions configure a call.
+type CallOptions struct {
+	// Timeout cancels the call if no result arrived in time, zero waits
+	// forever.
+	Timeout    time.Duration
+	DiscloseMe bool
+
+	// DealerTimeout is the timeout option of the CALL, for the router to
+	// cancel the call if no result arrived in time, in milliseconds on the
+	// wire. Zero sends none.
+	DealerTimeout time.Duration
+
+	// BinaryArgs are sent as byte strings after the string arguments.
+	BinaryArgs [][]byte
+
+	// Payload is a generated argument sent after the binary arguments, nil
+	// if none.
+	Payload interface{}
+
+	// Cryptobox encrypts the call end-to-end and decrypts its result, nil
+	// if not used.
+	Cryptobox *Cryptobox
+
+	// BinaryFormat is how binary results are printed, BinaryFormatBase64
+	// or BinaryFormatHex.
+	BinaryFormat string
+
+	// JSONStyle is how results are printed.
+	JSONStyle JSONStyle
+
+	// Metrics records the call latency, nil if not collected.
+	Metrics *Metrics
+
+	// Tracing traces the call, nil if not traced.
+	Tracing *Tracing
+
+	// Schema validates the arguments before they are sent, nil if not
+	// validated.
+	Schema *Schema
+
+	// RawKwargs sends every kwarg value as a string, otherwise the values
+	// that are JSON objects or arrays are sent decoded.
+	RawKwargs bool
+
+	// Extract prints only a value of the result, nil prints the first
+	// result argument.
+	Extract *Extractor
+
+	// Retry is how many times a failed call is tried again, if it failed
+	// with one of the RetryOn error URIs, or any WAMP error if RetryOn is
+	// empty. A call that timed out counts as wamp.error.timeout.
+	Retry   int
+	RetryOn []string
+
+	// RetryBackoff is the time before the first retry, doubled for every
+	// following one.
+	RetryBackoff time.Duration
+
+	// RunMode is how a sharded registration is called, RunModePartition
+	// for the callee of the shard of RKey or RunModeAll for all of them.
+	// Empty uses RunModePartition if RKey is set.
+	RunMode string
+
+	// RKey is the routing key that selects the shard of RunModePartition.
+	RKey string
+
+	// Tag prefixes every printed line, e.g. "[realm1] " when calling on
+	// several realms.
+	Tag string
+
+	// Extra are more options sent as is, the ones above take precedence.
+	Extra wamp.Dict
+
+	// Format is OutputText to print the first result argument, or
+	// OutputJSON to print the args and kwargs of the result as one JSON
+	// object, or the WAMP error under its "error" key.
+	Format string
+
+	// Output receives what is printed, nil prints to stdout.
+	Output io.Writer
+
+	// ErrorOutput receives the details of WAMP errors in OutputText, nil
+	// prints to stderr.
+	ErrorOutput io.Writer
+}
+
+// The formats of call results.
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// The run modes of calls to sharded registrations.
+const (
+	RunModePartition = "partition"
+	RunModeAll       = "all"
+)
+
+// CheckRunMode returns an error if runmode and rkey do not go together, a
+// partitioned call needs a routing key and a call to all shards has none.
+func CheckRunMode(runmode string, rkey string) error {
+	switch runmode {
+	case "":
+	case RunModePartition:
+		if rkey == "" {
+			return errors.New("runmode partition needs a routing key")
+		}
+	case RunModeAll:
+		if rkey != "" {
+			return errors.New("runmode all calls every shard, it takes no routing key")
+		}
+	default:
+		return fmt.Errorf("unknown runmode %q, expected %s or %s", runmode, RunModePartition, RunModeAll)
+	}
+	return nil
+}
+
+// Dict returns the options as sent in the CALL message.
+func (o CallOptions) Dict() wamp.Dict {
+	options := wamp.Dict{}
+	if o.DiscloseMe {
+		options[wamp.OptDiscloseMe] = true
+	}
+	if o.DealerTimeout > 0 {
+		options[wamp.OptTimeout] = o.DealerTimeout.Milliseconds()
 	}
+	if o.RKey != "" {
+		options["rkey"] = o.RKey
+		options["runmode"] = RunModePartition
+	}
+	if o.RunMode != "" {
+		options["runmode"] = o.RunMode
+	}
+	return withOptions(o.Extra, options)
+}
+
+// Call calls procedure and prints its result. The call is canceled on CTRL-c.
+func Call(session *client.Client, logger *logrus.Logger, procedure string, args []string, kwargs map[string]string,
+	options CallOptions) error {
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return CallContext(ctx, session, logger, procedure, args, kwargs, options)
+}
+
+// CallContext is like Call, canceled with ctx.
+func CallContext(ctx context.Context, session *client.Client, logger *logrus.Logger, procedure string,
+	args []string, kwargs map[string]string, options CallOptions) error {
+
+	resultArgs, resultKwargs, err := callOnce(ctx, session, logger, procedure, args, kwargs, options)
+	if err != nil {
+		printCallError(err, options)
+		return err
+	}
+	return printCallResult(resultArgs, resultKwargs, options)
+}
 
-	logger.Println("Registered procedure with router")
+// callOnce is like CallResult, for the callers that only need the payload.
+func callOnce(ctx context.Context, session *client.Client, logger *logrus.Logger, procedure string, args []string,
+	kwargs map[string]string, options CallOptions) (wamp.List, wamp.Dict, error) {
 
+	result, err := CallResult(ctx, session, logger, procedure, args, kwargs, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.Arguments, result.ArgumentsKw, nil
 }
 
-func Call(session *client.Client, logger *log.Logger, procedure string, args []string, kwargs map[string]string) {
-	ctx := context.Background()
+// CallResult calls procedure, retried and within options.Timeout per
+// attempt as set, and returns its result decrypted instead of printing it.
+// The printing options are ignored.
+func CallResult(ctx context.Context, session *client.Client, logger *logrus.Logger, procedure string,
+	args []string, kwargs map[string]string, options CallOptions) (result *wamp.Result, err error) {
+
+	ctx, span := options.Tracing.start(ctx, "call "+procedure, procedure)
+	defer func() { endSpan(span, err) }()
 
-	result, err := session.Call(ctx, procedure, nil, listToWampList(args), dictToWampDict(kwargs), nil)
+	callOptions := options.Dict()
+	keywordArguments, err := dictToWampDict(kwargs, options.RawKwargs)
 	if err != nil {
-		logger.Println("Failed to call ", err)
-	} else if result != nil {
-		jsonString, err := json.MarshalIndent(result.Arguments[0], "", "    ")
+		return nil, err
+	}
+	arguments := append(listToWampList(args), binaryList(options.BinaryArgs)...)
+	if options.Payload != nil {
+		arguments = append(arguments, options.Payload)
+	}
+	if err = options.Schema.Validate(arguments, keywordArguments); err != nil {
+		return nil, err
+	}
+	options.Tracing.inject(ctx, keywordArguments, callOptions)
+	if options.Cryptobox != nil {
+		if arguments, err = options.Cryptobox.Seal(procedure, arguments, keywordArguments, callOptions); err != nil {
+			return nil, err
+		}
+		keywordArguments = nil
+	}
+
+	backoff := options.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		result, err = callAttempt(ctx, session, logger, procedure, callOptions, arguments, keywordArguments,
+			options)
+		if err == nil || attempt > options.Retry || ctx.Err() != nil || !options.retryable(err) {
+			break
+		}
+
+		logger.WithFields(logrus.Fields{"uri": procedure, "attempt": attempt}).
+			Infof("Call failed, retrying in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Cryptobox != nil {
+		opened := *result
+		opened.Arguments, opened.ArgumentsKw, _, err = options.Cryptobox.Open(procedure, result.Arguments,
+			result.ArgumentsKw, result.Details)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		fmt.Println(string(jsonString))
+		result = &opened
+	}
+	return result, nil
+}
+
+// callAttempt sends the call once, within options.Timeout if set.
+func callAttempt(ctx context.Context, session *client.Client, logger *logrus.Logger, procedure string,
+	callOptions wamp.Dict, arguments wamp.List, keywordArguments wamp.Dict, options CallOptions) (*wamp.Result,
+	error) {
+
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	result, err := session.Call(ctx, procedure, callOptions, arguments, keywordArguments, nil)
+	options.Metrics.callDone(procedure, time.Since(start), err != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"session_id":  session.ID(),
+		"uri":         procedure,
+		"duration_ms": time.Since(start).Milliseconds(),
+	}).Debug("call succeeded")
+	return result, nil
+}
+
+// retryable reports whether a call that failed with err may be retried.
+func (o CallOptions) retryable(err error) bool {
+	uri := ErrorURI(err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		uri = "wamp.error.timeout"
+	}
+	if uri == "" {
+		return false
+	}
+	if len(o.RetryOn) == 0 {
+		return true
+	}
+	for _, retryOn := range o.RetryOn {
+		if uri == retryOn {
+			return true
+		}
+	}
+	return false
+}
+
+// printCallResult prints the result of a call as options say, every line
+// prefixed with options.Tag.
+func printCallResult(resultArgs wamp.List, resultKwargs wamp.Dict, options CallOptions) error {
+	var text string
+	var err error
+	style := options.JSONStyle
+	if options.Extract != nil {
+		text, err = options.Extract.format(resultArgs, resultKwargs, options.BinaryFormat, style)
+	} else if options.Format == OutputJSON {
+		args := make(wamp.List, len(resultArgs))
+		for i, arg := range resultArgs {
+			args[i] = displayed(arg, options.BinaryFormat, style)
+		}
+		kwargs := make(wamp.Dict, len(resultKwargs))
+		for key, value := range resultKwargs {
+			kwargs[key] = displayed(value, options.BinaryFormat, style)
+		}
+		text, err = style.format(wamp.Dict{"args": args, "kwargs": kwargs})
+	} else if style.Display.itemized() && (len(resultArgs) > 0 || len(resultKwargs) > 0) {
+		var items strings.Builder
+		printItems(&items, resultArgs, resultKwargs, options.BinaryFormat, style)
+		text = strings.TrimSuffix(items.String(), "\n")
+	} else if len(resultArgs) > 0 {
+		text, err = formatResult(resultArgs, options.BinaryFormat, style)
+	} else {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if options.Tag != "" {
+		text = options.Tag + strings.ReplaceAll(text, "\n", "\n"+options.Tag)
+	}
+	fmt.Fprintln(output(options.Output), text)
+	return nil
+}
+
+// printCallError prints the URI, args and kwargs of the WAMP error of a
+// failed call, if err is one, under the "error" key of the result in
+// OutputJSON. Otherwise they are printed to options.ErrorOutput, unless the
+// error has neither args nor kwargs and the logged error says it all.
+func printCallError(err error, options CallOptions) {
+	details := errorDetails(err, options.BinaryFormat)
+	if details == nil {
+		return
 	}
+
+	out := options.ErrorOutput
+	if out == nil {
+		out = os.Stderr
+	}
+	var value interface{} = details
+	if options.Format == OutputJSON {
+		out, value = output(options.Output), wamp.Dict{"error": details}
+	} else if len(details["args"].(wamp.List)) == 0 && len(details["kwargs"].(wamp.Dict)) == 0 {
+		return
+	}
+	text, err := options.JSONStyle.format(value)
+	if err != nil {
+		return
+	}
+	if options.Tag != "" {
+		text = options.Tag + strings.ReplaceAll(text, "\n", "\n"+options.Tag)
+	}
+	fmt.Fprintln(out, text)
+}
+
+// formatResult returns the first result argument as JSON in style, cut as
+// style.Display says.
+func formatResult(resultArgs wamp.List, binaryFormat string, style JSONStyle) (string, error) {
+	if len(resultArgs) == 0 {
+		return "", nil
+	}
+	return style.format(displayed(resultArgs[0], binaryFormat, style))
 }
 
 func listToWampList(args []string) wamp.List {
@@ -325,46 +1276,164 @@ func listToWampList(args []string) wamp.List {
 	return arguments
 }
 
-func dictToWampDict(kwargs map[string]string) wamp.Dict {
+// dictToWampDict returns kwargs as a WAMP dict. Unless raw, the values that
+// are JSON objects or arrays are decoded, the others are kept as strings.
+func dictToWampDict(kwargs map[string]string, raw bool) (wamp.Dict, error) {
 	var keywordArguments wamp.Dict = make(map[string]interface{})
 	for key, value := range kwargs {
-		keywordArguments[key] = value
+		if raw {
+			keywordArguments[key] = value
+			continue
+		}
+
+		decoded, err := structuredValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON value of kwarg %s: %w", key, err)
+		}
+		keywordArguments[key] = decoded
+	}
+	return keywordArguments, nil
+}
+
+// structuredValue returns value decoded if it is a JSON object or array,
+// as is otherwise.
+func structuredValue(value string) (interface{}, error) {
+	trimmed := strings.TrimSpace(value)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return value, nil
 	}
-	return keywordArguments
+	return decodeJSONValue(trimmed)
 }
 
-func argsKWArgs(args wamp.List, kwArgs wamp.Dict) {
+// decodeJSONValue decodes a single JSON value, with integers as int64 and
+// other numbers as float64.
+func decodeJSONValue(text string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the value")
+	}
+	return jsonNumbers(value), nil
+}
+
+// jsonNumbers replaces the json.Number values in value.
+func jsonNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonNumbers(v[i])
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = jsonNumbers(v[key])
+		}
+	}
+	return value
+}
+
+// output returns w, or stdout if w is nil.
+func output(w io.Writer) io.Writer {
+	if w == nil {
+		return os.Stdout
+	}
+	return w
+}
+
+// printIdentity prints the caller or publisher identity that the router
+// disclosed in details, if any.
+func printIdentity(out io.Writer, role string, details wamp.Dict) {
+	id, ok := details[role]
+	if !ok {
+		return
+	}
+
+	identity := fmt.Sprintf("%s: %v", role, id)
+	var extra []string
+	if authid, ok := wamp.AsString(details[role+"_authid"]); ok {
+		extra = append(extra, "authid="+authid)
+	}
+	if authrole, ok := wamp.AsString(details[role+"_authrole"]); ok {
+		extra = append(extra, "authrole="+authrole)
+	}
+	if len(extra) > 0 {
+		identity += " (" + strings.Join(extra, ", ") + ")"
+	}
+	fmt.Fprintln(out, identity)
+}
+
+func argsKWArgs(out io.Writer, args wamp.List, kwArgs wamp.Dict, binaryFormat string, style JSONStyle) {
+	switch style.Display.Mode {
+	case DisplaySizeOnly, DisplayHexdump:
+		if len(args) != 0 || len(kwArgs) != 0 {
+			printItems(out, args, kwArgs, binaryFormat, style)
+			return
+		}
+	}
+	shown := func(value interface{}) interface{} {
+		value = printable(value, binaryFormat)
+		if style.Display.Mode == DisplayTruncate {
+			value = truncated(value, style.Display.Length)
+		}
+		return value
+	}
+
 	if len(args) != 0 {
-		fmt.Println("args:")
-		jsonString, err := json.MarshalIndent(args, "", "    ")
+		fmt.Fprintln(out, "args:")
+		jsonString, err := style.format(shown(args))
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Println(string(jsonString))
+		fmt.Fprintln(out, jsonString)
 	}
 
 	if len(kwArgs) != 0 {
-		fmt.Println("kwargs:")
-		jsonString, err := json.MarshalIndent(kwArgs, "", "    ")
+		fmt.Fprintln(out, "kwargs:")
+		jsonString, err := style.format(shown(kwArgs))
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Println(string(jsonString))
+		fmt.Fprintln(out, jsonString)
 	}
 
 	if len(args) == 0 && len(kwArgs) == 0 {
-		fmt.Println("args: []")
-		fmt.Println("kwargs: {}")
+		fmt.Fprintln(out, "args: []")
+		fmt.Fprintln(out, "kwargs: {}")
 	}
 }
 
-func shellOut(command string) (error, string, string) {
+// shellOut runs command with bash and returns its output. The command and
+// the processes it started are killed once ctx is done.
+func shellOut(ctx context.Context, command string) (error, string, string) {
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 	var cmd *exec.Cmd
 	cmd = exec.Command("bash", "-c", command)
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	err := cmd.Run()
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err, "", ""
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-done
+		err = ctx.Err()
+	}
 	return err, stdout.String(), stderr.String()
 }
@@ -0,0 +1,147 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// Metrics are the Prometheus metrics of long-running sessions. A nil
+// *Metrics records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	eventsReceived     *prometheus.CounterVec
+	eventsDropped      *prometheus.CounterVec
+	invocationsHandled *prometheus.CounterVec
+	callDuration       *prometheus.HistogramVec
+	sessionsJoined     prometheus.Counter
+	sessionsLost       prometheus.Counter
+}
+
+// NewMetrics creates the metrics, registered in their own registry.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		eventsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wick_events_received_total",
+			Help: "Events received by subscriptions.",
+		}, []string{"topic"}),
+		eventsDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wick_events_dropped_total",
+			Help: "Events dropped by subscriptions whose queue was full.",
+		}, []string{"topic"}),
+		invocationsHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wick_invocations_handled_total",
+			Help: "Invocations handled by registrations.",
+		}, []string{"procedure", "result"}),
+		callDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wick_call_duration_seconds",
+			Help:    "Latency of calls, from CALL to RESULT or ERROR.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 16),
+		}, []string{"procedure", "result"}),
+		sessionsJoined: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "wick_sessions_joined_total",
+			Help: "Sessions that joined a realm.",
+		}),
+		sessionsLost: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "wick_sessions_lost_total",
+			Help: "Sessions closed by the router or the network.",
+		}),
+	}
+	m.registry.MustRegister(m.eventsReceived, m.eventsDropped, m.invocationsHandled, m.callDuration, m.sessionsJoined,
+		m.sessionsLost)
+	return m
+}
+
+// ServeMetrics exposes m on http://addr/metrics until the returned closer is
+// closed.
+func ServeMetrics(addr string, m *Metrics, logger *logrus.Logger) (io.Closer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Metrics server failed: ", err)
+		}
+	}()
+
+	logger.Debugf("serving metrics on http://%s/metrics", listener.Addr())
+	return server, nil
+}
+
+func (m *Metrics) eventReceived(topic string) {
+	if m != nil {
+		m.eventsReceived.WithLabelValues(topic).Inc()
+	}
+}
+
+func (m *Metrics) eventDropped(topic string) {
+	if m != nil {
+		m.eventsDropped.WithLabelValues(topic).Inc()
+	}
+}
+
+func (m *Metrics) invocationHandled(procedure string, failed bool) {
+	if m != nil {
+		m.invocationsHandled.WithLabelValues(procedure, resultLabel(failed)).Inc()
+	}
+}
+
+func (m *Metrics) callDone(procedure string, duration time.Duration, failed bool) {
+	if m != nil {
+		m.callDuration.WithLabelValues(procedure, resultLabel(failed)).Observe(duration.Seconds())
+	}
+}
+
+func (m *Metrics) sessionJoined() {
+	if m != nil {
+		m.sessionsJoined.Inc()
+	}
+}
+
+func (m *Metrics) sessionLost() {
+	if m != nil {
+		m.sessionsLost.Inc()
+	}
+}
+
+func resultLabel(failed bool) string {
+	if failed {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,235 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Mock is a set of procedures answering with canned responses, for clients
+// to be developed against a fake backend.
+type Mock struct {
+	Procedures []MockProcedure `yaml:"procedures"`
+}
+
+// MockProcedure is a procedure of a mock and the responses of its calls in
+// order. Once they are used up the last one answers every later call,
+// unless Cycle starts over with the first.
+type MockProcedure struct {
+	Procedure string         `yaml:"procedure"`
+	Responses []MockResponse `yaml:"responses"`
+	Cycle     bool           `yaml:"cycle"`
+
+	// Latency delays every response, before its own latency.
+	Latency time.Duration `yaml:"latency"`
+}
+
+// MockResponse answers a call with a result or, if Error is set, a WAMP
+// error, after the progressive results of Progress if the caller asked for
+// them.
+type MockResponse struct {
+	Args   []interface{}          `yaml:"args"`
+	Kwargs map[string]interface{} `yaml:"kwargs"`
+	Error  string                 `yaml:"error"`
+
+	// Progress are sent one after the other, each after its latency.
+	Progress []MockChunk `yaml:"progress"`
+
+	// Latency delays the response, after the progressive results.
+	Latency time.Duration `yaml:"latency"`
+
+	// Times is how many calls in a row the response answers, 1 if zero.
+	Times int `yaml:"times"`
+}
+
+// MockChunk is a progressive result of a response.
+type MockChunk struct {
+	Args    []interface{}          `yaml:"args"`
+	Kwargs  map[string]interface{} `yaml:"kwargs"`
+	Latency time.Duration          `yaml:"latency"`
+}
+
+// LoadMock reads and checks a mock file.
+func LoadMock(path string) (*Mock, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mock := &Mock{}
+	if err = yaml.Unmarshal(data, mock); err != nil {
+		return nil, fmt.Errorf("invalid mock %s: %w", path, err)
+	}
+	if len(mock.Procedures) == 0 {
+		return nil, fmt.Errorf("invalid mock %s: no procedures", path)
+	}
+	for i, procedure := range mock.Procedures {
+		if procedure.Procedure == "" {
+			return nil, fmt.Errorf("invalid mock %s: procedure %d has no name", path, i+1)
+		}
+		if len(procedure.Responses) == 0 {
+			return nil, fmt.Errorf("invalid mock %s: %s has no responses", path, procedure.Procedure)
+		}
+		for j, response := range procedure.Responses {
+			if response.Times < 0 {
+				return nil, fmt.Errorf("invalid mock %s: response %d of %s answers %d times", path, j+1,
+					procedure.Procedure, response.Times)
+			}
+		}
+	}
+	return mock, nil
+}
+
+// mockSequence picks the responses of the calls to a procedure in order.
+type mockSequence struct {
+	lock      sync.Mutex
+	procedure MockProcedure
+	calls     int
+}
+
+// next returns the number of the call and the index of its response.
+func (s *mockSequence) next() (int, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.calls++
+
+	total := 0
+	for _, response := range s.procedure.Responses {
+		total += mockTimes(response)
+	}
+	n := s.calls - 1
+	if n >= total {
+		if !s.procedure.Cycle {
+			return s.calls, len(s.procedure.Responses) - 1
+		}
+		n %= total
+	}
+	for i, response := range s.procedure.Responses {
+		if n < mockTimes(response) {
+			return s.calls, i
+		}
+		n -= mockTimes(response)
+	}
+	return s.calls, len(s.procedure.Responses) - 1
+}
+
+// mockTimes returns how many calls in a row response answers.
+func mockTimes(response MockResponse) int {
+	if response.Times == 0 {
+		return 1
+	}
+	return response.Times
+}
+
+// RunMock registers the procedures of mock on session and answers their
+// calls with its responses, until CTRL-c or the session is closed.
+func RunMock(session *client.Client, mock *Mock, out io.Writer, logger *logrus.Logger) error {
+	out = output(out)
+	for _, procedure := range mock.Procedures {
+		sequence := &mockSequence{procedure: procedure}
+		handler := func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+			return sequence.answer(ctx, session, inv, logger)
+		}
+		if err := session.Register(procedure.Procedure, handler, nil); err != nil {
+			return fmt.Errorf("register %s: %w", procedure.Procedure, err)
+		}
+		fmt.Fprintf(out, "Mocking procedure '%s' with %d responses\n", procedure.Procedure,
+			len(procedure.Responses))
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	defer signal.Stop(sigChan)
+	select {
+	case <-sigChan:
+	case <-session.Done():
+		return errors.New("router gone")
+	}
+
+	for _, procedure := range mock.Procedures {
+		if err := session.Unregister(procedure.Procedure); err != nil {
+			logger.WithField("uri", procedure.Procedure).Error("Failed to unregister: ", err)
+		}
+	}
+	return nil
+}
+
+// answer answers an invocation with the next response of the sequence.
+func (s *mockSequence) answer(ctx context.Context, session *client.Client, inv *wamp.Invocation,
+	logger *logrus.Logger) client.InvokeResult {
+
+	call, index := s.next()
+	response := s.procedure.Responses[index]
+	fields := logrus.Fields{"uri": s.procedure.Procedure, "call": call, "response": index + 1}
+
+	if !mockDelay(ctx, s.procedure.Latency) {
+		return client.InvokeResult{Err: wamp.ErrCanceled}
+	}
+	if wantProgress, _ := inv.Details[wamp.OptReceiveProgress].(bool); wantProgress {
+		for _, chunk := range response.Progress {
+			if !mockDelay(ctx, chunk.Latency) {
+				return client.InvokeResult{Err: wamp.ErrCanceled}
+			}
+			if err := session.SendProgress(ctx, chunk.Args, chunk.Kwargs); err != nil {
+				logger.WithFields(fields).Debug("Failed to send progressive result: ", err)
+			}
+		}
+	}
+	if !mockDelay(ctx, response.Latency) {
+		return client.InvokeResult{Err: wamp.ErrCanceled}
+	}
+
+	if response.Error != "" {
+		logger.WithFields(fields).Info("answered with ", response.Error)
+		return client.InvokeResult{Err: wamp.URI(response.Error), Args: response.Args, Kwargs: response.Kwargs}
+	}
+	logger.WithFields(fields).Info("answered")
+	return client.InvokeResult{Args: response.Args, Kwargs: response.Kwargs}
+}
+
+// mockDelay waits for latency, and tells whether ctx was not done before.
+func mockDelay(ctx context.Context, latency time.Duration) bool {
+	if latency <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
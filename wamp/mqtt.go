@@ -0,0 +1,278 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+	"github.com/ugorji/go/codec"
+	"gopkg.in/yaml.v3"
+)
+
+// Directions of MQTT mappings.
+const (
+	MQTTBoth     = "both"
+	MQTTToMQTT   = "to-mqtt"
+	MQTTFromMQTT = "from-mqtt"
+)
+
+// MQTTBridgeConfig is the mapping file of the MQTT bridge.
+type MQTTBridgeConfig struct {
+	Broker   string `yaml:"broker"`
+	ClientID string `yaml:"client-id"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	Mappings []MQTTMapping `yaml:"mappings"`
+}
+
+// MQTTMapping maps a WAMP topic to an MQTT topic.
+type MQTTMapping struct {
+	WAMP string `yaml:"wamp"`
+	MQTT string `yaml:"mqtt"`
+	// Direction is MQTTBoth, MQTTToMQTT or MQTTFromMQTT, both if empty.
+	Direction string `yaml:"direction"`
+	// QoS is the MQTT quality of service of publishes and subscriptions.
+	QoS byte `yaml:"qos"`
+}
+
+// LoadMQTTBridgeConfig reads and validates a mapping file.
+func LoadMQTTBridgeConfig(path string) (*MQTTBridgeConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &MQTTBridgeConfig{}
+	if err = yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid mapping file %s: %w", path, err)
+	}
+	if config.Broker == "" {
+		return nil, errors.New("the mapping file has no broker")
+	}
+	if len(config.Mappings) == 0 {
+		return nil, errors.New("the mapping file has no mappings")
+	}
+	for i, mapping := range config.Mappings {
+		if mapping.WAMP == "" || mapping.MQTT == "" {
+			return nil, fmt.Errorf("mapping %d: both wamp and mqtt topics are required", i+1)
+		}
+		switch mapping.Direction {
+		case "":
+			config.Mappings[i].Direction = MQTTBoth
+		case MQTTBoth, MQTTToMQTT, MQTTFromMQTT:
+		default:
+			return nil, fmt.Errorf("mapping %d: invalid direction %q", i+1, mapping.Direction)
+		}
+		if mapping.QoS > 2 {
+			return nil, fmt.Errorf("mapping %d: invalid qos %d", i+1, mapping.QoS)
+		}
+	}
+	if config.ClientID == "" {
+		config.ClientID = fmt.Sprintf("wick-%d", os.Getpid())
+	}
+	return config, nil
+}
+
+// mqttBridge forwards events between a WAMP session and an MQTT client.
+type mqttBridge struct {
+	session    *client.Client
+	broker     mqtt.Client
+	serializer serialize.Serialization
+	logger     *logrus.Logger
+
+	// echoes counts the payloads sent to MQTT topics that are also mapped
+	// back to WAMP, as the broker delivers them to the bridge itself.
+	lock   sync.Mutex
+	echoes map[[sha256.Size]byte]int
+}
+
+// MQTTBridge forwards the events of the mapped topics between session and
+// the MQTT broker of config, until CTRL-c. MQTT payloads hold the args and
+// kwargs of WAMP events, as a {"args": [...], "kwargs": {...}} map encoded
+// with serializer. JSON messages from MQTT may also be a bare array, object
+// or value, as with publish --from-stdin.
+func MQTTBridge(session *client.Client, logger *logrus.Logger, config *MQTTBridgeConfig,
+	serializer serialize.Serialization) error {
+
+	options := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetAutoReconnect(true)
+	b := &mqttBridge{
+		session:    session,
+		broker:     mqtt.NewClient(options),
+		serializer: serializer,
+		logger:     logger,
+		echoes:     map[[sha256.Size]byte]int{},
+	}
+
+	if token := b.broker.Connect(); token.Wait() && token.Error() != nil {
+		return &ConnectError{URL: config.Broker, Err: token.Error()}
+	}
+	defer b.broker.Disconnect(250)
+
+	for _, mapping := range config.Mappings {
+		if mapping.Direction != MQTTFromMQTT {
+			if err := b.toMQTT(mapping); err != nil {
+				return err
+			}
+		}
+		if mapping.Direction != MQTTToMQTT {
+			if err := b.fromMQTT(mapping); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Printf("Bridging %d topics with %s\n", len(config.Mappings), config.Broker)
+
+	// Wait for CTRL-c or client close while forwarding events.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	select {
+	case <-sigChan:
+		return nil
+	case <-session.Done():
+		return errors.New("router gone")
+	}
+}
+
+func (b *mqttBridge) toMQTT(mapping MQTTMapping) error {
+	echoed := mapping.Direction == MQTTBoth
+	eventHandler := func(event *wamp.Event) {
+		fields := logrus.Fields{"uri": mapping.WAMP, "mqtt_topic": mapping.MQTT}
+		payload, err := b.encode(event.Arguments, event.ArgumentsKw)
+		if err != nil {
+			b.logger.WithFields(fields).Error("Failed to encode event: ", err)
+			return
+		}
+
+		if echoed {
+			b.lock.Lock()
+			b.echoes[sha256.Sum256(payload)]++
+			b.lock.Unlock()
+		}
+		token := b.broker.Publish(mapping.MQTT, mapping.QoS, false, payload)
+		if token.Wait() && token.Error() != nil {
+			b.logger.WithFields(fields).Error("Failed to publish to MQTT: ", token.Error())
+			return
+		}
+		b.logger.WithFields(fields).Debug("forwarded event to MQTT")
+	}
+
+	return b.session.Subscribe(mapping.WAMP, eventHandler, nil)
+}
+
+func (b *mqttBridge) fromMQTT(mapping MQTTMapping) error {
+	messageHandler := func(_ mqtt.Client, message mqtt.Message) {
+		fields := logrus.Fields{"uri": mapping.WAMP, "mqtt_topic": message.Topic()}
+		payload := message.Payload()
+
+		b.lock.Lock()
+		sum := sha256.Sum256(payload)
+		echo := b.echoes[sum] > 0
+		if echo {
+			if b.echoes[sum]--; b.echoes[sum] == 0 {
+				delete(b.echoes, sum)
+			}
+		}
+		b.lock.Unlock()
+		if echo {
+			return
+		}
+
+		args, kwargs, err := b.decode(payload)
+		if err != nil {
+			b.logger.WithFields(fields).Error("Failed to decode MQTT message: ", err)
+			return
+		}
+		if err = b.session.Publish(mapping.WAMP, nil, args, kwargs); err != nil {
+			b.logger.WithFields(fields).Error("Failed to publish to WAMP: ", err)
+			return
+		}
+		b.logger.WithFields(fields).Debug("forwarded MQTT message")
+	}
+
+	token := b.broker.Subscribe(mapping.MQTT, mapping.QoS, messageHandler)
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("subscribing to MQTT topic '%s': %w", mapping.MQTT, token.Error())
+	}
+	return nil
+}
+
+type mqttPayload struct {
+	Args   wamp.List `json:"args,omitempty" codec:"args,omitempty"`
+	Kwargs wamp.Dict `json:"kwargs,omitempty" codec:"kwargs,omitempty"`
+}
+
+func (b *mqttBridge) encode(args wamp.List, kwargs wamp.Dict) ([]byte, error) {
+	payload := mqttPayload{Args: args, Kwargs: kwargs}
+	switch b.serializer {
+	case serialize.MSGPACK:
+		var data []byte
+		err := codec.NewEncoderBytes(&data, &codec.MsgpackHandle{}).Encode(payload)
+		return data, err
+	case serialize.CBOR:
+		var data []byte
+		err := codec.NewEncoderBytes(&data, &codec.CborHandle{}).Encode(payload)
+		return data, err
+	}
+	return json.Marshal(payload)
+}
+
+func (b *mqttBridge) decode(data []byte) (wamp.List, wamp.Dict, error) {
+	var payload mqttPayload
+	var err error
+	switch b.serializer {
+	case serialize.MSGPACK:
+		handle := &codec.MsgpackHandle{}
+		handle.MapType = mapStringInterfaceType
+		handle.RawToString = true
+		err = codec.NewDecoderBytes(data, handle).Decode(&payload)
+	case serialize.CBOR:
+		handle := &codec.CborHandle{}
+		handle.MapType = mapStringInterfaceType
+		err = codec.NewDecoderBytes(data, handle).Decode(&payload)
+	default:
+		// Devices rarely wrap their values, JSON payloads are read like the
+		// lines of a jsonl stream.
+		return parseJSONPayload(data)
+	}
+	return payload.Args, payload.Kwargs, err
+}
+
+var mapStringInterfaceType = reflect.TypeOf(map[string]interface{}(nil))
@@ -0,0 +1,162 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// optionType is the WAMP type of an option value.
+type optionType int
+
+const (
+	optionString optionType = iota
+	optionBool
+	optionInt
+	optionIDList
+	optionStringList
+)
+
+func (t optionType) String() string {
+	switch t {
+	case optionBool:
+		return "a bool"
+	case optionInt:
+		return "an integer"
+	case optionIDList:
+		return "comma separated IDs"
+	case optionStringList:
+		return "comma separated strings"
+	}
+	return "a string"
+}
+
+// knownOptions are the types of the options of the PUBLISH, SUBSCRIBE,
+// CALL and REGISTER messages, from the WAMP basic and advanced profiles.
+var knownOptions = map[string]optionType{
+	// PUBLISH
+	"acknowledge":       optionBool,
+	"exclude_me":        optionBool,
+	"disclose_me":       optionBool,
+	"retain":            optionBool,
+	"eligible":          optionIDList,
+	"exclude":           optionIDList,
+	"eligible_authid":   optionStringList,
+	"exclude_authid":    optionStringList,
+	"eligible_authrole": optionStringList,
+	"exclude_authrole":  optionStringList,
+
+	// SUBSCRIBE and REGISTER
+	"match":            optionString,
+	"get_retained":     optionBool,
+	"invoke":           optionString,
+	"disclose_caller":  optionBool,
+	"concurrency":      optionInt,
+	"force_reregister": optionBool,
+
+	// CALL
+	"timeout":          optionInt,
+	"receive_progress": optionBool,
+	"runmode":          optionString,
+	"rkey":             optionString,
+}
+
+// ParseOptions returns options given as strings, like with -o key=value,
+// as WAMP values with the type of the known options. Lists are comma
+// separated, unknown options are kept as strings.
+func ParseOptions(values map[string]string) (wamp.Dict, error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	options := wamp.Dict{}
+	for _, key := range keys {
+		kind := knownOptions[key]
+		value, err := parseOption(kind, values[key])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of option %s, expected %s: %s", key, kind, values[key])
+		}
+		options[key] = value
+	}
+	return options, nil
+}
+
+func parseOption(kind optionType, value string) (interface{}, error) {
+	switch kind {
+	case optionBool:
+		return strconv.ParseBool(value)
+	case optionInt:
+		return strconv.ParseInt(value, 10, 64)
+	case optionIDList:
+		list := wamp.List{}
+		for _, item := range splitOption(value) {
+			id, err := strconv.ParseUint(item, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, wamp.ID(id))
+		}
+		return list, nil
+	case optionStringList:
+		list := wamp.List{}
+		for _, item := range splitOption(value) {
+			list = append(list, item)
+		}
+		return list, nil
+	}
+	return value, nil
+}
+
+// splitOption returns the comma separated items of value, without blanks.
+func splitOption(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// withOptions returns extra with options added over it, options taking
+// precedence.
+func withOptions(extra wamp.Dict, options wamp.Dict) wamp.Dict {
+	if len(extra) == 0 {
+		return options
+	}
+	merged := make(wamp.Dict, len(extra)+len(options))
+	for key, value := range extra {
+		merged[key] = value
+	}
+	for key, value := range options {
+		merged[key] = value
+	}
+	return merged
+}
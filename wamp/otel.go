@@ -0,0 +1,90 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+
+	"github.com/gammazero/nexus/v3/wamp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts OpenTelemetry spans for connects, calls and publishes, and
+// propagates their trace context to the callee or subscribers. A nil
+// *Tracing traces nothing.
+type Tracing struct {
+	Tracer trace.Tracer
+
+	// Key is the name of the kwarg, or option, that carries the trace
+	// context as a dict of W3C trace context headers.
+	Key string
+
+	// InOptions puts the trace context in the CALL or PUBLISH options
+	// instead of kwargs, for routers that forward custom options.
+	InOptions bool
+}
+
+var tracePropagator = propagation.TraceContext{}
+
+func (t *Tracing) start(ctx context.Context, name string, uri string) (context.Context, trace.Span) {
+	if t == nil {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return t.Tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("wamp.uri", uri)))
+}
+
+// inject adds the trace context of ctx to kwargs or options.
+func (t *Tracing) inject(ctx context.Context, kwargs wamp.Dict, options wamp.Dict) {
+	if t == nil {
+		return
+	}
+
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return
+	}
+	traceContext := wamp.Dict{}
+	for key, value := range carrier {
+		traceContext[key] = value
+	}
+
+	if t.InOptions {
+		options[t.Key] = traceContext
+	} else {
+		kwargs[t.Key] = traceContext
+	}
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
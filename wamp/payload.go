@@ -0,0 +1,116 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Shapes of generated payloads.
+const (
+	// PayloadShapeString is a single flat string.
+	PayloadShapeString = "string"
+
+	// PayloadShapeObject is a list of records of nested objects, strings,
+	// numbers and lists.
+	PayloadShapeObject = "object"
+
+	// PayloadShapeFloats is a list of floats.
+	PayloadShapeFloats = "floats"
+)
+
+// PayloadShapes are the shapes accepted by GeneratePayload.
+var PayloadShapes = []string{PayloadShapeString, PayloadShapeObject, PayloadShapeFloats}
+
+// payloadLetters are the characters of random strings.
+const payloadLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// GeneratePayload returns an argument of shape that is about size bytes
+// long once serialized as JSON, to compare serializers and routers across
+// payload profiles. Its content is random if random, so that it does not
+// compress, and repeated otherwise.
+func GeneratePayload(size int, shape string, random bool) (interface{}, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("invalid payload size: %d", size)
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	text := func(n int) string {
+		if !random {
+			return strings.Repeat("x", n)
+		}
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = payloadLetters[rng.Intn(len(payloadLetters))]
+		}
+		return string(b)
+	}
+	number := func() float64 {
+		if !random {
+			return 0.5
+		}
+		return rng.Float64() * 1000
+	}
+
+	switch shape {
+	case PayloadShapeString:
+		// The quotes are part of the size.
+		if size <= 2 {
+			return "", nil
+		}
+		return text(size - 2), nil
+	case PayloadShapeFloats:
+		var floats wamp.List
+		for length := 1; length < size; {
+			value := number()
+			encoded, _ := json.Marshal(value)
+			floats = append(floats, value)
+			length += len(encoded) + 1
+		}
+		return floats, nil
+	case PayloadShapeObject:
+		record := func(i int) wamp.Dict {
+			return wamp.Dict{
+				"id":    i,
+				"name":  text(16),
+				"tags":  wamp.List{text(8), text(8), text(8)},
+				"score": number(),
+				"child": wamp.Dict{"label": text(12), "value": number(), "active": i%2 == 0},
+			}
+		}
+		var records wamp.List
+		for length := 1; length < size; {
+			r := record(len(records))
+			encoded, _ := json.Marshal(r)
+			records = append(records, r)
+			length += len(encoded) + 1
+		}
+		return records, nil
+	}
+	return nil, fmt.Errorf("unknown payload shape: %s", shape)
+}
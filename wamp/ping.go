@@ -0,0 +1,172 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// PingOptions configure Ping.
+type PingOptions struct {
+	// Procedure is called to measure the round trip, wamp.session.count if
+	// empty.
+	Procedure string
+
+	// Count stops after this many calls, zero pings until CTRL-c.
+	Count int
+
+	// Interval is the time between the start of two calls.
+	Interval time.Duration
+
+	// Timeout fails a call that was not answered in time, zero waits
+	// forever.
+	Timeout time.Duration
+}
+
+// Ping calls the ping procedure every interval and prints the round-trip
+// time of each call to out, like ping(8), followed by a summary once the
+// count is reached or on CTRL-c. It returns an error, wrapping the last call
+// error, if no call succeeded.
+func Ping(session *client.Client, logger *logrus.Logger, options PingOptions, out io.Writer) error {
+	if options.Interval <= 0 {
+		return errors.New("the interval must be positive")
+	}
+	procedure := options.Procedure
+	if procedure == "" {
+		procedure = string(wamp.MetaProcSessionCount)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Fprintf(out, "PING %s on session %v\n", procedure, session.ID())
+	var rtts []time.Duration
+	var sent int
+	var lastErr error
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+	for seq := 1; options.Count == 0 || seq <= options.Count; seq++ {
+		rtt, err := pingOnce(ctx, session, procedure, options.Timeout)
+		if ctx.Err() != nil {
+			break
+		}
+
+		sent++
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("no reply within %s: %w", options.Timeout, err)
+			}
+			fmt.Fprintf(out, "seq=%d error: %v\n", seq, err)
+			logger.WithFields(logrus.Fields{"uri": procedure, "seq": seq}).Debug("ping failed: ", err)
+			lastErr = err
+		} else {
+			fmt.Fprintf(out, "seq=%d time=%s ms\n", seq, milliseconds(rtt))
+			rtts = append(rtts, rtt)
+		}
+
+		if options.Count > 0 && seq == options.Count {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	printPingSummary(out, procedure+" ping", sent, rtts)
+	if len(rtts) == 0 && lastErr != nil {
+		return fmt.Errorf("no replies from %s: %w", procedure, lastErr)
+	}
+	return nil
+}
+
+// pingOnce calls procedure, within timeout if set, and returns how long the
+// call took.
+func pingOnce(ctx context.Context, session *client.Client, procedure string,
+	timeout time.Duration) (time.Duration, error) {
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	_, err := session.Call(ctx, procedure, nil, nil, nil, nil)
+	return time.Since(start), err
+}
+
+// printPingSummary prints the statistics of the round-trip times, like
+// ping(8) with name "<procedure> ping".
+func printPingSummary(out io.Writer, name string, sent int, rtts []time.Duration) {
+	fmt.Fprintf(out, "\n--- %s statistics ---\n", name)
+	loss := 0.0
+	if sent > 0 {
+		loss = 100 * float64(sent-len(rtts)) / float64(sent)
+	}
+	fmt.Fprintf(out, "%d calls, %d replies, %.1f%% failed\n", sent, len(rtts), loss)
+	if len(rtts) == 0 {
+		return
+	}
+
+	min, max, sum := rtts[0], rtts[0], time.Duration(0)
+	for _, rtt := range rtts {
+		if rtt < min {
+			min = rtt
+		}
+		if rtt > max {
+			max = rtt
+		}
+		sum += rtt
+	}
+	avg := sum / time.Duration(len(rtts))
+	var variance float64
+	for _, rtt := range rtts {
+		variance += math.Pow(float64(rtt-avg), 2)
+	}
+	mdev := time.Duration(math.Sqrt(variance / float64(len(rtts))))
+	fmt.Fprintf(out, "rtt min/avg/max/mdev = %s/%s/%s/%s ms\n", milliseconds(min), milliseconds(avg),
+		milliseconds(max), milliseconds(mdev))
+	if len(rtts) > 1 {
+		fmt.Fprintf(out, "rtt:\n%s\nrtt per call: %s\n", histogramOf(rtts).render(), sparkline(rtts))
+	}
+}
+
+// milliseconds formats d in milliseconds with three decimals, as ping(8)
+// does.
+func milliseconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond))
+}
@@ -0,0 +1,104 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PluginPrefix starts the file names of the plugin executables, which run
+// as the wick command named by the rest of the file name.
+const PluginPrefix = "wick-"
+
+// Plugin is an executable run as a wick command.
+type Plugin struct {
+	Name string
+	Path string
+}
+
+// DefaultPluginDir returns WICK_PLUGIN_DIR if set, or ~/.wick/plugins, or
+// an empty string if there is no home directory.
+func DefaultPluginDir() string {
+	if dir := os.Getenv("WICK_PLUGIN_DIR"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".wick", "plugins")
+}
+
+// FindPlugins returns the plugins of dir sorted by name, the executable
+// files named wick-<name>. A missing dir has no plugins.
+func FindPlugins(dir string) ([]Plugin, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var plugins []Plugin
+	for _, file := range files {
+		name := strings.TrimPrefix(file.Name(), PluginPrefix)
+		if name == file.Name() || name == "" || strings.HasPrefix(name, "-") {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		// Follow symlinks, plugins are often linked from where they are
+		// installed.
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+			continue
+		}
+		plugins = append(plugins, Plugin{Name: name, Path: path})
+	}
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name < plugins[j].Name })
+	return plugins, nil
+}
+
+// PluginEnv returns the environment a plugin runs with, environ with the
+// WICK_* variables of profile, so that the wick commands it runs join the
+// same session, and profile as JSON in WICK_SESSION.
+func PluginEnv(environ []string, profile Profile) ([]string, error) {
+	session, err := profile.JSON()
+	if err != nil {
+		return nil, err
+	}
+	env := append([]string{}, environ...)
+	for _, variable := range profile.variables() {
+		if variable.value != "" {
+			env = append(env, variable.name+"="+variable.value)
+		}
+	}
+	return append(env, "WICK_SESSION="+session), nil
+}
@@ -0,0 +1,107 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/antonmedv/expr"
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/sirupsen/logrus"
+)
+
+// PollOptions configure repeating a call until its result matches.
+type PollOptions struct {
+	// Until is the condition on the result, an expression over args and
+	// kwargs like `kwargs.state == "ready"`.
+	Until string
+
+	// Interval is the time between the end of a call and the next one.
+	Interval time.Duration
+
+	// Timeout gives up polling, zero polls forever.
+	Timeout time.Duration
+}
+
+// PollCall calls procedure every poll.Interval until its result matches
+// poll.Until, then prints that result. Failed calls are retried, as the
+// callee may not be ready yet. If the condition is not met in time, the
+// returned error wraps context.DeadlineExceeded.
+func PollCall(session *client.Client, logger *logrus.Logger, procedure string, args []string,
+	kwargs map[string]string, options CallOptions, poll PollOptions) error {
+
+	program, err := expr.Compile(poll.Until, expr.AsBool())
+	if err != nil {
+		return fmt.Errorf("invalid --until condition: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if poll.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, poll.Timeout)
+		defer cancel()
+	}
+
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		resultArgs, resultKwargs, err := callOnce(ctx, session, logger, procedure, args, kwargs, options)
+		fields := logrus.Fields{"uri": procedure, "attempt": attempt}
+		if err == nil {
+			env := map[string]interface{}{
+				"args":   []interface{}(resultArgs),
+				"kwargs": map[string]interface{}(resultKwargs),
+			}
+			matched, err := expr.Run(program, env)
+			if err != nil {
+				return fmt.Errorf("evaluating --until condition: %w", err)
+			}
+			if matched.(bool) {
+				return printCallResult(resultArgs, resultKwargs, options)
+			}
+			logger.WithFields(fields).Debug("condition not met")
+			lastErr = nil
+		} else if ctx.Err() == nil {
+			logger.WithFields(fields).Debug("call failed: ", err)
+			lastErr = err
+		}
+
+		select {
+		case <-ctx.Done():
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return ctx.Err()
+			}
+			if lastErr != nil {
+				return fmt.Errorf("condition not met within %s, last error: %v: %w", poll.Timeout, lastErr,
+					context.DeadlineExceeded)
+			}
+			return fmt.Errorf("condition not met within %s: %w", poll.Timeout, context.DeadlineExceeded)
+		case <-time.After(poll.Interval):
+		}
+	}
+}
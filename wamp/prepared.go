@@ -0,0 +1,101 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// PreparedPublish is a publish whose payload and options are converted,
+// validated and encrypted once, then published as is any number of times
+// and from any number of sessions at once, for high publish rates.
+type PreparedPublish struct {
+	topic     string
+	options   wamp.Dict
+	arguments wamp.List
+	kwargs    wamp.Dict
+
+	// ack and stats time the acknowledgments, if acknowledged.
+	ack   bool
+	stats *Stats
+}
+
+// PreparePublish prepares the publish of args and kwargs to topic with
+// options. Tracing is not supported, as every traced publish carries its own
+// trace context.
+func PreparePublish(topic string, args []string, kwargs map[string]string,
+	options PublishOptions) (*PreparedPublish, error) {
+
+	if options.Tracing != nil {
+		return nil, errors.New("prepared publishes cannot be traced, every traced publish carries its own " +
+			"trace context")
+	}
+	keywordArguments, err := dictToWampDict(kwargs, options.RawKwargs)
+	if err != nil {
+		return nil, err
+	}
+	arguments := append(listToWampList(args), binaryList(options.BinaryArgs)...)
+	if options.Payload != nil {
+		arguments = append(arguments, options.Payload)
+	}
+	if err = options.Schema.Validate(arguments, keywordArguments); err != nil {
+		return nil, err
+	}
+	if keywordArguments == nil {
+		keywordArguments = wamp.Dict{}
+	}
+	publishOptions := options.Dict()
+	if options.Cryptobox != nil {
+		if arguments, err = options.Cryptobox.Seal(topic, arguments, keywordArguments, publishOptions); err != nil {
+			return nil, err
+		}
+		keywordArguments = nil
+	}
+
+	return &PreparedPublish{topic: topic, options: publishOptions, arguments: arguments, kwargs: keywordArguments,
+		ack: options.Acknowledge, stats: options.Stats}, nil
+}
+
+// Publish publishes the prepared payload from session, without printing or
+// logging anything.
+func (p *PreparedPublish) Publish(ctx context.Context, session *client.Client) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !p.ack {
+		return session.Publish(p.topic, p.options, p.arguments, p.kwargs)
+	}
+	start := time.Now()
+	if err := session.Publish(p.topic, p.options, p.arguments, p.kwargs); err != nil {
+		return err
+	}
+	if p.stats != nil {
+		p.stats.RecordAck(time.Since(start))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/router"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// The payload of the benchmarked publishes, converted by every
+// PublishContext and once by PreparePublish.
+var (
+	benchmarkArgs   = []string{"1", "2.5", "true", `{"a": [1, 2, 3]}`, "text"}
+	benchmarkKwargs = map[string]string{"key": "value", "count": "42", "nested": `{"b": {"c": null}}`}
+)
+
+// benchmarkSession returns a session joined to an in-process router, so
+// that only the client side of publishing is measured.
+func benchmarkSession(b *testing.B) *client.Client {
+	realm := wamp.URI("realm1")
+	nxr, err := router.NewRouter(&router.Config{
+		RealmConfigs: []*router.RealmConfig{{URI: realm, AnonymousAuth: true}},
+	}, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(nxr.Close)
+
+	session, err := client.ConnectLocal(nxr, client.Config{
+		Realm:  string(realm),
+		Logger: log.New(ioutil.Discard, "", 0),
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { session.Close() })
+	return session
+}
+
+func BenchmarkPreparedPublish(b *testing.B) {
+	session := benchmarkSession(b)
+	prepared, err := PreparePublish("wick.benchmark", benchmarkArgs, benchmarkKwargs, PublishOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := prepared.Publish(ctx, session); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPublishContext(b *testing.B) {
+	session := benchmarkSession(b)
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	options := PublishOptions{Output: ioutil.Discard}
+
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := PublishContext(ctx, session, logger, "wick.benchmark", benchmarkArgs, benchmarkKwargs,
+			options); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// PresenceOptions holds the options of Presence.
+type PresenceOptions struct {
+	// Match is wamp.MatchExact to track the subscriptions to the topic itself or
+	// wamp.MatchPrefix to track the subscriptions whose URI starts with it.
+	Match string
+}
+
+// presenceSession is what Presence shows of a subscribed session.
+type presenceSession struct {
+	authid   string
+	authrole string
+}
+
+// presence tracks the sessions subscribed to the subscriptions that match
+// its topic. It is only used by the goroutine of Presence, the meta event
+// handlers only queue the events, as calling from them would block the
+// client.
+type presence struct {
+	session *client.Client
+	logger  *logrus.Logger
+	topic   string
+	options PresenceOptions
+	out     io.Writer
+
+	// uris are the URIs of the subscriptions seen, tracked or not.
+	uris     map[wamp.ID]string
+	members  map[wamp.ID]map[wamp.ID]bool
+	sessions map[wamp.ID]presenceSession
+
+	mu      sync.Mutex
+	queue   []presenceEvent
+	pending chan struct{}
+}
+
+// presenceEvent is a meta event queued for the goroutine of Presence.
+type presenceEvent struct {
+	topic wamp.URI
+	event *wamp.Event
+}
+
+// Presence prints the sessions subscribed to topic, then every session
+// that subscribes or unsubscribes, until CTRL-c. It relies on the
+// subscription and session meta API, that the router must allow the session
+// to use.
+func Presence(session *client.Client, logger *logrus.Logger, topic string, options PresenceOptions,
+	out io.Writer) error {
+
+	p := &presence{
+		session:  session,
+		logger:   logger,
+		topic:    topic,
+		options:  options,
+		out:      out,
+		uris:     map[wamp.ID]string{},
+		members:  map[wamp.ID]map[wamp.ID]bool{},
+		sessions: map[wamp.ID]presenceSession{},
+		pending:  make(chan struct{}, 1),
+	}
+
+	// Subscribe before listing, the events of the changes made meanwhile
+	// are applied after the list.
+	metaTopics := []wamp.URI{wamp.MetaEventSubOnCreate, wamp.MetaEventSubOnSubscribe,
+		wamp.MetaEventSubOnUnsubscribe, wamp.MetaEventSubOnDelete, wamp.MetaEventSessionOnLeave}
+	for _, metaTopic := range metaTopics {
+		metaTopic := metaTopic
+		handler := func(event *wamp.Event) { p.push(presenceEvent{topic: metaTopic, event: event}) }
+		if err := session.Subscribe(string(metaTopic), handler, nil); err != nil {
+			return fmt.Errorf("subscribing to %s: %w", metaTopic, err)
+		}
+	}
+	defer func() {
+		for _, metaTopic := range metaTopics {
+			session.Unsubscribe(string(metaTopic))
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := p.list(ctx); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-session.Done():
+			return errors.New("router gone")
+		case <-p.pending:
+			p.mu.Lock()
+			events := p.queue
+			p.queue = nil
+			p.mu.Unlock()
+			for _, event := range events {
+				p.apply(ctx, event.topic, event.event)
+			}
+		}
+	}
+}
+
+// push queues a meta event for the goroutine of Presence.
+func (p *presence) push(event presenceEvent) {
+	p.mu.Lock()
+	p.queue = append(p.queue, event)
+	p.mu.Unlock()
+	select {
+	case p.pending <- struct{}{}:
+	default:
+	}
+}
+
+// tracked tells whether the subscription of uri matches the topic.
+func (p *presence) tracked(uri string) bool {
+	if p.options.Match == wamp.MatchPrefix {
+		return strings.HasPrefix(uri, p.topic)
+	}
+	return uri == p.topic
+}
+
+// list finds the sessions already subscribed and prints them.
+func (p *presence) list(ctx context.Context) error {
+	result, err := p.session.Call(ctx, string(wamp.MetaProcSubList), nil, nil, nil, nil)
+	if err != nil {
+		return fmt.Errorf("calling %s: %w", wamp.MetaProcSubList, err)
+	}
+	var ids []wamp.ID
+	if len(result.Arguments) > 0 {
+		lists, _ := wamp.AsDict(result.Arguments[0])
+		for _, match := range []string{wamp.MatchExact, wamp.MatchPrefix, wamp.MatchWildcard} {
+			matchIDs, _ := wamp.AsList(lists[match])
+			for _, id := range matchIDs {
+				if id, ok := wamp.AsID(id); ok {
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+
+	type row struct {
+		uri     string
+		session wamp.ID
+	}
+	var rows []row
+	for _, id := range ids {
+		uri := p.subscriptionURI(ctx, id)
+		if !p.tracked(uri) {
+			continue
+		}
+		result, err = p.session.Call(ctx, string(wamp.MetaProcSubListSubscribers), nil, wamp.List{id}, nil, nil)
+		if err != nil {
+			// Gone between the list and the call.
+			continue
+		}
+		var subscribers wamp.List
+		if len(result.Arguments) > 0 {
+			subscribers, _ = wamp.AsList(result.Arguments[0])
+		}
+		for _, subscriber := range subscribers {
+			if sessionID, ok := wamp.AsID(subscriber); ok && sessionID != p.session.ID() {
+				p.join(ctx, id, sessionID)
+				rows = append(rows, row{uri: uri, session: sessionID})
+			}
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].uri != rows[j].uri {
+			return rows[i].uri < rows[j].uri
+		}
+		return rows[i].session < rows[j].session
+	})
+
+	writer := tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "Sessions subscribed to '%s' (%d):\n", p.topic, p.present())
+	for _, row := range rows {
+		details := p.sessions[row.session]
+		fmt.Fprintf(writer, "  %d\t%s\t%s\t%s\n", row.session, details.authid, details.authrole, row.uri)
+	}
+	return writer.Flush()
+}
+
+// subscriptionURI returns the URI of the subscription id, empty if it is
+// gone.
+func (p *presence) subscriptionURI(ctx context.Context, id wamp.ID) string {
+	if uri, ok := p.uris[id]; ok {
+		return uri
+	}
+	result, err := p.session.Call(ctx, string(wamp.MetaProcSubGet), nil, wamp.List{id}, nil, nil)
+	if err != nil {
+		p.logger.WithField("subscription", id).Debug("no subscription details: ", err)
+		return ""
+	}
+	var details wamp.Dict
+	if len(result.Arguments) > 0 {
+		details, _ = wamp.AsDict(result.Arguments[0])
+	}
+	uri, _ := wamp.AsString(details["uri"])
+	p.uris[id] = uri
+	return uri
+}
+
+// join records that sessionID subscribed to the subscription id, it reports
+// false if it already was.
+func (p *presence) join(ctx context.Context, id wamp.ID, sessionID wamp.ID) bool {
+	if p.members[id] == nil {
+		p.members[id] = map[wamp.ID]bool{}
+	}
+	if p.members[id][sessionID] {
+		return false
+	}
+	p.members[id][sessionID] = true
+	if _, ok := p.sessions[sessionID]; !ok {
+		var details presenceSession
+		result, err := p.session.Call(ctx, string(wamp.MetaProcSessionGet), nil, wamp.List{sessionID}, nil, nil)
+		if err == nil && len(result.Arguments) > 0 {
+			dict, _ := wamp.AsDict(result.Arguments[0])
+			details.authid, _ = wamp.AsString(dict["authid"])
+			details.authrole, _ = wamp.AsString(dict["authrole"])
+		} else if err != nil {
+			p.logger.WithField("session", sessionID).Debug("no session details: ", err)
+		}
+		p.sessions[sessionID] = details
+	}
+	return true
+}
+
+// leave records that sessionID unsubscribed from the subscription id and
+// prints it, if it was tracked.
+func (p *presence) leave(id wamp.ID, sessionID wamp.ID, reason string) {
+	if !p.members[id][sessionID] {
+		return
+	}
+	delete(p.members[id], sessionID)
+	details := p.sessions[sessionID]
+	present := p.present()
+	if !p.subscribed(sessionID) {
+		delete(p.sessions, sessionID)
+	}
+	p.print("left", p.uris[id], sessionID, details, present, reason)
+}
+
+// present returns how many sessions are subscribed to a tracked
+// subscription.
+func (p *presence) present() int {
+	sessions := map[wamp.ID]bool{}
+	for _, members := range p.members {
+		for sessionID := range members {
+			sessions[sessionID] = true
+		}
+	}
+	return len(sessions)
+}
+
+// subscribed tells whether sessionID is subscribed to a tracked
+// subscription.
+func (p *presence) subscribed(sessionID wamp.ID) bool {
+	for _, members := range p.members {
+		if members[sessionID] {
+			return true
+		}
+	}
+	return false
+}
+
+// apply updates the sessions for a meta event and prints the sessions that
+// joined or left.
+func (p *presence) apply(ctx context.Context, metaTopic wamp.URI, event *wamp.Event) {
+	var sessionID, id wamp.ID
+	if len(event.Arguments) > 0 {
+		sessionID, _ = wamp.AsID(event.Arguments[0])
+	}
+	if len(event.Arguments) > 1 {
+		id, _ = wamp.AsID(event.Arguments[1])
+	}
+	if sessionID == p.session.ID() {
+		return
+	}
+
+	switch metaTopic {
+	case wamp.MetaEventSubOnCreate:
+		if len(event.Arguments) > 1 {
+			details, _ := wamp.AsDict(event.Arguments[1])
+			if id, ok := wamp.AsID(details["id"]); ok {
+				p.uris[id], _ = wamp.AsString(details["uri"])
+			}
+		}
+	case wamp.MetaEventSubOnSubscribe:
+		uri := p.subscriptionURI(ctx, id)
+		if p.tracked(uri) && p.join(ctx, id, sessionID) {
+			p.print("joined", uri, sessionID, p.sessions[sessionID], p.present(), "")
+		}
+	case wamp.MetaEventSubOnUnsubscribe:
+		p.leave(id, sessionID, "")
+	case wamp.MetaEventSubOnDelete:
+		p.leave(id, sessionID, "")
+		delete(p.members, id)
+		delete(p.uris, id)
+	case wamp.MetaEventSessionOnLeave:
+		for id := range p.members {
+			p.leave(id, sessionID, "session left")
+		}
+	}
+}
+
+// print prints that a session joined or left the subscription of uri.
+func (p *presence) print(change string, uri string, sessionID wamp.ID, details presenceSession, present int,
+	reason string) {
+
+	line := fmt.Sprintf("%s %s %s: session %d", time.Now().Format("15:04:05.000"), change, uri, sessionID)
+	if details.authid != "" {
+		line += ", authid " + details.authid
+	}
+	if details.authrole != "" {
+		line += ", authrole " + details.authrole
+	}
+	if reason != "" {
+		line += ", " + reason
+	}
+	fmt.Fprintf(p.out, "%s (%d present)\n", line, present)
+}
@@ -0,0 +1,480 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formats of exported and imported profiles.
+const (
+	// ProfileFormatWick is the JSON of Profile.
+	ProfileFormatWick = "wick"
+
+	// ProfileFormatCrossbar is a Crossbar node config running a container
+	// component that connects to the router. A Crossbar router config is
+	// imported as a client of its first realm and transport.
+	ProfileFormatCrossbar = "crossbar"
+
+	// ProfileFormatAutobahn is the config of an Autobahn Component, its
+	// transports, realm and authentication.
+	ProfileFormatAutobahn = "autobahn"
+)
+
+// Profile holds the settings a client needs to connect to a router, for
+// handing them over to another client.
+type Profile struct {
+	URL           string `json:"url"`
+	Realm         string `json:"realm"`
+	Serialization string `json:"serializer,omitempty"`
+	AuthMethod    string `json:"authmethod,omitempty"`
+	AuthID        string `json:"authid,omitempty"`
+	AuthRole      string `json:"authrole,omitempty"`
+
+	// The credentials of AuthMethod, only set when they are exported.
+	Ticket     string `json:"ticket,omitempty"`
+	Secret     string `json:"secret,omitempty"`
+	PrivateKey string `json:"private_key,omitempty"`
+}
+
+// NewProfile returns the profile of cfg, with its credentials if
+// withCredentials is true.
+func NewProfile(cfg ClientConfig, serializer string, withCredentials bool) Profile {
+	profile := Profile{
+		URL:           cfg.URL,
+		Realm:         cfg.Realm,
+		Serialization: serializer,
+		AuthMethod:    cfg.AuthMethod,
+		AuthID:        cfg.AuthID,
+		AuthRole:      cfg.AuthRole,
+	}
+	if withCredentials {
+		switch cfg.AuthMethod {
+		case AuthTicket:
+			profile.Ticket = cfg.Ticket
+		case AuthWAMPCRA:
+			profile.Secret = cfg.Secret
+		case AuthCryptosign:
+			profile.PrivateKey = cfg.PrivateKey
+		}
+	}
+	return profile
+}
+
+// JSON returns the profile as compact JSON, which keeps its QR code small.
+func (p Profile) JSON() (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Export returns the profile in format, the wick format as compact JSON and
+// the others as indented JSON config files. The crossbar format only has the
+// URL, realm and serializer.
+func (p Profile) Export(format string) (string, error) {
+	var config interface{}
+	switch format {
+	case ProfileFormatWick:
+		return p.JSON()
+	case ProfileFormatAutobahn:
+		autobahn := map[string]interface{}{
+			"transports": []interface{}{p.transport(false)},
+			"realm":      p.Realm,
+		}
+		if p.AuthMethod != "" && p.AuthMethod != AuthAnonymous {
+			auth := map[string]interface{}{}
+			for key, value := range map[string]string{"authid": p.AuthID, "authrole": p.AuthRole,
+				"ticket": p.Ticket, "secret": p.Secret, "privkey": p.PrivateKey} {
+				if value != "" {
+					auth[key] = value
+				}
+			}
+			autobahn["authentication"] = map[string]interface{}{p.AuthMethod: auth}
+		}
+		config = autobahn
+	case ProfileFormatCrossbar:
+		config = map[string]interface{}{
+			"version": 2,
+			"workers": []interface{}{map[string]interface{}{
+				"type": "container",
+				"components": []interface{}{map[string]interface{}{
+					"type":      "class",
+					"classname": "app.AppSession",
+					"realm":     p.Realm,
+					"transport": p.transport(true),
+				}},
+			}},
+		}
+	default:
+		return "", fmt.Errorf("unknown profile format: %s", format)
+	}
+	data, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// transport returns the URL of the profile as an Autobahn or Crossbar
+// transport, with an endpoint for Crossbar.
+func (p Profile) transport(withEndpoint bool) map[string]interface{} {
+	transport := map[string]interface{}{}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		u = &url.URL{}
+	}
+	secure := false
+	switch u.Scheme {
+	case "unix":
+		transport["type"] = "rawsocket"
+		transport["endpoint"] = map[string]interface{}{"type": "unix", "path": u.Host + u.Path}
+		withEndpoint = false
+	case "tcp", "tcp4", "tcp6", "tcps", "tcp4s", "tcp6s":
+		secure = strings.HasSuffix(u.Scheme, "s")
+		transport["type"] = "rawsocket"
+		scheme := "rs"
+		if secure {
+			scheme = "rss"
+		}
+		transport["url"] = scheme + "://" + u.Host
+	default:
+		secure = u.Scheme == "wss" || u.Scheme == "https"
+		transport["type"] = "websocket"
+		transport["url"] = p.URL
+	}
+	if withEndpoint {
+		// Crossbar connects rawsockets to their endpoint only.
+		if transport["type"] == "rawsocket" {
+			delete(transport, "url")
+		}
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if secure {
+				port = "443"
+			}
+		}
+		portNumber, _ := strconv.Atoi(port)
+		endpoint := map[string]interface{}{"type": "tcp", "host": u.Hostname(), "port": portNumber}
+		if secure {
+			endpoint["tls"] = map[string]interface{}{"hostname": u.Hostname()}
+		}
+		transport["endpoint"] = endpoint
+	}
+	switch {
+	case p.Serialization == "":
+	case withEndpoint && transport["type"] == "rawsocket":
+		transport["serializer"] = p.Serialization
+	default:
+		transport["serializers"] = []interface{}{p.Serialization}
+	}
+	return transport
+}
+
+// ImportProfile reads a profile in the wick, autobahn or crossbar format,
+// and returns it with the format it was in.
+func ImportProfile(data []byte) (Profile, string, error) {
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Profile{}, "", fmt.Errorf("invalid profile: %w", err)
+	}
+
+	switch {
+	case config["url"] != nil:
+		var profile Profile
+		err := json.Unmarshal(data, &profile)
+		return profile, ProfileFormatWick, err
+	case config["transports"] != nil:
+		profile, err := importAutobahn(config)
+		return profile, ProfileFormatAutobahn, err
+	case config["workers"] != nil:
+		profile, err := importCrossbar(config)
+		return profile, ProfileFormatCrossbar, err
+	}
+	return Profile{}, "", errors.New("unknown profile format, expected a wick profile, an Autobahn " +
+		"component config or a Crossbar node config")
+}
+
+func importAutobahn(config map[string]interface{}) (Profile, error) {
+	profile := Profile{Realm: stringOf(config["realm"])}
+	transports := config["transports"]
+	if list, ok := transports.([]interface{}); ok && len(list) > 0 {
+		transports = list[0]
+	}
+	if text, ok := transports.(string); ok {
+		profile.URL = text
+	} else if err := profile.importTransport(dictOf(transports), ""); err != nil {
+		return Profile{}, err
+	}
+
+	auth := dictOf(config["authentication"])
+	if method := preferredAuthMethod(auth); method != "" {
+		credentials := dictOf(auth[method])
+		profile.AuthMethod = method
+		profile.AuthID = stringOf(credentials["authid"])
+		profile.AuthRole = stringOf(credentials["authrole"])
+		profile.Ticket = stringOf(credentials["ticket"])
+		profile.Secret = stringOf(credentials["secret"])
+		profile.PrivateKey = stringOf(credentials["privkey"])
+	}
+	return profile, nil
+}
+
+// importCrossbar imports the first component of a container worker, or else
+// the first realm and transport of a router worker, with the first of the
+// principals of its authentication.
+func importCrossbar(config map[string]interface{}) (Profile, error) {
+	workers, _ := config["workers"].([]interface{})
+	for _, worker := range workers {
+		worker := dictOf(worker)
+		if worker["type"] != "container" {
+			continue
+		}
+		components, _ := worker["components"].([]interface{})
+		for _, component := range components {
+			component := dictOf(component)
+			profile := Profile{Realm: stringOf(component["realm"])}
+			err := profile.importTransport(dictOf(component["transport"]), "")
+			return profile, err
+		}
+	}
+
+	for _, worker := range workers {
+		worker := dictOf(worker)
+		if worker["type"] != "router" {
+			continue
+		}
+		var profile Profile
+		if realms, _ := worker["realms"].([]interface{}); len(realms) > 0 {
+			profile.Realm = stringOf(dictOf(realms[0])["name"])
+		}
+		transports, _ := worker["transports"].([]interface{})
+		for _, transport := range transports {
+			transport := dictOf(transport)
+			if err := profile.importRouterTransport(transport); err != nil {
+				return Profile{}, err
+			}
+			if profile.URL != "" {
+				return profile, nil
+			}
+		}
+		return Profile{}, errors.New("no websocket or rawsocket transport in the Crossbar router config")
+	}
+	return Profile{}, errors.New("no container component or router worker in the Crossbar config")
+}
+
+// importRouterTransport sets the URL of a client of a Crossbar router
+// transport, and its authentication.
+func (p *Profile) importRouterTransport(transport map[string]interface{}) error {
+	// The websocket service of web and universal transports is on a path,
+	// with its own serializers and authentication.
+	path := ""
+	service := transport
+	switch transport["type"] {
+	case "websocket", "rawsocket":
+	case "web":
+		paths := dictOf(transport["paths"])
+		for _, key := range sortedKeys(paths) {
+			if dictOf(paths[key])["type"] == "websocket" {
+				path, service = key, dictOf(paths[key])
+				break
+			}
+		}
+		if path == "" {
+			return nil
+		}
+	case "universal":
+		websocket := dictOf(transport["websocket"])
+		if len(websocket) == 0 {
+			service = dictOf(transport["rawsocket"])
+			service["type"] = "rawsocket"
+			break
+		}
+		path = sortedKeys(websocket)[0]
+		service = dictOf(websocket[path])
+		service["type"] = "websocket"
+	default:
+		return nil
+	}
+	listener := map[string]interface{}{
+		"type":        service["type"],
+		"endpoint":    transport["endpoint"],
+		"serializers": service["serializers"],
+		"serializer":  service["serializer"],
+	}
+	if err := p.importTransport(listener, path); err != nil {
+		return err
+	}
+
+	auth := dictOf(service["auth"])
+	if _, ok := auth[AuthAnonymous]; ok || len(auth) == 0 {
+		p.AuthMethod = AuthAnonymous
+		return nil
+	}
+	p.AuthMethod = preferredAuthMethod(auth)
+	method := dictOf(auth[p.AuthMethod])
+	principals := dictOf(method["principals"])
+	if len(principals) == 0 {
+		principals = dictOf(method["users"])
+	}
+	if len(principals) > 0 {
+		p.AuthID = sortedKeys(principals)[0]
+		principal := dictOf(principals[p.AuthID])
+		p.AuthRole = stringOf(principal["role"])
+		p.Ticket = stringOf(principal["ticket"])
+		p.Secret = stringOf(principal["secret"])
+	}
+	return nil
+}
+
+// importTransport sets the URL and serializer of an Autobahn or Crossbar
+// transport. Listening endpoints are reached on localhost, websockets on
+// path.
+func (p *Profile) importTransport(transport map[string]interface{}, path string) error {
+	if serializers, ok := transport["serializers"].([]interface{}); ok && len(serializers) > 0 {
+		p.Serialization = stringOf(serializers[0])
+	} else if serializer := stringOf(transport["serializer"]); serializer != "" {
+		p.Serialization = serializer
+	}
+
+	rawsocket := transport["type"] == "rawsocket"
+	if text := stringOf(transport["url"]); text != "" {
+		// Autobahn names the rawsocket schemes rs and rss.
+		if strings.HasPrefix(text, "rs://") {
+			text = "tcp://" + strings.TrimPrefix(text, "rs://")
+		} else if strings.HasPrefix(text, "rss://") {
+			text = "tcps://" + strings.TrimPrefix(text, "rss://")
+		}
+		p.URL = text
+		return nil
+	}
+
+	endpoint := dictOf(transport["endpoint"])
+	if endpoint["type"] == "unix" {
+		p.URL = "unix://" + stringOf(endpoint["path"])
+		return nil
+	}
+	host := stringOf(endpoint["host"])
+	if host == "" {
+		host = stringOf(endpoint["interface"])
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	port := stringOf(endpoint["port"])
+	if port == "" {
+		return errors.New("the transport has neither a url nor an endpoint port")
+	}
+	secure := endpoint["tls"] != nil
+	address := net.JoinHostPort(host, port)
+	switch {
+	case rawsocket && secure:
+		p.URL = "tcps://" + address
+	case rawsocket:
+		p.URL = "tcp://" + address
+	case secure:
+		p.URL = "wss://" + address + "/" + path
+	default:
+		p.URL = "ws://" + address + "/" + path
+	}
+	return nil
+}
+
+// envVariable is a WICK_* variable of a profile.
+type envVariable struct{ name, value string }
+
+// variables returns the WICK_* variables of the profile, empty if unset.
+func (p Profile) variables() []envVariable {
+	return []envVariable{
+		{"WICK_URL", p.URL},
+		{"WICK_REALM", p.Realm},
+		{"WICK_SERIALIZER", p.Serialization},
+		{"WICK_AUTHMETHOD", p.AuthMethod},
+		{"WICK_AUTHID", p.AuthID},
+		{"WICK_AUTHROLE", p.AuthRole},
+		{"WICK_TICKET", p.Ticket},
+		{"WICK_SECRET", p.Secret},
+		{"WICK_PRIVATE_KEY", p.PrivateKey},
+	}
+}
+
+// Env returns the profile as the WICK_* variables of an env file.
+func (p Profile) Env() string {
+	var env strings.Builder
+	for _, variable := range p.variables() {
+		if variable.value == "" {
+			continue
+		}
+		value := variable.value
+		if strings.ContainsAny(value, " \t#'\"") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&env, "%s=%s\n", variable.name, value)
+	}
+	return env.String()
+}
+
+func dictOf(value interface{}) map[string]interface{} {
+	dict, _ := value.(map[string]interface{})
+	return dict
+}
+
+// stringOf returns strings as is and numbers, like ports, in decimal.
+func stringOf(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+func sortedKeys(dict map[string]interface{}) []string {
+	keys := make([]string, 0, len(dict))
+	for key := range dict {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// preferredAuthMethod returns the first of the authentication methods of dict, in the
+// order of preference of wick.
+func preferredAuthMethod(dict map[string]interface{}) string {
+	for _, method := range []string{AuthCryptosign, AuthWAMPCRA, AuthTicket, AuthAnonymous} {
+		if _, ok := dict[method]; ok {
+			return method
+		}
+	}
+	return ""
+}
@@ -0,0 +1,122 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// ProxyOptions configure a procedure proxy.
+type ProxyOptions struct {
+	// Procedure is the procedure registered.
+	Procedure string
+
+	// Target is the procedure its invocations are forwarded to, Procedure
+	// if empty.
+	Target string
+
+	// Extra are the options of the REGISTER message, nil sends none.
+	Extra wamp.Dict
+}
+
+// Proxy registers options.Procedure on local and forwards every invocation
+// to options.Target on remote, with the same args and kwargs, and returns
+// its result, progressive results and error to the caller. Canceled
+// invocations cancel the forwarded call. It runs until CTRL-c or one of the
+// sessions is closed.
+func Proxy(local *client.Client, remote *client.Client, logger *logrus.Logger, options ProxyOptions) error {
+	target := options.Target
+	if target == "" {
+		target = options.Procedure
+	}
+
+	var forwarded int64
+	invocationHandler := func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+		fields := logrus.Fields{"uri": options.Procedure, "target": target}
+		callOptions := wamp.Dict{}
+		if timeout, ok := inv.Details[wamp.OptTimeout]; ok {
+			callOptions[wamp.OptTimeout] = timeout
+		}
+		var progress client.ProgressHandler
+		if wantProgress, _ := inv.Details[wamp.OptReceiveProgress].(bool); wantProgress {
+			callOptions[wamp.OptReceiveProgress] = true
+			progress = func(result *wamp.Result) {
+				if err := local.SendProgress(ctx, result.Arguments, result.ArgumentsKw); err != nil {
+					logger.WithFields(fields).Debug("Failed to forward progressive result: ", err)
+				}
+			}
+		}
+
+		result, err := remote.Call(ctx, target, callOptions, inv.Arguments, inv.ArgumentsKw, progress)
+		atomic.AddInt64(&forwarded, 1)
+		if err != nil {
+			var rpcErr client.RPCError
+			if errors.As(err, &rpcErr) {
+				logger.WithFields(fields).Debug("forwarded error: ", rpcErr.Err.Error)
+				return client.InvokeResult{Err: rpcErr.Err.Error, Args: rpcErr.Err.Arguments,
+					Kwargs: rpcErr.Err.ArgumentsKw}
+			}
+			if ctx.Err() != nil {
+				return client.InvokeResult{Err: wamp.ErrCanceled}
+			}
+			logger.WithFields(fields).Error("Failed to forward invocation: ", err)
+			return client.InvokeResult{Err: wamp.ErrNetworkFailure, Args: wamp.List{err.Error()}}
+		}
+		logger.WithFields(fields).Debug("forwarded invocation")
+		return client.InvokeResult{Args: result.Arguments, Kwargs: result.ArgumentsKw}
+	}
+
+	if err := local.Register(options.Procedure, invocationHandler, options.Extra); err != nil {
+		return err
+	}
+	fmt.Printf("Proxying procedure '%s' to '%s'\n", options.Procedure, target)
+
+	// Wait for CTRL-c or either client close while forwarding invocations.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	var err error
+	select {
+	case <-sigChan:
+	case <-local.Done():
+		err = errors.New("local router gone")
+	case <-remote.Done():
+		err = errors.New("target router gone")
+	}
+
+	if err == nil {
+		if unregisterErr := local.Unregister(options.Procedure); unregisterErr != nil {
+			logger.WithField("uri", options.Procedure).Error("Failed to unregister: ", unregisterErr)
+		}
+	}
+	logger.WithField("count", atomic.LoadInt64(&forwarded)).Info("Proxy stopped")
+	return err
+}
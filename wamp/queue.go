@@ -0,0 +1,174 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// What a full event queue does with a new event.
+const (
+	OverflowBlock = "block"
+	OverflowDrop  = "drop"
+)
+
+// queueReportInterval is how often a queue that drops events or is full
+// logs it.
+const queueReportInterval = 5 * time.Second
+
+// eventQueue hands the events of a subscription to a handler running in its
+// own goroutine, so that a slow handler, like printing to a pager, does not
+// hold up the client dispatcher. A nil eventQueue is never used.
+type eventQueue struct {
+	events  chan *wamp.Event
+	drop    bool
+	handler func(*wamp.Event)
+	topic   string
+	metrics *Metrics
+	logger  *logrus.Logger
+
+	dropped int64
+	skip    int32
+	lock    sync.Mutex
+	closed  bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// newEventQueue starts handling the events pushed to a queue of size, nil if
+// size is zero. With OverflowDrop the events pushed to a full queue are
+// dropped, otherwise the push waits for room.
+func newEventQueue(size int, overflow string, handler func(*wamp.Event), topic string, metrics *Metrics,
+	logger *logrus.Logger) *eventQueue {
+
+	if size <= 0 {
+		return nil
+	}
+	q := &eventQueue{
+		events:  make(chan *wamp.Event, size),
+		drop:    overflow == OverflowDrop,
+		handler: handler,
+		topic:   topic,
+		metrics: metrics,
+		logger:  logger,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	go q.report()
+	return q
+}
+
+func (q *eventQueue) run() {
+	defer close(q.done)
+	var skipped int
+	for event := range q.events {
+		if atomic.LoadInt32(&q.skip) != 0 {
+			skipped++
+			continue
+		}
+		q.handler(event)
+	}
+	if skipped > 0 {
+		q.logger.WithField("uri", q.topic).Warnf("%d queued events not handled", skipped)
+	}
+}
+
+// report logs the events dropped and the queue length while events are
+// dropped or the queue is full.
+func (q *eventQueue) report() {
+	ticker := time.NewTicker(queueReportInterval)
+	defer ticker.Stop()
+	var reported int64
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+		}
+		dropped := atomic.LoadInt64(&q.dropped)
+		behind := len(q.events)
+		if dropped == reported && behind < cap(q.events) {
+			continue
+		}
+		q.logger.WithFields(logrus.Fields{
+			"uri":     q.topic,
+			"dropped": dropped - reported,
+			"behind":  behind,
+		}).Warn("Events arrive faster than they are handled")
+		reported = dropped
+	}
+}
+
+// push queues event, it is called by the client dispatcher.
+func (q *eventQueue) push(event *wamp.Event) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return
+	}
+	if q.drop {
+		select {
+		case q.events <- event:
+		default:
+			q.dropEvent()
+		}
+		return
+	}
+	select {
+	case q.events <- event:
+	case <-q.stop:
+		q.dropEvent()
+	}
+}
+
+func (q *eventQueue) dropEvent() {
+	atomic.AddInt64(&q.dropped, 1)
+	q.metrics.eventDropped(q.topic)
+}
+
+// close stops queueing events and waits for the handler to be done with the
+// queued ones, or to skip them unless drain.
+func (q *eventQueue) close(drain bool) {
+	if q == nil {
+		return
+	}
+	if !drain {
+		atomic.StoreInt32(&q.skip, 1)
+	}
+	close(q.stop)
+	q.lock.Lock()
+	q.closed = true
+	close(q.events)
+	q.lock.Unlock()
+	<-q.done
+
+	if dropped := atomic.LoadInt64(&q.dropped); dropped > 0 {
+		q.logger.WithField("uri", q.topic).Warnf("%d events dropped", dropped)
+	}
+}
@@ -0,0 +1,210 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// recordFlushInterval is how often the events recorded are flushed to the
+// file, at most that much is lost if wick is killed.
+const recordFlushInterval = time.Second
+
+// RecordOptions configure the recording of the events of a subscription to
+// gzip compressed JSON lines files.
+type RecordOptions struct {
+	// Dir is the directory of the files, created if needed. Empty records
+	// nothing.
+	Dir string
+
+	// RotateSize starts a new file once the current one has this many
+	// compressed bytes, zero never does.
+	RotateSize int64
+
+	// RotateInterval starts a new file once the current one is this old,
+	// zero never does.
+	RotateInterval time.Duration
+}
+
+// recordedEvent is a line of a recording.
+type recordedEvent struct {
+	Time        time.Time `json:"time"`
+	Topic       string    `json:"topic"`
+	Publication wamp.ID   `json:"publication"`
+	Details     wamp.Dict `json:"details,omitempty"`
+	Args        wamp.List `json:"args"`
+	Kwargs      wamp.Dict `json:"kwargs"`
+}
+
+// recorder writes the events of topic to the current file of a recording.
+// A nil recorder records nothing.
+type recorder struct {
+	topic   string
+	options RecordOptions
+
+	file    *os.File
+	gzip    *gzip.Writer
+	size    countingWriter
+	opened  time.Time
+	flushed time.Time
+}
+
+// countingWriter counts the bytes written to a file.
+type countingWriter struct {
+	file    *os.File
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func newRecorder(topic string, options RecordOptions) (*recorder, error) {
+	if options.Dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(options.Dir, 0755); err != nil {
+		return nil, err
+	}
+	r := &recorder{topic: topic, options: options}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open starts a new file, named after the topic and the time, like
+// "sensors.raw-20211014T120000.000Z.jsonl.gz".
+func (r *recorder) open() error {
+	now := time.Now().UTC()
+	base := filepath.Join(r.options.Dir, strings.NewReplacer("/", "_", "*", "_").Replace(r.topic)+"-"+
+		now.Format("20060102T150405.000Z"))
+	name := base + ".jsonl.gz"
+	for n := 2; ; n++ {
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			r.file = file
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		name = base + "-" + strconv.Itoa(n) + ".jsonl.gz"
+	}
+	r.size = countingWriter{file: r.file}
+	r.gzip = gzip.NewWriter(&r.size)
+	r.opened, r.flushed = now, now
+	return nil
+}
+
+// record writes event, with its args and kwargs as opened, starting a new
+// file first if the current one is due for rotation.
+func (r *recorder) record(event *wamp.Event, args wamp.List, kwargs wamp.Dict) error {
+	if r == nil {
+		return nil
+	}
+	now := time.Now()
+	if (r.options.RotateSize > 0 && r.size.written >= r.options.RotateSize) ||
+		(r.options.RotateInterval > 0 && now.Sub(r.opened) >= r.options.RotateInterval) {
+		if err := r.close(); err != nil {
+			return err
+		}
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+
+	topic := r.topic
+	if eventTopic, ok := wamp.AsString(event.Details["topic"]); ok {
+		topic = eventTopic
+	}
+	line, err := json.Marshal(recordedEvent{
+		Time:        now.UTC(),
+		Topic:       topic,
+		Publication: event.Publication,
+		Details:     event.Details,
+		Args:        printableList(args, BinaryFormatBase64),
+		Kwargs:      printableDict(kwargs, BinaryFormatBase64),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = r.gzip.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if now.Sub(r.flushed) >= recordFlushInterval {
+		r.flushed = now
+		return r.gzip.Flush()
+	}
+	return nil
+}
+
+// close ends the current file.
+func (r *recorder) close() error {
+	if r == nil {
+		return nil
+	}
+	err := r.gzip.Close()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ParseSize parses a number of bytes with an optional unit, like "500",
+// "100MB" or "1GiB". KB, MB and GB are powers of 1000, KiB, MiB and GiB of
+// 1024.
+func ParseSize(value string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+		{"B", 1},
+	}
+	number, multiplier := strings.TrimSpace(value), int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix)), unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected a number of bytes like 500, 100MB or 1GiB", value)
+	}
+	return int64(n * float64(multiplier)), nil
+}
@@ -0,0 +1,181 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// ReportSchema is the version of the layout of the report files, raised
+// whenever a field is renamed or removed.
+const ReportSchema = 1
+
+// The formats of the report files, given by their extension.
+const (
+	ReportJSON = "json"
+	ReportCSV  = "csv"
+)
+
+// Sample is an operation of a repeated run, kept if Stats.KeepSamples.
+type Sample struct {
+	Start   time.Time
+	Session wamp.ID
+	Latency time.Duration
+
+	// Error is the WAMP error URI, or the message, of a failed operation.
+	Error string
+}
+
+// reportLatency are the latency figures of a report, in seconds.
+type reportLatency struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// reportSample is a Sample in a JSON report.
+type reportSample struct {
+	Start   time.Time `json:"start"`
+	Session wamp.ID   `json:"session"`
+	Latency float64   `json:"latency_seconds"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// report is the layout of a JSON report.
+type report struct {
+	Schema   int            `json:"schema"`
+	Start    time.Time      `json:"start"`
+	Duration float64        `json:"duration_seconds"`
+	Ops      int            `json:"ops"`
+	Errors   int            `json:"errors"`
+	Rate     float64        `json:"rate"`
+	Latency  reportLatency  `json:"latency_seconds"`
+	Samples  []reportSample `json:"samples,omitempty"`
+}
+
+// reportAggregateHeader are the columns of a CSV report of the stats of
+// runs, one row per run.
+var reportAggregateHeader = []string{"schema", "start", "duration_seconds", "ops", "errors", "rate",
+	"latency_min", "latency_mean", "latency_p50", "latency_p90", "latency_p95", "latency_p99", "latency_max"}
+
+// reportSampleHeader are the columns of a CSV report of the samples of a
+// run, one row per operation.
+var reportSampleHeader = []string{"schema", "start", "session", "latency_seconds", "error"}
+
+// ReportFormat returns the format of the report file path from its
+// extension.
+func ReportFormat(path string) (string, error) {
+	switch format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."); format {
+	case ReportJSON, ReportCSV:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown report format of %s, expected a .json or .csv file", path)
+}
+
+// WriteReport writes the stats of a run to path, as JSON or CSV after its
+// extension. The JSON report holds the stats and the samples, if kept. The
+// CSV report holds the samples if kept, otherwise the stats as a row added
+// to the file, so that it tracks the runs one after the other.
+func WriteReport(path string, stats *Stats) error {
+	format, err := ReportFormat(path)
+	if err != nil {
+		return err
+	}
+	latency := reportLatency{
+		Min:  stats.Latency.Min.Seconds(),
+		Mean: stats.Latency.Mean().Seconds(),
+		P50:  stats.LatencyPercentile(50).Seconds(),
+		P90:  stats.LatencyPercentile(90).Seconds(),
+		P95:  stats.LatencyPercentile(95).Seconds(),
+		P99:  stats.LatencyPercentile(99).Seconds(),
+		Max:  stats.Latency.Max.Seconds(),
+	}
+
+	if format == ReportJSON {
+		data := report{
+			Schema:   ReportSchema,
+			Start:    stats.Start,
+			Duration: stats.Duration.Seconds(),
+			Ops:      stats.Ops,
+			Errors:   stats.Errors,
+			Rate:     stats.Rate(),
+			Latency:  latency,
+		}
+		for _, sample := range stats.Samples {
+			data.Samples = append(data.Samples, reportSample{Start: sample.Start, Session: sample.Session,
+				Latency: sample.Latency.Seconds(), Error: sample.Error})
+		}
+		encoded, err := json.MarshalIndent(data, "", "    ")
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, append(encoded, '\n'), 0644)
+	}
+
+	seconds := func(value float64) string { return strconv.FormatFloat(value, 'f', -1, 64) }
+	schema := strconv.Itoa(ReportSchema)
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	if stats.KeepSamples {
+		writer.Write(reportSampleHeader)
+		for _, sample := range stats.Samples {
+			writer.Write([]string{schema, sample.Start.Format(time.RFC3339Nano), fmt.Sprint(sample.Session),
+				seconds(sample.Latency.Seconds()), sample.Error})
+		}
+		writer.Flush()
+		return ioutil.WriteFile(path, buffer.Bytes(), 0644)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		writer.Write(reportAggregateHeader)
+	}
+	writer.Write([]string{schema, stats.Start.Format(time.RFC3339Nano), seconds(stats.Duration.Seconds()),
+		strconv.Itoa(stats.Ops), strconv.Itoa(stats.Errors), seconds(stats.Rate()), seconds(latency.Min),
+		seconds(latency.Mean), seconds(latency.P50), seconds(latency.P90), seconds(latency.P95),
+		seconds(latency.P99), seconds(latency.Max)})
+	writer.Flush()
+	if _, err = file.Write(buffer.Bytes()); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,176 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gammazero/nexus/v3/router"
+	"github.com/gammazero/nexus/v3/router/auth"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// RouterOptions configures the embedded router.
+type RouterOptions struct {
+	Host string
+	Port int
+
+	// Realms to serve, at least one is required.
+	Realms []string
+
+	// Anonymous allows clients to join without authenticating.
+	Anonymous bool
+
+	// Tickets maps an authid to the ticket it must present when using
+	// ticket authentication.
+	Tickets map[string]string
+
+	// Serializers accepted by the websocket transport, one of "json",
+	// "msgpack" and "cbor". Empty means all of them.
+	Serializers []string
+
+	// MetaKill enables the wamp.session.kill meta procedures.
+	MetaKill bool
+
+	// Compression accepts the permessage-deflate extension on websocket
+	// connections.
+	Compression bool
+}
+
+// ticketKeyStore is an in-memory auth.KeyStore for ticket authentication.
+type ticketKeyStore map[string]string
+
+func (ks ticketKeyStore) AuthKey(authid, authmethod string) ([]byte, error) {
+	ticket, ok := ks[authid]
+	if !ok || authmethod != "ticket" {
+		return nil, errors.New("no such user")
+	}
+	return []byte(ticket), nil
+}
+
+func (ks ticketKeyStore) PasswordInfo(string) (string, int, int) { return "", 0, 0 }
+
+func (ks ticketKeyStore) AuthRole(authid string) (string, error) {
+	if _, ok := ks[authid]; !ok {
+		return "", errors.New("no such user")
+	}
+	return "user", nil
+}
+
+func (ks ticketKeyStore) Provider() string { return "wick" }
+
+type routerCloser struct {
+	server *http.Server
+	router router.Router
+}
+
+func (c *routerCloser) Close() error {
+	err := c.server.Close()
+	c.router.Close()
+	return err
+}
+
+// StartRouter starts an in-process nexus router serving websocket
+// connections and returns once it is listening. Closing the returned closer
+// stops the router.
+func StartRouter(opts RouterOptions, logger *logrus.Logger) (io.Closer, error) {
+	if len(opts.Realms) == 0 {
+		return nil, errors.New("at least one realm is required")
+	}
+
+	var authenticators []auth.Authenticator
+	if len(opts.Tickets) > 0 {
+		authenticators = append(authenticators, auth.NewTicketAuthenticator(ticketKeyStore(opts.Tickets), time.Second))
+	}
+	if !opts.Anonymous && len(authenticators) == 0 {
+		return nil, errors.New("anonymous authentication is disabled but no ticket principals are configured")
+	}
+
+	config := &router.Config{}
+	for _, realm := range opts.Realms {
+		config.RealmConfigs = append(config.RealmConfigs, &router.RealmConfig{
+			URI:            wamp.URI(realm),
+			AnonymousAuth:  opts.Anonymous,
+			AllowDisclose:  true,
+			Authenticators: authenticators,
+			EnableMetaKill: opts.MetaKill,
+		})
+	}
+
+	nxr, err := router.NewRouter(config, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	wsServer := router.NewWebsocketServer(nxr)
+	wsServer.Upgrader.EnableCompression = opts.Compression
+	var handler http.Handler = wsServer
+	if len(opts.Serializers) > 0 {
+		allowed := map[string]bool{}
+		for _, serializer := range opts.Serializers {
+			allowed["wamp.2."+serializer] = true
+		}
+		// A client offering several serializers must not get one that is
+		// not enabled, so only the allowed ones are negotiated.
+		var subprotocols []string
+		for _, protocol := range wsServer.Upgrader.Subprotocols {
+			if allowed[protocol] {
+				subprotocols = append(subprotocols, protocol)
+			}
+		}
+		wsServer.Upgrader.Subprotocols = subprotocols
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+				for _, protocol := range strings.Split(header, ",") {
+					if allowed[strings.TrimSpace(protocol)] {
+						wsServer.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+			http.Error(w, "serializer not enabled on this router", http.StatusBadRequest)
+		})
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", opts.Host, opts.Port))
+	if err != nil {
+		nxr.Close()
+		return nil, err
+	}
+
+	server := &http.Server{Handler: handler}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Error("router stopped: ", err)
+		}
+	}()
+
+	return &routerCloser{server: server, router: nxr}, nil
+}
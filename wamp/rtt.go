@@ -0,0 +1,156 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// RTTOptions configure RTT.
+type RTTOptions struct {
+	// Count stops after this many calls, zero calls until CTRL-c.
+	Count int
+
+	// Interval is the time between the start of two calls.
+	Interval time.Duration
+
+	// Timeout fails a call that was not answered in time, zero waits
+	// forever.
+	Timeout time.Duration
+
+	// PayloadSize is the length of the string argument echoed back.
+	PayloadSize int
+}
+
+// RTT registers an echo procedure on callee and calls it from caller every
+// interval, printing the round trip through the router of each call to out
+// like ping(8), followed by a summary once the count is reached or on
+// CTRL-c. The time the echo handler took is measured by the callee and
+// subtracted, so only the router and the network are measured. The caller
+// and callee may be the same session. It returns an error, wrapping the last
+// call error, if no call succeeded.
+func RTT(caller *client.Client, callee *client.Client, logger *logrus.Logger, options RTTOptions,
+	out io.Writer) error {
+
+	if options.Interval <= 0 {
+		return errors.New("the interval must be positive")
+	}
+	procedure := fmt.Sprintf("wick.rtt.%v", callee.ID())
+	echo := func(ctx context.Context, invocation *wamp.Invocation) client.InvokeResult {
+		start := time.Now()
+		result := client.InvokeResult{Args: invocation.Arguments, Kwargs: wamp.Dict{}}
+		result.Kwargs["handled_ns"] = int64(time.Since(start))
+		return result
+	}
+	if err := callee.Register(procedure, echo, nil); err != nil {
+		return err
+	}
+	defer func() {
+		if err := callee.Unregister(procedure); err != nil {
+			logger.WithField("uri", procedure).Debug("failed to unregister echo procedure: ", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	payload := wamp.List{strings.Repeat("x", options.PayloadSize)}
+	fmt.Fprintf(out, "RTT %s from session %v to session %v\n", procedure, caller.ID(), callee.ID())
+	var rtts []time.Duration
+	var sent int
+	var lastErr error
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+	for seq := 1; options.Count == 0 || seq <= options.Count; seq++ {
+		rtt, handled, err := echoOnce(ctx, caller, procedure, payload, options.Timeout)
+		if ctx.Err() != nil {
+			break
+		}
+
+		sent++
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("no reply within %s: %w", options.Timeout, err)
+			}
+			fmt.Fprintf(out, "seq=%d error: %v\n", seq, err)
+			logger.WithFields(logrus.Fields{"uri": procedure, "seq": seq}).Debug("rtt call failed: ", err)
+			lastErr = err
+		} else {
+			fmt.Fprintf(out, "seq=%d rtt=%s ms callee=%s ms\n", seq, milliseconds(rtt), milliseconds(handled))
+			rtts = append(rtts, rtt)
+		}
+
+		if options.Count > 0 && seq == options.Count {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	printPingSummary(out, procedure+" rtt", sent, rtts)
+	if len(rtts) > 0 {
+		fmt.Fprintf(out, "rtt p50/p95/p99 = %s/%s/%s ms\n", milliseconds(percentile(rtts, 50)),
+			milliseconds(percentile(rtts, 95)), milliseconds(percentile(rtts, 99)))
+	}
+	if len(rtts) == 0 && lastErr != nil {
+		return fmt.Errorf("no replies from %s: %w", procedure, lastErr)
+	}
+	return nil
+}
+
+// echoOnce calls the echo procedure, within timeout if set, and returns the
+// round trip without the time the callee took, and that time.
+func echoOnce(ctx context.Context, session *client.Client, procedure string, payload wamp.List,
+	timeout time.Duration) (time.Duration, time.Duration, error) {
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	result, err := session.Call(ctx, procedure, nil, payload, nil, nil)
+	elapsed := time.Since(start)
+	if err != nil {
+		return elapsed, 0, err
+	}
+	handledNs, _ := wamp.AsInt64(result.ArgumentsKw["handled_ns"])
+	handled := time.Duration(handledNs)
+	return elapsed - handled, handled, nil
+}
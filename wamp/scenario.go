@@ -0,0 +1,322 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario is a declarative list of steps run against one or more sessions.
+type Scenario struct {
+	Sessions []ScenarioSession `yaml:"sessions"`
+	Steps    []ScenarioStep    `yaml:"steps"`
+}
+
+// ScenarioSession describes a session of a scenario. Empty URL and realm
+// fall back to the ones given on the command line.
+type ScenarioSession struct {
+	Name  string `yaml:"name"`
+	URL   string `yaml:"url"`
+	Realm string `yaml:"realm"`
+}
+
+// ScenarioPayload is the expected outcome of a call or event.
+type ScenarioPayload struct {
+	Args   []interface{}          `yaml:"args"`
+	Kwargs map[string]interface{} `yaml:"kwargs"`
+	Error  string                 `yaml:"error"`
+}
+
+// ScenarioStep is a single action of a scenario. Exactly one of the action
+// fields (Register, Subscribe, Publish, Call, ExpectEvent, Sleep) must be set.
+type ScenarioStep struct {
+	Session string        `yaml:"session"`
+	Delay   time.Duration `yaml:"delay"`
+
+	Register    string        `yaml:"register"`
+	Subscribe   string        `yaml:"subscribe"`
+	Publish     string        `yaml:"publish"`
+	Call        string        `yaml:"call"`
+	ExpectEvent string        `yaml:"expect-event"`
+	Sleep       time.Duration `yaml:"sleep"`
+
+	// Args and Kwargs are sent by publish and call, and returned by register.
+	// When register has neither, it echoes the invocation arguments.
+	Args   []interface{}          `yaml:"args"`
+	Kwargs map[string]interface{} `yaml:"kwargs"`
+	// Error makes a registered procedure fail with that error URI.
+	Error string `yaml:"error"`
+
+	// Expect is the result a call must return, or the payload the events of
+	// expect-event must carry.
+	Expect *ScenarioPayload `yaml:"expect"`
+	// Count is the number of events expect-event waits for, 1 by default.
+	// Only the events after the ones earlier expect-event steps of the
+	// subscription matched are counted.
+	Count int `yaml:"count"`
+	// Timeout bounds call and expect-event steps, 5 seconds by default.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// LoadScenario reads and parses a scenario file.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	scenario := &Scenario{}
+	if err = yaml.Unmarshal(data, scenario); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	if len(scenario.Sessions) == 0 {
+		scenario.Sessions = []ScenarioSession{{Name: "default"}}
+	}
+
+	return scenario, nil
+}
+
+type scenarioSubscription struct {
+	lock   sync.Mutex
+	events []*wamp.Event
+	// consumed is the number of events that earlier expect-event steps
+	// went through, which later ones do not count again.
+	consumed int
+	notify   chan struct{}
+}
+
+// RunScenario runs every step of the scenario in order, using connect to
+// join the sessions. It returns an error if any step fails, after all steps
+// have been run.
+func RunScenario(scenario *Scenario, connect func(url string, realm string) (*client.Client, error),
+	logger *logrus.Logger) error {
+
+	sessions := map[string]*client.Client{}
+	for _, s := range scenario.Sessions {
+		session, err := connect(s.URL, s.Realm)
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+		sessions[s.Name] = session
+	}
+
+	subscriptions := map[string]*scenarioSubscription{}
+	failures := 0
+
+	for i, step := range scenario.Steps {
+		if step.Delay > 0 {
+			time.Sleep(step.Delay)
+		}
+
+		sessionName := step.Session
+		if sessionName == "" {
+			sessionName = scenario.Sessions[0].Name
+		}
+		session, ok := sessions[sessionName]
+		if !ok {
+			return fmt.Errorf("step %d: unknown session '%s'", i+1, sessionName)
+		}
+
+		timeout := step.Timeout
+		if timeout == 0 {
+			timeout = 5 * time.Second
+		}
+
+		var description string
+		var err error
+		switch {
+		case step.Register != "":
+			description = fmt.Sprintf("register '%s'", step.Register)
+			err = session.Register(step.Register, scenarioHandler(step), nil)
+		case step.Subscribe != "":
+			description = fmt.Sprintf("subscribe '%s'", step.Subscribe)
+			sub := &scenarioSubscription{notify: make(chan struct{}, 1)}
+			subscriptions[sessionName+" "+step.Subscribe] = sub
+			err = session.Subscribe(step.Subscribe, func(event *wamp.Event) {
+				sub.lock.Lock()
+				sub.events = append(sub.events, event)
+				sub.lock.Unlock()
+				select {
+				case sub.notify <- struct{}{}:
+				default:
+				}
+			}, nil)
+		case step.Publish != "":
+			description = fmt.Sprintf("publish '%s'", step.Publish)
+			err = session.Publish(step.Publish, wamp.Dict{wamp.OptAcknowledge: true}, step.Args, step.Kwargs)
+		case step.Call != "":
+			description = fmt.Sprintf("call '%s'", step.Call)
+			err = scenarioCall(session, step, timeout)
+		case step.ExpectEvent != "":
+			description = fmt.Sprintf("expect event on '%s'", step.ExpectEvent)
+			sub, ok := subscriptions[sessionName+" "+step.ExpectEvent]
+			if !ok {
+				err = fmt.Errorf("session '%s' is not subscribed to '%s'", sessionName, step.ExpectEvent)
+			} else {
+				err = sub.wait(step, timeout)
+			}
+		case step.Sleep > 0:
+			description = fmt.Sprintf("sleep %s", step.Sleep)
+			time.Sleep(step.Sleep)
+		default:
+			return fmt.Errorf("step %d: no action given", i+1)
+		}
+
+		if err != nil {
+			failures++
+			fmt.Printf("FAIL  %d %s [%s]: %s\n", i+1, description, sessionName, err)
+		} else {
+			fmt.Printf("ok    %d %s [%s]\n", i+1, description, sessionName)
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d steps failed", failures, len(scenario.Steps))
+	}
+
+	logger.Debugf("all %d steps passed", len(scenario.Steps))
+	return nil
+}
+
+func scenarioHandler(step ScenarioStep) client.InvocationHandler {
+	return func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+		if step.Error != "" {
+			return client.InvokeResult{Err: wamp.URI(step.Error), Args: step.Args, Kwargs: step.Kwargs}
+		}
+		if step.Args == nil && step.Kwargs == nil {
+			return client.InvokeResult{Args: inv.Arguments, Kwargs: inv.ArgumentsKw}
+		}
+		return client.InvokeResult{Args: step.Args, Kwargs: step.Kwargs}
+	}
+}
+
+func scenarioCall(session *client.Client, step ScenarioStep, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	result, err := session.Call(ctx, step.Call, nil, step.Args, step.Kwargs, nil)
+	if step.Expect == nil {
+		return err
+	}
+
+	if step.Expect.Error != "" {
+		rpcErr, ok := err.(client.RPCError)
+		if !ok {
+			return fmt.Errorf("expected error '%s', got %v", step.Expect.Error, err)
+		}
+		if string(rpcErr.Err.Error) != step.Expect.Error {
+			return fmt.Errorf("expected error '%s', got '%s'", step.Expect.Error, rpcErr.Err.Error)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return step.Expect.match(result.Arguments, result.ArgumentsKw)
+}
+
+func (sub *scenarioSubscription) wait(step ScenarioStep, timeout time.Duration) error {
+	count := step.Count
+	if count == 0 {
+		count = 1
+	}
+
+	deadline := time.After(timeout)
+	for {
+		matched := 0
+		var lastErr error
+		sub.lock.Lock()
+		for i := sub.consumed; i < len(sub.events) && matched < count; i++ {
+			event := sub.events[i]
+			if step.Expect == nil {
+				matched++
+			} else if lastErr = step.Expect.match(event.Arguments, event.ArgumentsKw); lastErr == nil {
+				matched++
+			}
+			if matched == count {
+				sub.consumed = i + 1
+			}
+		}
+		sub.lock.Unlock()
+
+		if matched >= count {
+			return nil
+		}
+
+		select {
+		case <-sub.notify:
+		case <-deadline:
+			if lastErr != nil {
+				return fmt.Errorf("got %d of %d matching events, last mismatch: %s", matched, count, lastErr)
+			}
+			return fmt.Errorf("got %d of %d events within %s", matched, count, timeout)
+		}
+	}
+}
+
+// match checks args exactly and that every expected kwarg is present with
+// the expected value.
+func (p *ScenarioPayload) match(args wamp.List, kwargs wamp.Dict) error {
+	if args == nil {
+		args = wamp.List{}
+	}
+	if p.Args != nil && !jsonEqual(p.Args, args) {
+		return fmt.Errorf("expected args %s, got %s", jsonString(p.Args), jsonString(args))
+	}
+	for key, value := range p.Kwargs {
+		if !jsonEqual(value, kwargs[key]) {
+			return fmt.Errorf("expected kwarg %s=%s, got %s", key, jsonString(value), jsonString(kwargs[key]))
+		}
+	}
+	return nil
+}
+
+// jsonEqual compares two values after a round trip through JSON, so that the
+// types decoded from YAML and from the wire are comparable.
+func jsonEqual(a interface{}, b interface{}) bool {
+	var left, right interface{}
+	json.Unmarshal([]byte(jsonString(a)), &left)
+	json.Unmarshal([]byte(jsonString(b)), &right)
+	return reflect.DeepEqual(left, right)
+}
+
+func jsonString(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
@@ -0,0 +1,105 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Schema validates payloads against a JSON Schema. The schema describes the
+// payload as an object with an "args" array and a "kwargs" object, like the
+// JSON payloads of publish --from-stdin, e.g.
+//
+//	{"properties": {"args": {"items": {"type": "number"}}}, "required": ["args"]}
+type Schema struct {
+	path   string
+	schema *jsonschema.Schema
+}
+
+// LoadSchema compiles the JSON Schema file at path.
+func LoadSchema(path string) (*Schema, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	compiler := jsonschema.NewCompiler()
+	if err = compiler.AddResource(path, file); err != nil {
+		return nil, fmt.Errorf("invalid schema %s: %w", path, err)
+	}
+	schema, err := compiler.Compile(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema %s: %w", path, err)
+	}
+	return &Schema{path: path, schema: schema}, nil
+}
+
+// Validate returns an error naming the first offending value if args and
+// kwargs do not match the schema. A nil schema accepts everything.
+func (s *Schema) Validate(args wamp.List, kwargs wamp.Dict) error {
+	if s == nil {
+		return nil
+	}
+
+	// The validator only knows the types of decoded JSON, binary arguments
+	// are validated as base64 strings.
+	payload := wamp.Dict{"args": args, "kwargs": kwargs}
+	if args == nil {
+		payload["args"] = wamp.List{}
+	}
+	if kwargs == nil {
+		payload["kwargs"] = wamp.Dict{}
+	}
+	data, err := json.Marshal(printable(payload, BinaryFormatBase64))
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err = decoder.Decode(&value); err != nil {
+		return err
+	}
+
+	err = s.schema.Validate(value)
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return err
+	}
+	for len(validationErr.Causes) > 0 {
+		validationErr = validationErr.Causes[0]
+	}
+	location := validationErr.InstanceLocation
+	if location == "" {
+		location = "/"
+	}
+	return fmt.Errorf("payload does not match schema %s: %s: %s", s.path, location, validationErr.Message)
+}
@@ -0,0 +1,338 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/lib/json"
+	starlarkmath "go.starlark.net/lib/math"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+)
+
+// ScriptHandler is the function of a script that answers invocations.
+const ScriptHandler = "handle"
+
+// ErrScriptFailed answers invocations whose script failed without raising
+// a WAMP error.
+const ErrScriptFailed = wamp.URI("wick.error.script_failed")
+
+// Script is a Starlark file answering invocations in process, with its
+// handle(args, kwargs, details) function. What handle returns is the
+// argument of the result, unless it is a result(*args, **kwargs).
+// error(uri, *args, **kwargs) answers with a WAMP error instead.
+type Script struct {
+	path    string
+	handler starlark.Callable
+}
+
+// scriptBuiltins are predeclared in scripts.
+var scriptBuiltins = starlark.StringDict{
+	"json":   json.Module,
+	"math":   starlarkmath.Module,
+	"result": starlark.NewBuiltin("result", scriptResultBuiltin),
+	"error":  starlark.NewBuiltin("error", scriptErrorBuiltin),
+}
+
+func init() {
+	// Scripts are programs more than configs, they may loop with while and
+	// recursion and update their globals.
+	resolve.AllowRecursion = true
+	resolve.AllowGlobalReassign = true
+}
+
+// LoadScript runs the Starlark file at path, which must define a handle
+// function. Its print calls are logged to logger at debug level.
+func LoadScript(path string, logger *logrus.Logger) (*Script, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	thread := &starlark.Thread{Name: path, Print: scriptPrint(logger)}
+	globals, err := starlark.ExecFile(thread, path, src, scriptBuiltins)
+	if err != nil {
+		return nil, scriptError(err)
+	}
+	handler, ok := globals[ScriptHandler].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s: no %s(args, kwargs, details) function", path, ScriptHandler)
+	}
+	// Frozen, the globals can be shared by invocations running at once.
+	globals.Freeze()
+	return &Script{path: path, handler: handler}, nil
+}
+
+// Invoke calls the handle function with the arguments and details of an
+// invocation, canceled with ctx.
+func (s *Script) Invoke(ctx context.Context, args wamp.List, kwargs, details wamp.Dict,
+	logger *logrus.Logger) client.InvokeResult {
+
+	thread := &starlark.Thread{Name: s.path, Print: scriptPrint(logger)}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel(ctx.Err().Error())
+		case <-done:
+		}
+	}()
+
+	result, err := callHandler(thread, s.handler, args, kwargs, details)
+	if err != nil {
+		if ctx.Err() != nil {
+			return client.InvokeResult{Err: wamp.ErrCanceled}
+		}
+		logger.WithField("script", s.path).Error(err)
+	}
+	return result
+}
+
+// callHandler answers an invocation with handler, called on thread. The
+// error is why the handler failed, other than by raising a WAMP error.
+func callHandler(thread *starlark.Thread, handler starlark.Callable, args wamp.List,
+	kwargs, details wamp.Dict) (client.InvokeResult, error) {
+
+	handlerArgs := starlark.Tuple{toStarlark(args), toStarlark(kwargs), toStarlark(details)}
+	value, err := starlark.Call(thread, handler, handlerArgs, nil)
+	if err != nil {
+		var raised *scriptRaised
+		if errors.As(err, &raised) {
+			return client.InvokeResult{Err: raised.uri, Args: raised.args, Kwargs: raised.kwargs}, nil
+		}
+		err = scriptError(err)
+		return client.InvokeResult{Err: ErrScriptFailed, Args: wamp.List{err.Error()}}, err
+	}
+
+	if result, ok := value.(*scriptResult); ok {
+		return client.InvokeResult{Args: result.args, Kwargs: result.kwargs}, nil
+	}
+	if value == starlark.None {
+		return client.InvokeResult{}, nil
+	}
+	return client.InvokeResult{Args: wamp.List{fromStarlark(value)}}, nil
+}
+
+// scriptPrint logs the print calls of a script.
+func scriptPrint(logger *logrus.Logger) func(*starlark.Thread, string) {
+	return func(thread *starlark.Thread, msg string) {
+		logger.WithField("script", thread.Name).Debug(msg)
+	}
+}
+
+// scriptError adds the Starlark backtrace to err, if it has one.
+func scriptError(err error) error {
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return errors.New(evalErr.Backtrace())
+	}
+	return err
+}
+
+// scriptResult is what result() returns, the arguments of a result.
+type scriptResult struct {
+	args   wamp.List
+	kwargs wamp.Dict
+}
+
+func (r *scriptResult) String() string        { return fmt.Sprintf("result(%v, %v)", r.args, r.kwargs) }
+func (r *scriptResult) Type() string          { return "result" }
+func (r *scriptResult) Freeze()               {}
+func (r *scriptResult) Truth() starlark.Bool  { return starlark.True }
+func (r *scriptResult) Hash() (uint32, error) { return 0, errors.New("unhashable type: result") }
+
+// scriptRaised is the error raised by error(), the WAMP error to answer
+// with.
+type scriptRaised struct {
+	uri    wamp.URI
+	args   wamp.List
+	kwargs wamp.Dict
+}
+
+func (e *scriptRaised) Error() string {
+	return string(e.uri)
+}
+
+// scriptResultBuiltin is result(*args, **kwargs).
+func scriptResultBuiltin(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple,
+	kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	resultArgs, resultKwargs := fromStarlarkArgs(args, kwargs)
+	return &scriptResult{args: resultArgs, kwargs: resultKwargs}, nil
+}
+
+// scriptErrorBuiltin is error(uri, *args, **kwargs), which stops the script
+// to answer with the WAMP error.
+func scriptErrorBuiltin(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple,
+	kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: missing the error URI", fn.Name())
+	}
+	uri, ok := starlark.AsString(args[0])
+	if !ok || uri == "" {
+		return nil, fmt.Errorf("%s: the error URI must be a string, got %s", fn.Name(), args[0].Type())
+	}
+	errorArgs, errorKwargs := fromStarlarkArgs(args[1:], kwargs)
+	return nil, &scriptRaised{uri: wamp.URI(uri), args: errorArgs, kwargs: errorKwargs}
+}
+
+// fromStarlarkArgs returns the positional and keyword arguments of a
+// builtin as WAMP args and kwargs.
+func fromStarlarkArgs(args starlark.Tuple, kwargs []starlark.Tuple) (wamp.List, wamp.Dict) {
+	list := wamp.List{}
+	for _, arg := range args {
+		list = append(list, fromStarlark(arg))
+	}
+	dict := wamp.Dict{}
+	for _, kwarg := range kwargs {
+		name, _ := starlark.AsString(kwarg[0])
+		dict[name] = fromStarlark(kwarg[1])
+	}
+	return list, dict
+}
+
+// toStarlark returns the Starlark value of a decoded WAMP value, integers
+// as int, maps as dict, lists as list and binary as bytes.
+func toStarlark(value interface{}) starlark.Value {
+	switch v := value.(type) {
+	case nil:
+		return starlark.None
+	case bool:
+		return starlark.Bool(v)
+	case string:
+		return starlark.String(v)
+	case []byte:
+		return starlark.Bytes(v)
+	case int:
+		return starlark.MakeInt(v)
+	case int8:
+		return starlark.MakeInt64(int64(v))
+	case int16:
+		return starlark.MakeInt64(int64(v))
+	case int32:
+		return starlark.MakeInt64(int64(v))
+	case int64:
+		return starlark.MakeInt64(v)
+	case uint:
+		return starlark.MakeUint(v)
+	case uint8:
+		return starlark.MakeUint64(uint64(v))
+	case uint16:
+		return starlark.MakeUint64(uint64(v))
+	case uint32:
+		return starlark.MakeUint64(uint64(v))
+	case uint64:
+		return starlark.MakeUint64(v)
+	case float32:
+		return starlark.Float(v)
+	case float64:
+		return starlark.Float(v)
+	case wamp.List:
+		return toStarlark([]interface{}(v))
+	case []interface{}:
+		list := make([]starlark.Value, len(v))
+		for i, item := range v {
+			list[i] = toStarlark(item)
+		}
+		return starlark.NewList(list)
+	case wamp.Dict:
+		return toStarlark(map[string]interface{}(v))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		dict := starlark.NewDict(len(v))
+		for _, key := range keys {
+			_ = dict.SetKey(starlark.String(key), toStarlark(v[key]))
+		}
+		return dict
+	case map[interface{}]interface{}:
+		dict := starlark.NewDict(len(v))
+		for key, item := range v {
+			_ = dict.SetKey(starlark.String(fmt.Sprint(key)), toStarlark(item))
+		}
+		return dict
+	}
+	return starlark.String(fmt.Sprint(value))
+}
+
+// fromStarlark returns the WAMP value of a Starlark value, tuples as lists
+// and dict keys as strings.
+func fromStarlark(value starlark.Value) interface{} {
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil
+	case starlark.Bool:
+		return bool(v)
+	case starlark.String:
+		return string(v)
+	case starlark.Bytes:
+		return []byte(v)
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i
+		}
+		if u, ok := v.Uint64(); ok {
+			return u
+		}
+		return v.String()
+	case starlark.Float:
+		return float64(v)
+	case *starlark.List:
+		list := make(wamp.List, v.Len())
+		for i := range list {
+			list[i] = fromStarlark(v.Index(i))
+		}
+		return list
+	case starlark.Tuple:
+		list := make(wamp.List, len(v))
+		for i, item := range v {
+			list[i] = fromStarlark(item)
+		}
+		return list
+	case *starlark.Dict:
+		dict := wamp.Dict{}
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				key = item[0].String()
+			}
+			dict[key] = fromStarlark(item[1])
+		}
+		return dict
+	case *scriptResult:
+		return v.args
+	}
+	return value.String()
+}
@@ -0,0 +1,528 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// scriptTimeout bounds the calls and waits of scenario scripts that give
+// no timeout.
+const scriptTimeout = 5 * time.Second
+
+// scriptRunner runs a scenario script, holding lock while the script runs
+// so that the handlers of its registrations, which run as invocations
+// arrive, never run at the same time as the script. The script releases it
+// while it waits.
+type scriptRunner struct {
+	ctx     context.Context
+	path    string
+	connect func(url string, realm string) (*client.Client, error)
+	out     io.Writer
+	logger  *logrus.Logger
+
+	lock           sync.Mutex
+	sessions       []*client.Client
+	defaultSession starlark.Value
+	assertions     int
+	failures       int
+}
+
+// RunScript runs the Starlark scenario script at path, with argv as its
+// argv list and connect joining its sessions, until it ends or ctx is done.
+// The script calls, publishes, subscribes and registers with the builtins
+// of the same names, on the session of connect() or the default one, and
+// checks outcomes with the assert module. It returns an error if the script
+// failed or any assertion did not hold.
+func RunScript(ctx context.Context, path string, argv []string,
+	connect func(url string, realm string) (*client.Client, error), out io.Writer, logger *logrus.Logger) error {
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	r := &scriptRunner{ctx: ctx, path: path, connect: connect, out: output(out), logger: logger}
+	defer func() {
+		for _, session := range r.sessions {
+			session.Close()
+		}
+	}()
+
+	argList := make([]starlark.Value, len(argv))
+	for i, arg := range argv {
+		argList[i] = starlark.String(arg)
+	}
+	predeclared := starlark.StringDict{
+		"argv":    starlark.NewList(argList),
+		"connect": starlark.NewBuiltin("connect", r.connectBuiltin),
+		"sleep":   starlark.NewBuiltin("sleep", r.sleepBuiltin),
+		"assert":  r.assertModule(),
+	}
+	for name, value := range scriptBuiltins {
+		predeclared[name] = value
+	}
+	for _, name := range []string{"call", "publish", "subscribe", "register"} {
+		name := name
+		predeclared[name] = starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin,
+			args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+			session, err := r.defaultSessionValue()
+			if err != nil {
+				return nil, err
+			}
+			method, _ := session.(*starlarkstruct.Struct).Attr(name)
+			return starlark.Call(thread, method, args, kwargs)
+		})
+	}
+
+	thread := &starlark.Thread{Name: path, Print: func(thread *starlark.Thread, msg string) {
+		fmt.Fprintln(r.out, msg)
+	}}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel(ctx.Err().Error())
+		case <-done:
+		}
+	}()
+
+	r.lock.Lock()
+	_, err = starlark.ExecFile(thread, path, src, predeclared)
+	r.lock.Unlock()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return scriptError(err)
+	}
+
+	if r.failures > 0 {
+		return fmt.Errorf("%d of %d assertions failed", r.failures, r.assertions)
+	}
+	logger.Debugf("all %d assertions passed", r.assertions)
+	return nil
+}
+
+// waiting runs fn with the lock released, for handlers to run meanwhile.
+func (r *scriptRunner) waiting(fn func()) {
+	r.lock.Unlock()
+	defer r.lock.Lock()
+	fn()
+}
+
+// defaultSessionValue returns the session of the builtins called without
+// one, joined the first time.
+func (r *scriptRunner) defaultSessionValue() (starlark.Value, error) {
+	if r.defaultSession == nil {
+		session, err := r.join("", "")
+		if err != nil {
+			return nil, err
+		}
+		r.defaultSession = session
+	}
+	return r.defaultSession, nil
+}
+
+// connectBuiltin is connect(url="", realm=""), which joins a session, on
+// the router and realm of the command line if not given.
+func (r *scriptRunner) connectBuiltin(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple,
+	kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var url, realm string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "url?", &url, "realm?", &realm); err != nil {
+		return nil, err
+	}
+	return r.join(url, realm)
+}
+
+// join connects a session and returns it with its call, publish,
+// subscribe and register methods.
+func (r *scriptRunner) join(url string, realm string) (starlark.Value, error) {
+	var session *client.Client
+	var err error
+	r.waiting(func() { session, err = r.connect(url, realm) })
+	if err != nil {
+		return nil, err
+	}
+	r.sessions = append(r.sessions, session)
+
+	return starlarkstruct.FromStringDict(starlark.String("session"), starlark.StringDict{
+		"id":        starlark.MakeUint64(uint64(session.ID())),
+		"call":      starlark.NewBuiltin("call", r.callBuiltin(session)),
+		"publish":   starlark.NewBuiltin("publish", r.publishBuiltin(session)),
+		"subscribe": starlark.NewBuiltin("subscribe", r.subscribeBuiltin(session)),
+		"register":  starlark.NewBuiltin("register", r.registerBuiltin(session)),
+	}), nil
+}
+
+// builtinFunc is the function of a Starlark builtin.
+type builtinFunc func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple,
+	kwargs []starlark.Tuple) (starlark.Value, error)
+
+// scriptMessage holds the URI, WAMP arguments and keyword options of a
+// call, publish, subscribe or register builtin, the URI followed by the
+// arguments and the kwargs, options and timeout keywords.
+type scriptMessage struct {
+	uri     string
+	args    wamp.List
+	kwargs  wamp.Dict
+	options wamp.Dict
+	timeout time.Duration
+	handler starlark.Callable
+}
+
+// unpackMessage returns the message of the arguments given to fn, which
+// accepts the keywords in allowed.
+func unpackMessage(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple,
+	allowed ...string) (*scriptMessage, error) {
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: missing the URI", fn.Name())
+	}
+	uri, ok := starlark.AsString(args[0])
+	if !ok || uri == "" {
+		return nil, fmt.Errorf("%s: the URI must be a string, got %s", fn.Name(), args[0].Type())
+	}
+	message := &scriptMessage{uri: uri, args: wamp.List{}, kwargs: wamp.Dict{}, options: wamp.Dict{},
+		timeout: scriptTimeout}
+	for _, arg := range args[1:] {
+		message.args = append(message.args, fromStarlark(arg))
+	}
+
+	for _, kwarg := range kwargs {
+		name, _ := starlark.AsString(kwarg[0])
+		known := false
+		for _, keyword := range allowed {
+			known = known || keyword == name
+		}
+		if !known {
+			return nil, fmt.Errorf("%s: unexpected keyword argument %s", fn.Name(), name)
+		}
+
+		switch name {
+		case "kwargs", "options":
+			dict, ok := fromStarlark(kwarg[1]).(wamp.Dict)
+			if !ok {
+				return nil, fmt.Errorf("%s: %s must be a dict, got %s", fn.Name(), name, kwarg[1].Type())
+			}
+			if name == "kwargs" {
+				message.kwargs = dict
+			} else {
+				message.options = dict
+			}
+		case "timeout":
+			seconds, ok := starlark.AsFloat(kwarg[1])
+			if !ok {
+				return nil, fmt.Errorf("%s: timeout must be a number of seconds, got %s", fn.Name(),
+					kwarg[1].Type())
+			}
+			message.timeout = time.Duration(seconds * float64(time.Second))
+		case "handler":
+			if message.handler, ok = kwarg[1].(starlark.Callable); !ok {
+				return nil, fmt.Errorf("%s: handler must be a function, got %s", fn.Name(), kwarg[1].Type())
+			}
+		}
+	}
+	return message, nil
+}
+
+// callBuiltin is call(procedure, *args, kwargs={}, options={}, timeout=5),
+// which returns the result as a struct of args, kwargs and error, the URI
+// of the WAMP error the call failed with or None.
+func (r *scriptRunner) callBuiltin(session *client.Client) builtinFunc {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple,
+		kwargs []starlark.Tuple) (starlark.Value, error) {
+
+		message, err := unpackMessage(fn, args, kwargs, "kwargs", "options", "timeout")
+		if err != nil {
+			return nil, err
+		}
+
+		var result *wamp.Result
+		r.waiting(func() {
+			ctx, cancel := context.WithTimeout(r.ctx, message.timeout)
+			defer cancel()
+			result, err = session.Call(ctx, message.uri, message.options, message.args, message.kwargs, nil)
+		})
+		var rpcErr client.RPCError
+		if errors.As(err, &rpcErr) {
+			return scriptPayload(rpcErr.Err.Arguments, rpcErr.Err.ArgumentsKw,
+				starlark.String(rpcErr.Err.Error)), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", fn.Name(), message.uri, err)
+		}
+		return scriptPayload(result.Arguments, result.ArgumentsKw, starlark.None), nil
+	}
+}
+
+// publishBuiltin is publish(topic, *args, kwargs={}, options={}), which
+// waits for the router to acknowledge the event.
+func (r *scriptRunner) publishBuiltin(session *client.Client) builtinFunc {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple,
+		kwargs []starlark.Tuple) (starlark.Value, error) {
+
+		message, err := unpackMessage(fn, args, kwargs, "kwargs", "options")
+		if err != nil {
+			return nil, err
+		}
+		options := withOptions(message.options, wamp.Dict{wamp.OptAcknowledge: true})
+		r.waiting(func() {
+			err = session.Publish(message.uri, options, message.args, message.kwargs)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", fn.Name(), message.uri, err)
+		}
+		return starlark.None, nil
+	}
+}
+
+// subscribeBuiltin is subscribe(topic, options={}), which returns the
+// subscription, whose wait(count=1, timeout=5) returns the events received
+// once there are count of them or the timeout passed, as structs of args
+// and kwargs.
+func (r *scriptRunner) subscribeBuiltin(session *client.Client) builtinFunc {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple,
+		kwargs []starlark.Tuple) (starlark.Value, error) {
+
+		message, err := unpackMessage(fn, args, kwargs, "options")
+		if err != nil {
+			return nil, err
+		}
+		if len(message.args) > 0 {
+			return nil, fmt.Errorf("%s: takes only the topic", fn.Name())
+		}
+
+		// Events are handled on the loop of the client, they only queue.
+		sub := &scenarioSubscription{notify: make(chan struct{}, 1)}
+		r.waiting(func() {
+			err = session.Subscribe(message.uri, func(event *wamp.Event) {
+				sub.lock.Lock()
+				sub.events = append(sub.events, event)
+				sub.lock.Unlock()
+				select {
+				case sub.notify <- struct{}{}:
+				default:
+				}
+			}, message.options)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", fn.Name(), message.uri, err)
+		}
+
+		wait := starlark.NewBuiltin("wait", func(thread *starlark.Thread, fn *starlark.Builtin,
+			args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+			count := 1
+			timeout := starlark.Value(starlark.MakeInt(int(scriptTimeout / time.Second)))
+			if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "count?", &count,
+				"timeout?", &timeout); err != nil {
+				return nil, err
+			}
+			seconds, ok := starlark.AsFloat(timeout)
+			if !ok {
+				return nil, fmt.Errorf("%s: timeout must be a number of seconds, got %s", fn.Name(),
+					timeout.Type())
+			}
+			var events []*wamp.Event
+			r.waiting(func() { events = sub.waitFor(r.ctx, count, time.Duration(seconds*float64(time.Second))) })
+
+			list := make([]starlark.Value, len(events))
+			for i, event := range events {
+				list[i] = scriptPayload(event.Arguments, event.ArgumentsKw, nil)
+			}
+			return starlark.NewList(list), nil
+		})
+		return starlarkstruct.FromStringDict(starlark.String("subscription"), starlark.StringDict{
+			"topic": starlark.String(message.uri),
+			"wait":  wait,
+		}), nil
+	}
+}
+
+// registerBuiltin is register(procedure, handler, options={}), which
+// answers the invocations with handler, called like the handle function of
+// register --script.
+func (r *scriptRunner) registerBuiltin(session *client.Client) builtinFunc {
+	return func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple,
+		kwargs []starlark.Tuple) (starlark.Value, error) {
+
+		// The handler may also be given second.
+		if len(args) == 2 {
+			if handler, ok := args[1].(starlark.Callable); ok {
+				args = args[:1]
+				kwargs = append(kwargs, starlark.Tuple{starlark.String("handler"), handler})
+			}
+		}
+		message, err := unpackMessage(fn, args, kwargs, "handler", "options")
+		if err != nil {
+			return nil, err
+		}
+		if message.handler == nil || len(message.args) > 0 {
+			return nil, fmt.Errorf("%s: takes the procedure and a handler(args, kwargs, details)", fn.Name())
+		}
+
+		handler := func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+			r.lock.Lock()
+			defer r.lock.Unlock()
+			handlerThread := &starlark.Thread{Name: r.path, Print: thread.Print}
+			result, err := callHandler(handlerThread, message.handler, inv.Arguments, inv.ArgumentsKw,
+				inv.Details)
+			if err != nil {
+				r.logger.WithField("uri", message.uri).Error(err)
+			}
+			return result
+		}
+		r.waiting(func() { err = session.Register(message.uri, handler, message.options) })
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", fn.Name(), message.uri, err)
+		}
+		return starlark.None, nil
+	}
+}
+
+// sleepBuiltin is sleep(seconds).
+func (r *scriptRunner) sleepBuiltin(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple,
+	kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var value starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &value); err != nil {
+		return nil, err
+	}
+	seconds, ok := starlark.AsFloat(value)
+	if !ok {
+		return nil, fmt.Errorf("%s: want a number of seconds, got %s", fn.Name(), value.Type())
+	}
+	r.waiting(func() {
+		select {
+		case <-time.After(time.Duration(seconds * float64(time.Second))):
+		case <-r.ctx.Done():
+		}
+	})
+	return starlark.None, r.ctx.Err()
+}
+
+// assertModule returns the assert module, whose eq(a, b), ne(a, b) and
+// true(cond) print the assertions that do not hold, with an optional
+// message, and let the script go on.
+func (r *scriptRunner) assertModule() *starlarkstruct.Module {
+	check := func(name string, holds func(a, b starlark.Value) (bool, string, error), operands int) starlark.Value {
+		return starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin,
+			args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+
+			values := make([]starlark.Value, 2)
+			var msg string
+			pairs := []interface{}{"a", &values[0]}
+			if operands == 2 {
+				pairs = append(pairs, "b", &values[1])
+			}
+			pairs = append(pairs, "msg?", &msg)
+			if err := starlark.UnpackArgs("assert."+name, args, kwargs, pairs...); err != nil {
+				return nil, err
+			}
+
+			ok, failure, err := holds(values[0], values[1])
+			if err != nil {
+				return nil, err
+			}
+			r.assertions++
+			position := thread.CallFrame(1).Pos
+			if ok {
+				r.logger.Debugf("ok    %s", position)
+				return starlark.None, nil
+			}
+			r.failures++
+			if msg != "" {
+				failure = msg + ": " + failure
+			}
+			fmt.Fprintf(r.out, "FAIL  %s: %s\n", position, failure)
+			return starlark.None, nil
+		})
+	}
+
+	return &starlarkstruct.Module{Name: "assert", Members: starlark.StringDict{
+		"eq": check("eq", func(a, b starlark.Value) (bool, string, error) {
+			ok, err := starlark.Equal(a, b)
+			return ok, fmt.Sprintf("%s != %s", a, b), err
+		}, 2),
+		"ne": check("ne", func(a, b starlark.Value) (bool, string, error) {
+			ok, err := starlark.Equal(a, b)
+			return !ok, fmt.Sprintf("%s == %s", a, b), err
+		}, 2),
+		"true": check("true", func(a, _ starlark.Value) (bool, string, error) {
+			return bool(a.Truth()), fmt.Sprintf("%s is not true", a), nil
+		}, 1),
+	}}
+}
+
+// scriptPayload returns args and kwargs as a struct, with the error of a
+// call result unless nil.
+func scriptPayload(args wamp.List, kwargs wamp.Dict, callErr starlark.Value) starlark.Value {
+	if args == nil {
+		args = wamp.List{}
+	}
+	if kwargs == nil {
+		kwargs = wamp.Dict{}
+	}
+	fields := starlark.StringDict{"args": toStarlark(args), "kwargs": toStarlark(kwargs)}
+	if callErr != nil {
+		fields["error"] = callErr
+	}
+	return starlarkstruct.FromStringDict(starlark.String("payload"), fields)
+}
+
+// waitFor returns the events received once there are count of them, or
+// after timeout or when ctx is done.
+func (sub *scenarioSubscription) waitFor(ctx context.Context, count int, timeout time.Duration) []*wamp.Event {
+	deadline := time.After(timeout)
+	for {
+		sub.lock.Lock()
+		events := append([]*wamp.Event{}, sub.events...)
+		sub.lock.Unlock()
+		if len(events) >= count {
+			return events
+		}
+
+		select {
+		case <-sub.notify:
+		case <-deadline:
+			return events
+		case <-ctx.Done():
+			return events
+		}
+	}
+}
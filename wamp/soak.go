@@ -0,0 +1,337 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// SoakOptions configure a soak test.
+type SoakOptions struct {
+	// Duration stops the test, zero runs until CTRL-c or SIGTERM.
+	Duration time.Duration
+
+	// Sessions is the number of sessions, each registering a procedure
+	// called by the next one and subscribing to the topic all publish to.
+	Sessions int
+
+	// Interval is the time between two calls and publishes of a session.
+	Interval time.Duration
+
+	// ReportInterval is the time between two reports.
+	ReportInterval time.Duration
+
+	// URIPrefix starts the URIs of the procedures and the topic.
+	URIPrefix string
+
+	// Timeout fails a call that was not answered in time.
+	Timeout time.Duration
+}
+
+// SoakStats are the totals of a soak test, its fields must only be read once
+// the test is done.
+type SoakStats struct {
+	Calls         int
+	CallErrors    int
+	Publishes     int
+	PublishErrors int
+	Events        int
+	Invocations   int
+
+	// Reconnects counts the lost sessions joined again.
+	Reconnects int
+
+	// ErrorURIs counts the failed calls and publishes by WAMP error URI,
+	// the ones without an URI by error message.
+	ErrorURIs map[string]int
+
+	lock sync.Mutex
+
+	// latencies holds the call latencies since the last report.
+	latencies []time.Duration
+}
+
+// errors returns the number of failed calls and publishes.
+func (s *SoakStats) errors() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.CallErrors + s.PublishErrors
+}
+
+func (s *SoakStats) count(fn func(s *SoakStats)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fn(s)
+}
+
+func (s *SoakStats) failed(err error) {
+	key := ErrorURI(err)
+	if key == "" {
+		key = err.Error()
+	}
+	s.ErrorURIs[key]++
+}
+
+// fields returns the totals and the memory use of wick as log fields, and
+// starts a new window of call latencies.
+func (s *SoakStats) fields(start time.Time) logrus.Fields {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	fields := logrus.Fields{
+		"uptime":         time.Since(start).Round(time.Second).String(),
+		"calls":          s.Calls,
+		"call_errors":    s.CallErrors,
+		"call_p95":       percentile(s.latencies, 95).String(),
+		"publishes":      s.Publishes,
+		"publish_errors": s.PublishErrors,
+		"events":         s.Events,
+		"invocations":    s.Invocations,
+		"reconnects":     s.Reconnects,
+		"goroutines":     runtime.NumGoroutine(),
+		"heap_alloc_mb":  fmt.Sprintf("%.1f", float64(memory.HeapAlloc)/(1<<20)),
+		"heap_objects":   memory.HeapObjects,
+		"sys_mb":         fmt.Sprintf("%.1f", float64(memory.Sys)/(1<<20)),
+	}
+	if len(s.ErrorURIs) > 0 {
+		fields["error_uris"] = formatCounts(s.ErrorURIs)
+	}
+	s.latencies = nil
+	return fields
+}
+
+// Soak runs a soak test: options.Sessions sessions stay joined, each
+// registering a procedure and subscribing to a topic, and every interval
+// each calls the procedure of the next session and publishes to the topic.
+// Lost sessions are joined again with connect. The totals and the memory
+// use of wick are logged every report interval, to catch leaks over long
+// runs. It returns the totals, and an error if any call or publish failed.
+func Soak(connect func() (*client.Client, error), logger *logrus.Logger, options SoakOptions) (*SoakStats,
+	error) {
+
+	if options.Sessions < 1 {
+		return nil, fmt.Errorf("invalid number of soak sessions: %d", options.Sessions)
+	}
+	if options.Interval <= 0 {
+		return nil, errors.New("the interval must be positive")
+	}
+	if options.ReportInterval <= 0 {
+		return nil, errors.New("the report interval must be positive")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if options.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Duration)
+		defer cancel()
+	}
+
+	// All sessions join before the first call, so that every procedure is
+	// registered by then.
+	stats := &SoakStats{ErrorURIs: map[string]int{}}
+	soakSessions := make([]*soakSession, options.Sessions)
+	joined := make([]*client.Client, options.Sessions)
+	for i := range soakSessions {
+		soakSessions[i] = &soakSession{
+			index:   i,
+			connect: connect,
+			logger:  logger,
+			options: options,
+			stats:   stats,
+		}
+		session, err := soakSessions[i].join()
+		if err != nil {
+			for _, session := range joined[:i] {
+				session.Close()
+			}
+			return nil, err
+		}
+		joined[i] = session
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i, s := range soakSessions {
+		wg.Add(1)
+		go func(s *soakSession, session *client.Client) {
+			defer wg.Done()
+			s.run(ctx, session)
+		}(s, joined[i])
+	}
+
+	ticker := time.NewTicker(options.ReportInterval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+			logger.WithFields(stats.fields(start)).Info("soak report")
+		}
+	}
+	wg.Wait()
+	logger.WithFields(stats.fields(start)).Info("soak done")
+
+	if failures := stats.errors(); failures > 0 {
+		return stats, fmt.Errorf("%d calls and publishes failed during the soak test", failures)
+	}
+	return stats, nil
+}
+
+// soakSession is one session of a soak test, joined again whenever lost.
+type soakSession struct {
+	index   int
+	connect func() (*client.Client, error)
+	logger  *logrus.Logger
+	options SoakOptions
+	stats   *SoakStats
+}
+
+func (s *soakSession) procedure(index int) string {
+	return fmt.Sprintf("%sproc.%d", s.options.URIPrefix, index%s.options.Sessions)
+}
+
+func (s *soakSession) topic() string {
+	return s.options.URIPrefix + "topic"
+}
+
+// run keeps session busy until ctx is done, joining again whenever lost.
+func (s *soakSession) run(ctx context.Context, session *client.Client) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		if session == nil {
+			var err error
+			if session, err = s.join(); err != nil {
+				s.logger.WithField("session", s.index).Warnf("Failed to join, retrying in %s: %v", backoff, err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(backoff):
+				}
+				if backoff *= 2; backoff > 30*time.Second {
+					backoff = 30 * time.Second
+				}
+				continue
+			}
+			backoff = time.Second
+			s.stats.count(func(s *SoakStats) { s.Reconnects++ })
+		}
+
+		s.work(ctx, session)
+		session.Close()
+		session = nil
+		if ctx.Err() == nil {
+			s.logger.WithField("session", s.index).Warn("Session lost, joining again")
+		}
+	}
+}
+
+// join joins a session and sets up its registration and subscription.
+func (s *soakSession) join() (*client.Client, error) {
+	session, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	invoked := func(ctx context.Context, invocation *wamp.Invocation) client.InvokeResult {
+		s.stats.count(func(s *SoakStats) { s.Invocations++ })
+		return client.InvokeResult{Args: invocation.Arguments}
+	}
+	if err = session.Register(s.procedure(s.index), invoked, nil); err != nil {
+		session.Close()
+		return nil, err
+	}
+	received := func(event *wamp.Event) {
+		s.stats.count(func(s *SoakStats) { s.Events++ })
+	}
+	if err = session.Subscribe(s.topic(), received, nil); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// work calls and publishes every interval until ctx is done or the session
+// is lost.
+func (s *soakSession) work(ctx context.Context, session *client.Client) {
+	ticker := time.NewTicker(s.options.Interval)
+	defer ticker.Stop()
+
+	publishOptions := wamp.Dict{wamp.OptAcknowledge: true, wamp.OptExcludeMe: false}
+	for seq := 1; ; seq++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-session.Done():
+			return
+		case <-ticker.C:
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, s.options.Timeout)
+		callStart := time.Now()
+		_, err := session.Call(callCtx, s.procedure(s.index+1), nil, wamp.List{seq}, nil, nil)
+		latency := time.Since(callStart)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		s.stats.count(func(stats *SoakStats) {
+			stats.Calls++
+			if err != nil {
+				stats.CallErrors++
+				stats.failed(err)
+			} else {
+				stats.latencies = append(stats.latencies, latency)
+			}
+		})
+		if err != nil {
+			s.logger.WithFields(logrus.Fields{"session": s.index, "uri": s.procedure(s.index + 1)}).
+				Debug("soak call failed: ", err)
+		}
+
+		err = session.Publish(s.topic(), publishOptions, wamp.List{seq}, nil)
+		s.stats.count(func(stats *SoakStats) {
+			stats.Publishes++
+			if err != nil {
+				stats.PublishErrors++
+				stats.failed(err)
+			}
+		})
+		if err != nil {
+			s.logger.WithFields(logrus.Fields{"session": s.index, "uri": s.topic()}).
+				Debug("soak publish failed: ", err)
+		}
+	}
+}
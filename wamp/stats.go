@@ -0,0 +1,580 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// latencyBounds are the upper bounds of the latency histogram buckets, from
+// 100µs doubling up to about 52s.
+var latencyBounds = func() []time.Duration {
+	bounds := make([]time.Duration, 20)
+	for i := range bounds {
+		bounds[i] = 100 * time.Microsecond << i
+	}
+	return bounds
+}()
+
+// reservoirSize is the number of latencies kept for exact percentiles,
+// runs with more operations keep a uniform sample of them.
+const reservoirSize = 10000
+
+// Stats counts the operations of a repeated call or publish, which may run
+// from several sessions at once. Its fields must only be read once the run
+// is done.
+type Stats struct {
+	Start    time.Time
+	Duration time.Duration
+	Ops      int
+	Errors   int
+
+	// ErrorURIs counts the failed operations by WAMP error URI, the ones
+	// without an URI by error message.
+	ErrorURIs map[string]int
+
+	// Latency holds the latencies of all operations.
+	Latency LatencyHistogram
+
+	// Acks holds the times acknowledged publishes took to be acknowledged
+	// by the router, it is empty for calls and unacknowledged publishes.
+	Acks LatencyHistogram
+
+	// Sessions holds the stats of every session by session ID, when the
+	// run was on several sessions. Their Join is set by the caller, if
+	// measured.
+	Sessions map[wamp.ID]*Stats
+	Join     time.Duration
+
+	// KeepSamples keeps every operation of the run in Samples, for reports
+	// of the samples.
+	KeepSamples bool
+	Samples     []Sample
+
+	lock sync.Mutex
+
+	// window holds the latencies since the last progress line, for the
+	// rolling p95 and the current rate, while progress is reported.
+	reporting bool
+	window    []time.Duration
+
+	// seconds sums the latencies of every second of the run, for the
+	// sparkline of the summary.
+	seconds []latencySum
+
+	// reservoir samples the latencies of the run, for percentiles finer
+	// than the histogram buckets.
+	reservoir []time.Duration
+
+	// merged is set once worker stats were merged in.
+	merged bool
+}
+
+// latencySum adds up latencies, to average them.
+type latencySum struct {
+	sum   time.Duration
+	count int
+}
+
+// LatencyHistogram counts latencies in exponential buckets.
+type LatencyHistogram struct {
+	// Bounds are the upper bounds of the buckets. Counts[i] is the number
+	// of latencies above Bounds[i-1] up to Bounds[i], the last count is
+	// the number above the last bound.
+	Bounds []time.Duration
+	Counts []int
+
+	Min time.Duration
+	Max time.Duration
+	Sum time.Duration
+}
+
+// NewStats returns empty stats started now.
+func NewStats() *Stats {
+	return &Stats{
+		Start:     time.Now(),
+		ErrorURIs: map[string]int{},
+		Latency:   newLatencyHistogram(),
+		Acks:      newLatencyHistogram(),
+	}
+}
+
+func newLatencyHistogram() LatencyHistogram {
+	return LatencyHistogram{
+		Bounds: latencyBounds,
+		Counts: make([]int, len(latencyBounds)+1),
+	}
+}
+
+// Record counts an operation that took latency and failed with err, if not
+// nil.
+func (s *Stats) Record(latency time.Duration, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.Ops++
+	if err != nil {
+		s.Errors++
+		key := ErrorURI(err)
+		if key == "" {
+			key = err.Error()
+		}
+		s.ErrorURIs[key]++
+	}
+	s.Latency.add(latency)
+	if s.reporting {
+		s.window = append(s.window, latency)
+	}
+	if len(s.reservoir) < reservoirSize {
+		s.reservoir = append(s.reservoir, latency)
+	} else if i := rand.Intn(s.Ops); i < reservoirSize {
+		s.reservoir[i] = latency
+	}
+
+	second := int(time.Since(s.Start) / time.Second)
+	for len(s.seconds) <= second {
+		s.seconds = append(s.seconds, latencySum{})
+	}
+	s.seconds[second].sum += latency
+	s.seconds[second].count++
+}
+
+// LatencyPercentile returns the p-th percentile latency of the run, exact
+// up to reservoirSize operations and estimated from a uniform sample of them
+// beyond.
+func (s *Stats) LatencyPercentile(p int) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return percentile(s.reservoir, p)
+}
+
+// meanBySecond returns the mean latency of every second of the run in which
+// operations were done.
+func (s *Stats) meanBySecond() []time.Duration {
+	var means []time.Duration
+	for _, second := range s.seconds {
+		if second.count > 0 {
+			means = append(means, second.sum/time.Duration(second.count))
+		}
+	}
+	return means
+}
+
+// recordSample keeps the operation that started at start on session, if
+// samples are kept.
+func (s *Stats) recordSample(start time.Time, session wamp.ID, latency time.Duration, err error) {
+	if !s.KeepSamples {
+		return
+	}
+	sample := Sample{Start: start, Session: session, Latency: latency}
+	if err != nil {
+		if sample.Error = ErrorURI(err); sample.Error == "" {
+			sample.Error = err.Error()
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Samples = append(s.Samples, sample)
+}
+
+// RecordAck counts a publish the router acknowledged after latency.
+func (s *Stats) RecordAck(latency time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Acks.add(latency)
+}
+
+// done sets the duration of the run.
+func (s *Stats) done() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Duration = time.Since(s.Start)
+	for _, sessionStats := range s.Sessions {
+		sessionStats.Duration = s.Duration
+	}
+}
+
+// SessionSummary returns the line of the stats of one session in the
+// summary of a run on several sessions.
+func (s *Stats) SessionSummary() string {
+	summary := fmt.Sprintf("ops %d  errors %d  p50 %s  p95 %s  max %s", s.Ops, s.Errors,
+		s.Latency.Percentile(50).Round(time.Microsecond), s.Latency.Percentile(95).Round(time.Microsecond),
+		s.Latency.Max.Round(time.Microsecond))
+	if s.Join > 0 {
+		summary = fmt.Sprintf("joined in %s  ", s.Join.Round(time.Microsecond)) + summary
+	}
+	return summary
+}
+
+// Rate returns the operations per second of the run.
+func (s *Stats) Rate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Ops) / s.Duration.Seconds()
+}
+
+// progress returns a progress line and starts a new window.
+func (s *Stats) progress(elapsed time.Duration) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	window := s.window
+	s.window = nil
+	rate := float64(len(window)) / elapsed.Seconds()
+	return fmt.Sprintf("ops %d  rate %.0f/s  errors %d  p95 %s", s.Ops, rate, s.Errors, percentile(window, 95))
+}
+
+// Summary returns the line printed once the run is done, with the errors by
+// URI if any, followed by a histogram of the latencies and a sparkline of
+// their mean every second, then the distribution of the acknowledgment
+// latencies if publishes were acknowledged.
+func (s *Stats) Summary() string {
+	summary := fmt.Sprintf("done: ops %d in %s  rate %.0f/s  errors %d", s.Ops, s.Duration.Round(time.Millisecond),
+		s.Rate(), s.Errors)
+	if len(s.ErrorURIs) > 0 {
+		summary += " (" + s.errorCounts() + ")"
+	}
+	if s.Latency.Total() > 1 {
+		summary += "\nlatency:\n" + s.Latency.render()
+	}
+	if line := sparkline(s.meanBySecond()); line != "" {
+		summary += "\nlatency per second: " + line
+	}
+	if s.Acks.Total() > 0 {
+		h := s.Acks
+		summary += fmt.Sprintf("\nacks: %d  min %s  p50 %s  p90 %s  p99 %s  max %s", h.Total(),
+			h.Min.Round(time.Microsecond), h.Percentile(50).Round(time.Microsecond),
+			h.Percentile(90).Round(time.Microsecond), h.Percentile(99).Round(time.Microsecond),
+			h.Max.Round(time.Microsecond))
+	}
+	return summary
+}
+
+// errorCounts returns the failed operations by URI, like
+// "wamp.error.timeout 2, wamp.error.canceled 1".
+func (s *Stats) errorCounts() string {
+	keys := make([]string, 0, len(s.ErrorURIs))
+	for key := range s.ErrorURIs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	counts := make([]string, len(keys))
+	for i, key := range keys {
+		counts[i] = fmt.Sprintf("%s %d", key, s.ErrorURIs[key])
+	}
+	return strings.Join(counts, ", ")
+}
+
+// Report writes a progress line to out every interval until the returned
+// function is called.
+func (s *Stats) Report(out io.Writer, interval time.Duration) func() {
+	s.lock.Lock()
+	s.reporting = true
+	s.lock.Unlock()
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				fmt.Fprintln(out, s.progress(now.Sub(last)))
+				last = now
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// MarshalJSON returns the stats with durations in seconds, the
+// acknowledgment latencies only if publishes were acknowledged.
+func (s *Stats) MarshalJSON() ([]byte, error) {
+	stats := map[string]interface{}{
+		"start":            s.Start,
+		"duration_seconds": s.Duration.Seconds(),
+		"ops":              s.Ops,
+		"errors":           s.Errors,
+		"error_uris":       s.ErrorURIs,
+		"rate":             s.Rate(),
+		"latency_seconds":  s.Latency.seconds(),
+	}
+	if s.Acks.Total() > 0 {
+		stats["ack_latency_seconds"] = s.Acks.seconds()
+	}
+	if s.Join > 0 {
+		stats["join_seconds"] = s.Join.Seconds()
+	}
+	if len(s.Sessions) > 0 {
+		sessions := make(map[string]*Stats, len(s.Sessions))
+		for id, sessionStats := range s.Sessions {
+			sessions[fmt.Sprint(id)] = sessionStats
+		}
+		stats["sessions"] = sessions
+	}
+	return json.Marshal(stats)
+}
+
+// seconds returns the histogram and its percentiles in seconds, for JSON.
+func (h LatencyHistogram) seconds() map[string]interface{} {
+	type bucket struct {
+		LE    float64 `json:"le"`
+		Count int     `json:"count"`
+	}
+	var buckets []bucket
+	for i, count := range h.Counts {
+		if count == 0 {
+			continue
+		}
+		le := -1.0 // above the last bound
+		if i < len(h.Bounds) {
+			le = h.Bounds[i].Seconds()
+		}
+		buckets = append(buckets, bucket{LE: le, Count: count})
+	}
+
+	return map[string]interface{}{
+		"min":     h.Min.Seconds(),
+		"max":     h.Max.Seconds(),
+		"mean":    h.Mean().Seconds(),
+		"p50":     h.Percentile(50).Seconds(),
+		"p90":     h.Percentile(90).Seconds(),
+		"p95":     h.Percentile(95).Seconds(),
+		"p99":     h.Percentile(99).Seconds(),
+		"buckets": buckets,
+	}
+}
+
+func (h *LatencyHistogram) add(latency time.Duration) {
+	if h.Total() == 0 || latency < h.Min {
+		h.Min = latency
+	}
+	if latency > h.Max {
+		h.Max = latency
+	}
+	h.Sum += latency
+	h.Counts[sort.Search(len(h.Bounds), func(i int) bool { return h.Bounds[i] >= latency })]++
+}
+
+// Total returns the number of latencies counted.
+func (h LatencyHistogram) Total() int {
+	total := 0
+	for _, count := range h.Counts {
+		total += count
+	}
+	return total
+}
+
+// Mean returns the mean latency, zero if none was counted.
+func (h LatencyHistogram) Mean() time.Duration {
+	total := h.Total()
+	if total == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(total)
+}
+
+// Percentile returns an estimate of the p-th percentile latency, the upper
+// bound of the bucket it falls in, but at most Max.
+func (h LatencyHistogram) Percentile(p int) time.Duration {
+	total := h.Total()
+	if total == 0 {
+		return 0
+	}
+	rank := (total*p + 99) / 100
+	seen := 0
+	for i, count := range h.Counts {
+		if seen += count; seen < rank {
+			continue
+		}
+		if i < len(h.Bounds) && h.Bounds[i] < h.Max {
+			return h.Bounds[i]
+		}
+		break
+	}
+	return h.Max
+}
+
+// percentile returns the p-th percentile of latencies, zero if empty.
+func percentile(latencies []time.Duration, p int) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	index := (len(sorted)*p+99)/100 - 1
+	return sorted[index].Round(time.Microsecond)
+}
+
+// Repeat runs fn count times on every session at once, or until ctx is
+// done, with up to concurrency runs in flight on every session, recording
+// every run in stats, and in stats.Sessions as well if there are several
+// sessions. A failed run does not stop the others, the first error is
+// returned at the end.
+func Repeat(ctx context.Context, sessions []*client.Client, count int, concurrency int, stats *Stats,
+	fn func(ctx context.Context, session *client.Client) error) error {
+
+	if count < 1 {
+		return fmt.Errorf("invalid repeat count: %d", count)
+	}
+	counts := make([]int, len(sessions))
+	for i := range counts {
+		counts[i] = count
+	}
+	return repeat(ctx, sessions, counts, concurrency, stats, fn)
+}
+
+// RepeatTotal is like Repeat, but runs fn total times in all, spread
+// round-robin over the sessions as calls of a fleet of clients would be.
+func RepeatTotal(ctx context.Context, sessions []*client.Client, total int, concurrency int, stats *Stats,
+	fn func(ctx context.Context, session *client.Client) error) error {
+
+	if total < 1 {
+		return fmt.Errorf("invalid total count: %d", total)
+	}
+	counts := make([]int, len(sessions))
+	for i := range counts {
+		counts[i] = total / len(sessions)
+		if i < total%len(sessions) {
+			counts[i]++
+		}
+	}
+	return repeat(ctx, sessions, counts, concurrency, stats, fn)
+}
+
+// repeat runs fn counts[i] times on sessions[i], all sessions at once. Every
+// session has concurrency workers taking turns at the runs left, so the
+// concurrency of a session does not depend on the number of sessions and no
+// goroutine is started per run.
+func repeat(ctx context.Context, sessions []*client.Client, counts []int, concurrency int, stats *Stats,
+	fn func(ctx context.Context, session *client.Client) error) error {
+
+	if concurrency < 1 {
+		return fmt.Errorf("invalid concurrency: %d", concurrency)
+	}
+	sessionStats := make([]*Stats, len(sessions))
+	if len(sessions) > 1 {
+		stats.Sessions = make(map[wamp.ID]*Stats, len(sessions))
+		for i, session := range sessions {
+			sessionStats[i] = NewStats()
+			stats.Sessions[session.ID()] = sessionStats[i]
+		}
+	}
+	defer stats.done()
+
+	var wg sync.WaitGroup
+	var errsLock sync.Mutex
+	errs := make([]error, len(sessions))
+	for i, session := range sessions {
+		wg.Add(1)
+		go func(i int, session *client.Client) {
+			defer wg.Done()
+
+			var runs sync.WaitGroup
+			var started int64
+			for worker := 0; worker < concurrency && worker < counts[i]; worker++ {
+				runs.Add(1)
+				go func() {
+					defer runs.Done()
+					for ctx.Err() == nil && atomic.AddInt64(&started, 1) <= int64(counts[i]) {
+						start := time.Now()
+						err := fn(ctx, session)
+						latency := time.Since(start)
+						stats.Record(latency, err)
+						stats.recordSample(start, session.ID(), latency, err)
+						if sessionStats[i] != nil {
+							sessionStats[i].Record(latency, err)
+						}
+						if err != nil {
+							errsLock.Lock()
+							if errs[i] == nil {
+								errs[i] = err
+							}
+							errsLock.Unlock()
+						}
+					}
+				}()
+			}
+			runs.Wait()
+		}(i, session)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CallRepeated calls procedure count times from every session at once, one
+// call at a time per session, and returns the stats of the run, the results
+// are printed as options say.
+func CallRepeated(ctx context.Context, sessions []*client.Client, logger *logrus.Logger, procedure string,
+	args []string, kwargs map[string]string, options CallOptions, count int) (*Stats, error) {
+
+	stats := NewStats()
+	err := Repeat(ctx, sessions, count, 1, stats, func(ctx context.Context, session *client.Client) error {
+		return CallContext(ctx, session, logger, procedure, args, kwargs, options)
+	})
+	return stats, err
+}
+
+// PublishRepeated publishes to topic count times from every session at once,
+// one publish at a time per session, and returns the stats of the run, with
+// the acknowledgment latencies if options.Acknowledge.
+func PublishRepeated(ctx context.Context, sessions []*client.Client, logger *logrus.Logger, topic string,
+	args []string, kwargs map[string]string, options PublishOptions, count int) (*Stats, error) {
+
+	stats := NewStats()
+	options.Stats = stats
+	err := Repeat(ctx, sessions, count, 1, stats, func(ctx context.Context, session *client.Client) error {
+		return PublishContext(ctx, session, logger, topic, args, kwargs, options)
+	})
+	return stats, err
+}
@@ -0,0 +1,147 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// Formats of streamed publications.
+const (
+	// StreamFormatJSONL reads one JSON payload per line. An array is sent as
+	// args, an object as kwargs unless it only has "args" and "kwargs"
+	// members, and any other value as the only arg.
+	StreamFormatJSONL = "jsonl"
+
+	// StreamFormatLines sends every line as the only arg, as is.
+	StreamFormatLines = "lines"
+)
+
+// PublishStream publishes one event to topic per line of r, at most rate
+// events per second if rate is positive, until r ends or CTRL-c.
+func PublishStream(session *client.Client, logger *logrus.Logger, topic string, r io.Reader, format string,
+	rate float64, options PublishOptions) error {
+
+	var ticker *time.Ticker
+	if rate > 0 {
+		// Above one event per nanosecond the period truncates to zero.
+		period := time.Duration(float64(time.Second) / rate)
+		if period <= 0 {
+			return errors.New("the rate must be at most 1e9 events per second")
+		}
+		ticker = time.NewTicker(period)
+		defer ticker.Stop()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	published := 0
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if format == StreamFormatJSONL && strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		args, kwargs, err := parseStreamLine(text, format)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if ticker != nil {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err = publishOnce(ctx, session, topic, args, kwargs, options); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		published++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(output(options.Output), "Published %d events to topic '%s'\n", published, topic)
+	logger.WithFields(logrus.Fields{"uri": topic, "count": published}).Debug("published stream")
+	return nil
+}
+
+func parseStreamLine(text string, format string) (wamp.List, wamp.Dict, error) {
+	if format == StreamFormatLines {
+		return wamp.List{text}, nil, nil
+	}
+	return parseJSONPayload([]byte(text))
+}
+
+// parseJSONPayload returns the args and kwargs of a JSON payload, as
+// described for StreamFormatJSONL.
+func parseJSONPayload(data []byte) (wamp.List, wamp.Dict, error) {
+	var payload interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, nil, err
+	}
+
+	switch value := payload.(type) {
+	case []interface{}:
+		return value, nil, nil
+	case map[string]interface{}:
+		explicit := len(value) > 0
+		for key := range value {
+			if key != "args" && key != "kwargs" {
+				explicit = false
+			}
+		}
+		if !explicit {
+			return nil, value, nil
+		}
+		args, argsOK := value["args"].([]interface{})
+		kwargs, kwargsOK := value["kwargs"].(map[string]interface{})
+		if (value["args"] != nil && !argsOK) || (value["kwargs"] != nil && !kwargsOK) {
+			return nil, nil, fmt.Errorf("args must be an array and kwargs an object")
+		}
+		return args, kwargs, nil
+	default:
+		return wamp.List{value}, nil, nil
+	}
+}
@@ -0,0 +1,170 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// The scopes of a testament, when the router publishes it.
+const (
+	TestamentDestroyed = "destroyed"
+	TestamentDetached  = "detached"
+)
+
+// TestamentOptions holds the options of AddTestament and FlushTestaments.
+type TestamentOptions struct {
+	// Scope is TestamentDestroyed to publish the testament when the session
+	// ends or TestamentDetached when it is detached from its transport,
+	// empty is TestamentDestroyed.
+	Scope string
+
+	// Extra are the publish options of the testament event.
+	Extra map[string]string
+}
+
+// testamentArgs returns the arguments and keyword arguments of
+// wamp.session.add_testament for the event topic with args and kwargs.
+func testamentArgs(topic string, args []string, kwargs map[string]string, options TestamentOptions) (wamp.List,
+	wamp.Dict, error) {
+
+	eventArgs := listToWampList(args)
+	if eventArgs == nil {
+		eventArgs = wamp.List{}
+	}
+	eventKwargs, err := dictToWampDict(kwargs, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	publishOptions := wamp.Dict{}
+	for key, value := range options.Extra {
+		publishOptions[key] = value
+	}
+	testamentKwargs := wamp.Dict{"publish_options": publishOptions}
+	if options.Scope != "" {
+		testamentKwargs["scope"] = options.Scope
+	}
+	return wamp.List{topic, eventArgs, eventKwargs}, testamentKwargs, nil
+}
+
+// addTestament asks the router to publish the event when session ends.
+func addTestament(ctx context.Context, session *client.Client, topic string, args []string,
+	kwargs map[string]string, options TestamentOptions) error {
+
+	testamentArgs, testamentKwargs, err := testamentArgs(topic, args, kwargs, options)
+	if err != nil {
+		return err
+	}
+	if _, err = session.Call(ctx, string(wamp.MetaProcSessionAddTestament), nil, testamentArgs, testamentKwargs,
+		nil); err != nil {
+		return fmt.Errorf("adding testament: %w", err)
+	}
+	return nil
+}
+
+// flushTestaments removes the testaments of session of the scope.
+func flushTestaments(ctx context.Context, session *client.Client, options TestamentOptions) error {
+	var kwargs wamp.Dict
+	if options.Scope != "" {
+		kwargs = wamp.Dict{"scope": options.Scope}
+	}
+	if _, err := session.Call(ctx, string(wamp.MetaProcSessionFlushTestaments), nil, nil, kwargs,
+		nil); err != nil {
+		return fmt.Errorf("flushing testaments: %w", err)
+	}
+	return nil
+}
+
+// AddTestament asks the router to publish an event to topic with args and
+// kwargs when session ends, then keeps session joined until CTRL-c or
+// SIGTERM. Killing wick, or cutting its connection, shows how subscribers
+// learn that a client went offline.
+func AddTestament(session *client.Client, logger *logrus.Logger, topic string, args []string,
+	kwargs map[string]string, options TestamentOptions) error {
+
+	if err := addTestament(context.Background(), session, topic, args, kwargs, options); err != nil {
+		return err
+	}
+	fmt.Printf("Testament added, the router publishes to topic '%s' when the session ends\n", topic)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-sigChan:
+		logger.Debug("leaving, the router publishes the testament")
+		return nil
+	case <-session.Done():
+		return errors.New("router gone")
+	}
+}
+
+// DaemonAddTestament is like AddTestament, for the session of the daemon
+// listening on socket. It returns once the testament was added, the router
+// publishes it when the daemon exits.
+func DaemonAddTestament(socket string, topic string, args []string, kwargs map[string]string,
+	options TestamentOptions) error {
+
+	response, err := requestDaemon(socket, daemonRequest{
+		Command:   daemonTestamentAdd,
+		URI:       topic,
+		Args:      args,
+		Kwargs:    kwargs,
+		Testament: options,
+	})
+	if err != nil {
+		return err
+	}
+	if err = response.err(string(wamp.MetaProcSessionAddTestament)); err != nil {
+		return err
+	}
+	fmt.Printf("Testament added, the router publishes to topic '%s' when the daemon exits\n", topic)
+	return nil
+}
+
+// DaemonFlushTestaments removes the testaments of the scope added to the
+// session of the daemon listening on socket. Testaments belong to the
+// session that added them, that is why they are only flushed through a
+// daemon.
+func DaemonFlushTestaments(socket string, options TestamentOptions) error {
+	response, err := requestDaemon(socket, daemonRequest{
+		Command:   daemonTestamentFlush,
+		Testament: options,
+	})
+	if err != nil {
+		return err
+	}
+	if err = response.err(string(wamp.MetaProcSessionFlushTestaments)); err != nil {
+		return err
+	}
+	fmt.Println("Testaments flushed")
+	return nil
+}
@@ -0,0 +1,123 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TicketSource returns a fresh ticket, like a short-lived JWT, for every
+// connect attempt.
+type TicketSource func(ctx context.Context) (string, error)
+
+// TicketCommand returns a TicketSource running command with bash, the ticket
+// is its output without surrounding blanks.
+func TicketCommand(command string) TicketSource {
+	return func(ctx context.Context) (string, error) {
+		err, stdout, stderr := shellOut(ctx, command)
+		if err != nil {
+			if stderr = strings.TrimSpace(stderr); stderr != "" {
+				return "", fmt.Errorf("ticket command failed: %w: %s", err, stderr)
+			}
+			return "", fmt.Errorf("ticket command failed: %w", err)
+		}
+		ticket := strings.TrimSpace(stdout)
+		if ticket == "" {
+			return "", errors.New("ticket command printed no ticket")
+		}
+		return ticket, nil
+	}
+}
+
+// OAuthOptions configure fetching an access token with the OAuth 2.0 client
+// credentials grant.
+type OAuthOptions struct {
+	// TokenURL is the token endpoint of the authorization server.
+	TokenURL string
+
+	// ClientID and ClientSecret authenticate wick to the server with HTTP
+	// basic authentication.
+	ClientID     string
+	ClientSecret string
+
+	// Scope is the space separated scopes requested, none if empty.
+	Scope string
+}
+
+// OAuthTicket returns a TicketSource fetching a new access token from the
+// token endpoint of options.
+func OAuthTicket(options OAuthOptions) TicketSource {
+	return func(ctx context.Context) (string, error) {
+		form := url.Values{"grant_type": {"client_credentials"}}
+		if options.Scope != "" {
+			form.Set("scope", options.Scope)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, options.TokenURL,
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		if options.ClientID != "" {
+			req.SetBasicAuth(url.QueryEscape(options.ClientID), url.QueryEscape(options.ClientSecret))
+		}
+
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("fetching OAuth token: %w", err)
+		}
+		defer rsp.Body.Close()
+		body, err := ioutil.ReadAll(rsp.Body)
+		if err != nil {
+			return "", fmt.Errorf("fetching OAuth token: %w", err)
+		}
+
+		var token struct {
+			AccessToken      string `json:"access_token"`
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		if err = json.Unmarshal(body, &token); err != nil && rsp.StatusCode == http.StatusOK {
+			return "", fmt.Errorf("fetching OAuth token: invalid response: %w", err)
+		}
+		if rsp.StatusCode != http.StatusOK {
+			if token.Error != "" {
+				return "", fmt.Errorf("fetching OAuth token: %s: %s %s", rsp.Status, token.Error,
+					token.ErrorDescription)
+			}
+			return "", fmt.Errorf("fetching OAuth token: %s", rsp.Status)
+		}
+		if token.AccessToken == "" {
+			return "", errors.New("fetching OAuth token: no access_token in response")
+		}
+		return token.AccessToken, nil
+	}
+}
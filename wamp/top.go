@@ -0,0 +1,246 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// topErrors is how many of the latest errors Top shows.
+const topErrors = 5
+
+// TopOptions holds the options of Top.
+type TopOptions struct {
+	// Interval is the time between two refreshes.
+	Interval time.Duration
+
+	// Topics are subscribed to show their events per second.
+	Topics []string
+}
+
+// topCounter counts the events of a watched topic.
+type topCounter struct {
+	topic    string
+	total    int64
+	previous int64
+}
+
+// topState is what Top shows between refreshes. The counters are updated
+// by the event handlers, the rest only by the goroutine of Top.
+type topState struct {
+	counters []*topCounter
+	joined   int64
+	left     int64
+
+	// uris are the URIs of the registrations and subscriptions listed, by
+	// get meta procedure and ID, so each is only looked up once.
+	uris map[wamp.URI]map[wamp.ID]string
+
+	mu     sync.Mutex
+	errors []string
+}
+
+// addError keeps err as one of the latest errors.
+func (s *topState) addError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors = append(s.errors, time.Now().Format("15:04:05")+" "+err.Error())
+	if len(s.errors) > topErrors {
+		s.errors = s.errors[len(s.errors)-topErrors:]
+	}
+}
+
+// Top redraws the session, registration and subscription counts of the
+// realm of session on out every interval, like htop, until CTRL-c. It also
+// shows how many sessions joined and left, the events per second of the
+// watched topics and the latest errors of the meta API, which the router
+// must allow the session to use.
+func Top(session *client.Client, logger *logrus.Logger, options TopOptions, out io.Writer) error {
+	if options.Interval <= 0 {
+		return errors.New("the interval must be positive")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	state := &topState{uris: map[wamp.URI]map[wamp.ID]string{}}
+	subscribe := func(topic string, handler client.EventHandler) error {
+		if err := session.Subscribe(topic, handler, nil); err != nil {
+			return fmt.Errorf("subscribing to %s: %w", topic, err)
+		}
+		return nil
+	}
+	var subscribed []string
+	defer func() {
+		for _, topic := range subscribed {
+			if err := session.Unsubscribe(topic); err != nil {
+				logger.WithField("uri", topic).Debug("Failed to unsubscribe: ", err)
+			}
+		}
+	}()
+	for _, topic := range options.Topics {
+		counter := &topCounter{topic: topic}
+		if err := subscribe(topic, func(*wamp.Event) { atomic.AddInt64(&counter.total, 1) }); err != nil {
+			return err
+		}
+		subscribed = append(subscribed, topic)
+		state.counters = append(state.counters, counter)
+	}
+	// Without the session meta events the counts of joins and leaves stay
+	// at zero, that is no reason to stop.
+	if err := subscribe(string(wamp.MetaEventSessionOnJoin), func(*wamp.Event) {
+		atomic.AddInt64(&state.joined, 1)
+	}); err != nil {
+		state.addError(err)
+	} else {
+		subscribed = append(subscribed, string(wamp.MetaEventSessionOnJoin))
+	}
+	if err := subscribe(string(wamp.MetaEventSessionOnLeave), func(*wamp.Event) {
+		atomic.AddInt64(&state.left, 1)
+	}); err != nil {
+		state.addError(err)
+	} else {
+		subscribed = append(subscribed, string(wamp.MetaEventSessionOnLeave))
+	}
+
+	last := time.Now()
+	for {
+		sessions := topCount(ctx, session, state, wamp.MetaProcSessionCount, "")
+		registrations := topCount(ctx, session, state, wamp.MetaProcRegList, wamp.MetaProcRegGet)
+		subscriptions := topCount(ctx, session, state, wamp.MetaProcSubList, wamp.MetaProcSubGet)
+		if ctx.Err() != nil {
+			return nil
+		}
+		now := time.Now()
+		elapsed := now.Sub(last).Seconds()
+		last = now
+
+		var screen strings.Builder
+		screen.WriteString(clearScreen)
+		fmt.Fprintf(&screen, "wick top, every %s\t%s\n\n", options.Interval, now.Format(time.RFC1123))
+		writer := tabwriter.NewWriter(&screen, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(writer, "Sessions:\t%s\t(%d joined, %d left)\n", sessions, atomic.LoadInt64(&state.joined),
+			atomic.LoadInt64(&state.left))
+		fmt.Fprintf(writer, "Registrations:\t%s\n", registrations)
+		fmt.Fprintf(writer, "Subscriptions:\t%s\n", subscriptions)
+		if len(state.counters) > 0 {
+			fmt.Fprintln(writer, "\nTOPIC\tEVENTS/S\tTOTAL")
+			for _, counter := range state.counters {
+				total := atomic.LoadInt64(&counter.total)
+				rate := float64(total-counter.previous) / elapsed
+				counter.previous = total
+				fmt.Fprintf(writer, "%s\t%.1f\t%d\n", counter.topic, rate, total)
+			}
+		}
+		writer.Flush()
+
+		state.mu.Lock()
+		if len(state.errors) > 0 {
+			screen.WriteString("\nRecent errors:\n")
+			for _, line := range state.errors {
+				screen.WriteString("  " + line + "\n")
+			}
+		}
+		state.mu.Unlock()
+		io.WriteString(out, screen.String())
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-session.Done():
+			return errors.New("router gone")
+		case <-time.After(options.Interval):
+		}
+	}
+}
+
+// topCount calls the count or list meta procedure and returns the number
+// it answered, or the number of IDs listed, "?" if it failed. The IDs are
+// looked up with get, to leave out the meta procedures and topics of the
+// router.
+func topCount(ctx context.Context, session *client.Client, state *topState, procedure wamp.URI,
+	get wamp.URI) string {
+
+	result, err := session.Call(ctx, string(procedure), nil, nil, nil, nil)
+	if err != nil {
+		if ctx.Err() == nil {
+			state.addError(fmt.Errorf("calling %s: %w", procedure, err))
+		}
+		return "?"
+	}
+	if len(result.Arguments) == 0 {
+		return "?"
+	}
+	if count, ok := wamp.AsInt64(result.Arguments[0]); ok {
+		return fmt.Sprint(count)
+	}
+	lists, _ := wamp.AsDict(result.Arguments[0])
+	if state.uris[get] == nil {
+		state.uris[get] = map[wamp.ID]string{}
+	}
+	uris := state.uris[get]
+	listed := map[wamp.ID]bool{}
+	count := 0
+	for _, match := range []string{wamp.MatchExact, wamp.MatchPrefix, wamp.MatchWildcard} {
+		ids, _ := wamp.AsList(lists[match])
+		for _, value := range ids {
+			id, _ := wamp.AsID(value)
+			listed[id] = true
+			uri, ok := uris[id]
+			if !ok {
+				if result, err = session.Call(ctx, string(get), nil, wamp.List{id}, nil, nil); err != nil {
+					// Gone between the list and the get.
+					continue
+				}
+				var details wamp.Dict
+				if len(result.Arguments) > 0 {
+					details, _ = wamp.AsDict(result.Arguments[0])
+				}
+				uri, _ = wamp.AsString(details["uri"])
+				uris[id] = uri
+			}
+			if !strings.HasPrefix(uri, "wamp.") {
+				count++
+			}
+		}
+	}
+	for id := range uris {
+		if !listed[id] {
+			delete(uris, id)
+		}
+	}
+	return fmt.Sprint(count)
+}
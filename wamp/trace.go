@@ -0,0 +1,125 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// redacted replaces the secrets of traced messages.
+const redacted = "REDACTED"
+
+// tracingPeer wraps a peer and writes every message sent and received
+// through it to a writer. The signature of AUTHENTICATE messages is redacted
+// unless secrets.
+type tracingPeer struct {
+	wamp.Peer
+
+	out     io.Writer
+	format  string
+	secrets bool
+	lock    sync.Mutex
+	rd      chan wamp.Message
+}
+
+func newTracingPeer(peer wamp.Peer, out io.Writer, format string, secrets bool) wamp.Peer {
+	t := &tracingPeer{
+		Peer:    peer,
+		out:     out,
+		format:  format,
+		secrets: secrets,
+		rd:      make(chan wamp.Message),
+	}
+
+	go func() {
+		defer close(t.rd)
+		for msg := range peer.Recv() {
+			t.trace("in", msg)
+			t.rd <- msg
+		}
+	}()
+
+	return t
+}
+
+func (t *tracingPeer) Recv() <-chan wamp.Message { return t.rd }
+
+func (t *tracingPeer) Send(msg wamp.Message) error {
+	t.trace("out", msg)
+	return t.Peer.Send(msg)
+}
+
+func (t *tracingPeer) SendCtx(ctx context.Context, msg wamp.Message) error {
+	t.trace("out", msg)
+	return t.Peer.SendCtx(ctx, msg)
+}
+
+func (t *tracingPeer) TrySend(msg wamp.Message) error {
+	t.trace("out", msg)
+	return t.Peer.TrySend(msg)
+}
+
+func (t *tracingPeer) trace(direction string, msg wamp.Message) {
+	// The ticket, or the WAMP-CRA signature that lets anyone who saw the
+	// challenge authenticate, is only traced on demand.
+	traced := msg
+	if authenticate, ok := msg.(*wamp.Authenticate); ok && !t.secrets {
+		traced = &wamp.Authenticate{Signature: redacted, Extra: authenticate.Extra}
+	}
+
+	// Messages are always rendered as JSON, whatever serializer is used on
+	// the wire, as that is the only one readable by humans.
+	serializer := serialize.JSONSerializer{}
+	data, err := serializer.Serialize(traced)
+	if err != nil {
+		data = []byte(fmt.Sprintf("%q", err.Error()))
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.format == "json" {
+		line, _ := json.Marshal(map[string]interface{}{
+			"time":      time.Now().Format(time.RFC3339Nano),
+			"direction": direction,
+			"type":      msg.MessageType().String(),
+			"message":   json.RawMessage(data),
+		})
+		fmt.Fprintln(t.out, string(line))
+		return
+	}
+
+	arrow := "<<"
+	if direction == "out" {
+		arrow = ">>"
+	}
+	fmt.Fprintf(t.out, "%s %s %s %s\n", time.Now().Format("15:04:05.000"), arrow, msg.MessageType(), data)
+}
@@ -0,0 +1,502 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gammazero/nexus/v3/transport"
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// ConnectOptions holds the connection settings that are shared by all
+// authentication methods.
+type ConnectOptions struct {
+	// Debug enables debug logging of the nexus client.
+	Debug bool
+
+	// PingInterval is the interval between websocket pings. Zero disables
+	// the keepalive.
+	PingInterval time.Duration
+
+	// PongTimeout is how long to wait for the pong of a ping before the
+	// connection is considered dead and closed. Zero means twice the
+	// PingInterval.
+	PongTimeout time.Duration
+
+	// Trace receives every WAMP message sent and received over the
+	// connection, nil disables tracing.
+	Trace io.Writer
+
+	// TraceFormat is either "text" for one readable line per message or
+	// "json" for one JSON object per message.
+	TraceFormat string
+
+	// TraceSecrets traces the signature of AUTHENTICATE messages, the
+	// ticket or WAMP-CRA signature, instead of redacting it.
+	TraceSecrets bool
+
+	// Proxy is the URL of the HTTP proxy that websocket connections are
+	// tunneled through with CONNECT, credentials may be given in the URL.
+	// If empty, HTTP_PROXY, HTTPS_PROXY and NO_PROXY are used.
+	Proxy string
+
+	// Socks5 is the "[user:password@]host:port" of a SOCKS5 proxy to dial
+	// the router through.
+	Socks5 string
+
+	// SSHTunnel is the "[user@]host[:port]" of an SSH server to forward the
+	// connection to the router through, after the SOCKS5 proxy if any.
+	SSHTunnel string
+
+	// Metrics counts the sessions joined, nil if not collected.
+	Metrics *Metrics
+
+	// Tracing traces the connect, nil if not traced.
+	Tracing *Tracing
+
+	// TLS configures the wss and tcps connections, nil verifies the router
+	// certificate with the system roots.
+	TLS *tls.Config
+
+	// Revoked is called when the router revokes a registration of the
+	// session with an UNREGISTERED of its own, as when another session takes
+	// the procedure over with force_reregister. Nil if not watched.
+	Revoked func()
+
+	// Bytes counts the bytes sent and received over the connection, nil if
+	// not counted. Raw socket connections counted go through a local port
+	// forward.
+	Bytes *ByteCounter
+
+	// Compression negotiates the permessage-deflate extension on websocket
+	// connections.
+	Compression bool
+}
+
+// ByteCounter counts the bytes sent and received over connections, it is
+// safe for concurrent use.
+type ByteCounter struct {
+	sent     int64
+	received int64
+
+	// messagesSent and messagesReceived add up the uncompressed sizes of
+	// the messages of compressed websocket connections.
+	messagesSent     int64
+	messagesReceived int64
+}
+
+// Sent returns the number of bytes sent.
+func (c *ByteCounter) Sent() int64 { return atomic.LoadInt64(&c.sent) }
+
+// Received returns the number of bytes received.
+func (c *ByteCounter) Received() int64 { return atomic.LoadInt64(&c.received) }
+
+// Uncompressed returns the sizes of the messages sent and received over
+// compressed websocket connections before compression, zero without
+// compression.
+func (c *ByteCounter) Uncompressed() (sent int64, received int64) {
+	return atomic.LoadInt64(&c.messagesSent), atomic.LoadInt64(&c.messagesReceived)
+}
+
+// Summary returns the bytes sent and received in elapsed, with their rate and
+// their mean per operation if ops is not zero, like "bandwidth: sent 1.2MiB
+// (300.5KiB/s, 250B/op)  received 96.1KiB (24.0KiB/s, 20B/op)". With
+// compression a second line compares them with the uncompressed messages.
+func (c *ByteCounter) Summary(elapsed time.Duration, ops int) string {
+	figures := func(n int64) string {
+		figure := formatSize(int(n))
+		if elapsed > 0 {
+			figure += fmt.Sprintf(" (%s/s", formatSize(int(float64(n)/elapsed.Seconds())))
+			if ops > 0 {
+				figure += fmt.Sprintf(", %s/op", formatSize(int(n/int64(ops))))
+			}
+			figure += ")"
+		}
+		return figure
+	}
+	summary := fmt.Sprintf("bandwidth: sent %s  received %s", figures(c.Sent()), figures(c.Received()))
+	ratio := func(compressed, uncompressed int64) string {
+		if uncompressed == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.0f%%", float64(compressed)/float64(uncompressed)*100)
+	}
+	if sent, received := c.Uncompressed(); sent+received > 0 {
+		summary += fmt.Sprintf("\ncompression: sent %s of %s uncompressed (%s)  received %s of %s uncompressed (%s)",
+			formatSize(int(c.Sent())), formatSize(int(sent)), ratio(c.Sent(), sent), formatSize(int(c.Received())),
+			formatSize(int(received)), ratio(c.Received(), received))
+	}
+	return summary
+}
+
+// sizingPeer wraps the peer of a compressed websocket connection and adds
+// up the sizes of its messages serialized, before compression.
+type sizingPeer struct {
+	wamp.Peer
+
+	serializer serialize.Serializer
+	counter    *ByteCounter
+	rd         chan wamp.Message
+}
+
+func newSizingPeer(peer wamp.Peer, serializer serialize.Serializer, counter *ByteCounter) wamp.Peer {
+	p := &sizingPeer{Peer: peer, serializer: serializer, counter: counter, rd: make(chan wamp.Message)}
+	go func() {
+		defer close(p.rd)
+		for msg := range peer.Recv() {
+			atomic.AddInt64(&counter.messagesReceived, p.size(msg))
+			p.rd <- msg
+		}
+	}()
+	return p
+}
+
+func (p *sizingPeer) Recv() <-chan wamp.Message { return p.rd }
+
+func (p *sizingPeer) Send(msg wamp.Message) error {
+	atomic.AddInt64(&p.counter.messagesSent, p.size(msg))
+	return p.Peer.Send(msg)
+}
+
+func (p *sizingPeer) SendCtx(ctx context.Context, msg wamp.Message) error {
+	atomic.AddInt64(&p.counter.messagesSent, p.size(msg))
+	return p.Peer.SendCtx(ctx, msg)
+}
+
+func (p *sizingPeer) TrySend(msg wamp.Message) error {
+	atomic.AddInt64(&p.counter.messagesSent, p.size(msg))
+	return p.Peer.TrySend(msg)
+}
+
+// size returns the size of msg serialized, zero if it cannot be.
+func (p *sizingPeer) size(msg wamp.Message) int64 {
+	data, err := p.serializer.Serialize(msg)
+	if err != nil {
+		return 0
+	}
+	return int64(len(data))
+}
+
+// countingConn is a connection whose traffic is counted.
+type countingConn struct {
+	net.Conn
+
+	counter *ByteCounter
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.counter.received, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.counter.sent, int64(n))
+	return n, err
+}
+
+// countingDialer returns dial, or a direct dialer if nil, with the traffic
+// of its connections counted by counter.
+func countingDialer(dial dialFunc, counter *ByteCounter) dialFunc {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &countingConn{Conn: conn, counter: counter}, nil
+	}
+}
+
+// Serializer returns the serialization for one of the names accepted by
+// --serializer.
+func Serializer(name string) (serialize.Serialization, error) {
+	switch name {
+	case "json":
+		return serialize.JSON, nil
+	case "msgpack":
+		return serialize.MSGPACK, nil
+	case "cbor":
+		return serialize.CBOR, nil
+	}
+	return 0, fmt.Errorf("unknown serializer: %s", name)
+}
+
+// dialPeer connects the transport for routerURL, without joining a realm.
+// It also returns the state of the TLS connection of wss URLs, nil for the
+// others.
+func dialPeer(ctx context.Context, routerURL string, serializer serialize.Serialization, opts ConnectOptions,
+	logger *logrus.Logger) (wamp.Peer, *tls.ConnectionState, error) {
+
+	u, err := url.Parse(routerURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dial, tunnel, err := tunnelDialer(ctx, opts, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.Bytes != nil {
+		dial = countingDialer(dial, opts.Bytes)
+	}
+	var closers []io.Closer
+	if tunnel != nil {
+		closers = append(closers, tunnel)
+	}
+	// Raw socket peers dial by themselves, they reach the tunnel through a
+	// private unix socket forward.
+	forward := func(network, addr string) (string, error) {
+		listener, err := forwardLocal(dial, network, addr, logger)
+		if err != nil {
+			return "", err
+		}
+		closers = append(closers, listener)
+		return listener.Addr().String(), nil
+	}
+
+	var peer wamp.Peer
+	var tlsState *tls.ConnectionState
+	switch u.Scheme {
+	case "http", "https":
+		if u.Scheme == "http" {
+			u.Scheme = "ws"
+		} else {
+			u.Scheme = "wss"
+		}
+		fallthrough
+	case "ws", "wss":
+		peer, tlsState, err = connectWebsocket(ctx, u.String(), serializer, dial, opts, logger)
+	case "tcp", "tcp4", "tcp6", "tcps", "tcp4s", "tcp6s":
+		if opts.PingInterval > 0 {
+			logger.Debug("ping interval is ignored for non-websocket transports")
+		}
+		if opts.Proxy != "" {
+			logger.Debug("proxy is ignored for non-websocket transports")
+		}
+		var tlsConfig *tls.Config
+		network, addr := u.Scheme, u.Host
+		if strings.HasSuffix(network, "s") {
+			network = strings.TrimSuffix(network, "s")
+			tlsConfig = &tls.Config{}
+			if opts.TLS != nil {
+				tlsConfig = opts.TLS.Clone()
+			}
+			if tlsConfig.ServerName == "" {
+				tlsConfig.ServerName = u.Hostname()
+			}
+		}
+		if dial != nil {
+			if addr, err = forward(network, addr); err != nil {
+				break
+			}
+			network = "unix"
+		}
+		peer, err = transport.ConnectRawSocketPeer(ctx, network, addr, serializer, tlsConfig, logger, 0)
+	case "unix":
+		// If a relative path was specified, u.Host is first part of path.
+		network, addr := u.Scheme, path.Clean(u.Host+u.Path)
+		if dial != nil {
+			if addr, err = forward(network, addr); err != nil {
+				break
+			}
+		}
+		peer, err = transport.ConnectRawSocketPeer(ctx, network, addr, serializer, nil, logger, 0)
+	default:
+		err = fmt.Errorf("invalid url: %s", routerURL)
+	}
+	if err != nil {
+		for _, closer := range closers {
+			closer.Close()
+		}
+		return nil, nil, err
+	}
+
+	if len(closers) > 0 {
+		peer = &closingPeer{Peer: peer, closers: closers}
+	}
+	if opts.Revoked != nil {
+		peer = newRevokingPeer(peer, opts.Revoked)
+	}
+	if opts.Trace != nil {
+		peer = newTracingPeer(peer, opts.Trace, opts.TraceFormat, opts.TraceSecrets)
+	}
+
+	return peer, tlsState, nil
+}
+
+// revokingPeer wraps a peer and calls revoked for every UNREGISTERED the
+// router sends without a request, that the nexus client ignores.
+type revokingPeer struct {
+	wamp.Peer
+
+	rd chan wamp.Message
+}
+
+func newRevokingPeer(peer wamp.Peer, revoked func()) wamp.Peer {
+	r := &revokingPeer{Peer: peer, rd: make(chan wamp.Message)}
+	go func() {
+		defer close(r.rd)
+		for msg := range peer.Recv() {
+			if unregistered, ok := msg.(*wamp.Unregistered); ok && unregistered.Request == 0 {
+				revoked()
+			}
+			r.rd <- msg
+		}
+	}()
+	return r
+}
+
+func (r *revokingPeer) Recv() <-chan wamp.Message { return r.rd }
+
+func connectWebsocket(ctx context.Context, routerURL string, serializer serialize.Serialization, dial dialFunc,
+	opts ConnectOptions, logger *logrus.Logger) (wamp.Peer, *tls.ConnectionState, error) {
+
+	var protocol string
+	var payloadType int
+	var wampSerializer serialize.Serializer
+
+	switch serializer {
+	case serialize.JSON:
+		protocol = "wamp.2.json"
+		payloadType = websocket.TextMessage
+		wampSerializer = &serialize.JSONSerializer{}
+	case serialize.MSGPACK:
+		protocol = "wamp.2.msgpack"
+		payloadType = websocket.BinaryMessage
+		wampSerializer = &serialize.MessagePackSerializer{}
+	case serialize.CBOR:
+		protocol = "wamp.2.cbor"
+		payloadType = websocket.BinaryMessage
+		wampSerializer = &serialize.CBORSerializer{}
+	default:
+		return nil, nil, fmt.Errorf("unsupported serialization: %v", serializer)
+	}
+
+	dialer := websocket.Dialer{
+		Subprotocols:      []string{protocol},
+		Proxy:             http.ProxyFromEnvironment,
+		NetDialContext:    dial,
+		TLSClientConfig:   opts.TLS,
+		EnableCompression: opts.Compression,
+	}
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		if proxyURL.Scheme != "http" {
+			return nil, nil, fmt.Errorf("unsupported proxy scheme %q, only http proxies are supported", proxyURL.Scheme)
+		}
+		dialer.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	conn, rsp, err := dialer.DialContext(ctx, routerURL, nil)
+	if err != nil {
+		return nil, nil, &transport.WebsocketError{Err: err, Response: rsp}
+	}
+	compressed := strings.Contains(rsp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+	if opts.Compression && !compressed {
+		logger.Warn("The router declined permessage-deflate, the connection is not compressed")
+	}
+	var tlsState *tls.ConnectionState
+	if tlsConn, ok := conn.UnderlyingConn().(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		tlsState = &state
+	}
+
+	if opts.PingInterval > 0 {
+		pongTimeout := opts.PongTimeout
+		if pongTimeout == 0 {
+			pongTimeout = 2 * opts.PingInterval
+		}
+		keepAlive(conn, opts.PingInterval, pongTimeout, logger)
+	}
+
+	// Pings are handled by keepAlive, so the peer itself must not send any.
+	peer := transport.NewWebsocketPeer(conn, wampSerializer, payloadType, logger, 0, 0)
+	if compressed && opts.Bytes != nil {
+		peer = newSizingPeer(peer, wampSerializer, opts.Bytes)
+	}
+	return peer, tlsState, nil
+}
+
+// keepAlive starts sending a websocket ping every interval and closes the
+// connection if a ping is not answered within timeout. It must be called
+// before the connection is handed to the peer, as the pong handler cannot be
+// changed once the peer is reading. Every ping carries a sequence
+// number that the router echoes back in its pong, that way a late pong is
+// never mistaken for the answer to a newer ping.
+func keepAlive(conn *websocket.Conn, interval time.Duration, timeout time.Duration, logger *logrus.Logger) {
+	var lastPong uint64
+	conn.SetPongHandler(func(data string) error {
+		seq, err := strconv.ParseUint(data, 10, 64)
+		if err == nil && seq > atomic.LoadUint64(&lastPong) {
+			atomic.StoreUint64(&lastPong, seq)
+		}
+		return nil
+	})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var seq uint64
+		for range ticker.C {
+			seq++
+			payload := []byte(strconv.FormatUint(seq, 10))
+			if err := conn.WriteControl(websocket.PingMessage, payload, time.Now().Add(timeout)); err != nil {
+				if err != websocket.ErrCloseSent {
+					logger.Debug("ping failed: ", err)
+				}
+				return
+			}
+
+			sent := seq
+			time.AfterFunc(timeout, func() {
+				if atomic.LoadUint64(&lastPong) < sent {
+					logger.Debugf("no pong received for ping %d within %s, closing connection", sent, timeout)
+					conn.Close()
+				}
+			})
+		}
+	}()
+}
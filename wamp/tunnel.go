@@ -0,0 +1,243 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/proxy"
+)
+
+// dialFunc opens a network connection, like net.Dialer.DialContext.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// tunnelDialer returns the dialer that reaches the router through the SOCKS5
+// proxy and the SSH tunnel of opts, nil if the router is dialed directly. The
+// returned closer, if any, shuts the tunnel down.
+func tunnelDialer(ctx context.Context, opts ConnectOptions, logger *logrus.Logger) (dialFunc, io.Closer, error) {
+	var dial dialFunc
+	if opts.Socks5 != "" {
+		var err error
+		if dial, err = socks5Dialer(opts.Socks5); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if opts.SSHTunnel == "" {
+		return dial, nil, nil
+	}
+
+	forward := dial
+	if forward == nil {
+		forward = (&net.Dialer{}).DialContext
+	}
+	sshClient, err := dialSSH(ctx, opts.SSHTunnel, forward)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ssh tunnel to %s: %w", opts.SSHTunnel, err)
+	}
+	logger.Debug("ssh tunnel established through ", opts.SSHTunnel)
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return sshClient.Dial(network, addr)
+	}, sshClient, nil
+}
+
+// socks5Dialer dials through the SOCKS5 proxy at "[user:password@]host:port".
+func socks5Dialer(address string) (dialFunc, error) {
+	var auth *proxy.Auth
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		credentials := strings.SplitN(address[:i], ":", 2)
+		auth = &proxy.Auth{User: credentials[0]}
+		if len(credentials) == 2 {
+			auth.Password = credentials[1]
+		}
+		address = address[i+1:]
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", address, auth, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, errors.New("socks5 dialer does not support contexts")
+	}
+	return contextDialer.DialContext, nil
+}
+
+// dialSSH connects to the SSH server at "[user@]host[:port]". The user
+// defaults to the current user and the port to 22. The identity comes from
+// the SSH agent and the default unencrypted keys in ~/.ssh, and the server
+// must be listed in ~/.ssh/known_hosts.
+func dialSSH(ctx context.Context, target string, dial dialFunc) (*ssh.Client, error) {
+	username, host := "", target
+	if i := strings.LastIndex(target, "@"); i >= 0 {
+		username, host = target[:i], target[i+1:]
+	}
+	if username == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		username = current.Username
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, err
+	}
+
+	var authMethods []ssh.AuthMethod
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if agentConn, err := net.Dial("unix", socket); err == nil {
+			// The agent is only needed to authenticate, which is over once
+			// the client is up.
+			defer agentConn.Close()
+			authMethods = append(authMethods, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+		}
+	}
+	var signers []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		key, err := ioutil.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		if signer, err := ssh.ParsePrivateKey(key); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		authMethods = append(authMethods, ssh.PublicKeys(signers...))
+	}
+
+	config := &ssh.ClientConfig{
+		User:            username,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCallback,
+	}
+
+	conn, err := dial(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, host, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshConn, channels, requests), nil
+}
+
+// forwardLocal listens on a unix socket in a new directory private to the
+// user and forwards the first connection to addr through dial, for
+// transports that cannot be given a dialer. Unlike a loopback port, the
+// socket does not let other local users through the tunnel.
+func forwardLocal(dial dialFunc, network, addr string, logger *logrus.Logger) (net.Listener, error) {
+	dir, err := ioutil.TempDir("", "wick-tunnel-")
+	if err != nil {
+		return nil, err
+	}
+	listener, err := listenPrivate(filepath.Join(dir, "tunnel.sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	tunnel := &tunnelListener{Listener: listener, dir: dir}
+
+	go func() {
+		local, err := tunnel.Accept()
+		tunnel.Close()
+		if err != nil {
+			return
+		}
+		defer local.Close()
+		remote, err := dial(context.Background(), network, addr)
+		if err != nil {
+			logger.Debug("tunnel dial failed: ", err)
+			return
+		}
+		defer remote.Close()
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		pipe := func(dst, src net.Conn) {
+			defer wg.Done()
+			io.Copy(dst, src)
+			dst.Close()
+		}
+		go pipe(remote, local)
+		go pipe(local, remote)
+		wg.Wait()
+	}()
+
+	return tunnel, nil
+}
+
+// tunnelListener removes the private directory of its socket when closed.
+type tunnelListener struct {
+	net.Listener
+
+	dir string
+}
+
+func (l *tunnelListener) Close() error {
+	err := l.Listener.Close()
+	os.RemoveAll(l.dir)
+	return err
+}
+
+// closingPeer closes the tunnel underneath a peer together with the peer.
+type closingPeer struct {
+	wamp.Peer
+
+	closers []io.Closer
+}
+
+func (c *closingPeer) Close() {
+	c.Peer.Close()
+	for _, closer := range c.closers {
+		closer.Close()
+	}
+}
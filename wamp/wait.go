@@ -0,0 +1,150 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+)
+
+// WaitEventOptions configure waiting for an event.
+type WaitEventOptions struct {
+	// Timeout gives up waiting, zero waits forever.
+	Timeout time.Duration
+
+	// MatchArgs are the values the first args of the event must have.
+	MatchArgs []string
+
+	// MatchKwargs are the values some kwargs of the event must have.
+	MatchKwargs map[string]string
+
+	// Cryptobox decrypts end-to-end encrypted events, nil if not used.
+	Cryptobox *Cryptobox
+
+	// BinaryFormat is how binary payloads are printed, BinaryFormatBase64
+	// or BinaryFormatHex.
+	BinaryFormat string
+
+	// JSONStyle is how payloads are printed.
+	JSONStyle JSONStyle
+
+	// Output receives what is printed, nil prints to stdout.
+	Output io.Writer
+}
+
+// WaitEvent subscribes to topic and returns as soon as an event matching
+// options is received, after printing it. If none arrived in time, the
+// returned error wraps context.DeadlineExceeded.
+func WaitEvent(session *client.Client, logger *logrus.Logger, topic string, options WaitEventOptions) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	matched := make(chan struct{})
+	eventHandler := func(event *wamp.Event) {
+		args, kwargs := event.Arguments, event.ArgumentsKw
+		if options.Cryptobox != nil {
+			var err error
+			if args, kwargs, _, err = options.Cryptobox.Open(topic, args, kwargs, event.Details); err != nil {
+				logger.WithField("uri", topic).Warn(err)
+			}
+		}
+		if !eventMatches(args, kwargs, options.MatchArgs, options.MatchKwargs) {
+			logger.WithField("uri", topic).Debug("ignored event not matching")
+			return
+		}
+
+		select {
+		case <-matched:
+		default:
+			printIdentity(output(options.Output), "publisher", event.Details)
+			argsKWArgs(output(options.Output), args, kwargs, options.BinaryFormat, options.JSONStyle)
+			close(matched)
+		}
+	}
+
+	if err := session.Subscribe(topic, eventHandler, nil); err != nil {
+		return err
+	}
+	logger.WithField("uri", topic).Debug("waiting for event")
+
+	var err error
+	select {
+	case <-matched:
+	case <-ctx.Done():
+		err = ctx.Err()
+		if err == context.DeadlineExceeded {
+			err = fmt.Errorf("no matching event received within %s: %w", options.Timeout, err)
+		}
+	case <-session.Done():
+		return fmt.Errorf("router gone while waiting for an event on '%s'", topic)
+	}
+
+	if unsubscribeErr := session.Unsubscribe(topic); unsubscribeErr != nil {
+		logger.WithField("uri", topic).Error("Failed to unsubscribe: ", unsubscribeErr)
+	}
+	return err
+}
+
+// eventMatches tells if the first args and the given kwargs of an event have
+// the expected values. Strings are compared as is, other values by their
+// JSON representation, so `5`, `true` or `{"a":1}` match as expected.
+func eventMatches(args wamp.List, kwargs wamp.Dict, matchArgs []string, matchKwargs map[string]string) bool {
+	if len(args) < len(matchArgs) {
+		return false
+	}
+	for i, expected := range matchArgs {
+		if !valueMatches(args[i], expected) {
+			return false
+		}
+	}
+
+	for key, expected := range matchKwargs {
+		value, ok := kwargs[key]
+		if !ok || !valueMatches(value, expected) {
+			return false
+		}
+	}
+	return true
+}
+
+func valueMatches(value interface{}, expected string) bool {
+	if s, ok := value.(string); ok {
+		return s == expected
+	}
+	encoded, err := json.Marshal(printable(value, BinaryFormatBase64))
+	return err == nil && string(encoded) == expected
+}
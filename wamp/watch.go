@@ -0,0 +1,89 @@
+// MIT License
+//
+// Copyright (c) 2021 CODEBASE
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package wamp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	clearScreen  = "\033[H\033[2J"
+	reverseVideo = "\033[7m"
+	resetStyle   = "\033[0m"
+)
+
+// WatchCall calls procedure every interval and redraws its result on out,
+// like watch(1), until CTRL-c. With highlight, the lines that changed since
+// the previous result are shown in reverse video. A failed call is shown in
+// place of the result, the watch goes on.
+func WatchCall(session *client.Client, logger *logrus.Logger, procedure string, args []string,
+	kwargs map[string]string, options CallOptions, interval time.Duration, highlight bool, out io.Writer) error {
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	header := strings.TrimSpace(fmt.Sprintf("Every %s: call %s %s", interval, procedure, strings.Join(args, " ")))
+	var previous []string
+	for {
+		var output string
+		resultArgs, _, err := callOnce(ctx, session, logger, procedure, args, kwargs, options)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			output, err = formatResult(resultArgs, options.BinaryFormat, options.JSONStyle)
+		}
+		if err != nil {
+			output = "error: " + err.Error()
+		}
+
+		lines := strings.Split(output, "\n")
+		var screen strings.Builder
+		screen.WriteString(clearScreen)
+		fmt.Fprintf(&screen, "%s\t%s\n\n", header, time.Now().Format(time.RFC1123))
+		for i, line := range lines {
+			if highlight && previous != nil && (i >= len(previous) || previous[i] != line) {
+				// Colors reset the style, the highlight must outlast them.
+				line = reverseVideo + strings.ReplaceAll(line, resetStyle, resetStyle+reverseVideo) + resetStyle
+			}
+			screen.WriteString(line + "\n")
+		}
+		io.WriteString(out, screen.String())
+		previous = lines
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
@@ -1,10 +1,7 @@
-_wick_bash_autocomplete() {
-	    local cur prev opts base
-	        COMPREPLY=()
-		    cur="${COMP_WORDS[COMP_CWORD]}"
-		        opts=$( ${COMP_WORDS[0]} --completion-bash ${COMP_WORDS[@]:1:$COMP_CWORD} )
-			    COMPREPLY=( $(compgen -W "${opts}" -- ${cur}) )
-			        return 0
-			}
-		complete -F _wick_bash_autocomplete wick
-
+_wick_completion() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" word="" options
+    [[ "${cur}" == -* ]] && word="${cur}"
+    options=$("${COMP_WORDS[0]}" --completion-bash "${COMP_WORDS[@]:1:$((COMP_CWORD - 1))}" "${word}" 2>/dev/null)
+    COMPREPLY=($(compgen -W "${options}" -- "${cur}"))
+}
+complete -o default -F _wick_completion wick